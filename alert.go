@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	bikeshareapi "github.com/8245snake/bikeshare-client"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+const (
+	//MaxAlerts 台数アラートの登録件数
+	MaxAlerts = 5
+	//AlertHysteresis 一度通知したアラートを再び有効にするまでに台数が戻る必要がある幅
+	AlertHysteresis = 2
+	//AlertCooldown 同じアラートを続けて通知しない時間
+	AlertCooldown = 30 * time.Minute
+	//AlertCheckInterval 台数を確認する間隔（台数データの更新間隔に合わせる）
+	AlertCheckInterval = 5 * time.Minute
+)
+
+//AlertKind アラートの条件の種類
+type AlertKind string
+
+const (
+	//AlertKindBelow 指定台数未満になったら通知
+	AlertKindBelow AlertKind = "lt"
+	//AlertKindAbove 指定台数以上になったら通知
+	AlertKindAbove AlertKind = "ge"
+)
+
+//AlertChoices アラート登録時に選べる条件
+var AlertChoices = []string{"lt1", "lt3", "lt5", "ge3", "ge5", "ge10"}
+
+//SpotAlert お気に入りスポットの台数アラート
+type SpotAlert struct {
+	//Code スポットのコード（A1-01）
+	Code string
+	//Kind 条件の種類
+	Kind AlertKind
+	//Threshold 閾値
+	Threshold int
+	//Triggered 通知済みで台数が戻るのを待っている
+	Triggered bool `json:",omitempty"`
+	//LastNotified 最後に通知した時刻
+	LastNotified time.Time `json:",omitempty"`
+}
+
+//ParseSpotAlert 「A1-01:lt3」形式の文字列からアラートを作成
+func ParseSpotAlert(value string) (SpotAlert, error) {
+	arr := strings.Split(value, ":")
+	if len(arr) != 2 || arr[0] == "" {
+		return SpotAlert{}, fmt.Errorf("アラートの形式が不正です: %s", value)
+	}
+	kind, threshold, err := parseAlertCondition(arr[1])
+	if err != nil {
+		return SpotAlert{}, err
+	}
+	return SpotAlert{Code: arr[0], Kind: kind, Threshold: threshold}, nil
+}
+
+//parseAlertCondition 「lt3」形式の条件をパース
+func parseAlertCondition(condition string) (AlertKind, int, error) {
+	if len(condition) < 3 {
+		return "", 0, fmt.Errorf("アラートの条件が不正です: %s", condition)
+	}
+	kind := AlertKind(condition[:2])
+	if kind != AlertKindBelow && kind != AlertKindAbove {
+		return "", 0, fmt.Errorf("アラートの条件が不正です: %s", condition)
+	}
+	threshold, err := strconv.Atoi(condition[2:])
+	if err != nil || threshold < 0 {
+		return "", 0, fmt.Errorf("アラートの閾値が不正です: %s", condition)
+	}
+	return kind, threshold, nil
+}
+
+//Key 「A1-01:lt3」形式の文字列
+func (alert SpotAlert) Key() string {
+	return fmt.Sprintf("%s:%s%d", alert.Code, alert.Kind, alert.Threshold)
+}
+
+//Condition 条件の表示用文字列
+func (alert SpotAlert) Condition(lang Lang) string {
+	return alertConditionLabel(alert.Kind, alert.Threshold, lang)
+}
+
+//alertConditionLabel 条件の表示用文字列
+func alertConditionLabel(kind AlertKind, threshold int, lang Lang) string {
+	switch kind {
+	case AlertKindBelow:
+		if threshold == 1 {
+			return T(lang, "alert.empty")
+		}
+		return T(lang, "alert.below", threshold)
+	case AlertKindAbove:
+		return T(lang, "alert.above", threshold)
+	}
+	return ""
+}
+
+//Check 台数を評価して通知すべきならtrueを返す（状態も更新する）
+//一度通知したら台数が閾値からAlertHysteresis以上戻るまで再通知しない
+func (alert *SpotAlert) Check(count int, now time.Time) bool {
+	var hit, rearm bool
+	switch alert.Kind {
+	case AlertKindBelow:
+		hit = count < alert.Threshold
+		rearm = count >= alert.Threshold+AlertHysteresis
+	case AlertKindAbove:
+		hit = count >= alert.Threshold
+		rearm = count <= alert.Threshold-AlertHysteresis
+	}
+	if alert.Triggered {
+		if rearm {
+			alert.Triggered = false
+		}
+		return false
+	}
+	if !hit || now.Sub(alert.LastNotified) < AlertCooldown {
+		return false
+	}
+	alert.Triggered = true
+	alert.LastNotified = now
+	return true
+}
+
+//AddAlert アラートを追加したスライスを返す
+func AddAlert(alerts []SpotAlert, alert SpotAlert, max int) []SpotAlert {
+	for _, item := range alerts {
+		if item.Key() == alert.Key() {
+			//重複するならそのまま返す
+			return alerts
+		}
+	}
+	if len(alerts) >= max {
+		return alerts
+	}
+	return append(alerts, alert)
+}
+
+//RemoveAlert アラートを削除したスライスを返す
+func RemoveAlert(alerts []SpotAlert, key string) []SpotAlert {
+	buff := []SpotAlert{}
+	for _, item := range alerts {
+		if item.Key() != key {
+			buff = append(buff, item)
+		}
+	}
+	return buff
+}
+
+//AlertPoller 監視されているスポットの台数を定期的に確認してアラートを送信する
+type AlertPoller struct {
+	//Clock 現在時刻
+	Clock Clock
+	//Send メッセージを送信する
+	Send func(userID string, message linebot.SendingMessage) error
+}
+
+//NewAlertPoller コンストラクタ
+func NewAlertPoller() *AlertPoller {
+	return &AlertPoller{
+		Clock: systemClock{},
+		Send:  PushMessage,
+	}
+}
+
+//Run stopが閉じられるまで定期的に台数を確認する
+func (poller *AlertPoller) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			poller.Check()
+		case <-stop:
+			return
+		}
+	}
+}
+
+//Check 全ユーザーの監視スポットをまとめて検索してアラートを評価する
+func (poller *AlertPoller) Check() {
+	users := UserConfigs.List()
+	var codes []string
+	for _, user := range users {
+		for _, alert := range user.Alerts {
+			if !contains(codes, alert.Code) {
+				codes = append(codes, alert.Code)
+			}
+		}
+	}
+	if len(codes) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), APIBackgroundBudget)
+	defer cancel()
+	ctx = WithLogFields(ctx, LogFields{"job": "alert"})
+	spotinfos, stale, err := BikeshareAPI.FetchPlaces(ctx, bikeshareapi.SearchPlacesOption{Places: codes})
+	if err != nil {
+		LogError(ctx, "アラートの台数取得に失敗しました", LogFields{"error": err})
+		return
+	}
+	if stale {
+		//古い台数で判定すると同じアラートを何度も送りかねない
+		LogWarn(ctx, "アラートの台数が古いため判定しません", nil)
+		return
+	}
+	spots := make(map[string]bikeshareapi.SpotInfo)
+	for _, info := range spotinfos {
+		if len(info.Counts) > 0 {
+			spots[info.Area+"-"+info.Spot] = info
+		}
+	}
+
+	now := poller.Clock.Now()
+	for _, user := range users {
+		//一時停止中は判定もしない（再開したときにまだ条件を満たしていれば送る）
+		if len(user.Alerts) == 0 || !AllowProactivePush(ctx, user.LineID, now) {
+			continue
+		}
+		//状態が変わらないユーザーは保存しない
+		if !evaluateAlerts(copyUser(user).Alerts, spots, now) {
+			continue
+		}
+		var fired []SpotAlert
+		err := UpdateUserConfigFunc(user.LineID, func(config *UserConfig) {
+			fired = nil
+			for i := range config.Alerts {
+				alert := &config.Alerts[i]
+				info, ok := spots[alert.Code]
+				if ok && alert.Check(info.Counts[0].Count, now) {
+					fired = append(fired, *alert)
+				}
+			}
+		})
+		if err != nil {
+			//保存できなかったことはUpdateUserConfigFuncがログに出している
+			continue
+		}
+		for _, alert := range fired {
+			message := MakeAlertMessage(alert, spots[alert.Code], user.Lang())
+			if err := poller.Send(user.LineID, message); err != nil {
+				LogError(ctx, "アラートを送れませんでした", LogFields{"error": err, "user": HashUserID(user.LineID), "spot": alert.Code})
+			}
+		}
+	}
+}
+
+//evaluateAlerts アラートを評価していずれかの状態が変わればtrueを返す
+func evaluateAlerts(alerts []SpotAlert, spots map[string]bikeshareapi.SpotInfo, now time.Time) bool {
+	changed := false
+	for i := range alerts {
+		info, ok := spots[alerts[i].Code]
+		if !ok {
+			continue
+		}
+		before := alerts[i]
+		alerts[i].Check(info.Counts[0].Count, now)
+		if before != alerts[i] {
+			changed = true
+		}
+	}
+	return changed
+}
+
+//MakeAlertMessage アラート通知のメッセージ
+func MakeAlertMessage(alert SpotAlert, info bikeshareapi.SpotInfo, lang Lang) linebot.SendingMessage {
+	text := T(lang, "alert.message", alert.Code, info.Name, alert.Condition(lang), info.Counts[0].Count)
+	return linebot.NewTextMessage(text)
+}
+
+//CreateAlertSpotQuickReplyItems アラートを設定するスポットの選択肢
+func CreateAlertSpotQuickReplyItems(favorites []string) *linebot.QuickReplyItems {
+	items := linebot.NewQuickReplyItems()
+	for _, code := range favorites {
+		area, spot := SplitAreaSpot(code)
+		label := code
+		items.Items = append(items.Items, linebot.NewQuickReplyButton("",
+			linebot.NewPostbackAction(label, GetPostbackDataForAlert(PostBackCommandModeReg, area, spot, ""), "", label)))
+	}
+	return items
+}
+
+//CreateAlertConditionQuickReplyItems アラートの条件の選択肢
+func CreateAlertConditionQuickReplyItems(area, spot string, lang Lang) *linebot.QuickReplyItems {
+	items := linebot.NewQuickReplyItems()
+	for _, condition := range AlertChoices {
+		kind, threshold, _ := parseAlertCondition(condition)
+		label := alertConditionLabel(kind, threshold, lang)
+		items.Items = append(items.Items, linebot.NewQuickReplyButton("",
+			linebot.NewPostbackAction(label, GetPostbackDataForAlert(PostBackCommandModeReg, area, spot, condition), "", label)))
+	}
+	return items
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+func TestSpotAlertCheck(t *testing.T) {
+	type step struct {
+		minute int
+		count  int
+		want   bool
+	}
+	tests := []struct {
+		name  string
+		alert SpotAlert
+		steps []step
+	}{
+		{
+			name:  "閾値付近で揺れても戻りきるまで再通知しない",
+			alert: SpotAlert{Code: "A1-01", Kind: AlertKindBelow, Threshold: 3},
+			steps: []step{
+				{0, 5, false},
+				{5, 2, true},
+				{10, 3, false},
+				{15, 2, false},
+				{20, 4, false},
+				//閾値+AlertHysteresisまで戻ってから、クールダウンが明けて下回った
+				{40, 5, false},
+				{45, 2, true},
+			},
+		},
+		{
+			name:  "戻ってもクールダウン中は通知しない",
+			alert: SpotAlert{Code: "A1-01", Kind: AlertKindBelow, Threshold: 3},
+			steps: []step{
+				{0, 2, true},
+				{5, 10, false},
+				{10, 1, false},
+				{29, 1, false},
+				{30, 1, true},
+			},
+		},
+		{
+			name:  "以上の条件",
+			alert: SpotAlert{Code: "A1-01", Kind: AlertKindAbove, Threshold: 5},
+			steps: []step{
+				{0, 4, false},
+				{5, 5, true},
+				{10, 4, false},
+				{40, 6, false},
+				{45, 3, false},
+				{50, 5, true},
+			},
+		},
+	}
+	start := tokyoAt("08:00:00")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alert := tt.alert
+			for _, s := range tt.steps {
+				now := start.Add(time.Duration(s.minute) * time.Minute)
+				if got := alert.Check(s.count, now); got != s.want {
+					t.Errorf("%d分後 %d台: Check = %v, want %v（%+v）", s.minute, s.count, got, s.want, alert)
+				}
+			}
+		})
+	}
+}
+
+func TestAlertPollerFiresOncePerCrossing(t *testing.T) {
+	setupFakeBot(t)
+	clock := &fakeClock{now: time.Now().In(LocationTokyo)}
+	//偽のAPIの台数は100台未満なので条件をいつでも満たす
+	UpdateUserConfigFunc("U1", func(user *UserConfig) {
+		user.Alerts = []SpotAlert{{Code: "A1-01", Kind: AlertKindBelow, Threshold: 100}}
+	})
+	var sent []string
+	poller := &AlertPoller{
+		Clock: clock,
+		Send: func(userID string, message linebot.SendingMessage) error {
+			sent = append(sent, userID)
+			return nil
+		},
+	}
+	poller.Check()
+	clock.now = clock.now.Add(AlertCheckInterval)
+	poller.Check()
+	clock.now = clock.now.Add(AlertCooldown)
+	poller.Check()
+	//条件を満たしたままなので最初の1回だけ
+	if !reflect.DeepEqual(sent, []string{"U1"}) {
+		t.Errorf("sent = %v", sent)
+	}
+	if user := GetUserConfigFromCache("U1"); !user.Alerts[0].Triggered {
+		t.Errorf("alert = %+v", user.Alerts[0])
+	}
+}
+
+func TestAddRemoveAlert(t *testing.T) {
+	a := SpotAlert{Code: "A1-01", Kind: AlertKindBelow, Threshold: 3}
+	b := SpotAlert{Code: "A1-02", Kind: AlertKindAbove, Threshold: 5}
+	alerts := AddAlert(nil, a, 2)
+	alerts = AddAlert(alerts, a, 2)
+	alerts = AddAlert(alerts, b, 2)
+	alerts = AddAlert(alerts, SpotAlert{Code: "A1-03", Kind: AlertKindBelow, Threshold: 1}, 2)
+	if !reflect.DeepEqual(alerts, []SpotAlert{a, b}) {
+		t.Errorf("AddAlert = %v", alerts)
+	}
+	if got := RemoveAlert(alerts, "A1-01:lt3"); !reflect.DeepEqual(got, []SpotAlert{b}) {
+		t.Errorf("RemoveAlert = %v", got)
+	}
+	if got, err := ParseSpotAlert("A1-01:ge10"); err != nil || got.Key() != "A1-01:ge10" {
+		t.Errorf("ParseSpotAlert = %+v, %v", got, err)
+	}
+	for _, value := range []string{"A1-01", ":lt3", "A1-01:xx3", "A1-01:lt-1", "A1-01:lt"} {
+		if _, err := ParseSpotAlert(value); err == nil {
+			t.Errorf("ParseSpotAlert(%q) を受け付けた", value)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	bikeshareapi "github.com/8245snake/bikeshare-client"
+)
+
+const (
+	//APICacheTTL 当日の台数を含む取得結果を使い回す時間（台数データの更新間隔より短くする）
+	APICacheTTL = 2 * time.Minute
+	//APICachePastTTL 過去の日の台数を使い回す時間（もう変わらない）
+	APICachePastTTL = GraphMaxAgePast
+	//APIStaleLimit APIが失敗したときに古い取得結果を返してよい期間
+	APIStaleLimit = time.Hour
+	//APICacheMaxEntries 覚えておく取得結果の数
+	APICacheMaxEntries = 1000
+	//APICacheFetchTimeout 共有する取得にかける時間の上限（待っている呼び出し元の期限とは別に決める）
+	APICacheFetchTimeout = APIBackgroundBudget
+)
+
+//APICache BikeshareAPIの取得結果を使い回す
+//同じ内容を取得中なら終わるのを待って同じ結果を返し、APIが失敗したら期限切れでも前回の結果を返す
+type APICache struct {
+	//StaleLimit 失敗したときに古い取得結果を返してよい期間
+	StaleLimit time.Duration
+	//MaxEntries 覚えておく取得結果の数
+	MaxEntries int
+	//FetchTimeout 共有する取得にかける時間の上限
+	FetchTimeout time.Duration
+
+	mu       sync.Mutex
+	entries  map[string]*apiCacheEntry
+	inflight map[string]*apiCacheCall
+}
+
+//apiCacheEntry 取得結果
+type apiCacheEntry struct {
+	value   interface{}
+	fetched time.Time
+	expires time.Time
+}
+
+//apiCacheCall 取得中のリクエスト
+type apiCacheCall struct {
+	done  chan struct{}
+	value interface{}
+	stale bool
+	err   error
+}
+
+//NewAPICache コンストラクタ
+func NewAPICache() *APICache {
+	return &APICache{
+		StaleLimit:   APIStaleLimit,
+		MaxEntries:   APICacheMaxEntries,
+		FetchTimeout: APICacheFetchTimeout,
+		entries:      map[string]*apiCacheEntry{},
+		inflight:     map[string]*apiCacheCall{},
+	}
+}
+
+//detachedContext 呼び出し元の値（ログの項目など）だけを引き継ぎ、キャンセルや期限は引き継がないcontext
+type detachedContext struct {
+	context.Context
+}
+
+//Deadline 期限はない
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+//Done 終わらない
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+//Err 終わらないのでいつもnil
+func (detachedContext) Err() error { return nil }
+
+//Fetch keyの取得結果を返す（期限内ならfetchを呼ばない）
+//staleがtrueならfetchが失敗したので前回の結果を返している
+//fetchは待っている全員で共有するので、最初の呼び出し元のctxではなくFetchTimeoutを期限にして別に動かす
+//取得を待っている間にctxが終わったら、待つのをやめてctxのエラーを返す（取得は続けて次に使う）
+func (cache *APICache) Fetch(ctx context.Context, key string, ttl time.Duration, fetch func(ctx context.Context) (interface{}, error)) (value interface{}, stale bool, err error) {
+	if cache == nil {
+		value, err = fetch(ctx)
+		return value, false, err
+	}
+	now := time.Now()
+	endpoint := strings.SplitN(key, "?", 2)[0]
+	cache.mu.Lock()
+	if entry, ok := cache.entries[key]; ok && now.Before(entry.expires) {
+		cache.mu.Unlock()
+		MetricAPICache.Inc(endpoint, "hit")
+		return entry.value, false, nil
+	}
+	call, ok := cache.inflight[key]
+	if ok {
+		//同じ内容を取得中なので待つ
+		MetricAPICache.Inc(endpoint, "shared")
+	} else {
+		call = &apiCacheCall{done: make(chan struct{})}
+		cache.inflight[key] = call
+		go cache.run(detachedContext{ctx}, key, endpoint, ttl, call, fetch)
+	}
+	cache.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, call.stale, call.err
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+//run 共有する取得を行って結果を覚え、待っている呼び出し元に知らせる
+func (cache *APICache) run(ctx context.Context, key, endpoint string, ttl time.Duration, call *apiCacheCall, fetch func(ctx context.Context) (interface{}, error)) {
+	now := time.Now()
+	if cache.FetchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cache.FetchTimeout)
+		defer cancel()
+	}
+	value, err := fetch(ctx)
+
+	cache.mu.Lock()
+	delete(cache.inflight, key)
+	entry, ok := cache.entries[key]
+	switch {
+	case err == nil:
+		cache.store(key, &apiCacheEntry{value: value, fetched: now, expires: now.Add(ttl)})
+		call.value = value
+		MetricAPICache.Inc(endpoint, "miss")
+	case errors.Is(err, context.Canceled):
+		//APIの失敗ではないので古い結果には切り替えない
+		call.err = err
+		MetricAPICache.Inc(endpoint, "canceled")
+	case ok && now.Sub(entry.fetched) < cache.StaleLimit:
+		LogWarn(ctx, "APIが失敗したため前回取得した結果を使います", LogFields{"error": err, "endpoint": endpoint, "fetched": entry.fetched.In(LocationTokyo).Format(time.RFC3339)})
+		call.value, call.stale = entry.value, true
+		MetricAPICache.Inc(endpoint, "stale")
+	default:
+		call.err = err
+		MetricAPICache.Inc(endpoint, "error")
+	}
+	cache.mu.Unlock()
+	close(call.done)
+}
+
+//store 取得結果を覚える（多すぎるときは古いものから忘れる）
+func (cache *APICache) store(key string, entry *apiCacheEntry) {
+	cache.entries[key] = entry
+	if len(cache.entries) <= cache.MaxEntries {
+		return
+	}
+	//古いデータとしても使えないものを先に消す
+	for k, e := range cache.entries {
+		if entry.fetched.Sub(e.fetched) >= cache.StaleLimit {
+			delete(cache.entries, k)
+		}
+	}
+	for len(cache.entries) > cache.MaxEntries {
+		oldest := ""
+		for k, e := range cache.entries {
+			if oldest == "" || e.fetched.Before(cache.entries[oldest].fetched) {
+				oldest = k
+			}
+		}
+		delete(cache.entries, oldest)
+	}
+}
+
+//CachedAPIClient 台数の取得結果を使い回すBikeshareAPIクライアント
+//ユーザー設定の読み書きやステータスはキャッシュせずにそのまま呼ぶ
+type CachedAPIClient struct {
+	APIClient
+	Cache *APICache
+}
+
+//NewCachedAPIClient コンストラクタ
+func NewCachedAPIClient(client bikeshareapi.ApiClient) CachedAPIClient {
+	return CachedAPIClient{APIClient: APIClient{ApiClient: client}, Cache: NewAPICache()}
+}
+
+//FetchPlaces 駐輪場検索（staleがtrueならAPIが失敗したので前回の結果を返している）
+func (api *CachedAPIClient) FetchPlaces(ctx context.Context, option bikeshareapi.SearchPlacesOption) (spotinfos []bikeshareapi.SpotInfo, stale bool, err error) {
+	value, stale, err := api.Cache.Fetch(ctx, "places?"+option.GetQuery(), APICacheTTL, func(ctx context.Context) (interface{}, error) {
+		return api.APIClient.GetPlacesContext(ctx, option)
+	})
+	if err != nil {
+		return []bikeshareapi.SpotInfo{}, false, err
+	}
+	return copySpotInfos(value.([]bikeshareapi.SpotInfo)), stale, nil
+}
+
+//GetPlacesContext 駐輪場検索
+func (api *CachedAPIClient) GetPlacesContext(ctx context.Context, option bikeshareapi.SearchPlacesOption) ([]bikeshareapi.SpotInfo, error) {
+	spotinfos, _, err := api.FetchPlaces(ctx, option)
+	return spotinfos, err
+}
+
+//GetPlaces 駐輪場検索
+func (api *CachedAPIClient) GetPlaces(option bikeshareapi.SearchPlacesOption) ([]bikeshareapi.SpotInfo, error) {
+	return api.GetPlacesContext(context.Background(), option)
+}
+
+//FetchCounts 台数検索（過去の日は長く使い回す）
+func (api *CachedAPIClient) FetchCounts(ctx context.Context, option bikeshareapi.SearchCountsOption) (info bikeshareapi.SpotInfo, stale bool, err error) {
+	ttl := APICacheTTL
+	if option.Day != "" && option.Day < time.Now().In(LocationTokyo).Format(GraphDayLayout) {
+		ttl = APICachePastTTL
+	}
+	value, stale, err := api.Cache.Fetch(ctx, "counts?"+option.GetQuery(), ttl, func(ctx context.Context) (interface{}, error) {
+		return api.APIClient.GetCountsContext(ctx, option)
+	})
+	if err != nil {
+		return bikeshareapi.SpotInfo{}, false, err
+	}
+	return copySpotInfo(value.(bikeshareapi.SpotInfo)), stale, nil
+}
+
+//GetCountsContext 台数検索
+func (api *CachedAPIClient) GetCountsContext(ctx context.Context, option bikeshareapi.SearchCountsOption) (bikeshareapi.SpotInfo, error) {
+	info, _, err := api.FetchCounts(ctx, option)
+	return info, err
+}
+
+//GetCounts 台数検索
+func (api *CachedAPIClient) GetCounts(option bikeshareapi.SearchCountsOption) (bikeshareapi.SpotInfo, error) {
+	return api.GetCountsContext(context.Background(), option)
+}
+
+//FetchDistances 近いスポット検索
+func (api *CachedAPIClient) FetchDistances(ctx context.Context, option bikeshareapi.SearchDistanceOption) (distances bikeshareapi.DistanceInfo, stale bool, err error) {
+	value, stale, err := api.Cache.Fetch(ctx, "distances?"+option.GetQuery(), APICacheTTL, func(ctx context.Context) (interface{}, error) {
+		return api.APIClient.GetDistancesContext(ctx, option)
+	})
+	if err != nil {
+		return bikeshareapi.DistanceInfo{}, false, err
+	}
+	distances = value.(bikeshareapi.DistanceInfo)
+	spots := distances.Spots
+	distances.Spots = nil
+	for _, spot := range spots {
+		spot.SpotInfo = copySpotInfo(spot.SpotInfo)
+		distances.Spots = append(distances.Spots, spot)
+	}
+	return distances, stale, nil
+}
+
+//GetDistancesContext 近いスポット検索
+func (api *CachedAPIClient) GetDistancesContext(ctx context.Context, option bikeshareapi.SearchDistanceOption) (bikeshareapi.DistanceInfo, error) {
+	distances, _, err := api.FetchDistances(ctx, option)
+	return distances, err
+}
+
+//GetDistances 近いスポット検索
+func (api *CachedAPIClient) GetDistances(option bikeshareapi.SearchDistanceOption) (bikeshareapi.DistanceInfo, error) {
+	return api.GetDistancesContext(context.Background(), option)
+}
+
+//FetchGraph グラフ検索
+func (api *CachedAPIClient) FetchGraph(ctx context.Context, option bikeshareapi.SearchGraphOption) (graph bikeshareapi.GraphInfo, stale bool, err error) {
+	value, stale, err := api.Cache.Fetch(ctx, "graph?"+option.GetQuery(), APICacheTTL, func(ctx context.Context) (interface{}, error) {
+		return api.APIClient.GetGraphContext(ctx, option)
+	})
+	if err != nil {
+		return bikeshareapi.GraphInfo{}, false, err
+	}
+	graph = value.(bikeshareapi.GraphInfo)
+	graph.SpotInfo = copySpotInfo(graph.SpotInfo)
+	return graph, stale, nil
+}
+
+//GetGraphContext グラフ検索
+func (api *CachedAPIClient) GetGraphContext(ctx context.Context, option bikeshareapi.SearchGraphOption) (bikeshareapi.GraphInfo, error) {
+	graph, _, err := api.FetchGraph(ctx, option)
+	return graph, err
+}
+
+//GetGraph グラフ検索
+func (api *CachedAPIClient) GetGraph(option bikeshareapi.SearchGraphOption) (bikeshareapi.GraphInfo, error) {
+	return api.GetGraphContext(context.Background(), option)
+}
+
+//copySpotInfos 使い回す取得結果を呼び出し側で並べ替えても壊れないようにコピーする
+func copySpotInfos(spotinfos []bikeshareapi.SpotInfo) []bikeshareapi.SpotInfo {
+	buff := make([]bikeshareapi.SpotInfo, len(spotinfos))
+	for i, info := range spotinfos {
+		buff[i] = copySpotInfo(info)
+	}
+	return buff
+}
+
+//copySpotInfo 台数の履歴ごとコピーする
+func copySpotInfo(info bikeshareapi.SpotInfo) bikeshareapi.SpotInfo {
+	if info.Counts != nil {
+		info.Counts = append([]bikeshareapi.BikeCount{}, info.Counts...)
+	}
+	return info
+}
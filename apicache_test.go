@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAPICacheCoalesces(t *testing.T) {
+	cache := NewAPICache()
+	var calls int32
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "value", nil
+	}
+
+	const n = 5
+	var wg sync.WaitGroup
+	results := make([]interface{}, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value, stale, err := cache.Fetch(context.Background(), "places?q", time.Minute, fetch)
+			if err != nil || stale {
+				t.Errorf("%d: stale = %v, err = %v", i, stale, err)
+			}
+			results[i] = value
+		}(i)
+	}
+	//全員が待ち始めてから取得を終わらせる
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&calls) == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("calls = %d, want 1", got)
+	}
+	for i, value := range results {
+		if value != "value" {
+			t.Errorf("%d: value = %v", i, value)
+		}
+	}
+}
+
+func TestAPICacheFetchOutlivesFirstCaller(t *testing.T) {
+	cache := NewAPICache()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fetchErr := make(chan error, 1)
+	fetch := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-release
+		fetchErr <- ctx.Err()
+		return "value", nil
+	}
+
+	//最初の呼び出し元があきらめても、あとから待っている呼び出し元には結果が届く
+	first, cancel := context.WithCancel(context.Background())
+	firstDone := make(chan error, 1)
+	go func() {
+		_, _, err := cache.Fetch(first, "places?q", time.Minute, fetch)
+		firstDone <- err
+	}()
+	<-started
+	second := make(chan interface{}, 1)
+	go func() {
+		value, _, err := cache.Fetch(context.Background(), "places?q", time.Minute, fetch)
+		if err != nil {
+			t.Errorf("2番目: %v", err)
+		}
+		second <- value
+	}()
+	cancel()
+	if err := <-firstDone; !errors.Is(err, context.Canceled) {
+		t.Errorf("最初の呼び出し元 = %v", err)
+	}
+	close(release)
+	if err := <-fetchErr; err != nil {
+		t.Errorf("最初の呼び出し元のキャンセルが取得に伝わった: %v", err)
+	}
+	if value := <-second; value != "value" {
+		t.Errorf("2番目 = %v", value)
+	}
+	//結果は覚えている
+	value, _, err := cache.Fetch(context.Background(), "places?q", time.Minute, func(ctx context.Context) (interface{}, error) {
+		return nil, errors.New("呼ばれないはず")
+	})
+	if err != nil || value != "value" {
+		t.Errorf("value = %v, err = %v", value, err)
+	}
+}
+
+func TestAPICacheFetchTimeout(t *testing.T) {
+	cache := NewAPICache()
+	cache.FetchTimeout = 20 * time.Millisecond
+	//呼び出し元に期限がなくても、共有する取得はFetchTimeoutで打ち切る
+	_, _, err := cache.Fetch(context.Background(), "places?q", time.Minute, func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestAPICacheStale(t *testing.T) {
+	upstream := errors.New("503")
+	tests := []struct {
+		name       string
+		staleLimit time.Duration
+		err        error
+		wantStale  bool
+		wantErr    error
+	}{
+		{name: "APIが失敗したら前回の結果", staleLimit: time.Hour, err: upstream, wantStale: true},
+		{name: "古すぎる結果は使わない", staleLimit: time.Nanosecond, err: upstream, wantErr: upstream},
+		{name: "キャンセルはAPIの失敗ではない", staleLimit: time.Hour, err: context.Canceled, wantErr: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewAPICache()
+			cache.StaleLimit = tt.staleLimit
+			//すぐに期限が切れる結果
+			if _, _, err := cache.Fetch(context.Background(), "places?q", time.Nanosecond, func(ctx context.Context) (interface{}, error) {
+				return "old", nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond)
+
+			value, stale, err := cache.Fetch(context.Background(), "places?q", time.Minute, func(ctx context.Context) (interface{}, error) {
+				return nil, tt.err
+			})
+			if stale != tt.wantStale || err != tt.wantErr {
+				t.Fatalf("stale = %v, err = %v", stale, err)
+			}
+			if tt.wantStale && value != "old" {
+				t.Errorf("value = %v", value)
+			}
+		})
+	}
+}
+
+func TestAPICacheTTL(t *testing.T) {
+	cache := NewAPICache()
+	var calls int32
+	fetch := func(ctx context.Context) (interface{}, error) {
+		return atomic.AddInt32(&calls, 1), nil
+	}
+	const ttl = 30 * time.Millisecond
+	first, _, _ := cache.Fetch(context.Background(), "places?q", ttl, fetch)
+	second, _, _ := cache.Fetch(context.Background(), "places?q", ttl, fetch)
+	//別の内容は別に取得する
+	other, _, _ := cache.Fetch(context.Background(), "places?r", ttl, fetch)
+	if first != int32(1) || second != int32(1) || other != int32(2) {
+		t.Errorf("期限内 = %v, %v, 別の内容 = %v", first, second, other)
+	}
+	time.Sleep(ttl + 10*time.Millisecond)
+	if third, _, _ := cache.Fetch(context.Background(), "places?q", ttl, fetch); third != int32(3) {
+		t.Errorf("期限切れ = %v, want 3", third)
+	}
+}
+
+func TestAPICacheMaxEntries(t *testing.T) {
+	cache := NewAPICache()
+	cache.MaxEntries = 2
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Fetch(context.Background(), key, time.Minute, func(ctx context.Context) (interface{}, error) {
+			return key, nil
+		})
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := cache.entries["a"]; ok || len(cache.entries) != 2 {
+		t.Errorf("entries = %v", cache.entries)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	//APIDefaultTimeout 1回のリクエストで応答を待つ時間
+	APIDefaultTimeout = 10 * time.Second
+	//APIDefaultRetries GETが失敗したときにやり直す回数
+	APIDefaultRetries = 2
+	//APIRetryBackoff やり直すまでの待ち時間の基準（1回ごとに倍にして、前後にずらす）
+	APIRetryBackoff = 300 * time.Millisecond
+	//APIBreakerThreshold 続けて失敗したらAPIが止まっているとみなす回数
+	APIBreakerThreshold = 5
+	//APIBreakerCooldown APIが止まっているとみなしてからリクエストを送らない時間
+	APIBreakerCooldown = 30 * time.Second
+)
+
+//ErrAPIUnavailable APIが止まっているとみなしてリクエストを送らなかった
+var ErrAPIUnavailable = errors.New("APIが停止しているためリクエストを送りません")
+
+//APIStatusError APIがエラーのステータスコードを返した
+type APIStatusError struct {
+	StatusCode int
+	URL        string
+}
+
+func (e *APIStatusError) Error() string {
+	return fmt.Sprintf("APIがエラーを返しました(%d): %s", e.StatusCode, e.URL)
+}
+
+//APITimeoutError APIが時間内に応答しなかった
+type APITimeoutError struct {
+	URL string
+}
+
+func (e *APITimeoutError) Error() string {
+	return fmt.Sprintf("APIの応答がタイムアウトしました: %s", e.URL)
+}
+
+//APIErrorKind APIの失敗の種類（利用者に伝える内容を変える）
+type APIErrorKind string
+
+const (
+	//APIErrorTimeout 応答が遅い
+	APIErrorTimeout APIErrorKind = "timeout"
+	//APIErrorStatus エラーのステータスコードが返った
+	APIErrorStatus APIErrorKind = "status"
+	//APIErrorDecode レスポンスを読み取れない
+	APIErrorDecode APIErrorKind = "decode"
+	//APIErrorUnavailable 止まっているとみなして送らなかった
+	APIErrorUnavailable APIErrorKind = "unavailable"
+	//APIErrorNetwork 接続できないなどその他の失敗
+	APIErrorNetwork APIErrorKind = "network"
+)
+
+//APIErrorKindOf APIの失敗の種類を調べる
+//クライアントはエラーをそのまま返すので、JSONの読み取りエラーもここで見分ける
+func APIErrorKindOf(err error) APIErrorKind {
+	var statusErr *APIStatusError
+	var timeoutErr *APITimeoutError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrAPIUnavailable):
+		return APIErrorUnavailable
+	case errors.As(err, &timeoutErr), errors.Is(err, context.DeadlineExceeded):
+		return APIErrorTimeout
+	case errors.As(err, &statusErr):
+		return APIErrorStatus
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return APIErrorDecode
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return APIErrorTimeout
+	}
+	return APIErrorNetwork
+}
+
+//APIErrorText APIの失敗を利用者向けに説明する
+func APIErrorText(lang Lang, err error) string {
+	kind := APIErrorKindOf(err)
+	if kind == APIErrorStatus {
+		var statusErr *APIStatusError
+		errors.As(err, &statusErr)
+		return T(lang, "apierr.status", statusErr.StatusCode)
+	}
+	return T(lang, "apierr."+string(kind))
+}
+
+//APITransport BikeshareAPIへのリクエストにタイムアウト・やり直し・遮断を付ける
+//ステータスコードがエラーならエラーとして返す（クライアントは本文しか見ないため）
+type APITransport struct {
+	//Base 実際に送信するトランスポート
+	Base http.RoundTripper
+	//Timeout 1回のリクエストで応答を待つ時間
+	Timeout time.Duration
+	//Retries GETをやり直す回数
+	Retries int
+	//Backoff やり直すまでの待ち時間の基準
+	Backoff time.Duration
+	//Threshold 続けて失敗したら遮断する回数
+	Threshold int
+	//Cooldown 遮断している時間
+	Cooldown time.Duration
+
+	mu       sync.Mutex
+	breakers map[string]*apiBreaker
+	random   *rand.Rand
+}
+
+//apiBreaker ホストごとの失敗の状況
+type apiBreaker struct {
+	failures  int
+	openUntil time.Time
+	//probing 遮断の時間が過ぎて、様子を見るリクエストを1件だけ送っている
+	probing bool
+}
+
+//NewAPITransport 環境変数の設定を読んでトランスポートを作る
+func NewAPITransport() *APITransport {
+	transport := &APITransport{
+		Base:      http.DefaultTransport,
+		Timeout:   APIDefaultTimeout,
+		Retries:   APIDefaultRetries,
+		Backoff:   APIRetryBackoff,
+		Threshold: APIBreakerThreshold,
+		Cooldown:  APIBreakerCooldown,
+	}
+	if value := os.Getenv("API_TIMEOUT"); value != "" {
+		if timeout, err := time.ParseDuration(value); err == nil && timeout > 0 {
+			transport.Timeout = timeout
+		} else {
+			LogWarn(context.Background(), "API_TIMEOUTが不正です", LogFields{"value": value})
+		}
+	}
+	if value := os.Getenv("API_RETRIES"); value != "" {
+		if retries, err := strconv.Atoi(value); err == nil && retries >= 0 {
+			transport.Retries = retries
+		} else {
+			LogWarn(context.Background(), "API_RETRIESが不正です", LogFields{"value": value})
+		}
+	}
+	return transport
+}
+
+//RoundTrip リクエストを送信する（遮断中ならすぐにErrAPIUnavailableを返す）
+func (transport *APITransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	endpoint := path.Base(req.URL.Path)
+	allowed, probe := transport.allow(host, time.Now())
+	if !allowed {
+		MetricAPIRequests.Inc(endpoint, string(APIErrorUnavailable))
+		return nil, ErrAPIUnavailable
+	}
+	start := time.Now()
+	attempts := 1
+	if req.Method == http.MethodGet {
+		//同じ結果になるGETだけやり直す
+		attempts += transport.Retries
+	}
+	ctx := req.Context()
+	var resp *http.Response
+	var err error
+	tries := 0
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if !transport.sleep(ctx, attempt) {
+				break
+			}
+			LogWarn(ctx, "APIへのリクエストをやり直します", LogFields{"endpoint": endpoint, "attempt": attempt, "reason": describeAttempt(resp, err)})
+		}
+		resp, err = transport.send(req)
+		tries++
+		if !retryable(resp, err) {
+			break
+		}
+	}
+	transport.record(host, resp, err, probe, time.Now())
+	fields := LogFields{"endpoint": endpoint, "method": req.Method, "attempts": tries}
+	if resp != nil {
+		fields["status"] = resp.StatusCode
+	}
+	if err == nil && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		resp, err = nil, &APIStatusError{StatusCode: resp.StatusCode, URL: req.URL.Path}
+	}
+	elapsed := time.Since(start)
+	MetricAPIDuration.Observe(elapsed.Seconds(), endpoint)
+	MetricAPIRequests.Inc(endpoint, apiMetricResult(err))
+	addAPITiming(ctx, elapsed)
+	fields["duration_ms"] = elapsed.Milliseconds()
+	fields["result"] = apiMetricResult(err)
+	LogDebug(ctx, "APIを呼び出しました", fields)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+//send 1回だけ送信して本文まで読み込む（タイムアウトを本文の読み込みにも効かせるため）
+func (transport *APITransport) send(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), transport.Timeout)
+	defer cancel()
+	attempt := req.WithContext(ctx)
+	if req.GetBody != nil {
+		//POSTの本文は送るたびに作り直す
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		attempt.Body = body
+	}
+	resp, err := transport.base().RoundTrip(attempt)
+	if err == nil {
+		var body []byte
+		body, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if err != nil && ctx.Err() == context.DeadlineExceeded && req.Context().Err() == nil {
+		return nil, &APITimeoutError{URL: req.URL.Path}
+	}
+	return resp, err
+}
+
+//apiMetricResult リクエストの結果のラベルの値（失敗なら種類）
+func apiMetricResult(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	}
+	return string(APIErrorKindOf(err))
+}
+
+//base 実際に送信するトランスポート
+func (transport *APITransport) base() http.RoundTripper {
+	if transport.Base != nil {
+		return transport.Base
+	}
+	return http.DefaultTransport
+}
+
+//retryable やり直せば成功する見込みがあるか（接続できない・タイムアウト・5xx・429）
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
+
+//describeAttempt 失敗した1回の内容（ログ用）
+func describeAttempt(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
+
+//sleep やり直す前に待つ（キャンセルされたらfalse）
+//待ち時間は1回ごとに倍にして、同時に失敗したリクエストが重ならないように±50%ずらす
+func (transport *APITransport) sleep(ctx context.Context, attempt int) bool {
+	wait := transport.Backoff << uint(attempt-1)
+	transport.mu.Lock()
+	if transport.random == nil {
+		transport.random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	wait = time.Duration(float64(wait) * (0.5 + transport.random.Float64()))
+	transport.mu.Unlock()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+//allow 遮断していなければtrue
+//遮断の時間が過ぎたら1件だけ送ってみて（probeがtrue）、その結果が出るまでほかは遮断したままにする
+//また失敗すればすぐに遮断し直す
+func (transport *APITransport) allow(host string, now time.Time) (allowed bool, probe bool) {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	breaker, ok := transport.breakers[host]
+	if !ok || breaker.openUntil.IsZero() {
+		return true, false
+	}
+	if now.Before(breaker.openUntil) || breaker.probing {
+		return false, false
+	}
+	breaker.probing = true
+	return true, true
+}
+
+//record 結果を記録して、続けて失敗していれば遮断する
+//4xxはリクエストの問題なのでAPIの失敗に数えない。キャンセルは成功にも失敗にも数えない
+func (transport *APITransport) record(host string, resp *http.Response, err error, probe bool, now time.Time) {
+	failed := err != nil
+	if err == nil && resp.StatusCode >= 500 {
+		failed = true
+	}
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	if transport.breakers == nil {
+		transport.breakers = map[string]*apiBreaker{}
+	}
+	breaker, ok := transport.breakers[host]
+	if !ok {
+		breaker = &apiBreaker{}
+		transport.breakers[host] = breaker
+	}
+	if probe {
+		breaker.probing = false
+	}
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if !failed {
+		breaker.failures = 0
+		breaker.openUntil = time.Time{}
+		return
+	}
+	breaker.failures++
+	if breaker.failures >= transport.Threshold {
+		if now.After(breaker.openUntil) {
+			LogError(context.Background(), "APIが続けて失敗したためしばらくリクエストを止めます", LogFields{"failures": breaker.failures, "cooldown": transport.Cooldown, "host": host})
+		}
+		breaker.openUntil = now.Add(transport.Cooldown)
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//roundTripFunc 関数をトランスポートにする
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+//stubResponse 指定したステータスコードと本文のレスポンス
+func stubResponse(req *http.Request, status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Header:     http.Header{},
+		Request:    req,
+	}
+}
+
+//newTestTransport すぐにやり直すトランスポート
+func newTestTransport(base http.RoundTripper) *APITransport {
+	return &APITransport{
+		Base:      base,
+		Timeout:   time.Second,
+		Retries:   2,
+		Backoff:   time.Millisecond,
+		Threshold: 3,
+		Cooldown:  time.Hour,
+	}
+}
+
+//statusServer 指定した順にステータスコードを返す（足りなければ最後のものを繰り返す）偽のAPI
+func statusServer(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1))
+		if n > len(statuses) {
+			n = len(statuses)
+		}
+		w.WriteHeader(statuses[n-1])
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestAPITransportRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		statuses  []int
+		wantCalls int32
+		wantErr   APIErrorKind
+	}{
+		{name: "GETは3回目で成功", method: "GET", statuses: []int{503, 502, 200}, wantCalls: 3},
+		{name: "GETはRetries回までやり直す", method: "GET", statuses: []int{500}, wantCalls: 3, wantErr: APIErrorStatus},
+		{name: "429もやり直す", method: "GET", statuses: []int{429, 200}, wantCalls: 2},
+		{name: "POSTはやり直さない", method: "POST", statuses: []int{503, 200}, wantCalls: 1, wantErr: APIErrorStatus},
+		{name: "4xxはやり直さない", method: "GET", statuses: []int{404, 200}, wantCalls: 1, wantErr: APIErrorStatus},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, calls := statusServer(t, tt.statuses...)
+			client := &http.Client{Transport: newTestTransport(nil)}
+			req, _ := http.NewRequest(tt.method, server.URL+"/private/user", strings.NewReader(`{"LineID":"U1"}`))
+			resp, err := client.Do(req)
+			if resp != nil {
+				resp.Body.Close()
+			}
+			if got := atomic.LoadInt32(calls); got != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", got, tt.wantCalls)
+			}
+			if tt.wantErr == "" {
+				if err != nil || resp.StatusCode != 200 {
+					t.Errorf("err = %v", err)
+				}
+				return
+			}
+			if err == nil || APIErrorKindOf(err) != tt.wantErr {
+				t.Errorf("err = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPITransportDoesNotRetryPostOnNetworkError(t *testing.T) {
+	var bodies []string
+	transport := newTestTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		return nil, errors.New("connection reset")
+	}))
+	req, _ := http.NewRequest("POST", "http://api.test/private/user", strings.NewReader(`{"LineID":"U1"}`))
+	if _, err := transport.RoundTrip(req); err == nil {
+		t.Fatal("失敗しなかった")
+	}
+	if len(bodies) != 1 || bodies[0] != `{"LineID":"U1"}` {
+		t.Errorf("bodies = %q", bodies)
+	}
+}
+
+func TestAPITransportBreaker(t *testing.T) {
+	server, calls := statusServer(t, 500)
+	transport := newTestTransport(nil)
+	transport.Retries = 0
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < transport.Threshold; i++ {
+		if _, err := client.Get(server.URL + "/places"); APIErrorKindOf(err) != APIErrorStatus {
+			t.Fatalf("%d回目: %v", i+1, err)
+		}
+	}
+	//続けて失敗したので送らない
+	_, err := client.Get(server.URL + "/places")
+	if !errors.Is(err, ErrAPIUnavailable) || APIErrorKindOf(err) != APIErrorUnavailable {
+		t.Errorf("err = %v", err)
+	}
+	if got := atomic.LoadInt32(calls); got != int32(transport.Threshold) {
+		t.Errorf("calls = %d, want %d", got, transport.Threshold)
+	}
+}
+
+func TestAPITransportBreakerIgnoresClientErrors(t *testing.T) {
+	server, calls := statusServer(t, 404)
+	transport := newTestTransport(nil)
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < transport.Threshold*2; i++ {
+		_, err := client.Get(server.URL + "/places")
+		if errors.Is(err, ErrAPIUnavailable) {
+			t.Fatalf("%d回目で遮断した", i+1)
+		}
+	}
+	if got := atomic.LoadInt32(calls); got != int32(transport.Threshold*2) {
+		t.Errorf("calls = %d", got)
+	}
+}
+
+func TestAPITransportBreakerIgnoresCanceled(t *testing.T) {
+	transport := newTestTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}))
+	for i := 0; i < transport.Threshold*2; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req, _ := http.NewRequest("GET", "http://api.test/places", nil)
+		if _, err := transport.RoundTrip(req.WithContext(ctx)); errors.Is(err, ErrAPIUnavailable) {
+			t.Fatalf("%d回目で遮断した", i+1)
+		}
+	}
+}
+
+func TestAPITransportHalfOpenSendsOneProbe(t *testing.T) {
+	var calls int32
+	var fail int32 = 1
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	transport := newTestTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&fail) == 1 {
+			return stubResponse(req, 503, ""), nil
+		}
+		started <- struct{}{}
+		<-release
+		return stubResponse(req, 200, "{}"), nil
+	}))
+	transport.Retries = 0
+	transport.Cooldown = 20 * time.Millisecond
+	get := func() error {
+		req, _ := http.NewRequest("GET", "http://api.test/places", nil)
+		_, err := transport.RoundTrip(req)
+		return err
+	}
+	for i := 0; i < transport.Threshold; i++ {
+		get()
+	}
+	if err := get(); !errors.Is(err, ErrAPIUnavailable) {
+		t.Fatalf("遮断していない: %v", err)
+	}
+
+	//遮断の時間が過ぎたら様子を見る1件だけを送る
+	time.Sleep(transport.Cooldown + 10*time.Millisecond)
+	atomic.StoreInt32(&fail, 0)
+	var wg sync.WaitGroup
+	var probeErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		probeErr = get()
+	}()
+	<-started
+	for i := 0; i < 3; i++ {
+		if err := get(); !errors.Is(err, ErrAPIUnavailable) {
+			t.Errorf("様子見の間に送った: %v", err)
+		}
+	}
+	close(release)
+	wg.Wait()
+	if probeErr != nil {
+		t.Fatalf("様子見のリクエスト: %v", probeErr)
+	}
+	if got := atomic.LoadInt32(&calls); got != int32(transport.Threshold)+1 {
+		t.Errorf("calls = %d, want %d", got, transport.Threshold+1)
+	}
+	//成功したら元に戻る
+	if err := get(); err != nil {
+		t.Errorf("成功した後も遮断している: %v", err)
+	}
+}
+
+func TestAPITransportHalfOpenProbeFails(t *testing.T) {
+	var calls int32
+	transport := newTestTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		return stubResponse(req, 503, ""), nil
+	}))
+	transport.Retries = 0
+	transport.Cooldown = 20 * time.Millisecond
+	get := func() error {
+		req, _ := http.NewRequest("GET", "http://api.test/places", nil)
+		_, err := transport.RoundTrip(req)
+		return err
+	}
+	for i := 0; i < transport.Threshold; i++ {
+		get()
+	}
+	time.Sleep(transport.Cooldown + 10*time.Millisecond)
+	if err := get(); APIErrorKindOf(err) != APIErrorStatus {
+		t.Fatalf("様子見のリクエスト: %v", err)
+	}
+	//また失敗したのですぐに遮断し直す
+	if err := get(); !errors.Is(err, ErrAPIUnavailable) {
+		t.Errorf("遮断し直していない: %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != int32(transport.Threshold)+1 {
+		t.Errorf("calls = %d", got)
+	}
+}
+
+func TestAPIErrorKindOf(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	tests := []struct {
+		name string
+		base http.RoundTripper
+		url  string
+		want APIErrorKind
+	}{
+		{
+			name: "タイムアウト",
+			base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				<-req.Context().Done()
+				return nil, req.Context().Err()
+			}),
+			want: APIErrorTimeout,
+		},
+		{
+			name: "エラーのステータスコード",
+			base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return stubResponse(req, 404, "not found"), nil
+			}),
+			want: APIErrorStatus,
+		},
+		{
+			name: "接続できない",
+			url:  closed.URL + "/places",
+			want: APIErrorNetwork,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := newTestTransport(tt.base)
+			transport.Retries = 0
+			transport.Timeout = 10 * time.Millisecond
+			url := tt.url
+			if url == "" {
+				url = "http://api.test/places"
+			}
+			_, err := (&http.Client{Transport: transport}).Get(url)
+			if got := APIErrorKindOf(err); got != tt.want {
+				t.Errorf("APIErrorKindOf(%v) = %s, want %s", err, got, tt.want)
+			}
+		})
+	}
+
+	//エラーの種類ごとの説明
+	var syntaxErr error = &json.SyntaxError{}
+	if got := APIErrorKindOf(syntaxErr); got != APIErrorDecode {
+		t.Errorf("JSONの読み取りエラー = %s", got)
+	}
+	if got := APIErrorKindOf(fmt.Errorf("wrapped: %w", ErrAPIUnavailable)); got != APIErrorUnavailable {
+		t.Errorf("遮断 = %s", got)
+	}
+	if got := APIErrorText(LangJa, &APIStatusError{StatusCode: 503}); got != T(LangJa, "apierr.status", 503) {
+		t.Errorf("APIErrorText = %q", got)
+	}
+	if got := APIErrorText(LangEn, ErrAPIUnavailable); got != T(LangEn, "apierr.unavailable") {
+		t.Errorf("APIErrorText = %q", got)
+	}
+}
+
+func TestAPIClientGraphURL(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{endpoint: "https://hanetwi.ddns.net/bikeshare/api/v1/", want: "https://hanetwi.ddns.net/bikeshare/graph?"},
+		{endpoint: "http://127.0.0.1:8080/", want: "http://127.0.0.1:8080/graph?"},
+	}
+	for _, tt := range tests {
+		api := &APIClient{}
+		api.Endpoint = tt.endpoint
+		if got := api.graphURL(); got != tt.want {
+			t.Errorf("graphURL(%s) = %s, want %s", tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestAPIClientDecodeError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"items": [`))
+	}))
+	defer server.Close()
+	savedAPI := BikeshareAPI
+	defer func() { BikeshareAPI = savedAPI }()
+	SetupBikeshareAPI(server.URL + "/")
+
+	_, err := BikeshareAPI.GetAllSpotNamesContext(context.Background())
+	if got := APIErrorKindOf(err); got != APIErrorDecode {
+		t.Errorf("APIErrorKindOf(%v) = %s, want %s", err, got, APIErrorDecode)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	bikeshareapi "github.com/8245snake/bikeshare-client"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+const (
+	//WebhookBudget 1つのイベントの処理にかけてよい時間（返信トークンが切れる前に返信する）
+	WebhookBudget = 20 * time.Second
+	//SlackCommandBudget スラッシュコマンドの処理にかけてよい時間（Slackは3秒以内の応答を求める）
+	SlackCommandBudget = 2500 * time.Millisecond
+	//APIBackgroundBudget 定期処理など利用者を待たせない処理でAPIを待つ時間
+	APIBackgroundBudget = 30 * time.Second
+	//APIReplyReserve 期限のうちAPIに使わずに返信のために残しておく時間（最大）
+	APIReplyReserve = 2 * time.Second
+	//apiVersionPath エンドポイントのうちAPIのバージョンを表す部分（グラフ検索はこの外にある）
+	apiVersionPath = "api/v1/"
+)
+
+//startEvent イベントの処理の期限とログに付ける項目を決める（処理が終わったらdoneを呼ぶ）
+//Webhookの接続が切れても返信はできるので、リクエストのcontextからは作らない
+func startEvent(event *linebot.Event) (ctx context.Context, done func()) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), WebhookBudget)
+	fields := LogFields{"event_type": string(event.Type), "user": HashUserID(SourceID(event))}
+	if event.Source != nil {
+		fields["source"] = string(event.Source.Type)
+	}
+	ctx = WithLogFields(ctx, fields)
+	return ctx, func() {
+		if ctx.Err() == context.DeadlineExceeded {
+			LogWarn(ctx, "イベントの処理が期限を過ぎました", logTimings(ctx, start))
+		} else {
+			LogInfo(ctx, "イベントを処理しました", logTimings(ctx, start))
+		}
+		cancel()
+	}
+}
+
+//apiRequestContext 1回のAPIリクエストの期限
+//失敗しても返信が間に合うように、残り時間の1/5（最大APIReplyReserve）を残して打ち切る
+func apiRequestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	reserve := time.Until(deadline) / 5
+	if reserve > APIReplyReserve {
+		reserve = APIReplyReserve
+	}
+	return context.WithDeadline(ctx, deadline.Add(-reserve))
+}
+
+//APIClient BikeshareAPIクライアントにcontextを受け取るメソッドを足したもの
+//ctxがキャンセルされるか期限を過ぎたらリクエストを打ち切る
+type APIClient struct {
+	bikeshareapi.ApiClient
+}
+
+//GetPlacesContext 駐輪場検索
+func (api *APIClient) GetPlacesContext(ctx context.Context, option bikeshareapi.SearchPlacesOption) ([]bikeshareapi.SpotInfo, error) {
+	var data static.JPlacesBody
+	if err := api.getJSON(ctx, api.Endpoint+"places?"+option.GetQuery(), &data); err != nil {
+		return []bikeshareapi.SpotInfo{}, err
+	}
+	return bikeshareapi.GetSpotInfoListByPlaces(data), nil
+}
+
+//GetCountsContext 台数検索
+func (api *APIClient) GetCountsContext(ctx context.Context, option bikeshareapi.SearchCountsOption) (bikeshareapi.SpotInfo, error) {
+	var data static.JCountsBody
+	if err := api.getJSON(ctx, api.Endpoint+"counts?"+option.GetQuery(), &data); err != nil {
+		return bikeshareapi.SpotInfo{}, err
+	}
+	return bikeshareapi.GetSpotInfoByJCount(data), nil
+}
+
+//GetDistancesContext 近いスポット検索
+func (api *APIClient) GetDistancesContext(ctx context.Context, option bikeshareapi.SearchDistanceOption) (bikeshareapi.DistanceInfo, error) {
+	var data static.JDistancesBody
+	if err := api.getJSON(ctx, api.Endpoint+"distances?"+option.GetQuery(), &data); err != nil {
+		return bikeshareapi.DistanceInfo{}, err
+	}
+	distanceInfo := bikeshareapi.DistanceInfo{BaseLat: option.Lat, BaseLon: option.Lon}
+	distances := bikeshareapi.GetDistanceList(data)
+	for i, item := range bikeshareapi.GetSpotInfoListByDistance(data) {
+		distanceInfo.Spots = append(distanceInfo.Spots, struct {
+			SpotInfo bikeshareapi.SpotInfo
+			Distance string
+		}{SpotInfo: item, Distance: distances[i]})
+	}
+	return distanceInfo, nil
+}
+
+//GetAllSpotNamesContext すべてのスポットの名前だけ検索
+func (api *APIClient) GetAllSpotNamesContext(ctx context.Context) ([]bikeshareapi.SpotName, error) {
+	var data static.JAllPlacesBody
+	if err := api.getJSON(ctx, api.Endpoint+"all_places", &data); err != nil {
+		return []bikeshareapi.SpotName{}, err
+	}
+	var names []bikeshareapi.SpotName
+	for _, item := range data.Items {
+		names = append(names, bikeshareapi.SpotName{Area: item.Area, Spot: item.Spot, Name: item.Name})
+	}
+	return names, nil
+}
+
+//GetGraphContext グラフ検索
+func (api *APIClient) GetGraphContext(ctx context.Context, option bikeshareapi.SearchGraphOption) (bikeshareapi.GraphInfo, error) {
+	var data static.JGraphResponse
+	if err := api.getJSON(ctx, api.graphURL()+option.GetQuery(), &data); err != nil {
+		return bikeshareapi.GraphInfo{}, err
+	}
+	return bikeshareapi.GetGraphInfoByJGraphResponse(data), nil
+}
+
+//graphURL グラフ検索のURL（エンドポイントからAPIのバージョンを除いた場所にある）
+func (api *APIClient) graphURL() string {
+	return strings.TrimSuffix(api.Endpoint, apiVersionPath) + "graph?"
+}
+
+//GetUsersContext ユーザ情報取得
+func (api *APIClient) GetUsersContext(ctx context.Context) ([]bikeshareapi.Users, error) {
+	var data static.JUsers
+	if err := api.getJSON(ctx, api.Endpoint+"private/users", &data); err != nil {
+		return nil, err
+	}
+	return usersFromJSON(data), nil
+}
+
+//UpdateUserContext ユーザー更新
+func (api *APIClient) UpdateUserContext(ctx context.Context, user bikeshareapi.Users) ([]bikeshareapi.Users, error) {
+	juser := static.JUser{LineID: user.LineID, SlackID: user.SlackID, Favorites: user.Favorites, Histories: user.Histories, Notifies: user.Notifies}
+	var data static.JUsers
+	if err := api.sendJSON(ctx, http.MethodPost, api.Endpoint+"private/user", juser, &data); err != nil {
+		return nil, err
+	}
+	return usersFromJSON(data), nil
+}
+
+//GetStatusContext サービス稼働状況取得
+func (api *APIClient) GetStatusContext(ctx context.Context) (static.JServiceStatus, error) {
+	var data static.JServiceStatus
+	err := api.getJSON(ctx, api.Endpoint+"status", &data)
+	return data, err
+}
+
+//usersFromJSON APIのユーザー情報をクライアントの型にする
+func usersFromJSON(data static.JUsers) []bikeshareapi.Users {
+	var users []bikeshareapi.Users
+	for _, jUser := range data.Users {
+		users = append(users, bikeshareapi.Users{
+			LineID:    jUser.LineID,
+			SlackID:   jUser.SlackID,
+			Favorites: jUser.Favorites,
+			Histories: jUser.Histories,
+			Notifies:  jUser.Notifies,
+		})
+	}
+	return users
+}
+
+//getJSON GETリクエストを送信してレスポンスをdataに読み込む
+func (api *APIClient) getJSON(ctx context.Context, url string, data interface{}) error {
+	return api.sendJSON(ctx, http.MethodGet, url, nil, data)
+}
+
+//sendJSON リクエストを送信してレスポンスをdataに読み込む（payloadがnilでなければJSONで送る）
+func (api *APIClient) sendJSON(ctx context.Context, method, url string, payload interface{}, data interface{}) error {
+	ctx, cancel := apiRequestContext(ctx)
+	defer cancel()
+	var body io.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("cert", api.CertKey)
+	client := api.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	byteArray, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	} else if len(byteArray) == 0 {
+		return fmt.Errorf("レスポンスのデータ長が不正です")
+	}
+	return json.Unmarshal(byteArray, data)
+}
@@ -1,44 +1,92 @@
 package main
 
 import (
+	"context"
 	"strings"
 
 	"github.com/line/line-bot-sdk-go/linebot"
 )
 
+//commandAliases 入力しやすい短いコマンド名
+var commandAliases = map[PostBackCommandType]PostBackCommandType{
+	"fav": PostBackCommandTypeFavoriteList,
+}
+
 //ParseComamnd パース
+//「/fav 会社」はお気に入りグループの表示、「/fav 会社 A1-01」はお気に入りグループへの追加
+//「/snooze 3d」「/quiet 22:00-07:00」「/vacation 8/10 8/16」は通知の一時停止（offで解除）
 func ParseComamnd(data string) (postback PostBackCommand) {
-	command := strings.Replace(strings.TrimSpace(data), "/", "", 1)
-	postback.Type = PostBackCommandType(command)
+	fields := strings.Fields(strings.Replace(strings.TrimSpace(data), "/", "", 1))
+	if len(fields) == 0 {
+		return
+	}
+	postback.Type = PostBackCommandType(fields[0])
+	if alias, ok := commandAliases[postback.Type]; ok {
+		postback.Type = alias
+	}
+	switch kind := NotifyPauseKind(postback.Type); kind {
+	case NotifyPauseSnooze, NotifyPauseQuiet, NotifyPauseVacation:
+		postback.Type = PostBackCommandTypePause
+		postback.Target = string(kind)
+		postback.Value = strings.Join(fields[1:], " ")
+		postback.Mode = PostBackCommandModeReg
+		if isPauseOff(postback.Value) {
+			postback.Mode = PostBackCommandModeUnreg
+		}
+		return
+	}
+	if postback.Type != PostBackCommandTypeFavoriteList || len(fields) < 2 {
+		return
+	}
+	postback.Target = fields[1]
+	if len(fields) >= 3 {
+		postback.Type = PostBackCommandTypeFavoriteGroup
+		postback.Mode = PostBackCommandModeReg
+		postback.Area, postback.Spot = SplitAreaSpot(strings.ToUpper(fields[2]))
+	}
 	return
 }
 
 //CommandHandler コマンドを処理
-func CommandHandler(event *linebot.Event, message *linebot.TextMessage) {
+func CommandHandler(ctx context.Context, event *linebot.Event, message *linebot.TextMessage) {
 	command := ParseComamnd(message.Text)
+	SetLogField(ctx, "command", string(command.Type))
 	switch command.Type {
 	case PostBackCommandTypeAnalyze:
-		ReplyToPostbackAnalyze(event, &command)
+		ReplyToPostbackAnalyze(ctx, event, &command)
 	case PostBackCommandTypeHistory:
-		ReplyToPostbackHistory(event, &command)
+		ReplyToPostbackHistory(ctx, event, &command)
 	case PostBackCommandTypeCommands:
-		ReplyToPostbackCommand(event, &command)
+		ReplyToPostbackCommand(ctx, event, &command)
 	case PostBackCommandTypeFavoriteList:
-		ReplyToPostbackFavList(event, &command)
+		ReplyToPostbackFavList(ctx, event, &command)
 	case PostBackCommandTypeFavorite:
-		ReplyToPostbackFav(event, &command)
+		ReplyToPostbackFav(ctx, event, &command)
+	case PostBackCommandTypeFavoriteGroup:
+		ReplyToPostbackFavoriteGroup(ctx, event, &command)
 	case PostBackCommandTypeDatePicker:
-		ReplyToPostbackDatePicker(event, &command)
+		ReplyToPostbackDatePicker(ctx, event, &command)
 	case PostBackCommandTypeConfigOpen:
-		ReplyToPostbackConfigOpen(event, &command)
+		ReplyToPostbackConfigOpen(ctx, event, &command)
 	case PostBackCommandTypeNotify:
-		ReplyToPostbackNotifyConfig(event, &command)
+		ReplyToPostbackNotifyConfig(ctx, event, &command)
 	case PostBackCommandTypeStatus:
-		ReplyToPostbackServiceStatus(event, &command)
+		ReplyToPostbackServiceStatus(ctx, event, &command)
 	case PostBackCommandTypeRanking:
-		ReplyToPostbackRanking(event, &command)
+		ReplyToPostbackRanking(ctx, event, &command)
+	case PostBackCommandTypeAlert:
+		ReplyToPostbackAlertConfig(ctx, event, &command)
+	case PostBackCommandTypeAnnounce:
+		ReplyToPostbackAnnounceConfig(ctx, event, &command)
+	case PostBackCommandTypeTrip:
+		ReplyToPostbackTrip(ctx, event, &command)
+	case PostBackCommandTypeSlack:
+		ReplyToPostbackSlack(ctx, event, &command)
+	case PostBackCommandTypePause:
+		ReplyToPostbackPause(ctx, event, &command)
 	case PostBackCommandTypeLacation:
-		reply := linebot.NewTextMessage("現在メニューから位置情報検索ができません。\n↓にある「位置情報で検索」をタップしてください").WithQuickReplies(CreateQuickReplyItems())
-		ReplyMessage(event.ReplyToken, reply)
+		lang := GetUserLang(SourceID(event))
+		reply := linebot.NewTextMessage(T(lang, "location.menu")).WithQuickReplies(CreateQuickReplyItems(lang))
+		ReplyMessage(ctx, event.ReplyToken, reply)
 	}
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bikeshareapi "github.com/8245snake/bikeshare-client"
+	"github.com/8245snake/bikeshare-line/graph"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+)
+
+//fakeSpot 偽のAPIが返すスポット
+type fakeSpot struct {
+	Area, Spot, Name string
+	Lat, Lon         float64
+}
+
+//fakeSpots 偽のAPIが返すスポット一覧
+var fakeSpots = []fakeSpot{
+	{Area: "A1", Spot: "01", Name: "千代田区役所", Lat: 35.694003, Lon: 139.753634},
+	{Area: "A1", Spot: "02", Name: "東京駅八重洲口", Lat: 35.680722, Lon: 139.769828},
+	{Area: "A1", Spot: "03", Name: "秋葉原駅前", Lat: 35.698353, Lon: 139.773114},
+	{Area: "B2", Spot: "01", Name: "中央区役所", Lat: 35.670812, Lon: 139.772084},
+	{Area: "B2", Spot: "02", Name: "銀座四丁目", Lat: 35.671700, Lon: 139.765000},
+	{Area: "C3", Spot: "01", Name: "港区役所", Lat: 35.658068, Lon: 139.751599},
+	{Area: "C3", Spot: "02", Name: "新橋駅前", Lat: 35.666379, Lon: 139.758289},
+	{Area: "D4", Spot: "01", Name: "新宿区役所", Lat: 35.693840, Lon: 139.703549},
+}
+
+//NewFakeBikeshareServer 固定のデータを返すBikeshareAPIの偽サーバー（REPLでの動作確認用）
+//エンドポイントは戻り値のURLに"/"を付けたもの（グラフ検索も同じ場所の/graphで受ける）
+func NewFakeBikeshareServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/places", fakePlacesHandler)
+	mux.HandleFunc("/counts", fakeCountsHandler)
+	mux.HandleFunc("/distances", fakeDistancesHandler)
+	mux.HandleFunc("/all_places", fakeAllPlacesHandler)
+	mux.HandleFunc("/status", func(w http.ResponseWriter, req *http.Request) {
+		writeFakeJSON(w, static.JServiceStatus{Status: static.StatusOK, Connection: static.StatusOK, Scraping: static.StatusOK})
+	})
+	mux.HandleFunc("/private/users", func(w http.ResponseWriter, req *http.Request) {
+		writeFakeJSON(w, static.JUsers{Users: []static.JUser{}})
+	})
+	mux.HandleFunc("/private/user", func(w http.ResponseWriter, req *http.Request) {
+		//保存はしないで受け取ったものを返す
+		var user static.JUser
+		json.NewDecoder(req.Body).Decode(&user)
+		writeFakeJSON(w, static.JUsers{Users: []static.JUser{user}})
+	})
+	mux.HandleFunc("/graph", fakeGraphHandler)
+	//グラフ画像はこのボットの描画処理をそのまま使う
+	mux.HandleFunc("/graph.png", GraphHandler)
+	return httptest.NewServer(mux)
+}
+
+//writeFakeJSON JSONで返す
+func writeFakeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		LogError(context.Background(), "偽のAPIの応答に失敗しました", LogFields{"error": err})
+	}
+}
+
+//fakeCount 時刻から決まる台数（同じ時刻なら毎回同じ値）
+func fakeCount(spot fakeSpot, t time.Time) int {
+	seed := 0
+	for _, r := range spot.Area + spot.Spot {
+		seed += int(r)
+	}
+	minutes := float64(t.Hour()*60 + t.Minute())
+	return 10 + seed%7 + int(8*math.Sin(minutes/1440*2*math.Pi+float64(seed)))
+}
+
+//fakeRecent 最新の台数
+func fakeRecent(spot fakeSpot) static.Recent {
+	now := time.Now().In(LocationTokyo).Truncate(20 * time.Minute)
+	return static.Recent{Count: strconv.Itoa(fakeCount(spot, now)), Datetime: now.Format(bikeshareapi.JsonTimeLayout)}
+}
+
+//fakePlace スポット情報
+func fakePlace(spot fakeSpot) static.JPlaces {
+	return static.JPlaces{
+		Area:   spot.Area,
+		Spot:   spot.Spot,
+		Name:   spot.Name,
+		Lat:    strconv.FormatFloat(spot.Lat, 'f', 6, 64),
+		Lon:    strconv.FormatFloat(spot.Lon, 'f', 6, 64),
+		Recent: fakeRecent(spot),
+	}
+}
+
+//fakePlacesHandler 駐輪場検索
+func fakePlacesHandler(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	places := make(map[string]bool)
+	for _, code := range strings.Split(query.Get("places"), ",") {
+		if code != "" {
+			places[code] = true
+		}
+	}
+	var items []static.JPlaces
+	for _, spot := range fakeSpots {
+		if len(places) > 0 && !places[spot.Area+"-"+spot.Spot] {
+			continue
+		}
+		if area := query.Get("area"); area != "" && area != spot.Area {
+			continue
+		}
+		if code := query.Get("spot"); code != "" && code != spot.Spot {
+			continue
+		}
+		if q := query.Get("q"); q != "" && !strings.Contains(spot.Name, q) {
+			continue
+		}
+		items = append(items, fakePlace(spot))
+	}
+	if query.Get("sort") == "countd" {
+		sort.SliceStable(items, func(i, j int) bool {
+			a, _ := strconv.Atoi(items[i].Recent.Count)
+			b, _ := strconv.Atoi(items[j].Recent.Count)
+			return a > b
+		})
+	}
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	writeFakeJSON(w, static.JPlacesBody{Num: len(items), Items: items})
+}
+
+//fakeCountsHandler 台数検索（1日分を20分おきに返す）
+func fakeCountsHandler(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	for _, spot := range fakeSpots {
+		if spot.Area != query.Get("area") || spot.Spot != query.Get("spot") {
+			continue
+		}
+		now := time.Now().In(LocationTokyo)
+		day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, LocationTokyo)
+		end := now
+		if value := query.Get("day"); value != "" {
+			if t, err := time.ParseInLocation("20060102", value, LocationTokyo); err == nil && t.Before(day) {
+				day = t
+				end = t.AddDate(0, 0, 1)
+			}
+		}
+		body := static.JCountsBody{Area: spot.Area, Spot: spot.Spot, Name: spot.Name}
+		for t := day; t.Before(end); t = t.Add(20 * time.Minute) {
+			body.Counts = append(body.Counts, static.JCount{Count: strconv.Itoa(fakeCount(spot, t)), Datetime: t.Format(bikeshareapi.JsonTimeLayout)})
+		}
+		writeFakeJSON(w, body)
+		return
+	}
+	writeFakeJSON(w, static.JCountsBody{})
+}
+
+//fakeGraphHandler グラフ検索（画像のURLは/graph.pngを指す）
+func fakeGraphHandler(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	for _, spot := range fakeSpots {
+		if spot.Area != query.Get("area") || spot.Spot != query.Get("spot") {
+			continue
+		}
+		values := url.Values{}
+		values.Set("area", spot.Area)
+		values.Set("spot", spot.Spot)
+		if days := query.Get("days"); days != "" {
+			values.Set("days", days)
+		}
+		signGraphValues(values)
+		writeFakeJSON(w, static.JGraphResponse{
+			Title:  fmt.Sprintf("[%s-%s] %s", spot.Area, spot.Spot, spot.Name),
+			Width:  strconv.Itoa(graph.DefaultWidth),
+			Height: strconv.Itoa(graph.DefaultHeight),
+			URL:    "http://" + req.Host + "/graph.png?" + values.Encode(),
+			Item:   fakePlace(spot),
+		})
+		return
+	}
+	writeFakeJSON(w, static.JGraphResponse{})
+}
+
+//fakeDistancesHandler 近いスポット検索
+func fakeDistancesHandler(w http.ResponseWriter, req *http.Request) {
+	lat, _ := strconv.ParseFloat(req.URL.Query().Get("lat"), 64)
+	lon, _ := strconv.ParseFloat(req.URL.Query().Get("lon"), 64)
+	spots := make([]fakeSpot, len(fakeSpots))
+	copy(spots, fakeSpots)
+	sort.SliceStable(spots, func(i, j int) bool {
+		return distanceMeters(lat, lon, spots[i].Lat, spots[i].Lon) < distanceMeters(lat, lon, spots[j].Lat, spots[j].Lon)
+	})
+	var items []static.JDistances
+	for _, spot := range spots {
+		place := fakePlace(spot)
+		items = append(items, static.JDistances{
+			Area:     place.Area,
+			Spot:     place.Spot,
+			Name:     place.Name,
+			Lat:      place.Lat,
+			Lon:      place.Lon,
+			Distance: fmt.Sprintf("%dm", int(distanceMeters(lat, lon, spot.Lat, spot.Lon))),
+			Recent:   place.Recent,
+		})
+	}
+	writeFakeJSON(w, static.JDistancesBody{Num: len(items), Items: items})
+}
+
+//fakeAllPlacesHandler すべてのスポットの名前
+func fakeAllPlacesHandler(w http.ResponseWriter, req *http.Request) {
+	var body static.JAllPlacesBody
+	for _, spot := range fakeSpots {
+		body.Items = append(body.Items, static.JAllSpotChiled{Area: spot.Area, Spot: spot.Spot, Name: spot.Name})
+	}
+	body.Num = len(body.Items)
+	writeFakeJSON(w, body)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	bikeshareapi "github.com/8245snake/bikeshare-client"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+const (
+	//MaxFavoriteGroups お気に入りグループの作成可能数
+	MaxFavoriteGroups = 5
+	//MaxFavoriteGroupSpots 1グループに登録できるスポット数
+	MaxFavoriteGroupSpots = 10
+	//MaxFavoriteGroupName グループ名の最大文字数（クイックリプライのラベルに入るように）
+	MaxFavoriteGroupName = 10
+)
+
+//FavoriteGroup 名前を付けたお気に入り（「自宅」「会社」など）
+type FavoriteGroup struct {
+	Name string
+	//Spots 表示順に並べたスポットコード
+	Spots []string
+}
+
+//FavoriteGroupView お気に入りグループの編集画面
+type FavoriteGroupView struct {
+	Name     string     `json:"name"`
+	Spots    []SpotItem `json:"spots"`
+	MaxSpots int        `json:"maxSpots"`
+	Lang     Lang       `json:"lang"`
+}
+
+//ViewKind 種類
+func (FavoriteGroupView) ViewKind() string { return "favoriteGroup" }
+
+//AllFavorites お気に入りとお気に入りグループのスポットを重複なく並べる
+func (user *UserConfig) AllFavorites() []string {
+	codes := copyStrings(user.Favorites)
+	for _, group := range user.FavoriteGroups {
+		for _, code := range group.Spots {
+			if !contains(codes, code) {
+				codes = append(codes, code)
+			}
+		}
+	}
+	return codes
+}
+
+//FavoriteGroupNames お気に入りグループの名前の一覧
+func (user *UserConfig) FavoriteGroupNames() []string {
+	var names []string
+	for _, group := range user.FavoriteGroups {
+		names = append(names, group.Name)
+	}
+	return names
+}
+
+//FindFavoriteGroup 名前でお気に入りグループを探す
+func (user *UserConfig) FindFavoriteGroup(name string) (FavoriteGroup, bool) {
+	if i := indexFavoriteGroup(user.FavoriteGroups, name); i >= 0 {
+		return user.FavoriteGroups[i], true
+	}
+	return FavoriteGroup{}, false
+}
+
+//indexFavoriteGroup 名前が一致するグループの位置（なければ-1）
+func indexFavoriteGroup(groups []FavoriteGroup, name string) int {
+	for i, group := range groups {
+		if group.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+//AddFavoriteGroupSpot グループの末尾にスポットを追加する（グループがなければ作る）
+func AddFavoriteGroupSpot(groups []FavoriteGroup, name string, code string) []FavoriteGroup {
+	i := indexFavoriteGroup(groups, name)
+	if i < 0 {
+		if len(groups) >= MaxFavoriteGroups {
+			return groups
+		}
+		groups = append(groups, FavoriteGroup{Name: name})
+		i = len(groups) - 1
+	}
+	if !contains(groups[i].Spots, code) && len(groups[i].Spots) < MaxFavoriteGroupSpots {
+		groups[i].Spots = append(groups[i].Spots, code)
+	}
+	return groups
+}
+
+//RemoveFavoriteGroupSpot グループからスポットを削除する（空になってもグループは残す）
+func RemoveFavoriteGroupSpot(groups []FavoriteGroup, name string, code string) []FavoriteGroup {
+	if i := indexFavoriteGroup(groups, name); i >= 0 {
+		groups[i].Spots = RemoveList(groups[i].Spots, code)
+	}
+	return groups
+}
+
+//MoveFavoriteGroupSpot グループ内でスポットの順番を入れ替える（deltaが負なら上へ）
+func MoveFavoriteGroupSpot(groups []FavoriteGroup, name string, code string, delta int) []FavoriteGroup {
+	i := indexFavoriteGroup(groups, name)
+	if i < 0 {
+		return groups
+	}
+	spots := groups[i].Spots
+	for from := range spots {
+		if spots[from] != code {
+			continue
+		}
+		to := from + delta
+		if to < 0 || to >= len(spots) {
+			return groups
+		}
+		spots[from], spots[to] = spots[to], spots[from]
+		return groups
+	}
+	return groups
+}
+
+//DeleteFavoriteGroup グループを削除する
+func DeleteFavoriteGroup(groups []FavoriteGroup, name string) []FavoriteGroup {
+	buff := []FavoriteGroup{}
+	for _, group := range groups {
+		if group.Name != name {
+			buff = append(buff, group)
+		}
+	}
+	return buff
+}
+
+//BuildFavoriteGroupView お気に入りグループの台数一覧
+func BuildFavoriteGroupView(ctx context.Context, userID string, name string) View {
+	user := GetUserConfigFromCache(userID)
+	if user == nil {
+		return textView(DefaultLang, "user.loadFailed")
+	}
+	lang := user.Lang()
+	group, ok := user.FindFavoriteGroup(name)
+	if !ok {
+		return textView(lang, "favgroup.notFound", name)
+	}
+	if len(group.Spots) < 1 {
+		return textView(lang, "favgroup.empty", name)
+	}
+	spotinfos, stale, err := BikeshareAPI.FetchPlaces(ctx, bikeshareapi.SearchPlacesOption{Places: group.Spots})
+	if err != nil {
+		return apiErrorView(ctx, lang, "search.failed", err)
+	}
+	if len(spotinfos) < 1 {
+		return textView(lang, "fav.noSpots")
+	}
+	//登録した順に並べる
+	spotinfos = sortSpotInfosByCodes(spotinfos, group.Spots)
+	view := newSpotListView(T(lang, "favgroup.title", name), T(lang, "search.alt"), spotinfos, lang)
+	view.Group = name
+	view.Groups = user.FavoriteGroupNames()
+	view.setStale(stale)
+	return view
+}
+
+//BuildFavoriteGroupEditView お気に入りグループの編集画面
+func BuildFavoriteGroupEditView(userID string, name string) View {
+	user := GetUserConfigFromCache(userID)
+	if user == nil {
+		return textView(DefaultLang, "user.loadFailed")
+	}
+	lang := user.Lang()
+	group, ok := user.FindFavoriteGroup(name)
+	if !ok {
+		return textView(lang, "favgroup.notFound", name)
+	}
+	view := FavoriteGroupView{Name: name, MaxSpots: MaxFavoriteGroupSpots, Lang: lang}
+	for _, code := range group.Spots {
+		area, spot := SplitAreaSpot(code)
+		view.Spots = append(view.Spots, SpotItem{Area: area, Spot: spot, Name: GetPlaceNameByCode(code)})
+	}
+	return view
+}
+
+//MakeFavoriteGroupMessage お気に入りグループの台数一覧メッセージ
+func MakeFavoriteGroupMessage(ctx context.Context, userID string, name string) linebot.SendingMessage {
+	return RenderLine(BuildFavoriteGroupView(ctx, userID, name))
+}
+
+//MakeFavoriteGroupEditMessage お気に入りグループの編集画面メッセージ
+func MakeFavoriteGroupEditMessage(userID string, name string) linebot.SendingMessage {
+	return RenderLine(BuildFavoriteGroupEditView(userID, name))
+}
+
+//CreateFavoriteGroupQuickReplyItems お気に入りグループを切り替えるクイックリプライ
+//ポストバックに収まらない名前のグループは出さない
+func CreateFavoriteGroupQuickReplyItems(names []string) *linebot.QuickReplyItems {
+	items := linebot.NewQuickReplyItems()
+	for _, name := range names {
+		data, err := GetPostbackDataFavoriteGroup(name, "")
+		if err != nil {
+			continue
+		}
+		items.Items = append(items.Items, linebot.NewQuickReplyButton("",
+			linebot.NewPostbackAction(name, data, "", name)))
+	}
+	return items
+}
+
+//CreateFavoriteGroupAddQuickReplyItems スポットをお気に入りグループに追加するクイックリプライ
+func CreateFavoriteGroupAddQuickReplyItems(area, spot string, names []string, lang Lang) *linebot.QuickReplyItems {
+	items := linebot.NewQuickReplyItems()
+	for _, name := range names {
+		data, err := GetPostbackDataForFavoriteGroup(PostBackCommandModeReg, name, area, spot)
+		if err != nil {
+			continue
+		}
+		label := T(lang, "favgroup.addTo", name)
+		items.Items = append(items.Items, linebot.NewQuickReplyButton("",
+			linebot.NewPostbackAction(label, data, "", label)))
+	}
+	return items
+}
+
+//validFavoriteGroupName グループ名として使えるか
+//文字数のほかに、編集画面のボタンのポストバックに収まることも確かめる
+func validFavoriteGroupName(name, area, spot string) bool {
+	if name == "" || utf8.RuneCountInString(name) > MaxFavoriteGroupName {
+		return false
+	}
+	_, err := GetPostbackDataForFavoriteGroup(PostBackCommandModeUnreg, name, area, spot)
+	return err == nil
+}
+
+//ReplyToPostbackFavoriteGroup お気に入りグループの編集
+func ReplyToPostbackFavoriteGroup(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
+	var reply linebot.SendingMessage
+	user := GetUserConfigFromCache(SourceID(event))
+	if user == nil {
+		reply = linebot.NewTextMessage(T(DefaultLang, "user.loadFailed"))
+		ReplyMessage(ctx, event.ReplyToken, reply)
+		return
+	}
+	lang := user.Lang()
+	userID := SourceID(event)
+	name := strings.TrimSpace(command.Target)
+	code := command.Area + "-" + command.Spot
+	group, exists := user.FindFavoriteGroup(name)
+	//保存できなかったときは変更した画面ではなくその旨を返す
+	var err error
+
+	switch command.Mode {
+	case PostBackCommandModeReg:
+		if !validFavoriteGroupName(name, command.Area, command.Spot) {
+			reply = linebot.NewTextMessage(T(lang, "favgroup.badName", MaxFavoriteGroupName))
+			break
+		}
+		if GetPlaceNameByCode(code) == "" {
+			reply = linebot.NewTextMessage(T(lang, "favgroup.unknownSpot", code))
+			break
+		}
+		if !exists && len(user.FavoriteGroups) >= MaxFavoriteGroups {
+			reply = linebot.NewTextMessage(T(lang, "favgroup.tooMany", MaxFavoriteGroups))
+			break
+		}
+		if len(group.Spots) >= MaxFavoriteGroupSpots {
+			reply = linebot.NewTextMessage(T(lang, "favgroup.full", name, MaxFavoriteGroupSpots))
+			break
+		}
+		err = UpdateUserConfigFunc(userID, func(user *UserConfig) {
+			user.FavoriteGroups = AddFavoriteGroupSpot(user.FavoriteGroups, name, code)
+		})
+		reply = MakeFavoriteGroupEditMessage(userID, name)
+	case PostBackCommandModeUnreg, PostBackCommandModeUp, PostBackCommandModeDown:
+		if !exists {
+			reply = linebot.NewTextMessage(T(lang, "favgroup.notFound", name))
+			break
+		}
+		err = UpdateUserConfigFunc(userID, func(user *UserConfig) {
+			switch command.Mode {
+			case PostBackCommandModeUnreg:
+				user.FavoriteGroups = RemoveFavoriteGroupSpot(user.FavoriteGroups, name, code)
+			case PostBackCommandModeUp:
+				user.FavoriteGroups = MoveFavoriteGroupSpot(user.FavoriteGroups, name, code, -1)
+			case PostBackCommandModeDown:
+				user.FavoriteGroups = MoveFavoriteGroupSpot(user.FavoriteGroups, name, code, 1)
+			}
+		})
+		reply = MakeFavoriteGroupEditMessage(userID, name)
+	case PostBackCommandModeDelete:
+		if !exists {
+			reply = linebot.NewTextMessage(T(lang, "favgroup.notFound", name))
+			break
+		}
+		err = UpdateUserConfigFunc(userID, func(user *UserConfig) {
+			user.FavoriteGroups = DeleteFavoriteGroup(user.FavoriteGroups, name)
+			//このグループを送る通知は通常のお気に入りに戻す
+			for i := range user.Schedules {
+				if user.Schedules[i].Group == name {
+					user.Schedules[i].Group = ""
+				}
+			}
+		})
+		reply = linebot.NewTextMessage(T(lang, "favgroup.deleted", name))
+	default:
+		return
+	}
+	if err != nil {
+		reply = linebot.NewTextMessage(T(lang, "user.saveFailed"))
+	}
+	//返信
+	ReplyMessage(ctx, event.ReplyToken, reply)
+}
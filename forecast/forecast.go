@@ -0,0 +1,147 @@
+//Package forecast 過去の台数の推移から近い将来の台数を予測する
+package forecast
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	//DefaultHorizon 何分後の台数を予測するか
+	DefaultHorizon = 30 * time.Minute
+	//DefaultWindow 空になる時刻を探す範囲
+	DefaultWindow = 2 * time.Hour
+	//DefaultStep 空になる時刻を探す刻み
+	DefaultStep = 10 * time.Minute
+	//Tolerance 過去の台数を採用する時刻のずれの許容範囲
+	Tolerance = 30 * time.Minute
+)
+
+//ErrNoHistory 比較できる過去のデータがない
+var ErrNoHistory = errors.New("比較できる過去のデータがありません")
+
+//Point ある時刻の台数
+type Point struct {
+	Time  time.Time
+	Count int
+}
+
+//Series 1日分の台数の推移
+type Series []Point
+
+//Forecast 予測結果
+type Forecast struct {
+	//Base 予測の基準時刻（現在の台数の時刻）
+	Base time.Time
+	//Current 現在の台数
+	Current int
+	//Horizon 予測する時間
+	Horizon time.Duration
+	//Predicted Horizon後の予測台数
+	Predicted int
+	//EmptyAt 空になりそうな時刻（Window以内に空にならなければゼロ値）
+	EmptyAt time.Time
+	//Samples 予測に使った日数
+	Samples int
+}
+
+//WillBeEmpty Window以内に空になりそうか
+func (f Forecast) WillBeEmpty() bool {
+	return !f.EmptyAt.IsZero()
+}
+
+//Predict 過去の同じ曜日・同じ時間帯の増減を現在の台数に足して予測する
+//historyは比較する日ごとの推移（時刻は日付を問わず時・分だけを見る）
+func Predict(current Point, history []Series, horizon, window, step time.Duration) (Forecast, error) {
+	result := Forecast{Base: current.Time, Current: current.Count, Horizon: horizon, Predicted: current.Count}
+	base := minuteOfDay(current.Time)
+
+	//比較できる日だけ残す
+	var days []Series
+	for _, series := range history {
+		sorted := sortSeries(series)
+		if _, ok := countAt(sorted, base); ok {
+			days = append(days, sorted)
+		}
+	}
+	if len(days) == 0 {
+		return result, ErrNoHistory
+	}
+	result.Samples = len(days)
+
+	if predicted, ok := predictAt(current.Count, days, base, base+int(horizon/time.Minute)); ok {
+		result.Predicted = predicted
+	}
+	if current.Count <= 0 {
+		result.EmptyAt = current.Time
+		return result, nil
+	}
+	for offset := step; offset <= window; offset += step {
+		predicted, ok := predictAt(current.Count, days, base, base+int(offset/time.Minute))
+		if ok && predicted <= 0 {
+			result.EmptyAt = current.Time.Add(offset)
+			break
+		}
+	}
+	return result, nil
+}
+
+//predictAt 基準時刻から目標時刻までの過去の増減の平均を足した台数
+func predictAt(count int, days []Series, from, to int) (int, bool) {
+	var total, samples int
+	for _, series := range days {
+		before, ok1 := countAt(series, from)
+		after, ok2 := countAt(series, to)
+		if !ok1 || !ok2 {
+			continue
+		}
+		total += after - before
+		samples++
+	}
+	if samples == 0 {
+		return 0, false
+	}
+	//四捨五入
+	delta := float64(total) / float64(samples)
+	if delta < 0 {
+		delta -= 0.5
+	} else {
+		delta += 0.5
+	}
+	predicted := count + int(delta)
+	if predicted < 0 {
+		predicted = 0
+	}
+	return predicted, true
+}
+
+//countAt 指定した時刻（0時からの分）以前で最も近い台数
+func countAt(series Series, minute int) (int, bool) {
+	found := false
+	var count, at int
+	for _, point := range series {
+		m := minuteOfDay(point.Time)
+		if m > minute {
+			break
+		}
+		count, at, found = point.Count, m, true
+	}
+	if !found || minute-at > int(Tolerance/time.Minute) {
+		return 0, false
+	}
+	return count, true
+}
+
+//sortSeries 時刻の昇順に並べたコピーを返す
+func sortSeries(series Series) Series {
+	sorted := make(Series, len(series))
+	copy(sorted, series)
+	sort.Slice(sorted, func(i, j int) bool { return minuteOfDay(sorted[i].Time) < minuteOfDay(sorted[j].Time) })
+	return sorted
+}
+
+//minuteOfDay 0時からの経過分
+func minuteOfDay(t time.Time) int {
+	return t.Hour()*60 + t.Minute()
+}
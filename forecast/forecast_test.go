@@ -0,0 +1,154 @@
+package forecast
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//series 「08:00=10 08:20=6」のように記録した台数の推移を読む
+func series(date, recorded string) Series {
+	var s Series
+	for _, item := range strings.Fields(recorded) {
+		pair := strings.SplitN(item, "=", 2)
+		t, err := time.Parse("2006-01-02 15:04", date+" "+pair[0])
+		if err != nil {
+			panic(err)
+		}
+		count, err := strconv.Atoi(pair[1])
+		if err != nil {
+			panic(err)
+		}
+		s = append(s, Point{Time: t, Count: count})
+	}
+	return s
+}
+
+//point 予測する日の台数
+func point(hhmm string, count int) Point {
+	return series("2024-06-05", hhmm+"="+strconv.Itoa(count))[0]
+}
+
+func TestPredict(t *testing.T) {
+	//朝に減っていく駅前のスポット（前の週と前々週の水曜日）
+	morning := []Series{
+		series("2024-05-29", "07:40=11 08:00=10 08:20=6 08:40=2 09:00=0 09:20=0"),
+		series("2024-05-22", "07:40=12 08:00=12 08:20=9 08:40=4 09:00=1 09:20=1"),
+	}
+	tests := []struct {
+		name          string
+		current       Point
+		history       []Series
+		horizon       time.Duration
+		wantPredicted int
+		wantEmptyAt   string
+		wantSamples   int
+		wantErr       error
+	}{
+		{
+			//30分後は2日の増減（-4と-3）の平均を四捨五入して-4、09:00に空になる
+			name:          "減っていく",
+			current:       point("08:00", 9),
+			history:       morning,
+			horizon:       30 * time.Minute,
+			wantPredicted: 5,
+			wantEmptyAt:   "09:00",
+			wantSamples:   2,
+		},
+		{
+			name:          "台数は0より少なくならない",
+			current:       point("08:00", 3),
+			history:       morning,
+			horizon:       time.Hour,
+			wantPredicted: 0,
+			wantEmptyAt:   "08:20",
+			wantSamples:   2,
+		},
+		{
+			name:          "すでに空",
+			current:       point("08:10", 0),
+			history:       morning,
+			horizon:       30 * time.Minute,
+			wantPredicted: 0,
+			wantEmptyAt:   "08:10",
+			wantSamples:   2,
+		},
+		{
+			name:    "増えていく",
+			current: point("17:00", 4),
+			history: []Series{
+				series("2024-05-29", "17:00=3 17:20=6 17:40=9 18:00=12"),
+			},
+			horizon:       time.Hour,
+			wantPredicted: 13,
+			wantSamples:   1,
+		},
+		{
+			name:    "記録の順番が揃っていない",
+			current: point("08:00", 9),
+			history: []Series{
+				series("2024-05-29", "09:00=0 08:20=6 08:00=10 08:40=2"),
+			},
+			horizon:       20 * time.Minute,
+			wantPredicted: 5,
+			wantEmptyAt:   "09:00",
+			wantSamples:   1,
+		},
+		{
+			//08:20より後の記録がないので2時間後は予測できず、現在の台数のまま
+			name:    "予測する時刻が記録より先",
+			current: point("08:00", 9),
+			history: []Series{
+				series("2024-05-29", "07:40=10 08:00=10 08:20=8"),
+			},
+			horizon:       2 * time.Hour,
+			wantPredicted: 9,
+			wantSamples:   1,
+		},
+		{
+			name:          "過去のデータがない",
+			current:       point("08:00", 9),
+			history:       nil,
+			horizon:       30 * time.Minute,
+			wantPredicted: 9,
+			wantErr:       ErrNoHistory,
+		},
+		{
+			//基準時刻の前30分以内に記録がない日は比較に使わない
+			name:    "基準時刻の記録がない",
+			current: point("08:00", 9),
+			history: []Series{
+				series("2024-05-29", "06:00=10 09:00=0"),
+				series("2024-05-22", "08:40=4 09:00=1"),
+			},
+			horizon:       30 * time.Minute,
+			wantPredicted: 9,
+			wantErr:       ErrNoHistory,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Predict(tt.current, tt.history, tt.horizon, DefaultWindow, DefaultStep)
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got.Predicted != tt.wantPredicted {
+				t.Errorf("Predicted = %d, want %d", got.Predicted, tt.wantPredicted)
+			}
+			if got.Samples != tt.wantSamples {
+				t.Errorf("Samples = %d, want %d", got.Samples, tt.wantSamples)
+			}
+			emptyAt := ""
+			if got.WillBeEmpty() {
+				emptyAt = got.EmptyAt.Format("15:04")
+			}
+			if emptyAt != tt.wantEmptyAt {
+				t.Errorf("EmptyAt = %q, want %q", emptyAt, tt.wantEmptyAt)
+			}
+			if got.Current != tt.current.Count || got.Horizon != tt.horizon {
+				t.Errorf("Current = %d, Horizon = %v", got.Current, got.Horizon)
+			}
+		})
+	}
+}
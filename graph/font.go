@@ -0,0 +1,345 @@
+package graph
+
+import (
+	"image"
+	"image/color"
+)
+
+//glyphHeight 文字の高さ（ドット）
+const glyphHeight = 11
+
+//glyphs グラフの目盛りと凡例に使う文字だけを持つビットマップフォント
+//すべて下揃えで描画する
+var glyphs = map[rune][]string{
+	'0': {
+		".###.",
+		"#...#",
+		"#..##",
+		"#.#.#",
+		"##..#",
+		"#...#",
+		".###.",
+	},
+	'1': {
+		"..#..",
+		".##..",
+		"..#..",
+		"..#..",
+		"..#..",
+		"..#..",
+		".###.",
+	},
+	'2': {
+		".###.",
+		"#...#",
+		"....#",
+		"...#.",
+		"..#..",
+		".#...",
+		"#####",
+	},
+	'3': {
+		"#####",
+		"...#.",
+		"..#..",
+		"...#.",
+		"....#",
+		"#...#",
+		".###.",
+	},
+	'4': {
+		"...#.",
+		"..##.",
+		".#.#.",
+		"#..#.",
+		"#####",
+		"...#.",
+		"...#.",
+	},
+	'5': {
+		"#####",
+		"#....",
+		"####.",
+		"....#",
+		"....#",
+		"#...#",
+		".###.",
+	},
+	'6': {
+		"..##.",
+		".#...",
+		"#....",
+		"####.",
+		"#...#",
+		"#...#",
+		".###.",
+	},
+	'7': {
+		"#####",
+		"....#",
+		"...#.",
+		"..#..",
+		".#...",
+		".#...",
+		".#...",
+	},
+	'8': {
+		".###.",
+		"#...#",
+		"#...#",
+		".###.",
+		"#...#",
+		"#...#",
+		".###.",
+	},
+	'9': {
+		".###.",
+		"#...#",
+		"#...#",
+		".####",
+		"....#",
+		"...#.",
+		".##..",
+	},
+	'/': {
+		"....#",
+		"...#.",
+		"...#.",
+		"..#..",
+		".#...",
+		".#...",
+		"#....",
+	},
+	':': {
+		".",
+		"#",
+		".",
+		".",
+		".",
+		"#",
+		".",
+	},
+	'-': {
+		"...",
+		"...",
+		"...",
+		"###",
+		"...",
+		"...",
+		"...",
+	},
+	' ': {
+		"..",
+	},
+	'台': {
+		"....##.....",
+		"...##......",
+		"..##....#..",
+		".##......#.",
+		"###########",
+		"...........",
+		".#########.",
+		".#.......#.",
+		".#.......#.",
+		".#.......#.",
+		".#########.",
+	},
+	'時': {
+		".......#...",
+		"###..#####.",
+		"#.#....#...",
+		"#.#.#######",
+		"###.....#..",
+		"#.#.#######",
+		"#.#..#..#..",
+		"###...#.#..",
+		"#.#.....#..",
+		"........#..",
+		"......###..",
+	},
+	'日': {
+		".#######.",
+		".#.....#.",
+		".#.....#.",
+		".#.....#.",
+		".#######.",
+		".#.....#.",
+		".#.....#.",
+		".#.....#.",
+		".#######.",
+	},
+	'今': {
+		".....#.....",
+		"....#.#....",
+		"...#...#...",
+		"..#.....#..",
+		".#.#####.#.",
+		"#.........#",
+		"..#######..",
+		"........#..",
+		".......#...",
+		"......#....",
+		".....#.....",
+	},
+	'(': {
+		"..#",
+		".#.",
+		"#..",
+		"#..",
+		"#..",
+		"#..",
+		"#..",
+		"#..",
+		"#..",
+		".#.",
+		"..#",
+	},
+	')': {
+		"#..",
+		".#.",
+		"..#",
+		"..#",
+		"..#",
+		"..#",
+		"..#",
+		"..#",
+		"..#",
+		".#.",
+		"#..",
+	},
+	'月': {
+		"..#######",
+		"..#.....#",
+		"..#.....#",
+		"..#######",
+		"..#.....#",
+		"..#.....#",
+		"..#######",
+		"..#.....#",
+		".#......#",
+		".#......#",
+		"#.....###",
+	},
+	'火': {
+		".....#.....",
+		".....#.....",
+		".#...#...#.",
+		".#...#..#..",
+		"#....#.....",
+		".....#.....",
+		"....#.#....",
+		"....#.#....",
+		"...#...#...",
+		"..#.....#..",
+		"##.......##",
+	},
+	'水': {
+		".....#.....",
+		".....#.....",
+		"####.#...#.",
+		"...#.#..#..",
+		"...#.##....",
+		"..#..#.#...",
+		"..#..#..#..",
+		".#...#...#.",
+		"#....#....#",
+		".....#.....",
+		"...###.....",
+	},
+	'木': {
+		".....#.....",
+		".....#.....",
+		"###########",
+		".....#.....",
+		"....###....",
+		"...#.#.#...",
+		"..#..#..#..",
+		".#...#...#.",
+		"#....#....#",
+		".....#.....",
+		".....#.....",
+	},
+	'金': {
+		".....#.....",
+		"....#.#....",
+		"...#...#...",
+		"..#######..",
+		".....#.....",
+		".#########.",
+		".....#.....",
+		"..#..#..#..",
+		"...#.#.#...",
+		".....#.....",
+		"###########",
+	},
+	'土': {
+		".....#.....",
+		".....#.....",
+		".....#.....",
+		".....#.....",
+		"..#######..",
+		".....#.....",
+		".....#.....",
+		".....#.....",
+		".....#.....",
+		".....#.....",
+		"###########",
+	},
+	'祝': {
+		".#.........",
+		"..#..#####.",
+		"####.#...#.",
+		"...#.#...#.",
+		"..#..#####.",
+		".###..#.#..",
+		"#.#...#.#..",
+		"..#...#.#..",
+		"..#..#..#.#",
+		"..#.#...#.#",
+		"..#......##",
+	},
+	'昨': {
+		"......#....",
+		"###..#.....",
+		"#.#..######",
+		"#.#.#.#....",
+		"###...#....",
+		"#.#...#####",
+		"#.#...#....",
+		"###...#####",
+		"#.#...#....",
+		"......#....",
+		"......#....",
+	},
+}
+
+//textWidth 文字列を描画したときの幅
+func textWidth(text string, scale int) int {
+	width := 0
+	for _, r := range text {
+		if glyph, ok := glyphs[r]; ok {
+			width += (len(glyph[0]) + 1) * scale
+		}
+	}
+	return width
+}
+
+//drawText 左下を(x, y)として文字列を描画する（フォントにない文字は無視する）
+func drawText(img *image.RGBA, text string, x, y, scale int, c color.Color) {
+	for _, r := range text {
+		glyph, ok := glyphs[r]
+		if !ok {
+			continue
+		}
+		top := y - len(glyph)*scale
+		for row, line := range glyph {
+			for col, dot := range line {
+				if dot != '#' {
+					continue
+				}
+				fillRect(img, x+col*scale, top+row*scale, scale, scale, c)
+			}
+		}
+		x += (len(glyph[0]) + 1) * scale
+	}
+}
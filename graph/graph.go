@@ -0,0 +1,216 @@
+//Package graph 台数の経時変化グラフをPNG画像で描画する
+package graph
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	//DefaultWidth 画像の幅
+	DefaultWidth = 1000
+	//DefaultHeight 画像の高さ（LINEのヒーロー画像に合わせて4:3）
+	DefaultHeight = 750
+	//DefaultScale 文字の拡大率
+	DefaultScale = 2
+)
+
+//ErrNoData 描画するデータがない
+var ErrNoData = errors.New("描画するデータがありません")
+
+var (
+	colorBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	colorAxis       = color.RGBA{0x44, 0x44, 0x44, 0xff}
+	colorGrid       = color.RGBA{0xe0, 0xe0, 0xe0, 0xff}
+	colorText       = color.RGBA{0x22, 0x22, 0x22, 0xff}
+	//palette 系列の色（1つ目が当日）
+	palette = []color.RGBA{
+		{0x00, 0xac, 0xed, 0xff},
+		{0xaa, 0xaa, 0xaa, 0xff},
+		{0x1d, 0xb4, 0x46, 0xff},
+		{0xee, 0x00, 0x00, 0xff},
+		{0xff, 0x99, 0x00, 0xff},
+		{0x99, 0x33, 0xcc, 0xff},
+	}
+)
+
+//Point ある時刻の台数
+type Point struct {
+	Time  time.Time
+	Count int
+}
+
+//Line 1日分の系列
+type Line struct {
+	//Label 凡例（描画できる文字は数字・記号と一部の漢字のみ）
+	Label  string
+	Points []Point
+}
+
+//Options 描画オプション
+type Options struct {
+	Width, Height, Scale int
+}
+
+//DefaultOptions 標準の描画オプション
+func DefaultOptions() Options {
+	return Options{Width: DefaultWidth, Height: DefaultHeight, Scale: DefaultScale}
+}
+
+//Render 横軸を0時～24時、縦軸を台数として系列を重ねたグラフを描画する
+//時刻は日付を無視して時・分だけを見るので、複数日を重ねて比較できる
+func Render(w io.Writer, lines []Line, opt Options) error {
+	if opt.Width <= 0 || opt.Height <= 0 {
+		opt = DefaultOptions()
+	}
+	if opt.Scale <= 0 {
+		opt.Scale = 1
+	}
+	maxCount := 0
+	points := 0
+	for _, line := range lines {
+		for _, p := range line.Points {
+			if p.Count > maxCount {
+				maxCount = p.Count
+			}
+			points++
+		}
+	}
+	if points == 0 {
+		return ErrNoData
+	}
+	step := yStep(maxCount)
+	yMax := (maxCount/step + 1) * step
+
+	img := image.NewRGBA(image.Rect(0, 0, opt.Width, opt.Height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{colorBackground}, image.Point{}, draw.Src)
+
+	s := opt.Scale
+	lineHeight := (glyphHeight + 4) * s
+	//描画領域
+	left := textWidth("000台", s) + 8*s
+	right := opt.Width - textWidth("24時", s)/2 - 6*s
+	top := lineHeight + 10*s
+	bottom := opt.Height - 2*lineHeight - 10*s
+	plotW := right - left
+	plotH := bottom - top
+	xOf := func(t time.Time) int {
+		return left + plotW*(t.Hour()*60+t.Minute())/(24*60)
+	}
+	yOf := func(count int) int {
+		return bottom - plotH*count/yMax
+	}
+
+	//縦軸の目盛り
+	for v := 0; v <= yMax; v += step {
+		y := yOf(v)
+		fillRect(img, left, y, plotW, 1, colorGrid)
+		label := strconv.Itoa(v) + "台"
+		drawText(img, label, left-textWidth(label, s)-4*s, y+glyphHeight*s/2, s, colorText)
+	}
+	//横軸の目盛り（3時間ごと）
+	for h := 0; h <= 24; h += 3 {
+		x := left + plotW*h/24
+		fillRect(img, x, top, 1, plotH, colorGrid)
+		label := strconv.Itoa(h) + "時"
+		drawText(img, label, x-textWidth(label, s)/2, bottom+lineHeight, s, colorText)
+	}
+	//軸
+	fillRect(img, left, top, 1, plotH+1, colorAxis)
+	fillRect(img, left, bottom, plotW+1, 1, colorAxis)
+
+	//系列（古いものから描いて当日を一番上にする）
+	for i := len(lines) - 1; i >= 0; i-- {
+		c := palette[i%len(palette)]
+		pts := sortPoints(lines[i].Points)
+		for j := 1; j < len(pts); j++ {
+			drawLine(img, xOf(pts[j-1].Time), yOf(pts[j-1].Count), xOf(pts[j].Time), yOf(pts[j].Count), s+1, c)
+		}
+		if len(pts) == 1 {
+			fillRect(img, xOf(pts[0].Time)-s, yOf(pts[0].Count)-s, 2*s+1, 2*s+1, c)
+		}
+	}
+
+	//凡例
+	x := left
+	legendY := opt.Height - 8*s
+	for i, line := range lines {
+		c := palette[i%len(palette)]
+		fillRect(img, x, legendY-glyphHeight*s/2-s, 12*s, 3*s, c)
+		x += 16 * s
+		drawText(img, line.Label, x, legendY, s, colorText)
+		x += textWidth(line.Label, s) + 12*s
+	}
+
+	return png.Encode(w, img)
+}
+
+//yStep 縦軸の目盛りの間隔
+func yStep(maxCount int) int {
+	for _, step := range []int{1, 2, 5, 10, 20, 50, 100} {
+		if maxCount/step < 8 {
+			return step
+		}
+	}
+	return 200
+}
+
+//sortPoints 時刻の昇順に並べたコピー
+func sortPoints(points []Point) []Point {
+	sorted := make([]Point, len(points))
+	copy(sorted, points)
+	sort.Slice(sorted, func(i, j int) bool { return minuteOf(sorted[i].Time) < minuteOf(sorted[j].Time) })
+	return sorted
+}
+
+func minuteOf(t time.Time) int {
+	return t.Hour()*60 + t.Minute()
+}
+
+//fillRect 矩形を塗りつぶす
+func fillRect(img *image.RGBA, x, y, w, h int, c color.Color) {
+	draw.Draw(img, image.Rect(x, y, x+w, y+h), &image.Uniform{c}, image.Point{}, draw.Src)
+}
+
+//drawLine 太さwidthの線分を描く（ブレゼンハム）
+func drawLine(img *image.RGBA, x0, y0, x1, y1, width int, c color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	half := width / 2
+	for {
+		fillRect(img, x0-half, y0-half, width, width, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,162 @@
+package graph
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGlyphs(t *testing.T) {
+	for r, glyph := range glyphs {
+		if len(glyph) == 0 || len(glyph) > glyphHeight {
+			t.Errorf("%q: 高さ = %d", r, len(glyph))
+			continue
+		}
+		for i, row := range glyph {
+			if len(row) != len(glyph[0]) {
+				t.Errorf("%q: %d行目の幅 = %d, want %d", r, i+1, len(row), len(glyph[0]))
+			}
+			if strings.Trim(row, ".#") != "" {
+				t.Errorf("%q: %d行目に不正な文字 %q", r, i+1, row)
+			}
+		}
+	}
+	//目盛りと凡例に使う文字はすべてある
+	for _, r := range "0123456789台時今日昨祝月火水木金土/()" {
+		if _, ok := glyphs[r]; !ok {
+			t.Errorf("%qがない", r)
+		}
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	tests := []struct {
+		text  string
+		scale int
+		want  int
+	}{
+		{"", 1, 0},
+		{"1", 1, 6},
+		{"1", 2, 12},
+		{"10", 1, 12},
+		//フォントにない文字は数えない
+		{"1x", 1, 6},
+		{"台", 1, 12},
+	}
+	for _, tt := range tests {
+		if got := textWidth(tt.text, tt.scale); got != tt.want {
+			t.Errorf("textWidth(%q, %d) = %d, want %d", tt.text, tt.scale, got, tt.want)
+		}
+	}
+}
+
+func TestDrawText(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	black := color.RGBA{0, 0, 0, 0xff}
+	//左下を(0, 14)として「1」を2倍で描く（7行なので上端は0）
+	drawText(img, "1", 0, 14, 2, black)
+	for row, line := range glyphs['1'] {
+		for col, dot := range line {
+			for dy := 0; dy < 2; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					got := img.RGBAAt(col*2+dx, row*2+dy) == black
+					if got != (dot == '#') {
+						t.Fatalf("(%d, %d) = %v, want %q", col*2+dx, row*2+dy, got, dot)
+					}
+				}
+			}
+		}
+	}
+	//下揃えなので基準より下には描かない
+	for x := 0; x < 20; x++ {
+		if img.RGBAAt(x, 14) == black {
+			t.Fatalf("(%d, 14)に描いた", x)
+		}
+	}
+}
+
+func TestYStep(t *testing.T) {
+	tests := []struct{ max, want int }{
+		{0, 1}, {7, 1}, {8, 2}, {15, 2}, {16, 5}, {39, 5}, {40, 10}, {79, 10}, {80, 20}, {399, 50}, {799, 100}, {800, 200},
+	}
+	for _, tt := range tests {
+		if got := yStep(tt.max); got != tt.want {
+			t.Errorf("yStep(%d) = %d, want %d", tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestSortPoints(t *testing.T) {
+	day1 := time.Date(2024, 6, 5, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, -1)
+	points := []Point{
+		{Time: day1.Add(10 * time.Hour), Count: 1},
+		{Time: day2.Add(8 * time.Hour), Count: 2},
+		{Time: day1.Add(9 * time.Hour), Count: 3},
+	}
+	sorted := sortPoints(points)
+	//日付は見ずに時刻で並べる
+	if sorted[0].Count != 2 || sorted[1].Count != 3 || sorted[2].Count != 1 {
+		t.Errorf("sorted = %v", sorted)
+	}
+	if points[0].Count != 1 {
+		t.Error("元のスライスを並べ替えた")
+	}
+}
+
+func TestRender(t *testing.T) {
+	if err := Render(&bytes.Buffer{}, []Line{{Label: "今日"}}, DefaultOptions()); err != ErrNoData {
+		t.Errorf("データなし: err = %v", err)
+	}
+
+	day := time.Date(2024, 6, 5, 0, 0, 0, 0, time.UTC)
+	var today, yesterday Line
+	today.Label, yesterday.Label = "今日(水)", "昨日(火)"
+	for h := 0; h < 24; h++ {
+		today.Points = append(today.Points, Point{Time: day.Add(time.Duration(h) * time.Hour), Count: h})
+		yesterday.Points = append(yesterday.Points, Point{Time: day.AddDate(0, 0, -1).Add(time.Duration(h) * time.Hour), Count: 24 - h})
+	}
+	tests := []struct {
+		name       string
+		opt        Options
+		wantWidth  int
+		wantHeight int
+	}{
+		{name: "標準", opt: DefaultOptions(), wantWidth: DefaultWidth, wantHeight: DefaultHeight},
+		{name: "大きさなし", opt: Options{}, wantWidth: DefaultWidth, wantHeight: DefaultHeight},
+		{name: "小さい", opt: Options{Width: 400, Height: 300, Scale: 1}, wantWidth: 400, wantHeight: 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Render(&buf, []Line{today, yesterday}, tt.opt); err != nil {
+				t.Fatal(err)
+			}
+			img, err := png.Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := img.Bounds(); b.Dx() != tt.wantWidth || b.Dy() != tt.wantHeight {
+				t.Errorf("size = %dx%d", b.Dx(), b.Dy())
+			}
+			//系列の色が描かれている
+			found := map[color.RGBA]bool{}
+			b := img.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					r, g, bl, a := img.At(x, y).RGBA()
+					found[color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(bl >> 8), uint8(a >> 8)}] = true
+				}
+			}
+			for i := range []Line{today, yesterday} {
+				if !found[palette[i]] {
+					t.Errorf("%d番目の系列の色がない", i+1)
+				}
+			}
+		})
+	}
+}
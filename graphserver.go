@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bikeshareapi "github.com/8245snake/bikeshare-client"
+	"github.com/8245snake/bikeshare-line/graph"
+	"github.com/8245snake/bikeshare-line/holiday"
+)
+
+const (
+	//GraphDayLayout グラフの日付指定のフォーマット
+	GraphDayLayout = "20060102"
+	//GraphMaxAgeToday 当日を含むグラフのキャッシュ時間（台数データの更新間隔に合わせる）
+	GraphMaxAgeToday = 5 * time.Minute
+	//GraphMaxAgePast 過去の日だけのグラフのキャッシュ時間
+	GraphMaxAgePast = 24 * time.Hour
+	//GraphMaxDays 1つのグラフに描く日数の上限（1日ごとにAPIを呼ぶので、誰でも呼べる/graphで増やしすぎないようにする）
+	GraphMaxDays = 7
+	//GraphRenderRate /graphで1分あたりに描画する上限（超えたら429を返す）
+	GraphRenderRate = 60
+	//GraphRenderBurst /graphでまとめて描画してよい数（一覧のカルーセルなどで同時に取りに来る分）
+	GraphRenderBurst = 20
+)
+
+var (
+	//GraphBaseURL グラフ画像を配信するこのボットのURL（空ならBikeshareAPIのグラフを使う）
+	GraphBaseURL string
+	//GraphSigningKey グラフのURLの署名鍵（空なら署名しない）
+	//このボットが返信に載せたURLだけを描画して、/graphを好きな引数で呼ばれないようにする
+	GraphSigningKey []byte
+	//graphLimiter /graphの描画の回数制限
+	graphLimiter = newRateLimiter(GraphRenderRate, time.Minute, GraphRenderBurst)
+)
+
+//rateLimiter 一定時間あたりの回数を制限する（トークンバケット）
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+//newRateLimiter perあたりn回、まとめてburst回まで許す
+func newRateLimiter(n int, per time.Duration, burst int) *rateLimiter {
+	return &rateLimiter{rate: float64(n) / per.Seconds(), burst: float64(burst), tokens: float64(burst)}
+}
+
+//Allow 1回分を使えればtrueを返す
+func (limiter *rateLimiter) Allow(now time.Time) bool {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if !limiter.last.IsZero() {
+		limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+		if limiter.tokens > limiter.burst {
+			limiter.tokens = limiter.burst
+		}
+	}
+	limiter.last = now
+	if limiter.tokens < 1 {
+		return false
+	}
+	limiter.tokens--
+	return true
+}
+
+//signGraphValues グラフのURLの引数に署名を付ける（鍵がなければ付けない）
+func signGraphValues(values url.Values) {
+	values.Del("sig")
+	if len(GraphSigningKey) == 0 {
+		return
+	}
+	values.Set("sig", graphSignature(values.Encode()))
+}
+
+//verifyGraphValues 署名を確認する（鍵がなければ確認しない）
+func verifyGraphValues(values url.Values) bool {
+	if len(GraphSigningKey) == 0 {
+		return true
+	}
+	unsigned := url.Values{}
+	for key, value := range values {
+		if key != "sig" {
+			unsigned[key] = value
+		}
+	}
+	return hmac.Equal([]byte(values.Get("sig")), []byte(graphSignature(unsigned.Encode())))
+}
+
+//graphSignature 引数の署名（URLに載せるので短くする）
+func graphSignature(query string) string {
+	mac := hmac.New(sha256.New, GraphSigningKey)
+	mac.Write([]byte(query))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+//GraphHandler 台数の経時変化グラフを描画して返す
+//例：/graph?area=A1&spot=01&days=20201018,20201017&sig=...
+//日付はGraphMaxDaysまで（重複はまとめる）で、それより多ければ400を返す
+//署名が合わなければ403、描画が多すぎれば429を返す
+func GraphHandler(w http.ResponseWriter, req *http.Request) {
+	params := req.URL.Query()
+	if !verifyGraphValues(params) {
+		w.WriteHeader(403)
+		return
+	}
+	area := params.Get("area")
+	spot := params.Get("spot")
+	if area == "" || spot == "" {
+		w.WriteHeader(400)
+		return
+	}
+	today := time.Now().In(LocationTokyo)
+	days := splitGraphDays(params.Get("days"))
+	if len(days) > GraphMaxDays {
+		w.WriteHeader(400)
+		return
+	} else if len(days) == 0 {
+		days = defaultGraphDays(today)
+	}
+	if !graphLimiter.Allow(time.Now()) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(429)
+		return
+	}
+
+	//画像を取りに来た接続が切れたら取得もやめる
+	ctx, cancel := context.WithTimeout(req.Context(), WebhookBudget)
+	defer cancel()
+	var buf bytes.Buffer
+	if err := graph.Render(&buf, fetchGraphLines(ctx, area, spot, days, today), graph.DefaultOptions()); err != nil {
+		if err == graph.ErrNoData {
+			w.WriteHeader(404)
+		} else {
+			w.WriteHeader(500)
+		}
+		return
+	}
+	maxAge := GraphMaxAgePast
+	if contains(days, today.Format(GraphDayLayout)) {
+		maxAge = GraphMaxAgeToday
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge/time.Second)))
+	w.Write(buf.Bytes())
+}
+
+//GetGraphInfo グラフ情報を取得
+//GraphBaseURLが設定されていればこのボットで描画するグラフのURLを返す
+//staleがtrueならAPIが失敗したので前回取得した内容を返している
+func GetGraphInfo(ctx context.Context, option bikeshareapi.SearchGraphOption) (graphInfo bikeshareapi.GraphInfo, stale bool, err error) {
+	if GraphBaseURL == "" {
+		return BikeshareAPI.FetchGraph(ctx, option)
+	}
+	info, stale, err := BikeshareAPI.FetchCounts(ctx, bikeshareapi.SearchCountsOption{Area: option.Area, Spot: option.Spot})
+	if err != nil {
+		return bikeshareapi.GraphInfo{}, false, err
+	}
+	//最新の台数を先頭にする
+	sort.Slice(info.Counts, func(i, j int) bool { return info.Counts[i].Time.After(info.Counts[j].Time) })
+
+	//GraphHandlerと同じく不正な日付は捨て、重複はまとめる
+	days := splitGraphDays(strings.Join(option.Days, ","))
+	if len(days) == 0 {
+		days = defaultGraphDays(time.Now().In(LocationTokyo))
+	} else if len(days) > GraphMaxDays {
+		days = days[:GraphMaxDays]
+	}
+	values := url.Values{}
+	values.Set("area", option.Area)
+	values.Set("spot", option.Spot)
+	values.Set("days", strings.Join(days, ","))
+	//LINE側で古い画像がキャッシュされないように最終更新日時を付ける
+	if len(info.Counts) > 0 {
+		values.Set("v", info.Counts[0].Time.Format("200601021504"))
+	}
+	signGraphValues(values)
+	return bikeshareapi.GraphInfo{
+		Title:    fmt.Sprintf("[%s-%s] %s", option.Area, option.Spot, info.Name),
+		Width:    strconv.Itoa(graph.DefaultWidth),
+		Height:   strconv.Itoa(graph.DefaultHeight),
+		URL:      strings.TrimRight(GraphBaseURL, "/") + "/graph?" + values.Encode(),
+		SpotInfo: info,
+	}, stale, nil
+}
+
+//fetchGraphLines 日ごとの台数を並行して取得して系列にする
+func fetchGraphLines(ctx context.Context, area, spot string, days []string, today time.Time) []graph.Line {
+	lines := make([]graph.Line, len(days))
+	var wg sync.WaitGroup
+	for i, day := range days {
+		lines[i].Label = graphDayLabel(day, today)
+		wg.Add(1)
+		go func(i int, day string) {
+			defer wg.Done()
+			info, err := BikeshareAPI.GetCountsContext(ctx, bikeshareapi.SearchCountsOption{Area: area, Spot: spot, Day: day})
+			if err != nil {
+				return
+			}
+			for _, count := range info.Counts {
+				lines[i].Points = append(lines[i].Points, graph.Point{Time: count.Time, Count: count.Count})
+			}
+		}(i, day)
+	}
+	wg.Wait()
+	return lines
+}
+
+//defaultGraphDays 当日と前日、それに前回の同じ曜日（祝日なら前回の祝日）
+func defaultGraphDays(today time.Time) []string {
+	days := []string{today.Format(GraphDayLayout), today.AddDate(0, 0, -1).Format(GraphDayLayout)}
+	for _, day := range holiday.ComparableDays(today, 1) {
+		if !contains(days, day.Format(GraphDayLayout)) {
+			days = append(days, day.Format(GraphDayLayout))
+		}
+	}
+	return days
+}
+
+//splitGraphDays カンマ区切りの日付を分割する（不正な日付は捨て、重複はまとめる）
+//上限を超えたかは呼び出し側で判定できるように、GraphMaxDaysより1つ多いところで打ち切る
+func splitGraphDays(value string) []string {
+	var days []string
+	for _, day := range strings.Split(value, ",") {
+		if _, err := time.Parse(GraphDayLayout, day); err != nil || contains(days, day) {
+			continue
+		}
+		days = append(days, day)
+		if len(days) > GraphMaxDays {
+			break
+		}
+	}
+	return days
+}
+
+//graphWeekdays 凡例に付ける曜日
+var graphWeekdays = []string{"日", "月", "火", "水", "木", "金", "土"}
+
+//graphDayLabel 凡例の文字列（今日、昨日、それ以外は月/日）
+//後ろに曜日を付け、祝日なら「祝」にする
+func graphDayLabel(day string, today time.Time) string {
+	t, err := time.Parse(GraphDayLayout, day)
+	if err != nil {
+		return day
+	}
+	weekday := graphWeekdays[t.Weekday()]
+	if holiday.IsHoliday(t) {
+		weekday = "祝"
+	}
+	switch day {
+	case today.Format(GraphDayLayout):
+		return "今日(" + weekday + ")"
+	case today.AddDate(0, 0, -1).Format(GraphDayLayout):
+		return "昨日(" + weekday + ")"
+	}
+	return fmt.Sprintf("%d/%d(%s)", t.Month(), t.Day(), weekday)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	bikeshareapi "github.com/8245snake/bikeshare-client"
+)
+
+func TestSplitGraphDays(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"20240601", []string{"20240601"}},
+		{"20240601,bad,,20240632,20240602", []string{"20240601", "20240602"}},
+		{"20240601,20240601,20240602,20240601", []string{"20240601", "20240602"}},
+	}
+	for _, tt := range tests {
+		if got := splitGraphDays(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitGraphDays(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+
+	//上限を超えたことがわかるところで打ち切る
+	var many []string
+	for day := 10; day < 30; day++ {
+		many = append(many, fmt.Sprintf("202406%02d", day))
+	}
+	if got := splitGraphDays(strings.Join(many, ",")); len(got) != GraphMaxDays+1 {
+		t.Errorf("len = %d, want %d", len(got), GraphMaxDays+1)
+	}
+}
+
+func TestGraphHandlerRejectsTooManyDays(t *testing.T) {
+	var days []string
+	for day := 1; day <= GraphMaxDays+1; day++ {
+		days = append(days, fmt.Sprintf("202406%02d", day))
+	}
+	req := httptest.NewRequest("GET", "/graph?area=A1&spot=01&days="+strings.Join(days, ","), nil)
+	w := httptest.NewRecorder()
+	GraphHandler(w, req)
+	if w.Code != 400 {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/graph?area=A1&days=20240601", nil)
+	w = httptest.NewRecorder()
+	GraphHandler(w, req)
+	if w.Code != 400 {
+		t.Errorf("spotなし: status = %d, want 400", w.Code)
+	}
+}
+
+func TestGraphHandlerSignature(t *testing.T) {
+	setupFakeBot(t)
+	GraphSigningKey = []byte("test-graph-secret")
+	defer func() { GraphSigningKey = nil }()
+
+	signed := url.Values{}
+	signed.Set("area", "A1")
+	signed.Set("spot", "01")
+	signed.Set("days", "20240605")
+	signGraphValues(signed)
+	tampered := url.Values{}
+	for key, value := range signed {
+		tampered[key] = value
+	}
+	tampered.Set("days", "20240605,20240604")
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{name: "署名あり", query: signed.Encode(), want: 200},
+		{name: "署名なし", query: "area=A1&spot=01&days=20240605", want: 403},
+		{name: "書き換えた", query: tampered.Encode(), want: 403},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		GraphHandler(w, httptest.NewRequest("GET", "/graph?"+tt.query, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestGraphHandlerRateLimit(t *testing.T) {
+	setupFakeBot(t)
+	saved := graphLimiter
+	defer func() { graphLimiter = saved }()
+	graphLimiter = newRateLimiter(1, time.Hour, 2)
+
+	var codes []int
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		GraphHandler(w, httptest.NewRequest("GET", "/graph?area=A1&spot=01&days=20240605", nil))
+		codes = append(codes, w.Code)
+	}
+	if want := []int{200, 200, 429}; !reflect.DeepEqual(codes, want) {
+		t.Errorf("status = %v, want %v", codes, want)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(60, time.Minute, 2)
+	now := tokyoAt("08:00:00")
+	got := []bool{limiter.Allow(now), limiter.Allow(now), limiter.Allow(now)}
+	if !reflect.DeepEqual(got, []bool{true, true, false}) {
+		t.Errorf("まとめて = %v", got)
+	}
+	//1秒に1回分戻る
+	if !limiter.Allow(now.Add(time.Second)) || limiter.Allow(now.Add(time.Second)) {
+		t.Error("1秒後に1回分だけ戻っていない")
+	}
+	//burstより多くは貯まらない
+	later := now.Add(time.Hour)
+	got = []bool{limiter.Allow(later), limiter.Allow(later), limiter.Allow(later)}
+	if !reflect.DeepEqual(got, []bool{true, true, false}) {
+		t.Errorf("1時間後 = %v", got)
+	}
+}
+
+func TestGetGraphInfoDays(t *testing.T) {
+	setupFakeBot(t)
+	GraphBaseURL = "https://bot.example.com"
+	tests := []struct {
+		days []string
+		want string
+	}{
+		{days: []string{"20240605", "20240605", "20240604", "20240605"}, want: "20240605,20240604"},
+		{days: []string{"20240605", "bad", "20240604"}, want: "20240605,20240604"},
+		{days: []string{"20240601", "20240602", "20240603", "20240604", "20240605", "20240606", "20240607", "20240608", "20240609"}, want: "20240601,20240602,20240603,20240604,20240605,20240606,20240607"},
+	}
+	for _, tt := range tests {
+		info, _, err := GetGraphInfo(testContext(t), bikeshareapi.SearchGraphOption{Area: "A1", Spot: "01", Days: tt.days})
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := url.Parse(info.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := u.Query().Get("days"); got != tt.want {
+			t.Errorf("days %v = %s, want %s", tt.days, got, tt.want)
+		}
+	}
+}
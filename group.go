@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+//GroupCallPrefixes グループ・トークルームでボットに話しかけるときの接頭辞
+//メンションすると本文が「@表示名」で始まるので、BOT_NAMEを設定するとメンションでも反応する
+var GroupCallPrefixes = []string{"@bot", "＠bot"}
+
+//SourceID イベントの送信元のID（グループ・トークルームならそのID、1:1ならユーザーID）
+//お気に入りや通知時刻などの設定はこのIDごとに保存する
+func SourceID(event *linebot.Event) string {
+	if event.Source == nil {
+		return ""
+	}
+	switch event.Source.Type {
+	case linebot.EventSourceTypeGroup:
+		return event.Source.GroupID
+	case linebot.EventSourceTypeRoom:
+		return event.Source.RoomID
+	}
+	return event.Source.UserID
+}
+
+//IsGroupEvent グループ・トークルームのイベントか
+func IsGroupEvent(event *linebot.Event) bool {
+	if event.Source == nil {
+		return false
+	}
+	return event.Source.Type == linebot.EventSourceTypeGroup || event.Source.Type == linebot.EventSourceTypeRoom
+}
+
+//SetBotName メンションで反応するようにボットの表示名を登録する
+func SetBotName(name string) {
+	if name == "" {
+		return
+	}
+	GroupCallPrefixes = append(GroupCallPrefixes, "@"+name, "＠"+name)
+}
+
+//TrimGroupCall ボットに話しかけたメッセージなら接頭辞を取り除いて返す
+//接頭辞の大文字・小文字は区別せず、後ろは空白か本文の終わりでなければならない（「@bottle」は話しかけていない）
+//スラッシュコマンドは接頭辞がなくても話しかけたものとみなす
+func TrimGroupCall(text string) (string, bool) {
+	trimmed := strings.TrimSpace(text)
+	if strings.Index(trimmed, "/") == 0 {
+		return trimmed, true
+	}
+	for _, prefix := range GroupCallPrefixes {
+		if len(trimmed) < len(prefix) || !strings.EqualFold(trimmed[:len(prefix)], prefix) {
+			continue
+		}
+		rest := trimmed[len(prefix):]
+		if r, _ := utf8.DecodeRuneInString(rest); rest != "" && !unicode.IsSpace(r) {
+			continue
+		}
+		return strings.TrimSpace(rest), true
+	}
+	return "", false
+}
+
+//ReplyToJoinEvent グループ・トークルームに招待されたとき
+func ReplyToJoinEvent(ctx context.Context, event *linebot.Event) {
+	//グループの設定を作成
+	UpdateUserConfig(UserUpdateTypeUserAdd, SourceID(event), "")
+	lang := GetUserLang(SourceID(event))
+	ReplyMessage(ctx, event.ReplyToken, linebot.NewTextMessage(MakeGroupUsageText(T(lang, "group.invited"), lang)))
+}
+
+//ReplyToLeaveEvent グループ・トークルームから退出させられたとき
+func ReplyToLeaveEvent(ctx context.Context, event *linebot.Event) {
+	//返信はできないので設定を削除するだけ
+	//削除できなかったことはDeleteUserConfigがログに出している
+	DeleteUserConfig(SourceID(event))
+}
+
+//ReplyToMemberJoinedEvent グループにメンバーが参加したとき
+func ReplyToMemberJoinedEvent(ctx context.Context, event *linebot.Event) {
+	lang := GetUserLang(SourceID(event))
+	ReplyMessage(ctx, event.ReplyToken, linebot.NewTextMessage(MakeGroupUsageText(T(lang, "group.joined"), lang)))
+}
+
+//MakeGroupUsageText グループでの使い方
+func MakeGroupUsageText(greeting string, lang Lang) string {
+	return T(lang, "group.usage", greeting, GroupCallPrefixes[0])
+}
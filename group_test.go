@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+func TestTrimGroupCall(t *testing.T) {
+	saved := GroupCallPrefixes
+	defer func() { GroupCallPrefixes = saved }()
+	SetBotName("シェアサイクル")
+
+	tests := []struct {
+		text   string
+		want   string
+		called bool
+	}{
+		{text: "@bot 千代田", want: "千代田", called: true},
+		{text: "  @BOT   千代田  ", want: "千代田", called: true},
+		{text: "＠bot　千代田", want: "千代田", called: true},
+		{text: "@bot", want: "", called: true},
+		{text: "@シェアサイクル 東京駅", want: "東京駅", called: true},
+		{text: "/fav", want: "/fav", called: true},
+		//続けて書いたものは別の言葉
+		{text: "@bottle 買ってきて"},
+		{text: "@botの調子はどう"},
+		{text: "@シェアサイクルに乗った"},
+		{text: "千代田 @bot"},
+		{text: "こんにちは"},
+		{text: ""},
+		{text: "@bo"},
+	}
+	for _, tt := range tests {
+		got, called := TrimGroupCall(tt.text)
+		if got != tt.want || called != tt.called {
+			t.Errorf("TrimGroupCall(%q) = %q, %v, want %q, %v", tt.text, got, called, tt.want, tt.called)
+		}
+	}
+}
+
+func TestSourceID(t *testing.T) {
+	tests := []struct {
+		name   string
+		source *linebot.EventSource
+		want   string
+		group  bool
+	}{
+		{name: "1:1", source: &linebot.EventSource{Type: linebot.EventSourceTypeUser, UserID: "U1"}, want: "U1"},
+		{name: "グループ", source: &linebot.EventSource{Type: linebot.EventSourceTypeGroup, GroupID: "C1", UserID: "U1"}, want: "C1", group: true},
+		{name: "トークルーム", source: &linebot.EventSource{Type: linebot.EventSourceTypeRoom, RoomID: "R1", UserID: "U1"}, want: "R1", group: true},
+		{name: "送信元なし", source: nil, want: ""},
+	}
+	for _, tt := range tests {
+		event := &linebot.Event{Source: tt.source}
+		if got := SourceID(event); got != tt.want {
+			t.Errorf("%s: SourceID = %q, want %q", tt.name, got, tt.want)
+		}
+		if got := IsGroupEvent(event); got != tt.group {
+			t.Errorf("%s: IsGroupEvent = %v", tt.name, got)
+		}
+	}
+}
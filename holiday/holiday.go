@@ -0,0 +1,269 @@
+//Package holiday 日本の国民の祝日を判定する
+//祝日法の規則から計算する（振替休日・国民の休日、2019～2021年の特例を含む）
+//春分日・秋分日は近似式なので2000～2099年の範囲で使う
+package holiday
+
+import (
+	"time"
+)
+
+const (
+	//Substitute 振替休日の名前
+	Substitute = "振替休日"
+	//Citizens 国民の休日の名前
+	Citizens = "国民の休日"
+	//searchLimit 比較する日を遡って探す日数の上限
+	searchLimit = 400
+)
+
+//Name 祝日の名前（祝日でなければokがfalse）
+//日付だけを見るのでタイムゾーンは呼び出し側で日本時間にしておく
+func Name(t time.Time) (name string, ok bool) {
+	year, month, day := t.Date()
+	date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	if name, ok := nationalHoliday(date); ok {
+		return name, true
+	}
+	if isSubstitute(date) {
+		return Substitute, true
+	}
+	//前日と翌日が祝日の平日は休日になる
+	if date.Weekday() != time.Sunday {
+		_, before := nationalHoliday(date.AddDate(0, 0, -1))
+		_, after := nationalHoliday(date.AddDate(0, 0, 1))
+		if before && after {
+			return Citizens, true
+		}
+	}
+	return "", false
+}
+
+//englishNames 祝日の英語名
+var englishNames = map[string]string{
+	"元日":     "New Year's Day",
+	"成人の日":   "Coming of Age Day",
+	"建国記念の日": "National Foundation Day",
+	"天皇誕生日":  "Emperor's Birthday",
+	"春分の日":   "Vernal Equinox Day",
+	"昭和の日":   "Showa Day",
+	"みどりの日":  "Greenery Day",
+	"憲法記念日":  "Constitution Memorial Day",
+	"こどもの日":  "Children's Day",
+	"海の日":    "Marine Day",
+	"山の日":    "Mountain Day",
+	"敬老の日":   "Respect for the Aged Day",
+	"秋分の日":   "Autumnal Equinox Day",
+	"スポーツの日": "Sports Day",
+	"体育の日":   "Health and Sports Day",
+	"文化の日":   "Culture Day",
+	"勤労感謝の日": "Labor Thanksgiving Day",
+	"即位の日":   "Enthronement Day",
+	"即位礼正殿の儀の行われる日": "Enthronement Ceremony Day",
+	Substitute: "Substitute Holiday",
+	Citizens:   "Citizens' Holiday",
+}
+
+//EnglishName 祝日の英語名（祝日でなければokがfalse）
+func EnglishName(t time.Time) (name string, ok bool) {
+	name, ok = Name(t)
+	if !ok {
+		return "", false
+	}
+	if english, found := englishNames[name]; found {
+		return english, true
+	}
+	return "Public Holiday", true
+}
+
+//IsHoliday 祝日（振替休日・国民の休日を含む）か
+func IsHoliday(t time.Time) bool {
+	_, ok := Name(t)
+	return ok
+}
+
+//IsOffDay 土日か祝日か
+func IsOffDay(t time.Time) bool {
+	switch t.Weekday() {
+	case time.Saturday, time.Sunday:
+		return true
+	}
+	return IsHoliday(t)
+}
+
+//ComparableDays 台数の傾向を比べられる過去の日をn日分、近い順に返す
+//祝日なら前回までの祝日、それ以外は祝日を除いた同じ曜日
+func ComparableDays(t time.Time, n int) []time.Time {
+	var days []time.Time
+	holiday := IsHoliday(t)
+	for i := 1; i <= searchLimit && len(days) < n; i++ {
+		day := t.AddDate(0, 0, -i)
+		if holiday {
+			if IsHoliday(day) {
+				days = append(days, day)
+			}
+			continue
+		}
+		if day.Weekday() == t.Weekday() && !IsHoliday(day) {
+			days = append(days, day)
+		}
+	}
+	return days
+}
+
+//isSubstitute 振替休日か（日曜の祝日から続く祝日の翌日）
+func isSubstitute(date time.Time) bool {
+	if _, ok := nationalHoliday(date); ok {
+		return false
+	}
+	for d := date.AddDate(0, 0, -1); ; d = d.AddDate(0, 0, -1) {
+		if _, ok := nationalHoliday(d); !ok {
+			return false
+		}
+		if d.Weekday() == time.Sunday {
+			return true
+		}
+	}
+}
+
+//nationalHoliday 祝日法で日付が決まっている祝日（振替休日・国民の休日は含まない）
+func nationalHoliday(date time.Time) (string, bool) {
+	year, month, day := date.Date()
+	if name, ok := specialHoliday(year, month, day); ok {
+		return name, name != ""
+	}
+	switch month {
+	case time.January:
+		if day == 1 {
+			return "元日", true
+		}
+		if day == nthMonday(year, month, 2) {
+			return "成人の日", true
+		}
+	case time.February:
+		if day == 11 {
+			return "建国記念の日", true
+		}
+		if day == 23 && year >= 2020 {
+			return "天皇誕生日", true
+		}
+	case time.March:
+		if day == vernalEquinoxDay(year) {
+			return "春分の日", true
+		}
+	case time.April:
+		if day == 29 {
+			if year >= 2007 {
+				return "昭和の日", true
+			}
+			return "みどりの日", true
+		}
+	case time.May:
+		switch {
+		case day == 3:
+			return "憲法記念日", true
+		case day == 4 && year >= 2007:
+			return "みどりの日", true
+		case day == 5:
+			return "こどもの日", true
+		}
+	case time.July:
+		if year >= 2003 && day == nthMonday(year, month, 3) {
+			return "海の日", true
+		}
+		if year < 2003 && day == 20 {
+			return "海の日", true
+		}
+	case time.August:
+		if day == 11 && year >= 2016 {
+			return "山の日", true
+		}
+	case time.September:
+		if year >= 2003 && day == nthMonday(year, month, 3) {
+			return "敬老の日", true
+		}
+		if year < 2003 && day == 15 {
+			return "敬老の日", true
+		}
+		if day == autumnalEquinoxDay(year) {
+			return "秋分の日", true
+		}
+	case time.October:
+		if day == nthMonday(year, month, 2) {
+			if year >= 2020 {
+				return "スポーツの日", true
+			}
+			return "体育の日", true
+		}
+	case time.November:
+		switch day {
+		case 3:
+			return "文化の日", true
+		case 23:
+			return "勤労感謝の日", true
+		}
+	case time.December:
+		if day == 23 && year >= 1989 && year <= 2018 {
+			return "天皇誕生日", true
+		}
+	}
+	return "", false
+}
+
+//specialHoliday 特別な年だけの祝日と移動
+//okがtrueでnameが空なら、その年はその日に祝日がない
+func specialHoliday(year int, month time.Month, day int) (name string, ok bool) {
+	switch year {
+	case 2019:
+		switch {
+		case month == time.May && day == 1:
+			return "即位の日", true
+		case month == time.October && day == 22:
+			return "即位礼正殿の儀の行われる日", true
+		}
+	case 2020:
+		//東京オリンピックに合わせた移動
+		switch {
+		case month == time.July && day == 23:
+			return "海の日", true
+		case month == time.July && day == 24:
+			return "スポーツの日", true
+		case month == time.August && day == 10:
+			return "山の日", true
+		case month == time.July && day == nthMonday(year, month, 3),
+			month == time.October && day == nthMonday(year, month, 2),
+			month == time.August && day == 11:
+			return "", true
+		}
+	case 2021:
+		switch {
+		case month == time.July && day == 22:
+			return "海の日", true
+		case month == time.July && day == 23:
+			return "スポーツの日", true
+		case month == time.August && day == 8:
+			return "山の日", true
+		case month == time.July && day == nthMonday(year, month, 3),
+			month == time.October && day == nthMonday(year, month, 2),
+			month == time.August && day == 11:
+			return "", true
+		}
+	}
+	return "", false
+}
+
+//nthMonday その月の第n月曜日の日にち（ハッピーマンデー）
+func nthMonday(year int, month time.Month, n int) int {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC).Weekday()
+	offset := (int(time.Monday) - int(first) + 7) % 7
+	return 1 + offset + (n-1)*7
+}
+
+//vernalEquinoxDay 春分日（1980～2099年で使える近似式）
+func vernalEquinoxDay(year int) int {
+	return int(20.8431+0.242194*float64(year-1980)) - (year-1980)/4
+}
+
+//autumnalEquinoxDay 秋分日（1980～2099年で使える近似式）
+func autumnalEquinoxDay(year int) int {
+	return int(23.2488+0.242194*float64(year-1980)) - (year-1980)/4
+}
@@ -0,0 +1,170 @@
+package holiday
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+//holidays 内閣府の「国民の祝日」の一覧（振替休日・国民の休日を含む、MMDD）
+//2027年以降は春分日・秋分日が官報で決まる前なので、同じ計算で見込まれる日付
+var holidays = map[int]string{
+	2019: "0101 0114 0211 0321 0429 0430 0501 0502 0503 0504 0505 0506 0715 0811 0812 0916 0923 1014 1022 1103 1104 1123",
+	2020: "0101 0113 0211 0223 0224 0320 0429 0503 0504 0505 0506 0723 0724 0810 0921 0922 1103 1123",
+	2021: "0101 0111 0211 0223 0320 0429 0503 0504 0505 0722 0723 0808 0809 0920 0923 1103 1123",
+	2022: "0101 0110 0211 0223 0321 0429 0503 0504 0505 0718 0811 0919 0923 1010 1103 1123",
+	2023: "0101 0102 0109 0211 0223 0321 0429 0503 0504 0505 0717 0811 0918 0923 1009 1103 1123",
+	2024: "0101 0108 0211 0212 0223 0320 0429 0503 0504 0505 0506 0715 0811 0812 0916 0922 0923 1014 1103 1104 1123",
+	2025: "0101 0113 0211 0223 0224 0320 0429 0503 0504 0505 0506 0721 0811 0915 0923 1013 1103 1123 1124",
+	2026: "0101 0112 0211 0223 0320 0429 0503 0504 0505 0506 0720 0811 0921 0922 0923 1012 1103 1123",
+	2027: "0101 0111 0211 0223 0321 0322 0429 0503 0504 0505 0719 0811 0920 0923 1011 1103 1123",
+	2028: "0101 0110 0211 0223 0320 0429 0503 0504 0505 0717 0811 0918 0922 1009 1103 1123",
+	2029: "0101 0108 0211 0212 0223 0320 0429 0430 0503 0504 0505 0716 0811 0917 0923 0924 1008 1103 1123",
+	2030: "0101 0114 0211 0223 0320 0429 0503 0504 0505 0506 0715 0811 0812 0916 0923 1014 1103 1104 1123",
+}
+
+//date 日付を作る
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestIsHoliday(t *testing.T) {
+	for year, list := range holidays {
+		want := strings.Fields(list)
+		var got []string
+		for d := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC); d.Year() == year; d = d.AddDate(0, 0, 1) {
+			if IsHoliday(d) {
+				got = append(got, d.Format("0102"))
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d年\n got %v\nwant %v", year, got, want)
+		}
+	}
+}
+
+func TestName(t *testing.T) {
+	tests := []struct {
+		date string
+		want string
+	}{
+		//振替休日
+		{"2020-05-06", Substitute},
+		{"2024-02-12", Substitute},
+		{"2019-05-06", Substitute},
+		{"2021-08-09", Substitute},
+		//国民の休日（前後が祝日の平日）
+		{"2019-04-30", Citizens},
+		{"2019-05-02", Citizens},
+		{"2026-09-22", Citizens},
+		//即位に伴う祝日
+		{"2019-05-01", "即位の日"},
+		{"2019-10-22", "即位礼正殿の儀の行われる日"},
+		//東京オリンピックに合わせた移動
+		{"2020-07-23", "海の日"},
+		{"2020-07-24", "スポーツの日"},
+		{"2020-08-10", "山の日"},
+		{"2021-07-22", "海の日"},
+		{"2021-07-23", "スポーツの日"},
+		{"2021-08-08", "山の日"},
+		//名前が変わった祝日
+		{"2019-10-14", "体育の日"},
+		{"2022-10-10", "スポーツの日"},
+		{"2019-12-23", ""},
+		{"2020-02-23", "天皇誕生日"},
+		//移動する前の日は祝日ではない
+		{"2020-07-20", ""},
+		{"2020-08-11", ""},
+		{"2020-10-12", ""},
+		{"2021-07-19", ""},
+		{"2021-08-11", ""},
+		{"2021-10-11", ""},
+	}
+	for _, tt := range tests {
+		name, ok := Name(date(tt.date))
+		if name != tt.want || ok != (tt.want != "") {
+			t.Errorf("Name(%s) = %q, %v, want %q", tt.date, name, ok, tt.want)
+		}
+	}
+}
+
+func TestNameIgnoresTimeZone(t *testing.T) {
+	tokyo := time.FixedZone("Asia/Tokyo", 9*60*60)
+	//日本時間の2024-02-12 00:30（UTCでは前日）
+	if name, ok := Name(time.Date(2024, time.February, 12, 0, 30, 0, 0, tokyo)); !ok || name != Substitute {
+		t.Errorf("Name = %q, %v", name, ok)
+	}
+}
+
+func TestIsOffDay(t *testing.T) {
+	tests := []struct {
+		date string
+		want bool
+	}{
+		{"2024-06-08", true},  //土曜日
+		{"2024-06-09", true},  //日曜日
+		{"2024-06-10", false}, //月曜日
+		{"2024-09-23", true},  //振替休日
+	}
+	for _, tt := range tests {
+		if got := IsOffDay(date(tt.date)); got != tt.want {
+			t.Errorf("IsOffDay(%s) = %v, want %v", tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestComparableDays(t *testing.T) {
+	format := func(days []time.Time) []string {
+		var s []string
+		for _, d := range days {
+			s = append(s, d.Format("2006-01-02"))
+		}
+		return s
+	}
+	//平日は祝日を除いた同じ曜日（2024-05-06の振替休日と昭和の日を飛ばす）
+	got := format(ComparableDays(date("2024-05-20"), 3))
+	if want := []string{"2024-05-13", "2024-04-22", "2024-04-15"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("平日 = %v, want %v", got, want)
+	}
+	//祝日は前回までの祝日
+	got = format(ComparableDays(date("2024-05-06"), 3))
+	if want := []string{"2024-05-05", "2024-05-04", "2024-05-03"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("祝日 = %v, want %v", got, want)
+	}
+}
+
+func TestEnglishName(t *testing.T) {
+	tests := []struct {
+		date string
+		want string
+	}{
+		{"2024-01-01", "New Year's Day"},
+		{"2019-10-22", "Enthronement Ceremony Day"},
+		{"2024-02-12", "Substitute Holiday"},
+		{"2019-04-30", "Citizens' Holiday"},
+		{"2024-06-05", ""},
+	}
+	for _, tt := range tests {
+		date, err := time.Parse("2006-01-02", tt.date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, ok := EnglishName(date)
+		if name != tt.want || ok != (tt.want != "") {
+			t.Errorf("EnglishName(%s) = %q, %v, want %q", tt.date, name, ok, tt.want)
+		}
+	}
+	//すべての祝日に英語名がある
+	for day := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC); day.Year() < 2100; day = day.AddDate(0, 0, 1) {
+		if name, ok := Name(day); ok {
+			if _, found := englishNames[name]; !found {
+				t.Errorf("%sの英語名がない", name)
+			}
+		}
+	}
+}
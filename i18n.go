@@ -0,0 +1,550 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+//Lang 表示言語
+type Lang string
+
+const (
+	//LangJa 日本語
+	LangJa Lang = "ja"
+	//LangEn 英語
+	LangEn Lang = "en"
+	//DefaultLang 言語が分からないときの表示言語
+	DefaultLang = LangJa
+)
+
+//LangChoices 設定画面で選べる言語（空文字はLINEの設定に合わせる）
+var LangChoices = []Lang{"", LangJa, LangEn}
+
+//MessageCatalog 言語ごとの文言
+//キーが見つからなければ日本語、それもなければキーをそのまま表示する
+var MessageCatalog = map[Lang]map[string]string{
+	LangJa: {
+		//共通
+		"button.detail":      "詳細",
+		"button.delete":      "削除",
+		"button.deleting":    "削除しています",
+		"button.register":    "新規登録",
+		"button.registering": " 登録しています",
+		"button.switch":      "切替",
+		"button.edit":        "編集",
+		"user.loadFailed":    "ユーザー設定の読み込みに失敗しました",
+		"user.saveFailed":    "設定を保存できませんでした。しばらくしてからもう一度お試しください",
+		"follow.welcome":     "フォローありがとうございます！\n駐輪場の名前を入力してみてください",
+		"location.quick":     "位置情報で検索",
+		"postback.invalid":   "このボタンは使えません。もう一度メニューから操作してください",
+		"postback.expired":   "このボタンは有効期限が切れています。設定画面を開き直してください",
+		"location.menu":      "現在メニューから位置情報検索ができません。\n↓にある「位置情報で検索」をタップしてください",
+		"location.title":     "位置情報検索結果",
+		"location.alt":       "近いスポットを10件表示します",
+		//システム状況
+		"status.apiError":      "APIとの通信に失敗しています",
+		"status.ok":            "システムは正常に稼働しています",
+		"status.dbError":       "DBとの接続が切れています",
+		"status.scrapingError": "台数データの取得に失敗しています",
+		//APIの失敗の理由
+		"apierr.timeout":     "（APIの応答が遅いため中断しました。しばらくしてからもう一度お試しください）",
+		"apierr.status":      "（APIがエラーを返しました：%d）",
+		"apierr.decode":      "（APIの応答を読み取れませんでした）",
+		"apierr.unavailable": "（APIが停止しているようです。しばらくしてからもう一度お試しください）",
+		"apierr.network":     "（APIに接続できませんでした）",
+		//検索
+		"search.failed":      "検索に失敗しました",
+		"search.spotFailed":  "駐輪場の検索に失敗しました",
+		"api.stale":          "データが古い可能性があります",
+		"search.notFound":    "「%s」に一致するスポットが見つかりませんでした",
+		"search.tooMany":     "「%s」に一致するスポットが多すぎて表示できませんでした(%d件)\n検索クエリを変えてください",
+		"search.found":       "「%s」に一致するスポットが%d件見つかりました",
+		"search.alt":         "検索結果を表示します",
+		"search.zero":        "検索結果が0件でした",
+		"search.tooManyHits": "検索結果が多すぎます",
+		"ranking.title":      "台数が多いスポットTop %d を表示します",
+		//スポット一覧
+		"spot.count":        "[%s-%s] %s (%d台)",
+		"spot.countUnknown": "[%s-%s] %s (台数不明)",
+		"spot.bikes":        "%d台",
+		"spot.bikesUnknown": "台数不明",
+		"spot.lastUpdate":   "最終更新日時：%s",
+		"spot.noLastUpdate": "最終更新日時不明",
+		"spot.listFooter":   "「詳細」ボタンをクリックすると時系列グラフを表示します（返信まで2秒程度かかります）",
+		//グラフ
+		"graph.failed":   "グラフの作成に失敗しました",
+		"graph.wait":     "グラフ作成中です。\nしばらくお待ち下さい・・・",
+		"graph.otherDay": "別の日のグラフを表示する",
+		"forecast.text":  "%d分後の予測：約%d台",
+		"forecast.empty": "（%s頃に空になりそう）",
+		"forecast.basis": "今日は祝日（%s）なので、過去%d回の祝日と比べています",
+		//お気に入り
+		"fav.add":          "お気に入りに登録する",
+		"fav.adding":       "お気に入りに登録しています",
+		"fav.deleting":     "お気に入りから削除しています",
+		"fav.empty":        "お気に入りがまだ登録されていません",
+		"fav.noSpots":      "お気に入り登録したスポットがありません。",
+		"fav.title":        "お気に入り登録されたスポットを表示します",
+		"fav.full":         "これ以上お気に入りを登録できません",
+		"fav.cannotDelete": "お気に入りを削除できません",
+		//お気に入りグループ
+		"favgroup.title":       "「%s」のお気に入りを表示します",
+		"favgroup.notFound":    "「%s」というお気に入りグループはありません",
+		"favgroup.empty":       "お気に入りグループ「%s」にはスポットがありません",
+		"favgroup.list":        "通常のお気に入りはまだ登録されていません。\nお気に入りグループ：%s\n「/fav グループ名」でグループのスポットを表示します",
+		"favgroup.edit":        "並べ替え・編集",
+		"favgroup.editButton":  "編集",
+		"favgroup.editTitle":   "お気に入りグループ「%s」",
+		"favgroup.editHint":    "「/fav %[1]s A1-01」のように入力するとスポットを追加できます（%[2]d件まで）",
+		"favgroup.show":        "台数を表示",
+		"favgroup.deleteGroup": "グループを削除",
+		"favgroup.deleted":     "お気に入りグループ「%s」を削除しました",
+		"favgroup.addTo":       "「%s」に追加",
+		"favgroup.item":        "%s（%d件）",
+		"favgroup.howto":       "「/fav 会社 A1-01」のように入力すると名前を付けたお気に入りグループを作れます",
+		"favgroup.badName":     "グループ名は%d文字以内で指定してください",
+		"favgroup.unknownSpot": "スポット「%s」が見つかりません",
+		"favgroup.tooMany":     "お気に入りグループは%d個まで作成できます",
+		"favgroup.full":        "「%s」にはこれ以上登録できません（%d件まで）",
+		//コマンド・履歴
+		"command.title":      "コマンド一覧です",
+		"command.alt":        "コマンド一覧を表示します",
+		"command.ranking":    "台数ランキング",
+		"command.rankingMsg": "台数が多い順にスポットを表示します",
+		"command.trip":       "2地点でルート検索",
+		"command.tripMsg":    "2地点でルート検索します",
+		"command.config":     "設定",
+		"command.configMsg":  "設定画面を開きます",
+		"command.status":     "システム障害状況",
+		"command.statusMsg":  "稼働状況の確認中です...",
+		"history.empty":      "履歴がありません",
+		"history.title":      "履歴の一覧を表示します",
+		"history.alt":        "検索履歴を10件まで表示します",
+		//設定画面
+		"config.alt":            "設定画面",
+		"config.title":          "ユーザー設定",
+		"config.favorites":      "お気に入り登録されたスポット",
+		"config.favoriteGroups": "お気に入りグループ（%d個まで作成できます）",
+		"config.notifies":       "お気に入り登録したスポットの通知の設定（%d件まで設定できます）",
+		"config.alerts":         "お気に入り登録したスポットの台数アラート（%d件まで設定できます）",
+		"config.announce":       "お気に入りの近くに新しいスポットができたときや、お気に入りのスポットがなくなったときのお知らせ",
+		"config.unset":          "未登録",
+		"config.language":       "表示言語（現在：%s）",
+		"config.languageAuto":   "自動（LINEの設定）",
+		"config.back":           "設定画面へ",
+		//通知
+		"notify.full":           "これ以上通知を登録できません",
+		"notify.notFound":       "その通知は見つかりませんでした。設定画面から選び直してください",
+		"notify.badTime":        "時刻を読み取れませんでした",
+		"notify.withGroup":      "%s（%s）",
+		"notify.allFavorites":   "お気に入り",
+		"notify.spotTitle":      "%sの台数",
+		"notify.editTitle":      "%sの通知",
+		"notify.everyday":       "毎日",
+		"notify.weekdays":       "平日",
+		"notify.weekends":       "土日",
+		"notify.once":           "%sだけ",
+		"notify.daySeparator":   "・",
+		"notify.exceptHolidays": "（祝日を除く）",
+		"notify.days":           "曜日（押すと切り替わります）",
+		"notify.holidays":       "祝日",
+		"notify.holidaysSend":   "祝日も送る",
+		"notify.holidaysSkip":   "祝日は送らない",
+		"notify.asSunday":       "祝日は日曜日として扱う",
+		"notify.asSundayNote":   "（祝日は日曜扱い）",
+		"notify.repeat":         "繰り返し",
+		"notify.repeatWeekly":   "毎週",
+		"notify.repeatTomorrow": "明日だけ",
+		"notify.target":         "送る内容",
+		"notify.targetHint":     "下のボタンからお気に入りグループやスポットを選べます",
+		"notify.changeTime":     "時刻を変更",
+		"weekday.0":             "日",
+		"weekday.1":             "月",
+		"weekday.2":             "火",
+		"weekday.3":             "水",
+		"weekday.4":             "木",
+		"weekday.5":             "金",
+		"weekday.6":             "土",
+		//通知の一時停止
+		"pause.title":       "通知の一時停止",
+		"pause.snooze":      "一時停止",
+		"pause.days":        "%d日",
+		"pause.snoozed":     "%sまで停止中",
+		"pause.quiet":       "%s～%sは送らない",
+		"pause.vacation":    "休暇中（%s～%s）",
+		"pause.resume":      "解除",
+		"pause.resuming":    "一時停止を解除しています",
+		"pause.howto":       "「/snooze 3d」で一時停止、「/quiet 22:00-07:00」で送らない時間帯、「/vacation 8/10 8/16」で休暇を設定できます（offで解除）",
+		"pause.badSnooze":   "「/snooze 3d」「/snooze 12h」のように%d日以内の長さを指定してください（「/snooze off」で解除）",
+		"pause.badQuiet":    "「/quiet 22:00-07:00」のように時間帯を指定してください（「/quiet off」で解除）",
+		"pause.badVacation": "「/vacation 8/10 8/16」のように%d日以内の期間を指定してください（「/vacation off」で解除）",
+		"pause.snoozeSet":   "%sまで通知を止めます",
+		"pause.quietSet":    "毎日%s～%sは通知を送りません",
+		"pause.vacationSet": "%s～%sは通知を送りません。休暇が明けたらお知らせします",
+		"pause.snoozeOff":   "通知の一時停止を解除しました",
+		"pause.quietOff":    "通知を送らない時間帯を解除しました",
+		"pause.vacationOff": "休暇を解除しました",
+		"pause.resumed":     "おかえりなさい！休暇が明けたので通知を再開します",
+		//台数アラート
+		"alert.deleting":        "アラートを削除しています",
+		"alert.registering":     "アラートを登録します",
+		"alert.full":            "これ以上アラートを登録できません",
+		"alert.needFavorite":    "アラートはお気に入りのスポットに設定できます。先にお気に入りを登録してください",
+		"alert.chooseSpot":      "アラートを設定するスポットを選んでください",
+		"alert.chooseCondition": "[%s] %s\nいつ通知しますか？",
+		"alert.invalid":         "アラートの条件が不正です",
+		"alert.cannotDelete":    "アラートを削除できません",
+		"alert.empty":           "0台になったら",
+		"alert.below":           "%d台未満",
+		"alert.above":           "%d台以上",
+		"alert.message":         "台数アラート\n[%s] %s の台数が%sになりました（現在%d台）",
+		//スポットのお知らせ
+		"announce.enabled":  "受け取る",
+		"announce.disabled": "受け取らない",
+		"announce.stop":     "停止",
+		"announce.receive":  "受け取る",
+		"announce.stopping": "お知らせを停止しています",
+		"announce.starting": "お知らせを受け取る設定にしています",
+		"announce.title":    "スポットのお知らせ",
+		"announce.removed":  "・お気に入りの[%s] %s がなくなりました",
+		"announce.renamed":  "・お気に入りの[%s]の名前が変わりました\n　%s → %s",
+		"announce.added":    "・お気に入りの[%s]の近く（約%dm）に新しいスポット[%s] %s ができました",
+		//ルート検索
+		"trip.title":          "ルート検索結果",
+		"trip.origin":         "出発地",
+		"trip.dest":           "目的地",
+		"trip.askOrigin":      "出発地の位置情報を送ってください",
+		"trip.askDest":        "続けて目的地の位置情報を送ってください",
+		"trip.originNotFound": "出発地「%s」が見つかりませんでした",
+		"trip.destNotFound":   "目的地「%s」が見つかりませんでした",
+		"trip.noSpots":        "近くにスポットが見つかりませんでした",
+		"trip.rent":           "出発地の近くで借りる",
+		"trip.return":         "目的地の近くで返す",
+		"trip.candidate":      "[%s-%s] %s\n徒歩約%dm (%s)",
+		//Slack
+		"command.slack":       "Slack連携",
+		"command.slackMsg":    "Slack連携のコードを発行します",
+		"slack.helpTitle":     "使い方",
+		"slack.help":          "`%[1]s 駐輪場の名前` でスポットを検索します。\n`%[1]s fav` お気に入り（`%[1]s fav グループ名` でお気に入りグループ）　`%[1]s ranking` 台数ランキング　`%[1]s status` システム障害状況\n`%[1]s link コード` LINEの設定と連携します（LINEのコマンド一覧「Slack連携」でコードを発行）",
+		"slack.favorites":     "お気に入り",
+		"slack.favRemove":     "お気に入りから削除する",
+		"slack.favAdded":      "お気に入りに登録しました：%s",
+		"slack.favRemoved":    "お気に入りから削除しました：%s",
+		"slack.more":          "ほか%d件",
+		"slack.unsupported":   "この操作はSlackではできません",
+		"slack.linkCode":      "Slackで `/bikeshare link %s` と入力すると、このアカウントのお気に入りや通知をSlackでも使えます（%d分以内に入力してください）",
+		"slack.linked":        "LINEのアカウントと連携しました",
+		"slack.linkInvalid":   "連携コードが正しくないか、有効期限が切れています",
+		"slack.linkLocked":    "連携コードを何度も間違えたため、%d分ほど待ってからもう一度お試しください",
+		"slack.notConfigured": "Slack連携は利用できません",
+		"slack.groupOnly":     "Slack連携は1:1のトークで行ってください",
+		//グループ
+		"group.invited": "招待ありがとうございます！",
+		"group.joined":  "ようこそ！",
+		"group.called":  "お呼びでしょうか？",
+		"group.usage":   "%s\nグループでは「%s 駐輪場の名前」のように話しかけると検索します。\n「/commands」でコマンド一覧を表示します。\nお気に入りや通知時刻はグループで共有されます。",
+	},
+	LangEn: {
+		//共通
+		"button.detail":      "Details",
+		"button.delete":      "Delete",
+		"button.deleting":    "Deleting...",
+		"button.register":    "Add",
+		"button.registering": "Adding...",
+		"button.switch":      "Switch",
+		"button.edit":        "Edit",
+		"user.loadFailed":    "Failed to load your settings",
+		"user.saveFailed":    "Could not save your settings. Please try again later",
+		"follow.welcome":     "Thanks for following!\nTry sending the name of a bike station.",
+		"location.quick":     "Search by location",
+		"postback.invalid":   "This button cannot be used. Please try again from the menu",
+		"postback.expired":   "This button has expired. Please open the settings again",
+		"location.menu":      "Location search is not available from the menu.\nTap \"Search by location\" below.",
+		"location.title":     "Stations near you",
+		"location.alt":       "Showing the 10 nearest stations",
+		//システム状況
+		"status.apiError":      "Cannot reach the API",
+		"status.ok":            "All systems are operational",
+		"status.dbError":       "The database connection is down",
+		"status.scrapingError": "Failed to collect bike counts",
+		//APIの失敗の理由
+		"apierr.timeout":     "(The API is responding slowly. Please try again later)",
+		"apierr.status":      "(The API returned an error: %d)",
+		"apierr.decode":      "(Could not read the API response)",
+		"apierr.unavailable": "(The API seems to be down. Please try again later)",
+		"apierr.network":     "(Could not connect to the API)",
+		//検索
+		"search.failed":      "Search failed",
+		"search.spotFailed":  "Station search failed",
+		"api.stale":          "The data may be out of date",
+		"search.notFound":    "No stations matched \"%s\"",
+		"search.tooMany":     "Too many stations matched \"%s\" (%d)\nPlease refine your search",
+		"search.found":       "%[2]d stations matched \"%[1]s\"",
+		"search.alt":         "Search results",
+		"search.zero":        "No results",
+		"search.tooManyHits": "Too many results",
+		"ranking.title":      "Top %d stations by number of bikes",
+		//スポット一覧
+		"spot.count":        "[%s-%s] %s (%d bikes)",
+		"spot.countUnknown": "[%s-%s] %s (count unknown)",
+		"spot.bikes":        "%d bikes",
+		"spot.bikesUnknown": "count unknown",
+		"spot.lastUpdate":   "Last updated: %s",
+		"spot.noLastUpdate": "Last update unknown",
+		"spot.listFooter":   "Tap \"Details\" to see a graph over time (takes about 2 seconds)",
+		//グラフ
+		"graph.failed":   "Failed to create the graph",
+		"graph.wait":     "Creating the graph.\nPlease wait...",
+		"graph.otherDay": "Show another day",
+		"forecast.text":  "Forecast in %d min: about %d bikes",
+		"forecast.empty": " (likely empty around %s)",
+		"forecast.basis": "Today is a public holiday (%s), so it is compared with the last %d holidays",
+		//お気に入り
+		"fav.add":          "Add to favorites",
+		"fav.adding":       "Adding to favorites...",
+		"fav.deleting":     "Removing from favorites...",
+		"fav.empty":        "You have no favorites yet",
+		"fav.noSpots":      "None of your favorite stations were found.",
+		"fav.title":        "Your favorite stations",
+		"fav.full":         "You cannot add more favorites",
+		"fav.cannotDelete": "Cannot remove the favorite",
+		//お気に入りグループ
+		"favgroup.title":       "Favorites in \"%s\"",
+		"favgroup.notFound":    "There is no favorite group named \"%s\"",
+		"favgroup.empty":       "The favorite group \"%s\" has no stations",
+		"favgroup.list":        "You have no favorites yet.\nFavorite groups: %s\nSend \"/fav GROUP\" to see a group.",
+		"favgroup.edit":        "Reorder / edit",
+		"favgroup.editButton":  "Edit",
+		"favgroup.editTitle":   "Favorite group \"%s\"",
+		"favgroup.editHint":    "Send \"/fav %[1]s A1-01\" to add a station (up to %[2]d)",
+		"favgroup.show":        "Show counts",
+		"favgroup.deleteGroup": "Delete group",
+		"favgroup.deleted":     "Deleted the favorite group \"%s\"",
+		"favgroup.addTo":       "Add to %s",
+		"favgroup.item":        "%s (%d)",
+		"favgroup.howto":       "Send \"/fav work A1-01\" to create a named favorite group",
+		"favgroup.badName":     "Group names must be %d characters or fewer",
+		"favgroup.unknownSpot": "Station \"%s\" was not found",
+		"favgroup.tooMany":     "You can create up to %d favorite groups",
+		"favgroup.full":        "\"%s\" is full (up to %d stations)",
+		//コマンド・履歴
+		"command.title":      "Commands",
+		"command.alt":        "Showing the command list",
+		"command.ranking":    "Bike ranking",
+		"command.rankingMsg": "Showing stations with the most bikes",
+		"command.trip":       "Route between 2 places",
+		"command.tripMsg":    "Searching a route between 2 places",
+		"command.config":     "Settings",
+		"command.configMsg":  "Opening settings",
+		"command.status":     "System status",
+		"command.statusMsg":  "Checking the system status...",
+		"history.empty":      "No history",
+		"history.title":      "Your search history",
+		"history.alt":        "Showing up to 10 recent searches",
+		//設定画面
+		"config.alt":            "Settings",
+		"config.title":          "Settings",
+		"config.favorites":      "Favorite stations",
+		"config.favoriteGroups": "Favorite groups (up to %d)",
+		"config.notifies":       "Notifications for your favorites (up to %d)",
+		"config.alerts":         "Bike count alerts for your favorites (up to %d)",
+		"config.announce":       "Notify me when a station opens near my favorites or a favorite station closes",
+		"config.unset":          "Not set",
+		"config.language":       "Language (current: %s)",
+		"config.languageAuto":   "Auto (LINE setting)",
+		"config.back":           "Settings",
+		//通知
+		"notify.full":           "You cannot add more notifications",
+		"notify.notFound":       "That notification was not found. Please choose it again from the settings",
+		"notify.badTime":        "Could not read the time",
+		"notify.withGroup":      "%s (%s)",
+		"notify.allFavorites":   "Favorites",
+		"notify.spotTitle":      "%s",
+		"notify.editTitle":      "Notification at %s",
+		"notify.everyday":       "Every day",
+		"notify.weekdays":       "Weekdays",
+		"notify.weekends":       "Weekends",
+		"notify.once":           "Only %s",
+		"notify.daySeparator":   ", ",
+		"notify.exceptHolidays": " (except holidays)",
+		"notify.days":           "Days (tap to toggle)",
+		"notify.holidays":       "Public holidays",
+		"notify.holidaysSend":   "Send on holidays",
+		"notify.holidaysSkip":   "Skip holidays",
+		"notify.asSunday":       "Treat holidays as Sunday",
+		"notify.asSundayNote":   " (holidays as Sunday)",
+		"notify.repeat":         "Repeat",
+		"notify.repeatWeekly":   "Every week",
+		"notify.repeatTomorrow": "Tomorrow only",
+		"notify.target":         "What to send",
+		"notify.targetHint":     "Choose a favorite group or station from the buttons below",
+		"notify.changeTime":     "Change time",
+		"weekday.0":             "Sun",
+		"weekday.1":             "Mon",
+		"weekday.2":             "Tue",
+		"weekday.3":             "Wed",
+		"weekday.4":             "Thu",
+		"weekday.5":             "Fri",
+		"weekday.6":             "Sat",
+		//通知の一時停止
+		"pause.title":       "Pause notifications",
+		"pause.snooze":      "Snooze",
+		"pause.days":        "%dd",
+		"pause.snoozed":     "Snoozed until %s",
+		"pause.quiet":       "Quiet hours %s-%s",
+		"pause.vacation":    "On vacation (%s-%s)",
+		"pause.resume":      "Resume",
+		"pause.resuming":    "Resuming notifications...",
+		"pause.howto":       "Send \"/snooze 3d\" to snooze, \"/quiet 22:00-07:00\" for quiet hours or \"/vacation 8/10 8/16\" for a vacation (\"off\" to cancel)",
+		"pause.badSnooze":   "Send a length up to %d days like \"/snooze 3d\" or \"/snooze 12h\" (\"/snooze off\" to cancel)",
+		"pause.badQuiet":    "Send a time range like \"/quiet 22:00-07:00\" (\"/quiet off\" to cancel)",
+		"pause.badVacation": "Send a period up to %d days like \"/vacation 8/10 8/16\" (\"/vacation off\" to cancel)",
+		"pause.snoozeSet":   "Notifications are snoozed until %s",
+		"pause.quietSet":    "No notifications between %s and %s every day",
+		"pause.vacationSet": "No notifications from %s to %s. I will let you know when your vacation is over",
+		"pause.snoozeOff":   "Notifications resumed",
+		"pause.quietOff":    "Quiet hours removed",
+		"pause.vacationOff": "Vacation removed",
+		"pause.resumed":     "Welcome back! Your vacation is over, so notifications are back on",
+		//台数アラート
+		"alert.deleting":        "Removing the alert...",
+		"alert.registering":     "Adding an alert",
+		"alert.full":            "You cannot add more alerts",
+		"alert.needFavorite":    "Alerts can be set on favorite stations. Please add a favorite first",
+		"alert.chooseSpot":      "Choose a station for the alert",
+		"alert.chooseCondition": "[%s] %s\nWhen should I notify you?",
+		"alert.invalid":         "Invalid alert condition",
+		"alert.cannotDelete":    "Cannot remove the alert",
+		"alert.empty":           "when empty",
+		"alert.below":           "below %d",
+		"alert.above":           "%d or more",
+		"alert.message":         "Bike alert\n[%s] %s is now %s (%d bikes)",
+		//スポットのお知らせ
+		"announce.enabled":  "On",
+		"announce.disabled": "Off",
+		"announce.stop":     "Stop",
+		"announce.receive":  "Turn on",
+		"announce.stopping": "Turning off notices...",
+		"announce.starting": "Turning on notices...",
+		"announce.title":    "Station notice",
+		"announce.removed":  "- Your favorite [%s] %s has been removed",
+		"announce.renamed":  "- Your favorite [%s] has been renamed\n  %s -> %s",
+		"announce.added":    "- A new station [%[3]s] %[4]s opened near your favorite [%[1]s] (about %[2]dm)",
+		//ルート検索
+		"trip.title":          "Route search",
+		"trip.origin":         "Origin",
+		"trip.dest":           "Destination",
+		"trip.askOrigin":      "Send the location of your origin",
+		"trip.askDest":        "Now send the location of your destination",
+		"trip.originNotFound": "Origin \"%s\" was not found",
+		"trip.destNotFound":   "Destination \"%s\" was not found",
+		"trip.noSpots":        "No stations found nearby",
+		"trip.rent":           "Rent near the origin",
+		"trip.return":         "Return near the destination",
+		"trip.candidate":      "[%s-%s] %s\nabout %dm walk (%s)",
+		//Slack
+		"command.slack":       "Link Slack",
+		"command.slackMsg":    "Issuing a Slack link code",
+		"slack.helpTitle":     "Usage",
+		"slack.help":          "`%[1]s station name` searches stations.\n`%[1]s fav` favorites (`%[1]s fav GROUP` for a favorite group)  `%[1]s ranking` bike ranking  `%[1]s status` system status\n`%[1]s link CODE` links your LINE settings (get a code from \"Link Slack\" in the LINE command list)",
+		"slack.favorites":     "Favorites",
+		"slack.favRemove":     "Remove from favorites",
+		"slack.favAdded":      "Added to favorites: %s",
+		"slack.favRemoved":    "Removed from favorites: %s",
+		"slack.more":          "%d more",
+		"slack.unsupported":   "This action is not available on Slack",
+		"slack.linkCode":      "Type `/bikeshare link %s` in Slack to use this account's favorites and notifications there (within %d minutes)",
+		"slack.linked":        "Linked with your LINE account",
+		"slack.linkInvalid":   "The link code is wrong or has expired",
+		"slack.linkLocked":    "Too many wrong link codes. Please wait about %d minutes and try again",
+		"slack.notConfigured": "Slack integration is not available",
+		"slack.groupOnly":     "Please link Slack from a 1:1 chat",
+		//グループ
+		"group.invited": "Thanks for inviting me!",
+		"group.joined":  "Welcome!",
+		"group.called":  "How can I help?",
+		"group.usage":   "%s\nIn groups, talk to me like \"%s station name\" to search.\nSend \"/commands\" to see the command list.\nFavorites and notification times are shared in the group.",
+	},
+}
+
+//T 言語に合わせた文言を返す（引数があれば書式に埋め込む）
+func T(lang Lang, key string, args ...interface{}) string {
+	text, ok := MessageCatalog[lang][key]
+	if !ok {
+		if text, ok = MessageCatalog[DefaultLang][key]; !ok {
+			text = key
+		}
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+//ParseLang LINEの言語設定（"en-US"など）を表示言語に変換する（対応していなければ空文字）
+func ParseLang(value string) Lang {
+	value = strings.ToLower(value)
+	if i := strings.IndexAny(value, "-_"); i >= 0 {
+		value = value[:i]
+	}
+	lang := Lang(value)
+	if _, ok := MessageCatalog[lang]; ok {
+		return lang
+	}
+	return ""
+}
+
+//LangName 言語の表示名（空文字は自動）
+func LangName(lang Lang, display Lang) string {
+	switch lang {
+	case LangJa:
+		return "日本語"
+	case LangEn:
+		return "English"
+	}
+	return T(display, "config.languageAuto")
+}
+
+//Lang ユーザーの表示言語（設定 > LINEの言語設定 > 日本語の順）
+func (user *UserConfig) Lang() Lang {
+	if user == nil {
+		return DefaultLang
+	}
+	if lang := ParseLang(user.Language); lang != "" {
+		return lang
+	}
+	if lang := ParseLang(user.ProfileLanguage); lang != "" {
+		return lang
+	}
+	return DefaultLang
+}
+
+//GetUserLang ユーザーの表示言語
+func GetUserLang(userID string) Lang {
+	return GetUserConfigFromCache(userID).Lang()
+}
+
+//FetchProfileLanguage 1:1のトークならLINEのプロフィールから言語を取得して保存する
+//取得済みなら何もしない
+func FetchProfileLanguage(userID string) {
+	if LineBotAPI == nil || !strings.HasPrefix(userID, "U") {
+		//グループ・トークルームにはプロフィールがない
+		return
+	}
+	if user := GetUserConfigFromCache(userID); user != nil && user.ProfileLanguage != "" {
+		return
+	}
+	profile, err := LineBotAPI.GetProfile(userID).Do()
+	if err != nil {
+		LogWarn(context.Background(), "プロフィールを取得できませんでした", LogFields{"error": err, "user": HashUserID(userID)})
+		return
+	}
+	if profile.Language == "" {
+		return
+	}
+	//保存できなくても次の機会に取り直すだけなので、エラーはUpdateUserConfigFuncのログに任せる
+	UpdateUserConfigFunc(userID, func(user *UserConfig) {
+		user.ProfileLanguage = profile.Language
+	})
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//LogLevel ログの重要度
+type LogLevel int
+
+const (
+	//LogLevelDebug 開発時だけ見たいもの（APIの1回ごとの応答時間など）
+	LogLevelDebug LogLevel = iota
+	//LogLevelInfo 普段の動作
+	LogLevelInfo
+	//LogLevelWarn 処理は続けられたが気にしておくもの
+	LogLevelWarn
+	//LogLevelError 処理に失敗したもの
+	LogLevelError
+)
+
+//logLevelNames ログに出す重要度の名前
+var logLevelNames = map[LogLevel]string{
+	LogLevelDebug: "debug",
+	LogLevelInfo:  "info",
+	LogLevelWarn:  "warn",
+	LogLevelError: "error",
+}
+
+func (level LogLevel) String() string {
+	return logLevelNames[level]
+}
+
+//ParseLogLevel 重要度の名前を解釈する（大文字小文字は区別しない）
+func ParseLogLevel(value string) (LogLevel, bool) {
+	for level, name := range logLevelNames {
+		if strings.EqualFold(value, name) {
+			return level, true
+		}
+	}
+	return LogLevelInfo, false
+}
+
+//LogFields ログに付ける項目（errorは文字列にして出す）
+type LogFields map[string]interface{}
+
+//Logger JSONで1行ずつ書き出すログ
+type Logger struct {
+	//Out 書き出し先
+	Out io.Writer
+	//Level これより重要度の低いものは出さない
+	Level LogLevel
+
+	mu sync.Mutex
+}
+
+//AppLogger ボット全体で使うログ
+var AppLogger = &Logger{Out: os.Stdout, Level: LogLevelInfo}
+
+//SetupLogger 環境変数LOG_LEVELを読んでログの重要度を設定する
+func SetupLogger() {
+	value := os.Getenv("LOG_LEVEL")
+	if value == "" {
+		return
+	}
+	if level, ok := ParseLogLevel(value); ok {
+		AppLogger.Level = level
+	} else {
+		LogWarn(context.Background(), "LOG_LEVELが不正です", LogFields{"value": value})
+	}
+}
+
+//Log ctxに付けた項目（イベントIDなど）と合わせて書き出す
+func (logger *Logger) Log(ctx context.Context, level LogLevel, msg string, fields LogFields) {
+	if level < logger.Level {
+		return
+	}
+	merged := LogFields{}
+	if scope := logScopeFrom(ctx); scope != nil {
+		scope.mu.Lock()
+		for key, value := range scope.fields {
+			merged[key] = value
+		}
+		scope.mu.Unlock()
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+
+	//時刻・重要度・内容を先頭にして、残りは名前順に並べる
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	writeLogValue(&buf, time.Now().In(LocationTokyo).Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeLogValue(&buf, level.String())
+	buf.WriteString(`,"msg":`)
+	writeLogValue(&buf, msg)
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		buf.WriteByte(',')
+		writeLogValue(&buf, key)
+		buf.WriteByte(':')
+		writeLogValue(&buf, merged[key])
+	}
+	buf.WriteString("}\n")
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	logger.Out.Write(buf.Bytes())
+}
+
+//writeLogValue 値をJSONで書く（書けない値は文字列にする）
+func writeLogValue(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case time.Duration:
+		value = v.String()
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		b, _ = json.Marshal(err.Error())
+	}
+	buf.Write(b)
+}
+
+//LogDebug 開発時だけ見たいもの
+func LogDebug(ctx context.Context, msg string, fields LogFields) {
+	AppLogger.Log(ctx, LogLevelDebug, msg, fields)
+}
+
+//LogInfo 普段の動作
+func LogInfo(ctx context.Context, msg string, fields LogFields) {
+	AppLogger.Log(ctx, LogLevelInfo, msg, fields)
+}
+
+//LogWarn 処理は続けられたが気にしておくもの
+func LogWarn(ctx context.Context, msg string, fields LogFields) {
+	AppLogger.Log(ctx, LogLevelWarn, msg, fields)
+}
+
+//LogError 処理に失敗したもの
+func LogError(ctx context.Context, msg string, fields LogFields) {
+	AppLogger.Log(ctx, LogLevelError, msg, fields)
+}
+
+//HashUserID ログに出すユーザーID（そのままは出さずにハッシュの先頭だけにする）
+func HashUserID(userID string) string {
+	if userID == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(userID))
+	return hex.EncodeToString(sum[:8])
+}
+
+//NewCorrelationID 1つのイベントのログをまとめるID
+func NewCorrelationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strings.Replace(time.Now().Format("150405.000000000"), ".", "", 1)
+	}
+	return hex.EncodeToString(b)
+}
+
+//logScope 1つのイベント（や定期処理）のログに共通で付ける項目
+type logScope struct {
+	mu       sync.Mutex
+	fields   LogFields
+	apiCalls int
+	apiTime  time.Duration
+}
+
+//logScopeKey contextにlogScopeを入れるキー
+type logScopeKey struct{}
+
+//WithLogFields ctxから出すログにfieldsを付ける（イベントIDのない処理にはIDを振る）
+func WithLogFields(ctx context.Context, fields LogFields) context.Context {
+	scope := &logScope{fields: LogFields{}}
+	if parent := logScopeFrom(ctx); parent != nil {
+		parent.mu.Lock()
+		for key, value := range parent.fields {
+			scope.fields[key] = value
+		}
+		parent.mu.Unlock()
+	} else {
+		scope.fields["event_id"] = NewCorrelationID()
+	}
+	for key, value := range fields {
+		scope.fields[key] = value
+	}
+	return context.WithValue(ctx, logScopeKey{}, scope)
+}
+
+//SetLogField 処理の途中でわかった項目（コマンドの種類など）をctxのログに足す
+func SetLogField(ctx context.Context, key string, value interface{}) {
+	if scope := logScopeFrom(ctx); scope != nil {
+		scope.mu.Lock()
+		scope.fields[key] = value
+		scope.mu.Unlock()
+	}
+}
+
+//addAPITiming APIの呼び出しにかかった時間を足しておく（処理の最後にまとめて出す）
+func addAPITiming(ctx context.Context, elapsed time.Duration) {
+	if scope := logScopeFrom(ctx); scope != nil {
+		scope.mu.Lock()
+		scope.apiCalls++
+		scope.apiTime += elapsed
+		scope.mu.Unlock()
+	}
+}
+
+//logTimings 処理全体とAPIの呼び出しにかかった時間の項目
+func logTimings(ctx context.Context, start time.Time) LogFields {
+	fields := LogFields{"duration_ms": time.Since(start).Milliseconds()}
+	if scope := logScopeFrom(ctx); scope != nil {
+		scope.mu.Lock()
+		fields["api_calls"] = scope.apiCalls
+		fields["api_ms"] = scope.apiTime.Milliseconds()
+		scope.mu.Unlock()
+	}
+	return fields
+}
+
+//logScopeFrom ctxに入っているlogScope（なければnil）
+func logScopeFrom(ctx context.Context) *logScope {
+	if ctx == nil {
+		return nil
+	}
+	scope, _ := ctx.Value(logScopeKey{}).(*logScope)
+	return scope
+}
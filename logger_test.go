@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+//logLines 書き出した行をJSONとして読む
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestLoggerWritesJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &Logger{Out: &buf, Level: LogLevelDebug}
+	logger.Log(context.Background(), LogLevelWarn, "警告\n2行目", LogFields{
+		"zeta":    1,
+		"alpha":   "a",
+		"error":   errors.New("失敗"),
+		"elapsed": 1500 * time.Millisecond,
+		"bad":     func() {},
+	})
+	out := buf.String()
+	if strings.Count(out, "\n") != 1 || !strings.HasSuffix(out, "\n") {
+		t.Fatalf("1行になっていない: %q", out)
+	}
+	//時刻・重要度・内容が先頭で、残りは名前順
+	if !strings.HasPrefix(out, `{"time":"`) || !strings.Contains(out, `"level":"warn","msg":"警告\n2行目","alpha":"a","bad":`) {
+		t.Errorf("並び = %s", out)
+	}
+	fields := logLines(t, &buf)[0]
+	if _, err := time.Parse(time.RFC3339Nano, fields["time"].(string)); err != nil {
+		t.Errorf("time = %v", fields["time"])
+	}
+	want := map[string]interface{}{"level": "warn", "msg": "警告\n2行目", "zeta": float64(1), "alpha": "a", "error": "失敗", "elapsed": "1.5s"}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Errorf("%s = %v, want %v", key, fields[key], value)
+		}
+	}
+	//JSONにできない値も行を壊さない
+	if _, ok := fields["bad"].(string); !ok {
+		t.Errorf("bad = %v", fields["bad"])
+	}
+}
+
+func TestLoggerMergesScopeFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &Logger{Out: &buf, Level: LogLevelInfo}
+	ctx := WithLogFields(context.Background(), LogFields{"event": "message", "user": "u1"})
+	child := WithLogFields(ctx, LogFields{"command": "search"})
+	SetLogField(child, "result", "ok")
+	//子に足した項目は親には付かない
+	logger.Log(ctx, LogLevelInfo, "親", nil)
+	//呼び出しで渡した項目がスコープの項目より優先される
+	logger.Log(child, LogLevelInfo, "子", LogFields{"user": "u2"})
+
+	lines := logLines(t, &buf)
+	if len(lines) != 2 {
+		t.Fatalf("lines = %v", lines)
+	}
+	parent, got := lines[0], lines[1]
+	if parent["event_id"] == nil || parent["event_id"] != got["event_id"] {
+		t.Errorf("event_id = %v, %v", parent["event_id"], got["event_id"])
+	}
+	if parent["command"] != nil || parent["result"] != nil || parent["user"] != "u1" {
+		t.Errorf("親 = %v", parent)
+	}
+	for key, value := range map[string]interface{}{"event": "message", "command": "search", "result": "ok", "user": "u2"} {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %v", key, got[key], value)
+		}
+	}
+}
+
+func TestLoggerLevelFilter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &Logger{Out: &buf, Level: LogLevelWarn}
+	ctx := context.Background()
+	logger.Log(ctx, LogLevelDebug, "debug", nil)
+	logger.Log(ctx, LogLevelInfo, "info", nil)
+	logger.Log(ctx, LogLevelWarn, "warn", nil)
+	logger.Log(ctx, LogLevelError, "error", nil)
+	var msgs []string
+	for _, line := range logLines(t, &buf) {
+		msgs = append(msgs, line["msg"].(string))
+	}
+	if want := []string{"warn", "error"}; !reflect.DeepEqual(msgs, want) {
+		t.Errorf("msgs = %v, want %v", msgs, want)
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		value string
+		want  LogLevel
+		ok    bool
+	}{
+		{"debug", LogLevelDebug, true},
+		{"WARN", LogLevelWarn, true},
+		{"Error", LogLevelError, true},
+		{"verbose", LogLevelInfo, false},
+	}
+	for _, tt := range tests {
+		if got, ok := ParseLogLevel(tt.value); got != tt.want || ok != tt.ok {
+			t.Errorf("ParseLogLevel(%q) = %v, %v", tt.value, got, ok)
+		}
+	}
+}
+
+func TestHashUserID(t *testing.T) {
+	hash := HashUserID("U1234567890abcdef")
+	if len(hash) != 16 || strings.Trim(hash, "0123456789abcdef") != "" {
+		t.Errorf("HashUserID = %q", hash)
+	}
+	if HashUserID("U1234567890abcdef") != hash {
+		t.Error("同じIDでハッシュが変わった")
+	}
+	if HashUserID("U2") == hash {
+		t.Error("違うIDで同じハッシュになった")
+	}
+	if strings.Contains(hash, "U123") {
+		t.Error("IDがそのまま出ている")
+	}
+	if HashUserID("") != "" {
+		t.Error("空のIDは空のまま")
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	//テスト中のログは出さない
+	AppLogger.Out = ioutil.Discard
+	os.Exit(m.Run())
+}
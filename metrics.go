@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//metric /metricsで公開する値
+type metric interface {
+	//writeTo Prometheusのテキスト形式で書き出す
+	writeTo(w io.Writer)
+}
+
+//metrics 登録した順に公開する
+var (
+	metricsMu sync.Mutex
+	metrics   []metric
+)
+
+//registerMetric 公開する値に加える
+func registerMetric(m metric) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metrics = append(metrics, m)
+}
+
+//MetricsToken /metricsを読むのに必要なトークン（空なら誰でも読める）
+var MetricsToken string
+
+var (
+	//MetricWebhookEvents 受け取ったイベントの数
+	MetricWebhookEvents = NewCounter("bikeshare_webhook_events_total", "受け取ったイベントの数", "type")
+	//MetricPostbacks 署名を確認したポストバックの数
+	MetricPostbacks = NewCounter("bikeshare_postbacks_total", "署名を確認したポストバックの数", "command")
+	//MetricPostbacksRejected 拒否したポストバックの数
+	MetricPostbacksRejected = NewCounter("bikeshare_postbacks_rejected_total", "拒否したポストバックの数", "reason")
+	//MetricSearches スポット名の検索の数
+	MetricSearches = NewCounter("bikeshare_searches_total", "スポット名の検索の数", "result")
+	//MetricSearchHits スポット名の検索で見つかったスポットの数
+	MetricSearchHits = NewHistogram("bikeshare_search_hits", "スポット名の検索で見つかったスポットの数", []float64{0, 1, 2, 5, 10, 20, 50, 100})
+	//MetricLineSends LINEへの返信・送信の数
+	MetricLineSends = NewCounter("bikeshare_line_sends_total", "LINEへの返信・送信の数", "method", "result")
+	//MetricAPIRequests BikeshareAPIへのリクエストの数（やり直しはまとめて1回）
+	MetricAPIRequests = NewCounter("bikeshare_api_requests_total", "BikeshareAPIへのリクエストの数", "endpoint", "result")
+	//MetricAPIDuration BikeshareAPIの応答までの時間（やり直しを含む）
+	MetricAPIDuration = NewHistogram("bikeshare_api_request_duration_seconds", "BikeshareAPIの応答までの時間", []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20}, "endpoint")
+	//MetricAPICache 取得結果の使い回しの結果（hit / shared / miss / stale / error / canceled）
+	MetricAPICache = NewCounter("bikeshare_api_cache_requests_total", "BikeshareAPIの取得結果の使い回しの結果", "endpoint", "result")
+	//MetricScheduledNotifies 決まった時間の通知の結果
+	MetricScheduledNotifies = NewCounter("bikeshare_scheduled_notifies_total", "決まった時間の通知の結果", "result")
+)
+
+//MetricsHandler 集計した値をPrometheusのテキスト形式で返す
+func MetricsHandler(w http.ResponseWriter, req *http.Request) {
+	if MetricsToken != "" {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(MetricsToken)) != 1 {
+			w.WriteHeader(401)
+			return
+		}
+	}
+	var buf bytes.Buffer
+	WriteMetrics(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+//WriteMetrics 集計した値をすべて書き出す
+func WriteMetrics(w io.Writer) {
+	metricsMu.Lock()
+	list := append([]metric{}, metrics...)
+	metricsMu.Unlock()
+	for _, m := range list {
+		m.writeTo(w)
+	}
+}
+
+//Counter ラベルごとに増えていく値
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+//NewCounter コンストラクタ（/metricsで公開される）
+func NewCounter(name, help string, labels ...string) *Counter {
+	counter := &Counter{name: name, help: help, labels: labels, values: map[string]float64{}}
+	registerMetric(counter)
+	return counter
+}
+
+//Inc ラベルの値（labelsと同じ順）の数を1つ増やす
+func (counter *Counter) Inc(values ...string) {
+	counter.Add(1, values...)
+}
+
+//Add ラベルの値（labelsと同じ順）の数を増やす
+func (counter *Counter) Add(n float64, values ...string) {
+	key := metricKey(counter.labels, values)
+	counter.mu.Lock()
+	counter.values[key] += n
+	counter.mu.Unlock()
+}
+
+func (counter *Counter) writeTo(w io.Writer) {
+	writeMetricHeader(w, counter.name, counter.help, "counter")
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+	for _, key := range sortedMetricKeys(counter.values) {
+		fmt.Fprintf(w, "%s%s %s\n", counter.name, metricLabels(counter.labels, key), formatMetricValue(counter.values[key]))
+	}
+}
+
+//Histogram ラベルごとの値の分布
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+//histogramSeries ラベルの値ごとの分布
+type histogramSeries struct {
+	//counts バケットごとの数（上限以下の数ではなく、そのバケットに入った数）
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+//NewHistogram コンストラクタ（bucketsは小さい順。/metricsで公開される）
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	histogram := &Histogram{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+	registerMetric(histogram)
+	return histogram
+}
+
+//Observe ラベルの値（labelsと同じ順）に1つ記録する
+func (histogram *Histogram) Observe(value float64, values ...string) {
+	key := metricKey(histogram.labels, values)
+	histogram.mu.Lock()
+	defer histogram.mu.Unlock()
+	series, ok := histogram.series[key]
+	if !ok {
+		series = &histogramSeries{counts: make([]uint64, len(histogram.buckets))}
+		histogram.series[key] = series
+	}
+	for i, bound := range histogram.buckets {
+		if value <= bound {
+			series.counts[i]++
+			break
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+func (histogram *Histogram) writeTo(w io.Writer) {
+	writeMetricHeader(w, histogram.name, histogram.help, "histogram")
+	histogram.mu.Lock()
+	defer histogram.mu.Unlock()
+	keys := make([]string, 0, len(histogram.series))
+	for key := range histogram.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	labels := append(append([]string{}, histogram.labels...), "le")
+	for _, key := range keys {
+		series := histogram.series[key]
+		var cumulative uint64
+		for i, bound := range histogram.buckets {
+			cumulative += series.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", histogram.name, metricLabels(labels, joinMetricKey(histogram.labels, key, formatMetricValue(bound))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", histogram.name, metricLabels(labels, joinMetricKey(histogram.labels, key, "+Inf")), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", histogram.name, metricLabels(histogram.labels, key), formatMetricValue(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", histogram.name, metricLabels(histogram.labels, key), series.count)
+	}
+}
+
+//metricKeySeparator ラベルの値をつないでキーにするときの区切り（値には出てこない文字）
+const metricKeySeparator = "\xff"
+
+//metricKey ラベルの値をつないだキー（数が合わなければ足りない分を空にする）
+func metricKey(labels, values []string) string {
+	buff := make([]string, len(labels))
+	copy(buff, values)
+	return strings.Join(buff, metricKeySeparator)
+}
+
+//joinMetricKey キーの後ろにラベルの値を足す
+func joinMetricKey(labels []string, key, value string) string {
+	if len(labels) == 0 {
+		return value
+	}
+	return key + metricKeySeparator + value
+}
+
+//sortedMetricKeys 出力の順番を揃える
+func sortedMetricKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//writeMetricHeader 説明と種類
+func writeMetricHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+//metricLabels {name="value",...}の形にする（ラベルがなければ空文字）
+func metricLabels(labels []string, key string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	values := strings.Split(key, metricKeySeparator)
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	var pairs []string
+	for i, label := range labels {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, label, escaper.Replace(value)))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+//formatMetricValue 数値の書式
+func formatMetricValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+//metricResult 成功・失敗のラベルの値
+func metricResult(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
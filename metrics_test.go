@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+//setupTestMetrics 公開する値をテストで作ったものだけにする（終わったら元に戻す）
+func setupTestMetrics(t *testing.T) {
+	metricsMu.Lock()
+	saved := metrics
+	metrics = nil
+	metricsMu.Unlock()
+	t.Cleanup(func() {
+		metricsMu.Lock()
+		metrics = saved
+		metricsMu.Unlock()
+	})
+}
+
+func TestWriteMetrics(t *testing.T) {
+	setupTestMetrics(t)
+	counter := NewCounter("test_requests_total", "リクエストの数\nバックスラッシュ\\", "method", "result")
+	counter.Inc("reply", "ok")
+	counter.Add(2, "push", "error")
+	counter.Inc("reply", "ok")
+	counter.Inc(`a"b\c`+"\n", "ok")
+	plain := NewCounter("test_events_total", "ラベルなし")
+	plain.Add(0.5)
+	histogram := NewHistogram("test_duration_seconds", "かかった時間", []float64{0.1, 1, 10})
+	for _, value := range []float64{0.05, 0.1, 0.5, 3, 30} {
+		histogram.Observe(value)
+	}
+	labeled := NewHistogram("test_hits", "件数", []float64{1, 5}, "endpoint")
+	labeled.Observe(2, "counts")
+	//一度も記録していないものは見出しだけ
+	NewCounter("test_unused_total", "未使用", "result")
+
+	var buf bytes.Buffer
+	WriteMetrics(&buf)
+	want := `# HELP test_requests_total リクエストの数\nバックスラッシュ\\
+# TYPE test_requests_total counter
+test_requests_total{method="a\"b\\c\n",result="ok"} 1
+test_requests_total{method="push",result="error"} 2
+test_requests_total{method="reply",result="ok"} 2
+# HELP test_events_total ラベルなし
+# TYPE test_events_total counter
+test_events_total 0.5
+# HELP test_duration_seconds かかった時間
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.1"} 2
+test_duration_seconds_bucket{le="1"} 3
+test_duration_seconds_bucket{le="10"} 4
+test_duration_seconds_bucket{le="+Inf"} 5
+test_duration_seconds_sum 33.65
+test_duration_seconds_count 5
+# HELP test_hits 件数
+# TYPE test_hits histogram
+test_hits_bucket{endpoint="counts",le="1"} 0
+test_hits_bucket{endpoint="counts",le="5"} 1
+test_hits_bucket{endpoint="counts",le="+Inf"} 1
+test_hits_sum{endpoint="counts"} 2
+test_hits_count{endpoint="counts"} 1
+# HELP test_unused_total 未使用
+# TYPE test_unused_total counter
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteMetricsFormat(t *testing.T) {
+	//登録済みの値もすべてテキスト形式の文法に沿っている
+	MetricSearchHits.Observe(3)
+	MetricAPIRequests.Inc("counts", "ok")
+	MetricScheduledNotifies.Inc("sent")
+	var buf bytes.Buffer
+	WriteMetrics(&buf)
+
+	comment := regexp.MustCompile(`^# (HELP [a-zA-Z_:][a-zA-Z0-9_:]* .*|TYPE [a-zA-Z_:][a-zA-Z0-9_:]* (counter|histogram))$`)
+	sample := regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*(\{[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\]|\\.)*"(,[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\]|\\.)*")*\})? (\+Inf|-?[0-9.eE+-]+)$`)
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if !comment.MatchString(line) && !sample.MatchString(line) {
+			t.Errorf("形式が不正: %q", line)
+		}
+	}
+}
+
+func TestMetricsHandlerToken(t *testing.T) {
+	saved := MetricsToken
+	defer func() { MetricsToken = saved }()
+	MetricsToken = "secret"
+
+	tests := []struct {
+		authorization string
+		want          int
+	}{
+		{"Bearer secret", 200},
+		{"Bearer wrong", 401},
+		{"", 401},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		MetricsHandler(w, req)
+		if w.Code != tt.want {
+			t.Errorf("Authorization %q: status = %d, want %d", tt.authorization, w.Code, tt.want)
+		}
+		if w.Code == 200 && !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+			t.Errorf("Content-Type = %s", w.Header().Get("Content-Type"))
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+const (
+	//MaxSnoozeDays 一時停止できる最大の日数
+	MaxSnoozeDays = 30
+	//MaxVacationDays 休暇として設定できる最大の日数
+	MaxVacationDays = 60
+	//VacationResumeTime 休暇明けのお知らせを送る時刻（これより前に通知があればその前に送る）
+	VacationResumeTime = "07:00"
+	//pauseDateLayout 画面に出す日付
+	pauseDateLayout = "1/2"
+	//pauseTimeLayout 画面に出す日時
+	pauseTimeLayout = "1/2 15:04"
+)
+
+//NotifyPauseKind 通知を止める方法
+type NotifyPauseKind string
+
+const (
+	//NotifyPauseSnooze 指定した時間だけ止める
+	NotifyPauseSnooze NotifyPauseKind = "snooze"
+	//NotifyPauseQuiet 毎日決まった時間帯は止める
+	NotifyPauseQuiet NotifyPauseKind = "quiet"
+	//NotifyPauseVacation 休暇の間は止める（終わったらお知らせする）
+	NotifyPauseVacation NotifyPauseKind = "vacation"
+)
+
+//NotifyPause 通知の一時停止（どれも空なら止めていない）
+type NotifyPause struct {
+	//SnoozeUntil この時刻まで止める（RFC3339）
+	SnoozeUntil string `json:",omitempty"`
+	//QuietStart 通知しない時間帯の始まり（HH:MM、終わりより後なら日付をまたぐ）
+	QuietStart string `json:",omitempty"`
+	//QuietEnd 通知しない時間帯の終わり（この時刻からは送る）
+	QuietEnd string `json:",omitempty"`
+	//VacationStart 休暇の初日（YYYY-MM-DD）
+	VacationStart string `json:",omitempty"`
+	//VacationEnd 休暇の最終日（この日まで止める）
+	VacationEnd string `json:",omitempty"`
+}
+
+//Paused 指定した時刻に通知を止めているか（止めている理由も返す）
+func (pause NotifyPause) Paused(t time.Time) (NotifyPauseKind, bool) {
+	if pause.OnVacation(t) {
+		return NotifyPauseVacation, true
+	}
+	if until, ok := pause.snoozeUntil(); ok && t.Before(until) {
+		return NotifyPauseSnooze, true
+	}
+	if pause.InQuietHours(t) {
+		return NotifyPauseQuiet, true
+	}
+	return "", false
+}
+
+//OnVacation 休暇中か
+func (pause NotifyPause) OnVacation(t time.Time) bool {
+	date := t.Format(NotifyDateLayout)
+	return pause.VacationStart != "" && pause.VacationStart <= date && date <= pause.VacationEnd
+}
+
+//VacationOver 休暇が終わっていてまだお知らせしていないか
+func (pause NotifyPause) VacationOver(t time.Time) bool {
+	return pause.VacationEnd != "" && pause.VacationEnd < t.Format(NotifyDateLayout)
+}
+
+//InQuietHours 通知しない時間帯か
+func (pause NotifyPause) InQuietHours(t time.Time) bool {
+	if pause.QuietStart == "" || pause.QuietEnd == "" {
+		return false
+	}
+	hhmm := t.Format(NotifyTimeLayout)
+	if pause.QuietStart < pause.QuietEnd {
+		return pause.QuietStart <= hhmm && hhmm < pause.QuietEnd
+	}
+	//日付をまたぐ
+	return pause.QuietStart <= hhmm || hhmm < pause.QuietEnd
+}
+
+//snoozeUntil 一時停止の終わり
+func (pause NotifyPause) snoozeUntil() (time.Time, bool) {
+	if pause.SnoozeUntil == "" {
+		return time.Time{}, false
+	}
+	until, err := time.Parse(time.RFC3339, pause.SnoozeUntil)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return until.In(LocationTokyo), true
+}
+
+//Clear 指定した方法の一時停止を解除する
+func (pause *NotifyPause) Clear(kind NotifyPauseKind) {
+	switch kind {
+	case NotifyPauseSnooze:
+		pause.SnoozeUntil = ""
+	case NotifyPauseQuiet:
+		pause.QuietStart, pause.QuietEnd = "", ""
+	case NotifyPauseVacation:
+		pause.VacationStart, pause.VacationEnd = "", ""
+	}
+}
+
+//PruneNotifyPause 終わった一時停止を片付ける（休暇はお知らせを送るときに消すので残す）
+func PruneNotifyPause(user *UserConfig, now time.Time) {
+	if until, ok := user.snoozeUntil(); ok && !now.Before(until) {
+		user.SnoozeUntil = ""
+	}
+}
+
+//ParseSnooze 「3d」「12h」「30m」のような長さから一時停止の終わりを求める（単位がなければ日数）
+func ParseSnooze(value string, now time.Time) (time.Time, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	units := []struct {
+		suffix string
+		unit   time.Duration
+	}{
+		{"d", 24 * time.Hour},
+		{"日", 24 * time.Hour},
+		{"h", time.Hour},
+		{"時間", time.Hour},
+		{"m", time.Minute},
+		{"分", time.Minute},
+		{"w", 7 * 24 * time.Hour},
+		{"週間", 7 * 24 * time.Hour},
+	}
+	unit := 24 * time.Hour
+	for _, u := range units {
+		if strings.HasSuffix(value, u.suffix) {
+			value = strings.TrimSuffix(value, u.suffix)
+			unit = u.unit
+			break
+		}
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return time.Time{}, fmt.Errorf("一時停止の長さが不正です: %s", value)
+	}
+	if time.Duration(n)*unit > MaxSnoozeDays*24*time.Hour {
+		return time.Time{}, fmt.Errorf("一時停止の長さが長すぎます: %s", value)
+	}
+	return now.Add(time.Duration(n) * unit).Truncate(time.Minute), nil
+}
+
+//ParseQuietHours 「22:00-07:00」のような通知しない時間帯を読む
+func ParseQuietHours(value string) (start string, end string, err error) {
+	fields := strings.Fields(replaceRangeSeparators(value, "-"))
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("時間帯の形式が不正です: %s", value)
+	}
+	times := make([]string, 2)
+	for i, field := range fields {
+		t, err := time.Parse(NotifyTimeLayout, field)
+		if err != nil {
+			return "", "", fmt.Errorf("時刻の形式が不正です: %s", field)
+		}
+		times[i] = t.Format(NotifyTimeLayout)
+	}
+	if times[0] == times[1] {
+		return "", "", fmt.Errorf("時間帯の始まりと終わりが同じです: %s", value)
+	}
+	return times[0], times[1], nil
+}
+
+//ParseVacation 「8/10 8/16」や「2020-08-10～2020-08-16」のような休暇の期間を読む（1日だけなら日付1つ）
+//年を省略したときは今日以降で一番近い日とする（休暇の途中で指定したときは初日だけ過去になる）
+func ParseVacation(value string, now time.Time) (start time.Time, end time.Time, err error) {
+	fields := strings.Fields(replaceRangeSeparators(value, ""))
+	if len(fields) == 1 && strings.Count(fields[0], "/") == 2 && strings.Contains(fields[0], "-") {
+		//「8/10-8/16」
+		fields = strings.Split(fields[0], "-")
+	}
+	if len(fields) < 1 || len(fields) > 2 {
+		return start, end, fmt.Errorf("休暇の形式が不正です: %s", value)
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	dates := make([]time.Time, len(fields))
+	for i, field := range fields {
+		if dates[i], err = parseVacationDate(field, today); err != nil {
+			return start, end, err
+		}
+	}
+	start, end = dates[0], dates[len(dates)-1]
+	if end.Before(start) {
+		if before := start.AddDate(-1, 0, 0); !today.Before(before) {
+			//「6/1 6/7」や「12/30 1/3」を休暇の途中で指定した（初日だけ来年にされている）
+			start = before
+		} else {
+			//「12/30 1/3」のように年をまたいでいる
+			end = end.AddDate(1, 0, 0)
+		}
+	}
+	if end.Before(today) {
+		return start, end, fmt.Errorf("休暇が終わっています: %s", value)
+	}
+	if end.Sub(start) >= MaxVacationDays*24*time.Hour {
+		return start, end, fmt.Errorf("休暇が長すぎます: %s", value)
+	}
+	return start, end, nil
+}
+
+//parseVacationDate 「2020-08-10」「2020/8/10」「8/10」のどれかの日付を読む
+func parseVacationDate(value string, today time.Time) (time.Time, error) {
+	for _, layout := range []string{NotifyDateLayout, "2006/1/2"} {
+		if t, err := time.ParseInLocation(layout, value, today.Location()); err == nil {
+			return t, nil
+		}
+	}
+	t, err := time.ParseInLocation("1/2", value, today.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("日付の形式が不正です: %s", value)
+	}
+	date := time.Date(today.Year(), t.Month(), t.Day(), 0, 0, 0, 0, today.Location())
+	if date.Before(today) {
+		date = date.AddDate(1, 0, 0)
+	}
+	return date, nil
+}
+
+//replaceRangeSeparators 期間の区切り（～、〜、~）を空白にする（sepを指定すればそれも空白にする）
+func replaceRangeSeparators(value string, sep string) string {
+	separators := []string{"～", "〜", "~"}
+	if sep != "" {
+		separators = append(separators, sep)
+	}
+	for _, s := range separators {
+		value = strings.Replace(value, s, " ", -1)
+	}
+	return value
+}
+
+//isPauseOff 解除の指定か
+func isPauseOff(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "off", "解除":
+		return true
+	}
+	return false
+}
+
+//newPauseItem 設定画面に出す一時停止の状態（終わったものは出さない）
+func newPauseItem(pause NotifyPause, now time.Time) PauseItem {
+	var item PauseItem
+	if until, ok := pause.snoozeUntil(); ok && now.Before(until) {
+		item.SnoozeUntil = until.Format(pauseTimeLayout)
+	}
+	if pause.QuietStart != "" && pause.QuietEnd != "" {
+		item.QuietStart, item.QuietEnd = pause.QuietStart, pause.QuietEnd
+	}
+	if pause.VacationStart != "" && !pause.VacationOver(now) {
+		item.VacationStart = formatPauseDate(pause.VacationStart)
+		item.VacationEnd = formatPauseDate(pause.VacationEnd)
+	}
+	return item
+}
+
+//formatPauseDate 保存している日付を画面に出す形にする
+func formatPauseDate(date string) string {
+	t, err := time.Parse(NotifyDateLayout, date)
+	if err != nil {
+		return date
+	}
+	return t.Format(pauseDateLayout)
+}
+
+//ReplyToPostbackPause 通知の一時停止の設定
+//コマンドなら設定した内容を、設定画面のボタンなら設定画面を返す
+func ReplyToPostbackPause(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
+	userID := SourceID(event)
+	lang := GetUserLang(userID)
+	now := time.Now().In(LocationTokyo)
+	kind := NotifyPauseKind(command.Target)
+	var update func(user *UserConfig)
+	var text string
+	switch {
+	case command.Mode == PostBackCommandModeUnreg:
+		update = func(user *UserConfig) { user.NotifyPause.Clear(kind) }
+		text = T(lang, "pause."+string(kind)+"Off")
+	case kind == NotifyPauseSnooze:
+		until, err := ParseSnooze(command.Value, now)
+		if err != nil {
+			text = T(lang, "pause.badSnooze", MaxSnoozeDays)
+			break
+		}
+		update = func(user *UserConfig) { user.SnoozeUntil = until.Format(time.RFC3339) }
+		text = T(lang, "pause.snoozeSet", until.Format(pauseTimeLayout))
+	case kind == NotifyPauseQuiet:
+		start, end, err := ParseQuietHours(command.Value)
+		if err != nil {
+			text = T(lang, "pause.badQuiet")
+			break
+		}
+		update = func(user *UserConfig) { user.QuietStart, user.QuietEnd = start, end }
+		text = T(lang, "pause.quietSet", start, end)
+	case kind == NotifyPauseVacation:
+		start, end, err := ParseVacation(command.Value, now)
+		if err != nil {
+			text = T(lang, "pause.badVacation", MaxVacationDays)
+			break
+		}
+		update = func(user *UserConfig) {
+			user.VacationStart, user.VacationEnd = start.Format(NotifyDateLayout), end.Format(NotifyDateLayout)
+		}
+		text = T(lang, "pause.vacationSet", start.Format(pauseDateLayout), end.Format(pauseDateLayout))
+	default:
+		return
+	}
+	if update != nil {
+		if err := UpdateUserConfigFunc(userID, update); err != nil {
+			ReplyMessage(ctx, event.ReplyToken, linebot.NewTextMessage(T(lang, "user.saveFailed")).WithQuickReplies(CreateConfigQuickReplyItems(lang)))
+			return
+		}
+	}
+	//返信
+	if event.Type == linebot.EventTypePostback && update != nil {
+		ReplyMessage(ctx, event.ReplyToken, MakeDateConfigWindowMessage(userID))
+		return
+	}
+	ReplyMessage(ctx, event.ReplyToken, linebot.NewTextMessage(text).WithQuickReplies(CreateConfigQuickReplyItems(lang)))
+}
+
+//AllowProactivePush 利用者から求められていないメッセージ（定時の通知・台数アラート・スポットのお知らせ）を送ってよいか
+//送る側はすべてここで一時停止を確かめる。休暇が明けていれば先にそのお知らせを送る
+func AllowProactivePush(ctx context.Context, userID string, now time.Time) bool {
+	user := GetUserConfigFromCache(userID)
+	if user == nil {
+		return true
+	}
+	now = now.In(LocationTokyo)
+	if user.VacationOver(now) && !user.InQuietHours(now) {
+		SendVacationResume(userID)
+	}
+	if kind, paused := user.Paused(now); paused {
+		LogInfo(ctx, "通知を一時停止中のため送りません", LogFields{"user": HashUserID(userID), "pause": string(kind)})
+		return false
+	}
+	return true
+}
+
+//SendVacationResume 休暇が終わったことを知らせる
+//先に休暇の設定を消して、消せたときだけ送る（何度も送らないようにするため）
+func SendVacationResume(userID string) {
+	cleared := false
+	err := UpdateUserConfigFunc(userID, func(user *UserConfig) {
+		cleared = user.VacationEnd != ""
+		user.Clear(NotifyPauseVacation)
+	})
+	if err != nil {
+		//消せなかったことはUpdateUserConfigFuncがログに出している
+		return
+	}
+	if !cleared || IsSlackUserKey(userID) {
+		return
+	}
+	lang := GetUserLang(userID)
+	message := linebot.NewTextMessage(T(lang, "pause.resumed")).WithQuickReplies(CreateConfigQuickReplyItems(lang))
+	if err := PushMessage(userID, message); err != nil {
+		LogError(context.Background(), "休暇明けのお知らせを送れませんでした", LogFields{"error": err, "user": HashUserID(userID)})
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+func TestParseSnooze(t *testing.T) {
+	now := time.Date(2024, 6, 5, 8, 15, 30, 0, LocationTokyo)
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"3", "2024-06-08 08:15"},
+		{"3d", "2024-06-08 08:15"},
+		{"2日", "2024-06-07 08:15"},
+		{"12h", "2024-06-05 20:15"},
+		{"3時間", "2024-06-05 11:15"},
+		{"30m", "2024-06-05 08:45"},
+		{"90分", "2024-06-05 09:45"},
+		{"1w", "2024-06-12 08:15"},
+		{" 2週間 ", "2024-06-19 08:15"},
+		{"30D", "2024-07-05 08:15"},
+		//間違い
+		{"", ""},
+		{"0", ""},
+		{"-1d", ""},
+		{"abc", ""},
+		{"31d", ""},
+		{"5w", ""},
+	}
+	for _, tt := range tests {
+		got, err := ParseSnooze(tt.value, now)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("ParseSnooze(%q) = %v, want error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || got.Format("2006-01-02 15:04") != tt.want {
+			t.Errorf("ParseSnooze(%q) = %v, %v, want %s", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseQuietHours(t *testing.T) {
+	tests := []struct {
+		value      string
+		start, end string
+		wantErr    bool
+	}{
+		{value: "22:00-07:00", start: "22:00", end: "07:00"},
+		{value: "22:00～7:00", start: "22:00", end: "07:00"},
+		{value: "23:30 〜 06:15", start: "23:30", end: "06:15"},
+		{value: "12:00 13:00", start: "12:00", end: "13:00"},
+		{value: "22:00", wantErr: true},
+		{value: "22:00-07:00-08:00", wantErr: true},
+		{value: "25:00-07:00", wantErr: true},
+		{value: "07:00-07:00", wantErr: true},
+	}
+	for _, tt := range tests {
+		start, end, err := ParseQuietHours(tt.value)
+		if (err != nil) != tt.wantErr || start != tt.start || end != tt.end {
+			t.Errorf("ParseQuietHours(%q) = %q, %q, %v", tt.value, start, end, err)
+		}
+	}
+}
+
+func TestInQuietHours(t *testing.T) {
+	//日付をまたぐ時間帯
+	overnight := NotifyPause{QuietStart: "22:00", QuietEnd: "07:00"}
+	daytime := NotifyPause{QuietStart: "12:00", QuietEnd: "13:00"}
+	tests := []struct {
+		hhmm      string
+		overnight bool
+		daytime   bool
+	}{
+		{"21:59", false, false},
+		{"22:00", true, false},
+		{"23:59", true, false},
+		{"00:00", true, false},
+		{"06:59", true, false},
+		{"07:00", false, false},
+		{"12:00", false, true},
+		{"12:59", false, true},
+		{"13:00", false, false},
+	}
+	for _, tt := range tests {
+		now := tokyoAt(tt.hhmm + ":00")
+		if got := overnight.InQuietHours(now); got != tt.overnight {
+			t.Errorf("22:00-07:00の%s = %v", tt.hhmm, got)
+		}
+		if got := daytime.InQuietHours(now); got != tt.daytime {
+			t.Errorf("12:00-13:00の%s = %v", tt.hhmm, got)
+		}
+		if kind, paused := overnight.Paused(now); paused != tt.overnight || (paused && kind != NotifyPauseQuiet) {
+			t.Errorf("Paused(%s) = %q, %v", tt.hhmm, kind, paused)
+		}
+	}
+}
+
+func TestParseVacation(t *testing.T) {
+	at := func(date string) time.Time {
+		t, err := time.ParseInLocation("2006-01-02 15:04", date, LocationTokyo)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	tests := []struct {
+		name       string
+		value      string
+		now        string
+		start, end string
+	}{
+		{name: "ハイフン区切り", value: "8/10-8/16", now: "2024-06-05 09:00", start: "2024-08-10", end: "2024-08-16"},
+		{name: "空白区切り", value: "8/10 8/16", now: "2024-06-05 09:00", start: "2024-08-10", end: "2024-08-16"},
+		{name: "波線区切り", value: "8/10～8/16", now: "2024-06-05 09:00", start: "2024-08-10", end: "2024-08-16"},
+		{name: "1日だけ", value: "8/10", now: "2024-06-05 09:00", start: "2024-08-10", end: "2024-08-10"},
+		{name: "年を指定", value: "2024-08-10～2024-08-16", now: "2024-06-05 09:00", start: "2024-08-10", end: "2024-08-16"},
+		{name: "スラッシュで年を指定", value: "2024/8/10 2024/8/16", now: "2024-06-05 09:00", start: "2024-08-10", end: "2024-08-16"},
+		{name: "今年はもう過ぎた日付", value: "3/1-3/3", now: "2024-06-05 09:00", start: "2025-03-01", end: "2025-03-03"},
+		{name: "休暇の途中", value: "6/1-6/7", now: "2024-06-05 09:00", start: "2024-06-01", end: "2024-06-07"},
+		{name: "今日から", value: "6/5-6/7", now: "2024-06-05 23:00", start: "2024-06-05", end: "2024-06-07"},
+		{name: "年をまたぐ", value: "12/30-1/3", now: "2024-12-20 09:00", start: "2024-12-30", end: "2025-01-03"},
+		{name: "年をまたぐ休暇の途中（年末）", value: "12/30-1/3", now: "2024-12-31 09:00", start: "2024-12-30", end: "2025-01-03"},
+		{name: "年をまたぐ休暇の途中（年始）", value: "12/30-1/3", now: "2025-01-02 09:00", start: "2024-12-30", end: "2025-01-03"},
+		{name: "形式が不正", value: "8/10 8/12 8/16", now: "2024-06-05 09:00"},
+		{name: "日付ではない", value: "来週", now: "2024-06-05 09:00"},
+		{name: "終わっている", value: "2024-05-01 2024-05-03", now: "2024-06-05 09:00"},
+		{name: "長すぎる", value: "6/10-8/10", now: "2024-06-05 09:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, err := ParseVacation(tt.value, at(tt.now))
+			if tt.start == "" {
+				if err == nil {
+					t.Errorf("ParseVacation(%q) = %v - %v, want error", tt.value, start, end)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseVacation(%q): %v", tt.value, err)
+			}
+			got := []string{start.Format(NotifyDateLayout), end.Format(NotifyDateLayout)}
+			if want := []string{tt.start, tt.end}; !reflect.DeepEqual(got, want) {
+				t.Errorf("ParseVacation(%q) = %v, want %v", tt.value, got, want)
+			}
+		})
+	}
+}
+
+func TestAlertPollerRespectsNotifyPause(t *testing.T) {
+	setupFakeBot(t)
+	now := time.Now().In(LocationTokyo)
+	//台数が0台以上（いつでも条件を満たす）のアラート
+	alert := SpotAlert{Code: "A1-01", Kind: AlertKindAbove, Threshold: 0}
+	UpdateUserConfigFunc("U1", func(user *UserConfig) {
+		user.Alerts = []SpotAlert{alert}
+		user.SnoozeUntil = now.Add(time.Hour).Format(time.RFC3339)
+	})
+	UpdateUserConfigFunc("U2", func(user *UserConfig) {
+		user.Alerts = []SpotAlert{alert}
+	})
+
+	var sent []string
+	poller := &AlertPoller{
+		Clock: &fakeClock{now: now},
+		Send: func(userID string, message linebot.SendingMessage) error {
+			sent = append(sent, userID)
+			return nil
+		},
+	}
+	poller.Check()
+	if !reflect.DeepEqual(sent, []string{"U2"}) {
+		t.Fatalf("sent = %v, want [U2]", sent)
+	}
+	if user := GetUserConfigFromCache("U1"); user.Alerts[0].Triggered {
+		t.Error("一時停止中にアラートの状態を進めた")
+	}
+
+	//再開したら送る
+	UpdateUserConfigFunc("U1", func(user *UserConfig) { user.Clear(NotifyPauseSnooze) })
+	sent = nil
+	poller.Check()
+	if !reflect.DeepEqual(sent, []string{"U1"}) {
+		t.Errorf("再開後 sent = %v, want [U1]", sent)
+	}
+}
+
+func TestSpotAnnounceRespectsNotifyPause(t *testing.T) {
+	setupFakeBot(t)
+	now := time.Now().In(LocationTokyo)
+	UpdateUserConfigFunc("U1", func(user *UserConfig) {
+		user.Favorites = []string{"A1-01"}
+		user.SpotAnnounce = true
+		user.VacationStart = now.Format(NotifyDateLayout)
+		user.VacationEnd = now.AddDate(0, 0, 3).Format(NotifyDateLayout)
+	})
+	UpdateUserConfigFunc("U2", func(user *UserConfig) {
+		user.Favorites = []string{"A1-01"}
+		user.SpotAnnounce = true
+	})
+
+	var sent []string
+	refresher := &SpotMasterRefresher{
+		Clock: &fakeClock{now: now},
+		Send: func(userID string, message linebot.SendingMessage) error {
+			sent = append(sent, userID)
+			return nil
+		},
+	}
+	refresher.announce(testContext(t), SpotMasterDiff{Renamed: []SpotRename{{Code: "A1-01", OldName: "千代田区役所", NewName: "千代田区役所前"}}})
+	if !reflect.DeepEqual(sent, []string{"U2"}) {
+		t.Errorf("sent = %v, want [U2]", sent)
+	}
+}
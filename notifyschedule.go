@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bikeshareapi "github.com/8245snake/bikeshare-client"
+	"github.com/8245snake/bikeshare-line/holiday"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+//NotifyDateLayout 1回だけ送る通知の日付のフォーマット
+const NotifyDateLayout = "2006-01-02"
+
+const (
+	//NotifyHolidaysSend 祝日も曜日どおりに送る
+	NotifyHolidaysSend = "off"
+	//NotifyHolidaysSkip 祝日は送らない
+	NotifyHolidaysSkip = "on"
+	//NotifyHolidaysAsSunday 祝日は日曜日として扱う
+	NotifyHolidaysAsSunday = "sunday"
+)
+
+//NotifyTarget 通知で送る内容（どちらも空ならお気に入り）
+type NotifyTarget struct {
+	//Group お気に入りグループの名前
+	Group string `json:",omitempty"`
+	//Spot 1か所だけ送るときのスポットコード
+	Spot string `json:",omitempty"`
+}
+
+//NotifySchedule 通知のルール（いつ・何を送るか）
+type NotifySchedule struct {
+	//ID ユーザーごとの連番（ボタンで指定するのに使う）
+	ID int
+	//Time 通知時刻（HH:MM）
+	Time string
+	//Weekdays 通知する曜日（空なら毎日）
+	Weekdays []time.Weekday `json:",omitempty"`
+	//SkipHolidays 祝日は送らない
+	SkipHolidays bool `json:",omitempty"`
+	//HolidaysAsSunday 祝日は日曜日の通知として扱う（平日の通知を止めて休日の通知に切り替える）
+	HolidaysAsSunday bool `json:",omitempty"`
+	//Date この日だけ送る（空なら毎週）
+	Date string `json:",omitempty"`
+	NotifyTarget
+}
+
+//Due 指定した分に送る通知か
+func (schedule NotifySchedule) Due(t time.Time) bool {
+	if t.Format(NotifyTimeLayout) != schedule.Time {
+		return false
+	}
+	if schedule.Date != "" {
+		return t.Format(NotifyDateLayout) == schedule.Date
+	}
+	weekday := t.Weekday()
+	if holiday.IsHoliday(t) {
+		if schedule.SkipHolidays {
+			return false
+		}
+		if schedule.HolidaysAsSunday {
+			weekday = time.Sunday
+		}
+	}
+	return len(schedule.Weekdays) == 0 || containsWeekday(schedule.Weekdays, weekday)
+}
+
+//Expired 1回だけ送る通知で日付が過ぎているか
+func (schedule NotifySchedule) Expired(t time.Time) bool {
+	return schedule.Date != "" && schedule.Date < t.Format(NotifyDateLayout)
+}
+
+//matches ボタンで指定された通知か（古いボタンは時刻で指定している）
+func (schedule NotifySchedule) matches(target string) bool {
+	return strconv.Itoa(schedule.ID) == target || schedule.Time == target
+}
+
+//containsWeekday 曜日が含まれているか
+func containsWeekday(weekdays []time.Weekday, weekday time.Weekday) bool {
+	for _, w := range weekdays {
+		if w == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+//ParseWeekdays 「12345」のような曜日の番号（0が日曜）の並びを読む
+//7日すべてなら毎日として空にする
+func ParseWeekdays(value string) []time.Weekday {
+	var weekdays []time.Weekday
+	for _, r := range value {
+		if r < '0' || r > '6' {
+			continue
+		}
+		if weekday := time.Weekday(r - '0'); !containsWeekday(weekdays, weekday) {
+			weekdays = append(weekdays, weekday)
+		}
+	}
+	if len(weekdays) == 7 {
+		return nil
+	}
+	sort.Slice(weekdays, func(i, j int) bool { return weekdays[i] < weekdays[j] })
+	return weekdays
+}
+
+//FormatWeekdays 曜日を「12345」のような番号の並びにする
+func FormatWeekdays(weekdays []time.Weekday) string {
+	var buff strings.Builder
+	for _, weekday := range weekdays {
+		buff.WriteString(strconv.Itoa(int(weekday)))
+	}
+	return buff.String()
+}
+
+//toggleWeekday 曜日を1つ入れたり外したりした並びを返す（空は毎日の意味）
+func toggleWeekday(weekdays []time.Weekday, weekday time.Weekday) string {
+	if len(weekdays) == 0 {
+		//毎日からその曜日だけ外す
+		var rest []time.Weekday
+		for w := time.Sunday; w <= time.Saturday; w++ {
+			if w != weekday {
+				rest = append(rest, w)
+			}
+		}
+		return FormatWeekdays(rest)
+	}
+	if !containsWeekday(weekdays, weekday) {
+		return FormatWeekdays(append(append([]time.Weekday{}, weekdays...), weekday))
+	}
+	var rest []time.Weekday
+	for _, w := range weekdays {
+		if w != weekday {
+			rest = append(rest, w)
+		}
+	}
+	if len(rest) == 0 {
+		//1日も残らないなら毎日に戻す
+		return ""
+	}
+	return FormatWeekdays(rest)
+}
+
+//MigrateNotifySchedules 時刻だけの通知設定を通知のルールに移行する
+//Schedulesを一度も使っていない（nilの）ユーザーだけが対象で、移行したらtrueを返す
+//移行後は空にしても[]として保存されるので、再起動しても移行し直さない
+func MigrateNotifySchedules(user *UserConfig) bool {
+	if user.Schedules != nil || len(user.Notifies) == 0 {
+		return false
+	}
+	user.Schedules = []NotifySchedule{}
+	for _, hhmm := range user.Notifies {
+		user.Schedules = AddNotifySchedule(user.Schedules, NotifySchedule{
+			Time:         hhmm,
+			NotifyTarget: NotifyTarget{Group: user.NotifyGroups[hhmm]},
+		})
+	}
+	user.NotifyGroups = nil
+	syncNotifyTimes(user)
+	return true
+}
+
+//PruneNotifySchedules 日付が過ぎた1回だけの通知を削除する
+func PruneNotifySchedules(user *UserConfig, now time.Time) {
+	if len(user.Schedules) == 0 {
+		return
+	}
+	buff := []NotifySchedule{}
+	for _, schedule := range user.Schedules {
+		if !schedule.Expired(now) {
+			buff = append(buff, schedule)
+		}
+	}
+	user.Schedules = buff
+	syncNotifyTimes(user)
+}
+
+//syncNotifyTimes 通知時刻の一覧をルールに合わせる
+//外から/notifyを呼ぶ仕組みのためにAPIのNotifiesにも時刻を残しておく
+func syncNotifyTimes(user *UserConfig) {
+	var times []string
+	for _, schedule := range user.Schedules {
+		if !contains(times, schedule.Time) {
+			times = append(times, schedule.Time)
+		}
+	}
+	sort.Strings(times)
+	user.Notifies = times
+}
+
+//AddNotifySchedule 通知のルールを追加する（IDは今ある最大の番号の次）
+func AddNotifySchedule(schedules []NotifySchedule, schedule NotifySchedule) []NotifySchedule {
+	schedule.ID = 1
+	for _, item := range schedules {
+		if item.ID >= schedule.ID {
+			schedule.ID = item.ID + 1
+		}
+	}
+	return append(schedules, schedule)
+}
+
+//FindNotifySchedule ボタンで指定された通知のルールを探す
+func (user *UserConfig) FindNotifySchedule(target string) (NotifySchedule, bool) {
+	for _, schedule := range user.Schedules {
+		if schedule.matches(target) {
+			return schedule, true
+		}
+	}
+	return NotifySchedule{}, false
+}
+
+//EditNotifySchedule 指定された通知のルールをコールバックで書き換える
+func EditNotifySchedule(user *UserConfig, target string, fn func(schedule *NotifySchedule)) {
+	for i := range user.Schedules {
+		if user.Schedules[i].matches(target) {
+			fn(&user.Schedules[i])
+			break
+		}
+	}
+	syncNotifyTimes(user)
+}
+
+//RemoveNotifySchedule 指定された通知のルールを削除する
+func RemoveNotifySchedule(user *UserConfig, target string) {
+	buff := []NotifySchedule{}
+	for _, schedule := range user.Schedules {
+		if !schedule.matches(target) {
+			buff = append(buff, schedule)
+		}
+	}
+	user.Schedules = buff
+	syncNotifyTimes(user)
+}
+
+//newNotifyItem 設定画面・編集画面に出す通知の内容
+func newNotifyItem(schedule NotifySchedule) NotifyItem {
+	item := NotifyItem{
+		ID:           schedule.ID,
+		Time:         schedule.Time,
+		Weekdays:     FormatWeekdays(schedule.Weekdays),
+		SkipHolidays: schedule.SkipHolidays,
+		AsSunday:     schedule.HolidaysAsSunday,
+		Date:         schedule.Date,
+		Group:        schedule.Group,
+		Spot:         schedule.Spot,
+	}
+	if schedule.Spot != "" {
+		item.SpotName = GetPlaceNameByCode(schedule.Spot)
+	}
+	return item
+}
+
+//BuildNotifyTargetView 通知で送る台数一覧
+func BuildNotifyTargetView(ctx context.Context, userID string, target NotifyTarget) View {
+	switch {
+	case target.Group != "":
+		return BuildFavoriteGroupView(ctx, userID, target.Group)
+	case target.Spot != "":
+		lang := GetUserLang(userID)
+		spotinfos, stale, err := BikeshareAPI.FetchPlaces(ctx, bikeshareapi.SearchPlacesOption{Places: []string{target.Spot}})
+		if err != nil {
+			return apiErrorView(ctx, lang, "search.failed", err)
+		}
+		if len(spotinfos) < 1 {
+			return textView(lang, "fav.noSpots")
+		}
+		view := newSpotListView(T(lang, "notify.spotTitle", GetPlaceNameByCode(target.Spot)), T(lang, "search.alt"), spotinfos, lang)
+		view.setStale(stale)
+		return view
+	}
+	return BuildFavoriteListView(ctx, userID)
+}
+
+//BuildNotifyScheduleView 通知のルールの編集画面
+func BuildNotifyScheduleView(userID string, target string) View {
+	user := GetUserConfigFromCache(userID)
+	if user == nil {
+		return textView(DefaultLang, "user.loadFailed")
+	}
+	lang := user.Lang()
+	schedule, ok := user.FindNotifySchedule(target)
+	if !ok {
+		return textView(lang, "notify.notFound")
+	}
+	return NotifyScheduleView{
+		NotifyItem: newNotifyItem(schedule),
+		Groups:     user.FavoriteGroupNames(),
+		Favorites:  copyStrings(user.Favorites),
+		Lang:       lang,
+	}
+}
+
+//MakeNotifyScheduleMessage 通知のルールの編集画面メッセージ
+func MakeNotifyScheduleMessage(userID string, target string) linebot.SendingMessage {
+	return RenderLine(BuildNotifyScheduleView(userID, target))
+}
+
+//CreateNotifyTargetQuickReplyItems 通知で送る内容を選ぶクイックリプライ
+func CreateNotifyTargetQuickReplyItems(view NotifyScheduleView) *linebot.QuickReplyItems {
+	items := linebot.NewQuickReplyItems()
+	add := func(label string, target NotifyTarget) {
+		items.Items = append(items.Items, linebot.NewQuickReplyButton("",
+			linebot.NewPostbackAction(label, GetPostbackDataForNotifyTarget(view.ID, target), "", label)))
+	}
+	add(T(view.Lang, "notify.allFavorites"), NotifyTarget{})
+	for _, name := range view.Groups {
+		add(name, NotifyTarget{Group: name})
+	}
+	for _, code := range view.Favorites {
+		add(code, NotifyTarget{Spot: code})
+	}
+	return items
+}
+
+//ReplyToPostbackNotifyConfig 通知の編集
+func ReplyToPostbackNotifyConfig(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
+	var reply linebot.SendingMessage
+	user := GetUserConfigFromCache(SourceID(event))
+	if user == nil {
+		reply = linebot.NewTextMessage(T(DefaultLang, "user.loadFailed"))
+		ReplyMessage(ctx, event.ReplyToken, reply)
+		return
+	}
+	lang := user.Lang()
+	userID := SourceID(event)
+	target := command.Target
+
+	//新規登録以外は対象の通知がなければ何もできない
+	if _, ok := user.FindNotifySchedule(target); !ok && command.Mode != PostBackCommandModeReg {
+		ReplyMessage(ctx, event.ReplyToken, linebot.NewTextMessage(T(lang, "notify.notFound")))
+		return
+	}
+
+	//保存できなかったときは変更した画面ではなくその旨を返す
+	var err error
+	switch command.Mode {
+	case PostBackCommandModeReg:
+		hhmm := event.Postback.Params.Time
+		if _, err := time.Parse(NotifyTimeLayout, hhmm); err != nil {
+			reply = linebot.NewTextMessage(T(lang, "notify.badTime"))
+			break
+		}
+		//上限は保存先から読み直した設定で確かめる（同時に押されても超えないように）
+		var id int
+		err = UpdateUserConfigFunc(userID, func(user *UserConfig) {
+			if len(user.Schedules) >= MaxNotifySchedules {
+				return
+			}
+			user.Schedules = AddNotifySchedule(user.Schedules, NotifySchedule{Time: hhmm})
+			id = user.Schedules[len(user.Schedules)-1].ID
+			syncNotifyTimes(user)
+		})
+		if err == nil && id == 0 {
+			reply = linebot.NewTextMessage(T(lang, "notify.full"))
+			break
+		}
+		reply = MakeNotifyScheduleMessage(userID, strconv.Itoa(id))
+	case PostBackCommandModeUnreg:
+		err = UpdateUserConfigFunc(userID, func(user *UserConfig) {
+			RemoveNotifySchedule(user, target)
+		})
+		reply = MakeDateConfigWindowMessage(userID)
+	case PostBackCommandModeEdit:
+		reply = MakeNotifyScheduleMessage(userID, target)
+	case PostBackCommandModeTime:
+		hhmm := event.Postback.Params.Time
+		if _, err := time.Parse(NotifyTimeLayout, hhmm); err != nil {
+			reply = linebot.NewTextMessage(T(lang, "notify.badTime"))
+			break
+		}
+		err = UpdateUserConfigFunc(userID, func(user *UserConfig) {
+			EditNotifySchedule(user, target, func(schedule *NotifySchedule) { schedule.Time = hhmm })
+		})
+		reply = MakeNotifyScheduleMessage(userID, target)
+	case PostBackCommandModeDays:
+		err = UpdateUserConfigFunc(userID, func(user *UserConfig) {
+			EditNotifySchedule(user, target, func(schedule *NotifySchedule) { schedule.Weekdays = ParseWeekdays(command.Value) })
+		})
+		reply = MakeNotifyScheduleMessage(userID, target)
+	case PostBackCommandModeHoliday:
+		err = UpdateUserConfigFunc(userID, func(user *UserConfig) {
+			EditNotifySchedule(user, target, func(schedule *NotifySchedule) {
+				schedule.SkipHolidays = command.Value == NotifyHolidaysSkip
+				schedule.HolidaysAsSunday = command.Value == NotifyHolidaysAsSunday
+			})
+		})
+		reply = MakeNotifyScheduleMessage(userID, target)
+	case PostBackCommandModeOnce:
+		//押した日の翌日だけ送る
+		date := ""
+		if command.Value == "on" {
+			date = time.Now().In(LocationTokyo).AddDate(0, 0, 1).Format(NotifyDateLayout)
+		}
+		err = UpdateUserConfigFunc(userID, func(user *UserConfig) {
+			EditNotifySchedule(user, target, func(schedule *NotifySchedule) { schedule.Date = date })
+		})
+		reply = MakeNotifyScheduleMessage(userID, target)
+	case PostBackCommandModeGroup:
+		next := NotifyTarget{Group: command.Value}
+		if command.Area != "" {
+			next = NotifyTarget{Spot: command.Area + "-" + command.Spot}
+		}
+		if _, ok := user.FindFavoriteGroup(next.Group); next.Group != "" && !ok {
+			reply = linebot.NewTextMessage(T(lang, "favgroup.notFound", next.Group))
+			break
+		}
+		err = UpdateUserConfigFunc(userID, func(user *UserConfig) {
+			EditNotifySchedule(user, target, func(schedule *NotifySchedule) { schedule.NotifyTarget = next })
+		})
+		reply = MakeNotifyScheduleMessage(userID, target)
+	default:
+		return
+	}
+	if err != nil {
+		reply = linebot.NewTextMessage(T(lang, "user.saveFailed"))
+	}
+	//返信
+	ReplyMessage(ctx, event.ReplyToken, reply)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMigrateNotifySchedules(t *testing.T) {
+	user := NewUserConfig("U1")
+	user.Notifies = []string{"08:00", "18:30"}
+	user.NotifyGroups = map[string]string{"18:30": "帰宅"}
+
+	if !MigrateNotifySchedules(&user) {
+		t.Fatal("旧形式の通知時刻が移行されなかった")
+	}
+	want := []NotifySchedule{
+		{ID: 1, Time: "08:00"},
+		{ID: 2, Time: "18:30", NotifyTarget: NotifyTarget{Group: "帰宅"}},
+	}
+	if !reflect.DeepEqual(user.Schedules, want) {
+		t.Errorf("Schedules = %+v, want %+v", user.Schedules, want)
+	}
+	if user.NotifyGroups != nil || !reflect.DeepEqual(user.Notifies, []string{"08:00", "18:30"}) {
+		t.Errorf("NotifyGroups = %v, Notifies = %v", user.NotifyGroups, user.Notifies)
+	}
+
+	//移行済みなら何度呼んでも変わらない
+	user.Schedules[0].Weekdays = []time.Weekday{time.Monday}
+	if MigrateNotifySchedules(&user) || !reflect.DeepEqual(user.Schedules[0].Weekdays, []time.Weekday{time.Monday}) {
+		t.Errorf("移行し直した: %+v", user.Schedules)
+	}
+	//すべて消したあとも移行し直さない
+	user.Schedules = []NotifySchedule{}
+	user.Notifies = []string{"08:00"}
+	if MigrateNotifySchedules(&user) || len(user.Schedules) != 0 {
+		t.Errorf("消した通知が戻った: %+v", user.Schedules)
+	}
+}
+
+func TestNotifySchedulesSurviveRestart(t *testing.T) {
+	setupFakeBot(t)
+	api := newTestUsersAPI(t)
+	path := filepath.Join(t.TempDir(), "users.json")
+	//再起動する（保存先を作り直してキャッシュを読み込む）
+	restart := func() {
+		store, err := NewRemoteUserStore(api, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		UserStorage = store
+		if err := CacheUsrConfigs(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	schedules := func() []NotifySchedule {
+		user := GetUserConfigFromCache("U1")
+		if user == nil {
+			t.Fatal("ユーザーがいない")
+		}
+		return user.Schedules
+	}
+
+	//旧形式のまま保存されているユーザー
+	restart()
+	if _, err := UserStorage.Update("U1", func(user *UserConfig) {
+		user.Notifies = []string{"08:00", "18:30"}
+		user.NotifyGroups = map[string]string{"18:30": "帰宅"}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	restart()
+	want := []NotifySchedule{
+		{ID: 1, Time: "08:00"},
+		{ID: 2, Time: "18:30", NotifyTarget: NotifyTarget{Group: "帰宅"}},
+	}
+	if got := schedules(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("移行後 = %+v, want %+v", got, want)
+	}
+
+	//曜日や祝日のルールを付けて再起動しても残る
+	if err := UpdateUserConfigFunc("U1", func(user *UserConfig) {
+		EditNotifySchedule(user, "1", func(schedule *NotifySchedule) {
+			schedule.Weekdays = []time.Weekday{time.Monday, time.Friday}
+			schedule.SkipHolidays = true
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	restart()
+	want[0].Weekdays = []time.Weekday{time.Monday, time.Friday}
+	want[0].SkipHolidays = true
+	if got := schedules(); !reflect.DeepEqual(got, want) {
+		t.Errorf("再起動後 = %+v, want %+v", got, want)
+	}
+
+	//すべて消して再起動しても旧形式から作り直さない
+	if err := UpdateUserConfigFunc("U1", func(user *UserConfig) {
+		RemoveNotifySchedule(user, "1")
+		RemoveNotifySchedule(user, "2")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	restart()
+	if got := schedules(); got == nil || len(got) != 0 {
+		t.Errorf("消した後の再起動 = %#v", got)
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 //PostBackCommand ポストバックデータ
@@ -44,6 +47,8 @@ const (
 	PostBackCommandTypeFavorite PostBackCommandType = "favorite"
 	//PostBackCommandTypeFavoriteList お気に入り一覧の表示
 	PostBackCommandTypeFavoriteList PostBackCommandType = "favlist"
+	//PostBackCommandTypeFavoriteGroup お気に入りグループの編集
+	PostBackCommandTypeFavoriteGroup PostBackCommandType = "favgroup"
 	//PostBackCommandTypeDatePicker 日付情報送信
 	PostBackCommandTypeDatePicker PostBackCommandType = "date"
 	//PostBackCommandTypeTimePicker 時刻情報送信
@@ -66,6 +71,16 @@ const (
 	PostBackCommandTypeSlack PostBackCommandType = "slack"
 	//PostBackCommandTypeStatus システム障害状況
 	PostBackCommandTypeStatus PostBackCommandType = "system"
+	//PostBackCommandTypeAlert 台数アラート編集
+	PostBackCommandTypeAlert PostBackCommandType = "alert"
+	//PostBackCommandTypeAnnounce スポットのお知らせ設定
+	PostBackCommandTypeAnnounce PostBackCommandType = "announce"
+	//PostBackCommandTypeTrip 2地点でルート検索
+	PostBackCommandTypeTrip PostBackCommandType = "trip"
+	//PostBackCommandTypeLanguage 表示言語の設定
+	PostBackCommandTypeLanguage PostBackCommandType = "lang"
+	//PostBackCommandTypePause 通知の一時停止
+	PostBackCommandTypePause PostBackCommandType = "pause"
 )
 
 //PostBackCommandMode モード（登録/解除）お気に入りに使用
@@ -76,60 +91,128 @@ const (
 	PostBackCommandModeReg PostBackCommandMode = "reg"
 	//PostBackCommandModeUnreg 解除
 	PostBackCommandModeUnreg PostBackCommandMode = "unreg"
+	//PostBackCommandModeUp 1つ上へ移動
+	PostBackCommandModeUp PostBackCommandMode = "up"
+	//PostBackCommandModeDown 1つ下へ移動
+	PostBackCommandModeDown PostBackCommandMode = "down"
+	//PostBackCommandModeDelete まとめて削除
+	PostBackCommandModeDelete PostBackCommandMode = "delete"
+	//PostBackCommandModeEdit 編集画面の表示
+	PostBackCommandModeEdit PostBackCommandMode = "edit"
+	//PostBackCommandModeGroup お気に入りグループの指定
+	PostBackCommandModeGroup PostBackCommandMode = "group"
+	//PostBackCommandModeTime 時刻の変更
+	PostBackCommandModeTime PostBackCommandMode = "time"
+	//PostBackCommandModeDays 曜日の指定
+	PostBackCommandModeDays PostBackCommandMode = "days"
+	//PostBackCommandModeHoliday 祝日に送るかどうか
+	PostBackCommandModeHoliday PostBackCommandMode = "holiday"
+	//PostBackCommandModeOnce 1回だけにするかどうか
+	PostBackCommandModeOnce PostBackCommandMode = "once"
 )
 
+//PostbackDataVersion ポストバック文字列の形式のバージョン
+const PostbackDataVersion = "v2"
+
+//PostbackDataMaxLength LINEのポストバックに載せられる最大文字数
+const PostbackDataMaxLength = 300
+
+//ErrPostbackTooLong LINEのポストバックに載せられる文字数を超えている
+var ErrPostbackTooLong = errors.New("ポストバック文字列が長すぎます")
+
 //ParsePostbackData パース
+//「v2;」で始まればURLエンコードされた形式、それ以外はトーク履歴に残っている古いボタンの形式として読む
 func ParsePostbackData(data string) (postback PostBackCommand) {
-	keyvalArr := strings.Split(data, "_")
-	for _, keyval := range keyvalArr {
-		key, val := splitKeyVal(keyval)
-		switch PostBackElement(key) {
-		case PostBackElementCommand:
-			postback.Type = PostBackCommandType(val)
-		case PostBackElementArea:
-			postback.Area = val
-		case PostBackElementSpot:
-			postback.Spot = val
-		case PostBackElementTarget:
-			postback.Target = val
-		case PostBackElementValue:
-			postback.Value = val
-		case PostBackElementMode:
-			postback.Mode = PostBackCommandMode(val)
-		case PostBackElementSpan:
-			if span, err := strconv.Atoi(val); err == nil {
-				postback.Span = span
-			}
+	version, body := splitPostbackVersion(data)
+	switch version {
+	case PostbackDataVersion:
+		values, err := url.ParseQuery(body)
+		if err != nil {
+			LogWarn(context.Background(), "ポストバックの形式が不正です", LogFields{"error": err})
+			return
+		}
+		for key := range values {
+			postback.set(PostBackElement(key), values.Get(key))
+		}
+	case "":
+		//旧形式（key=value を _ でつないだもの）
+		keyvalArr := strings.Split(data, "_")
+		for _, keyval := range keyvalArr {
+			key, val := splitKeyVal(keyval)
+			postback.set(PostBackElement(key), val)
 		}
+	default:
+		LogWarn(context.Background(), "未対応のポストバックのバージョンです", LogFields{"version": version})
 	}
 	return
 }
 
-//Serialize パラメータを直列化
-func (pb *PostBackCommand) Serialize() string {
-	params := []string{}
-	if pb.Type != "" {
-		params = append(params, fmt.Sprintf("%s=%s", PostBackElementCommand, pb.Type))
+//splitPostbackVersion バージョンと本体に分ける（旧形式ならバージョンは空文字）
+func splitPostbackVersion(data string) (version string, body string) {
+	i := strings.Index(data, ";")
+	if i < 0 || !strings.HasPrefix(data, "v") || strings.ContainsAny(data[:i], "=_") {
+		return "", data
 	}
-	if pb.Area != "" {
-		params = append(params, fmt.Sprintf("%s=%s", PostBackElementArea, pb.Area))
-	}
-	if pb.Spot != "" {
-		params = append(params, fmt.Sprintf("%s=%s", PostBackElementSpot, pb.Spot))
+	return data[:i], data[i+1:]
+}
+
+//set パラメータを1つ設定する
+func (pb *PostBackCommand) set(key PostBackElement, val string) {
+	switch key {
+	case PostBackElementCommand:
+		pb.Type = PostBackCommandType(val)
+	case PostBackElementArea:
+		pb.Area = val
+	case PostBackElementSpot:
+		pb.Spot = val
+	case PostBackElementTarget:
+		pb.Target = val
+	case PostBackElementValue:
+		pb.Value = val
+	case PostBackElementMode:
+		pb.Mode = PostBackCommandMode(val)
+	case PostBackElementSpan:
+		if span, err := strconv.Atoi(val); err == nil {
+			pb.Span = span
+		}
 	}
-	if pb.Target != "" {
-		params = append(params, fmt.Sprintf("%s=%s", PostBackElementTarget, pb.Target))
+}
+
+//Serialize パラメータを直列化
+//値はURLエンコードするので「_」「=」「&」などを含んでいてもよい
+//署名鍵があれば発行時刻と署名を末尾に付ける
+//LINEの文字数制限を超えるとErrPostbackTooLongを返す（LINEに送信を拒否されるため）
+func (pb *PostBackCommand) Serialize() (string, error) {
+	params := []string{}
+	add := func(key PostBackElement, val string) {
+		if val != "" {
+			params = append(params, string(key)+"="+url.QueryEscape(val))
+		}
 	}
-	if pb.Value != "" {
-		params = append(params, fmt.Sprintf("%s=%s", PostBackElementValue, pb.Value))
+	add(PostBackElementCommand, string(pb.Type))
+	add(PostBackElementArea, pb.Area)
+	add(PostBackElementSpot, pb.Spot)
+	add(PostBackElementTarget, pb.Target)
+	add(PostBackElementValue, pb.Value)
+	add(PostBackElementMode, string(pb.Mode))
+	if pb.Span != 0 {
+		add(PostBackElementSpan, strconv.Itoa(pb.Span))
 	}
-	if pb.Mode != "" {
-		params = append(params, fmt.Sprintf("%s=%s", PostBackElementMode, pb.Mode))
+	data := signPostbackData(PostbackDataVersion+";"+strings.Join(params, "&"), time.Now())
+	if len(data) > PostbackDataMaxLength {
+		return "", ErrPostbackTooLong
 	}
-	if pb.Span != 0 {
-		params = append(params, fmt.Sprintf("%s=%d", PostBackElementSpan, pb.Span))
+	return data, nil
+}
+
+//serialize コードや数値など長さの決まった値だけのパラメータを直列化
+//文字数制限を超えるのは不具合なので、気づけるようにログに出す
+func (pb *PostBackCommand) serialize() string {
+	data, err := pb.Serialize()
+	if err != nil {
+		LogError(context.Background(), "ポストバック文字列が長すぎます", LogFields{"command": pb.Type, "error": err})
 	}
-	return strings.Join(params, "_")
+	return data
 }
 
 func splitKeyVal(keyval string) (string, string) {
@@ -150,7 +233,7 @@ func GetPostbackDataForAnalyze(area string, spot string, span int) string {
 		Spot: spot,
 		Span: span,
 	}
-	return postback.Serialize()
+	return postback.serialize()
 }
 
 //GetPostbackDataForDateAnalyze グラフ要求用ポストバック文字列
@@ -160,7 +243,7 @@ func GetPostbackDataForDateAnalyze(area string, spot string) string {
 		Area: area,
 		Spot: spot,
 	}
-	return postback.Serialize()
+	return postback.serialize()
 }
 
 //GetPostbackDataForCommands コマンド一覧ポストバック文字列
@@ -168,7 +251,7 @@ func GetPostbackDataForCommands() string {
 	postback := PostBackCommand{
 		Type: PostBackCommandTypeCommands,
 	}
-	return postback.Serialize()
+	return postback.serialize()
 }
 
 //GetPostbackDataForHistory 履歴一覧ポストバック文字列
@@ -176,7 +259,7 @@ func GetPostbackDataForHistory() string {
 	postback := PostBackCommand{
 		Type: PostBackCommandTypeHistory,
 	}
-	return postback.Serialize()
+	return postback.serialize()
 }
 
 //GetPostbackDataFavoriteList お気に入り一覧ポストバック文字列
@@ -184,6 +267,31 @@ func GetPostbackDataFavoriteList() string {
 	postback := PostBackCommand{
 		Type: PostBackCommandTypeFavoriteList,
 	}
+	return postback.serialize()
+}
+
+//GetPostbackDataFavoriteGroup お気に入りグループ表示ポストバック文字列（editなら編集画面）
+//グループ名が長すぎて文字数制限を超えるとErrPostbackTooLong
+func GetPostbackDataFavoriteGroup(name string, mode PostBackCommandMode) (string, error) {
+	postback := PostBackCommand{
+		Type:   PostBackCommandTypeFavoriteList,
+		Target: name,
+		Mode:   mode,
+	}
+	return postback.Serialize()
+}
+
+//GetPostbackDataForFavoriteGroup お気に入りグループ編集用ポストバック文字列
+//グループごと削除するときはarea、spotを空にする
+//グループ名が長すぎて文字数制限を超えるとErrPostbackTooLong
+func GetPostbackDataForFavoriteGroup(mode PostBackCommandMode, name string, area string, spot string) (string, error) {
+	postback := PostBackCommand{
+		Type:   PostBackCommandTypeFavoriteGroup,
+		Target: name,
+		Area:   area,
+		Spot:   spot,
+		Mode:   mode,
+	}
 	return postback.Serialize()
 }
 
@@ -192,7 +300,7 @@ func GetPostbackDataServiceStatus() string {
 	postback := PostBackCommand{
 		Type: PostBackCommandTypeStatus,
 	}
-	return postback.Serialize()
+	return postback.serialize()
 }
 
 //GetPostbackDataRanking 台数ランキング取得ポストバック文字列
@@ -200,7 +308,7 @@ func GetPostbackDataRanking() string {
 	postback := PostBackCommand{
 		Type: PostBackCommandTypeRanking,
 	}
-	return postback.Serialize()
+	return postback.serialize()
 }
 
 //GetPostbackDataConfigOpen 設定画面表示ポストバック文字列
@@ -208,7 +316,7 @@ func GetPostbackDataConfigOpen() string {
 	postback := PostBackCommand{
 		Type: PostBackCommandTypeConfigOpen,
 	}
-	return postback.Serialize()
+	return postback.serialize()
 }
 
 //GetPostbackDataForFovarite お気に入り登録用ポストバック文字列
@@ -219,15 +327,92 @@ func GetPostbackDataForFovarite(area string, spot string, mode PostBackCommandMo
 		Spot: spot,
 		Mode: mode,
 	}
-	return postback.Serialize()
+	return postback.serialize()
 }
 
-//GetPostbackDataForNotify 通知時刻登録用ポストバック文字列
-func GetPostbackDataForNotify(mode PostBackCommandMode, targetTime string) string {
+//GetPostbackDataForNotify 通知編集用ポストバック文字列
+//新規登録以外は通知のIDを指定する（valueは曜日の並びやon/offなどモードごとの値）
+func GetPostbackDataForNotify(mode PostBackCommandMode, id int, value string) string {
+	postback := PostBackCommand{
+		Type:  PostBackCommandTypeNotify,
+		Value: value,
+		Mode:  mode,
+	}
+	if id > 0 {
+		postback.Target = strconv.Itoa(id)
+	}
+	return postback.serialize()
+}
+
+//GetPostbackDataForNotifyTarget 通知で送る内容の指定用ポストバック文字列
+//お気に入りグループはvalue、スポットはarea、spotに入れる（どちらも空ならお気に入り）
+func GetPostbackDataForNotifyTarget(id int, target NotifyTarget) string {
 	postback := PostBackCommand{
 		Type:   PostBackCommandTypeNotify,
-		Target: targetTime,
+		Target: strconv.Itoa(id),
+		Value:  target.Group,
+		Mode:   PostBackCommandModeGroup,
+	}
+	if target.Spot != "" {
+		postback.Area, postback.Spot = SplitAreaSpot(target.Spot)
+	}
+	return postback.serialize()
+}
+
+//GetPostbackDataForPause 通知の一時停止用ポストバック文字列（valueは一時停止の長さなど）
+func GetPostbackDataForPause(mode PostBackCommandMode, kind NotifyPauseKind, value string) string {
+	postback := PostBackCommand{
+		Type:   PostBackCommandTypePause,
+		Target: string(kind),
+		Value:  value,
 		Mode:   mode,
 	}
-	return postback.Serialize()
+	return postback.serialize()
+}
+
+//GetPostbackDataForAlert 台数アラート編集用ポストバック文字列
+//登録時はvalueに条件（lt3など）、解除時はアラートのキー（A1-01:lt3）を指定する
+func GetPostbackDataForAlert(mode PostBackCommandMode, area string, spot string, value string) string {
+	postback := PostBackCommand{
+		Type:  PostBackCommandTypeAlert,
+		Area:  area,
+		Spot:  spot,
+		Value: value,
+		Mode:  mode,
+	}
+	return postback.serialize()
+}
+
+//GetPostbackDataForAnnounce スポットのお知らせ設定用ポストバック文字列
+func GetPostbackDataForAnnounce(mode PostBackCommandMode) string {
+	postback := PostBackCommand{
+		Type: PostBackCommandTypeAnnounce,
+		Mode: mode,
+	}
+	return postback.serialize()
+}
+
+//GetPostbackDataForTrip 2地点でルート検索ポストバック文字列
+func GetPostbackDataForTrip() string {
+	postback := PostBackCommand{
+		Type: PostBackCommandTypeTrip,
+	}
+	return postback.serialize()
+}
+
+//GetPostbackDataForLanguage 表示言語設定用ポストバック文字列（空文字はLINEの設定に合わせる）
+func GetPostbackDataForLanguage(lang Lang) string {
+	postback := PostBackCommand{
+		Type:  PostBackCommandTypeLanguage,
+		Value: string(lang),
+	}
+	return postback.serialize()
+}
+
+//GetPostbackDataForSlack Slack連携ポストバック文字列
+func GetPostbackDataForSlack() string {
+	postback := PostBackCommand{
+		Type: PostBackCommandTypeSlack,
+	}
+	return postback.serialize()
 }
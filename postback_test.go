@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPostbackDataRoundTrip(t *testing.T) {
+	tests := []PostBackCommand{
+		{Type: PostBackCommandTypeAnalyze, Area: "A1", Spot: "01", Span: 2},
+		{Type: PostBackCommandTypeFavoriteGroup, Mode: PostBackCommandModeReg, Target: "通勤_朝=1&2", Area: "A1", Spot: "01"},
+		{Type: PostBackCommandTypeFavoriteList, Target: "a b+c%d;e"},
+		{Type: PostBackCommandTypeNotify, Mode: PostBackCommandModeDays, Target: "3", Value: "12345"},
+		{Type: PostBackCommandTypeCommands},
+	}
+	for _, want := range tests {
+		data, err := want.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(data, PostbackDataVersion+";") {
+			t.Errorf("%q にバージョンがない", data)
+		}
+		if got := ParsePostbackData(data); got != want {
+			t.Errorf("ParsePostbackData(%q) = %+v, want %+v", data, got, want)
+		}
+	}
+}
+
+func TestParseLegacyPostbackData(t *testing.T) {
+	tests := []struct {
+		data string
+		want PostBackCommand
+	}{
+		{"command=analysis_area=A1_spot=01_span=2", PostBackCommand{Type: PostBackCommandTypeAnalyze, Area: "A1", Spot: "01", Span: 2}},
+		{"command=favorite_area=A1_spot=01_mode=reg", PostBackCommand{Type: PostBackCommandTypeFavorite, Area: "A1", Spot: "01", Mode: PostBackCommandModeReg}},
+		{"command=favlist", PostBackCommand{Type: PostBackCommandTypeFavoriteList}},
+		//値に「=」があるものや数値でないspanは読み飛ばす
+		{"command=favlist_targer=a=b_span=x", PostBackCommand{Type: PostBackCommandTypeFavoriteList}},
+		{"", PostBackCommand{}},
+	}
+	for _, tt := range tests {
+		if got := ParsePostbackData(tt.data); got != tt.want {
+			t.Errorf("ParsePostbackData(%q) = %+v, want %+v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestParsePostbackDataUnknownVersion(t *testing.T) {
+	if got := ParsePostbackData("v9;command=favlist"); got != (PostBackCommand{}) {
+		t.Errorf("ParsePostbackData = %+v", got)
+	}
+}
+
+func TestSerializeTooLong(t *testing.T) {
+	saved := PostbackSigningKey
+	defer func() { PostbackSigningKey = saved }()
+	PostbackSigningKey = []byte("test-postback-secret")
+
+	//URLエンコードすると1文字が9文字になる
+	if _, err := GetPostbackDataFavoriteGroup(strings.Repeat("通", 40), PostBackCommandModeEdit); err != ErrPostbackTooLong {
+		t.Errorf("err = %v, want ErrPostbackTooLong", err)
+	}
+	data, err := GetPostbackDataForFavoriteGroup(PostBackCommandModeUnreg, strings.Repeat("通", MaxFavoriteGroupName), "A1", "01")
+	if err != nil || len(data) > PostbackDataMaxLength {
+		t.Errorf("len = %d, err = %v", len(data), err)
+	}
+}
+
+func TestValidFavoriteGroupName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"通勤", true},
+		{strings.Repeat("😀", MaxFavoriteGroupName), true},
+		{"", false},
+		{strings.Repeat("あ", MaxFavoriteGroupName+1), false},
+	}
+	for _, tt := range tests {
+		if got := validFavoriteGroupName(tt.name, "A1", "01"); got != tt.want {
+			t.Errorf("validFavoriteGroupName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//PostBackElementIssuedAt 発行時刻（UNIX時間の36進数）
+	PostBackElementIssuedAt PostBackElement = "iat"
+	//PostBackElementSignature 署名（必ず最後に置く）
+	PostBackElementSignature PostBackElement = "sig"
+	//PostbackSignatureLength 署名に使うHMACのバイト数（ポストバックの文字数制限があるため切り詰める）
+	PostbackSignatureLength = 12
+	//PostbackMaxAge 設定を変更するボタンの有効期限
+	PostbackMaxAge = 7 * 24 * time.Hour
+)
+
+var (
+	//PostbackSigningKey ポストバックの署名鍵（空なら署名しない）
+	PostbackSigningKey []byte
+	//ErrPostbackForged 署名がない・一致しない
+	ErrPostbackForged = errors.New("ポストバックの署名が不正です")
+	//ErrPostbackExpired 有効期限切れ
+	ErrPostbackExpired = errors.New("ポストバックの有効期限が切れています")
+)
+
+//postbackReadOnly 署名がなくても受け付けるコマンド（表示するだけで設定を変更しないもの）
+//署名を入れる前に送ったボタンがトーク履歴に残っているため
+var postbackReadOnly = map[PostBackCommandType]bool{
+	PostBackCommandTypeAnalyze:      true,
+	PostBackCommandTypeFavoriteList: true,
+	PostBackCommandTypeDatePicker:   true,
+	PostBackCommandTypeConfigOpen:   true,
+	PostBackCommandTypeCommands:     true,
+	PostBackCommandTypeHistory:      true,
+	PostBackCommandTypeRanking:      true,
+	PostBackCommandTypeStatus:       true,
+}
+
+//signPostbackData 発行時刻と署名を付け加える
+func signPostbackData(data string, now time.Time) string {
+	if len(PostbackSigningKey) == 0 {
+		return data
+	}
+	data += "&" + string(PostBackElementIssuedAt) + "=" + strconv.FormatInt(now.Unix(), 36)
+	return data + "&" + string(PostBackElementSignature) + "=" + postbackSignature(data)
+}
+
+//postbackSignature 署名を計算する
+func postbackSignature(data string) string {
+	mac := hmac.New(sha256.New, PostbackSigningKey)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:PostbackSignatureLength])
+}
+
+//VerifyPostbackData 署名と有効期限を確認してパースする
+//署名のないデータは表示だけのコマンドなら受け付ける
+func VerifyPostbackData(data string, now time.Time) (PostBackCommand, error) {
+	command := ParsePostbackData(data)
+	if len(PostbackSigningKey) == 0 {
+		//署名鍵がない環境（ローカルでのデバッグなど）では確認しない
+		return command, nil
+	}
+	marker := "&" + string(PostBackElementSignature) + "="
+	i := strings.LastIndex(data, marker)
+	if i < 0 {
+		if postbackReadOnly[command.Type] {
+			return command, nil
+		}
+		return command, ErrPostbackForged
+	}
+	signed, signature := data[:i], data[i+len(marker):]
+	if !hmac.Equal([]byte(signature), []byte(postbackSignature(signed))) {
+		return command, ErrPostbackForged
+	}
+	if postbackReadOnly[command.Type] {
+		return command, nil
+	}
+	//設定を変更するボタンは古くなったら使えなくする
+	issuedAt, ok := postbackIssuedAt(signed)
+	if !ok {
+		return command, ErrPostbackForged
+	}
+	if now.Sub(issuedAt) > PostbackMaxAge || issuedAt.Sub(now) > time.Minute {
+		return command, ErrPostbackExpired
+	}
+	return command, nil
+}
+
+//postbackIssuedAt 発行時刻を取り出す
+func postbackIssuedAt(data string) (time.Time, bool) {
+	marker := "&" + string(PostBackElementIssuedAt) + "="
+	i := strings.LastIndex(data, marker)
+	if i < 0 {
+		return time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(data[i+len(marker):], 36, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(unix, 0), true
+}
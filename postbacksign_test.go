@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyPostbackData(t *testing.T) {
+	saved := PostbackSigningKey
+	defer func() { PostbackSigningKey = saved }()
+	PostbackSigningKey = []byte("test-postback-secret")
+
+	now := tokyoAt("08:00:00")
+	sign := func(body string, issuedAt time.Time) string {
+		return signPostbackData(PostbackDataVersion+";"+body, issuedAt)
+	}
+	favorite := "command=favorite&area=A1&spot=01&mode=reg"
+	signed := sign(favorite, now)
+	tests := []struct {
+		name    string
+		data    string
+		want    PostBackCommandType
+		wantErr error
+	}{
+		{name: "署名あり", data: signed, want: PostBackCommandTypeFavorite},
+		{name: "署名が違う", data: signed[:len(signed)-2] + "AA", wantErr: ErrPostbackForged},
+		{name: "別の鍵の署名", data: PostbackDataVersion + ";" + favorite + "&iat=" + strconv.FormatInt(now.Unix(), 36) + "&sig=AAAAAAAAAAAAAAAA", wantErr: ErrPostbackForged},
+		{name: "本体を書き換えた", data: strings.Replace(signed, "mode=reg", "mode=unreg", 1), wantErr: ErrPostbackForged},
+		{name: "期限切れ", data: sign(favorite, now.Add(-PostbackMaxAge-time.Minute)), wantErr: ErrPostbackExpired},
+		{name: "未来の発行時刻", data: sign(favorite, now.Add(time.Hour)), wantErr: ErrPostbackExpired},
+		{name: "発行時刻がない", data: PostbackDataVersion + ";" + favorite + "&sig=" + postbackSignature(PostbackDataVersion+";"+favorite), wantErr: ErrPostbackForged},
+		{name: "署名なしの表示コマンド", data: "command=analysis_area=A1_spot=01", want: PostBackCommandTypeAnalyze},
+		{name: "署名なしの表示コマンド（v2）", data: PostbackDataVersion + ";command=ranking", want: PostBackCommandTypeRanking},
+		{name: "古い署名付きの表示コマンド", data: sign("command=history", now.Add(-PostbackMaxAge-time.Hour)), want: PostBackCommandTypeHistory},
+		{name: "署名なしの設定変更", data: "command=favorite_area=A1_spot=01_mode=reg", wantErr: ErrPostbackForged},
+		{name: "署名なしのルート検索", data: PostbackDataVersion + ";command=trip", wantErr: ErrPostbackForged},
+		{name: "署名なしのSlack連携", data: PostbackDataVersion + ";command=slack", wantErr: ErrPostbackForged},
+	}
+	for _, tt := range tests {
+		got, err := VerifyPostbackData(tt.data, now)
+		if err != tt.wantErr {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got.Type != tt.want {
+			t.Errorf("%s: Type = %s, want %s", tt.name, got.Type, tt.want)
+		}
+	}
+}
+
+func TestVerifyPostbackDataWithoutKey(t *testing.T) {
+	saved := PostbackSigningKey
+	defer func() { PostbackSigningKey = saved }()
+	PostbackSigningKey = nil
+
+	//署名鍵のない環境では確かめない
+	got, err := VerifyPostbackData("command=favorite_area=A1_spot=01_mode=reg", tokyoAt("08:00:00"))
+	if err != nil || got.Type != PostBackCommandTypeFavorite {
+		t.Errorf("got = %+v, err = %v", got, err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+//SpotListCarouselSize これ以上の件数はカルーセルで表示する
+const SpotListCarouselSize = 20
+
+//RenderLine LINEのメッセージにする
+func RenderLine(view View) linebot.SendingMessage {
+	switch view := view.(type) {
+	case SpotListView:
+		var message linebot.SendingMessage
+		if len(view.Spots) < SpotListCarouselSize {
+			container := CreateSpotListBubbleContainer(view)
+			message = linebot.NewFlexMessage(view.Title, &container)
+		} else {
+			container := CreateSpotListCarouselContainer(view)
+			message = linebot.NewFlexMessage(view.Title, &container)
+		}
+		if len(view.Groups) > 0 {
+			message = message.WithQuickReplies(CreateFavoriteGroupQuickReplyItems(view.Groups))
+		}
+		return message
+	case AnalysisView:
+		container := CreateAnalysisBubbleContainer(view)
+		message := linebot.NewFlexMessage(view.Title, &container)
+		if len(view.Groups) > 0 {
+			return message.WithQuickReplies(CreateFavoriteGroupAddQuickReplyItems(view.Area, view.Spot, view.Groups, view.Lang))
+		}
+		return message
+	case ConfigView:
+		container := CreateConfigBubbleContainer(view)
+		return linebot.NewFlexMessage(T(view.Lang, "config.alt"), &container)
+	case MenuView:
+		container := CreateCommandListBubbleContainer(view.Title, view.Items)
+		return linebot.NewFlexMessage(view.AltText, &container)
+	case FavoriteGroupView:
+		container := CreateFavoriteGroupBubbleContainer(view)
+		return linebot.NewFlexMessage(T(view.Lang, "favgroup.editTitle", view.Name), &container)
+	case NotifyScheduleView:
+		container := CreateNotifyScheduleBubbleContainer(view)
+		return linebot.NewFlexMessage(T(view.Lang, "notify.editTitle", view.Time), &container).WithQuickReplies(CreateNotifyTargetQuickReplyItems(view))
+	case StatusView:
+		return linebot.NewTextMessage(view.Text)
+	case TextView:
+		return linebot.NewTextMessage(view.Text)
+	}
+	return linebot.NewTextMessage(fmt.Sprintf("unknown view: %T", view))
+}
+
+//RenderText 文字だけで表示する（ログやコンソール向け）
+func RenderText(view View) string {
+	var lines []string
+	switch view := view.(type) {
+	case SpotListView:
+		lines = append(lines, view.Title, view.LastUpdate)
+		for _, spot := range view.Spots {
+			lines = append(lines, "- "+strings.Replace(spotItemLabel(spot, view.Lang), "\n", " ", -1))
+		}
+	case AnalysisView:
+		lines = append(lines, view.Title, view.URL)
+		for _, text := range []string{view.Description, view.Forecast, view.LastUpdate} {
+			if text != "" {
+				lines = append(lines, text)
+			}
+		}
+		if !view.Favorite {
+			lines = append(lines, "["+T(view.Lang, "fav.add")+"]")
+		}
+		for _, name := range view.Groups {
+			lines = append(lines, "["+T(view.Lang, "favgroup.addTo", name)+"]")
+		}
+	case ConfigView:
+		lang := view.Lang
+		lines = append(lines, T(lang, "config.title"), "", T(lang, "config.favorites"))
+		for _, favorite := range view.Favorites {
+			lines = append(lines, fmt.Sprintf("- [%s-%s] %s", favorite.Area, favorite.Spot, favorite.Name))
+		}
+		lines = append(lines, "", T(lang, "config.favoriteGroups", view.MaxFavoriteGroups))
+		for _, group := range view.FavoriteGroups {
+			lines = append(lines, "- "+T(lang, "favgroup.item", group.Name, group.Count))
+		}
+		lines = append(lines, "", T(lang, "config.notifies", view.MaxNotifies))
+		for _, notify := range view.Notifies {
+			lines = append(lines, "- "+strings.Replace(notifyItemLabel(notify, lang), "\n", " ", -1))
+		}
+		lines = append(lines, "", T(lang, "pause.title"))
+		if view.Pause.VacationStart != "" {
+			lines = append(lines, "- "+T(lang, "pause.vacation", view.Pause.VacationStart, view.Pause.VacationEnd))
+		}
+		if view.Pause.QuietStart != "" {
+			lines = append(lines, "- "+T(lang, "pause.quiet", view.Pause.QuietStart, view.Pause.QuietEnd))
+		}
+		if view.Pause.SnoozeUntil != "" {
+			lines = append(lines, "- "+T(lang, "pause.snoozed", view.Pause.SnoozeUntil))
+		}
+		lines = append(lines, "", T(lang, "config.alerts", view.MaxAlerts))
+		for _, alert := range view.Alerts {
+			lines = append(lines, fmt.Sprintf("- [%s] %s", alert.Code, alert.Condition))
+		}
+		announce := T(lang, "announce.disabled")
+		if view.SpotAnnounce {
+			announce = T(lang, "announce.enabled")
+		}
+		lines = append(lines, "", T(lang, "config.announce"), "- "+announce)
+		lines = append(lines, "", T(lang, "config.language", LangName(view.Language, lang)))
+	case NotifyScheduleView:
+		lines = append(lines, T(view.Lang, "notify.editTitle", view.Time), strings.Replace(notifyItemLabel(view.NotifyItem, view.Lang), "\n", " ", -1))
+	case FavoriteGroupView:
+		lines = append(lines, T(view.Lang, "favgroup.editTitle", view.Name))
+		for i, spot := range view.Spots {
+			lines = append(lines, fmt.Sprintf("%d. [%s-%s] %s", i+1, spot.Area, spot.Spot, spot.Name))
+		}
+	case MenuView:
+		lines = append(lines, view.Title)
+		for _, item := range view.Items {
+			lines = append(lines, "- "+item.Label)
+		}
+	case StatusView:
+		lines = append(lines, view.Text)
+	case TextView:
+		lines = append(lines, view.Text)
+	default:
+		lines = append(lines, fmt.Sprintf("unknown view: %T", view))
+	}
+	return strings.Join(lines, "\n")
+}
+
+//RenderJSON 種類と中身をJSONにする
+func RenderJSON(view View) ([]byte, error) {
+	return json.Marshal(struct {
+		Kind string `json:"kind"`
+		View View   `json:"view"`
+	}{view.ViewKind(), view})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+func TestRenderText(t *testing.T) {
+	tests := []struct {
+		name string
+		view View
+		want string
+	}{
+		{
+			name: "台数一覧",
+			view: SpotListView{
+				Title:      "お気に入り",
+				LastUpdate: "最終更新：2024/06/05 08:00",
+				Spots: []SpotItem{
+					{Area: "A1", Spot: "01", Name: "千代田区役所", Count: 18, HasCount: true},
+					{Area: "B2", Spot: "02", Name: "銀座四丁目", Note: "120m"},
+				},
+				Lang: LangJa,
+			},
+			want: "お気に入り\n最終更新：2024/06/05 08:00\n" +
+				"- [A1-01] 千代田区役所 (18台)\n" +
+				"- [B2-02] 銀座四丁目 120m (台数不明)",
+		},
+		{
+			name: "グラフ",
+			view: AnalysisView{
+				Title:       "[A1-01] 千代田区役所",
+				URL:         "https://example.com/graph?area=A1&spot=01",
+				Description: "区役所の前",
+				Groups:      []string{"通勤"},
+				Lang:        LangJa,
+			},
+			want: "[A1-01] 千代田区役所\nhttps://example.com/graph?area=A1&spot=01\n区役所の前\n" +
+				"[お気に入りに登録する]\n[「通勤」に追加]",
+		},
+		{
+			name: "お気に入り登録済みのグラフ",
+			view: AnalysisView{Title: "[A1-01] 千代田区役所", URL: "u", Favorite: true, Forecast: "予測", Lang: LangJa},
+			want: "[A1-01] 千代田区役所\nu\n予測",
+		},
+		{
+			name: "設定画面",
+			view: ConfigView{
+				Favorites:         []SpotItem{{Area: "A1", Spot: "01", Name: "千代田区役所"}},
+				FavoriteGroups:    []FavoriteGroupItem{{Name: "通勤", Count: 2}},
+				MaxFavoriteGroups: 5,
+				Notifies:          []NotifyItem{{ID: 1, Time: "08:00", Weekdays: "12345", Group: "通勤"}},
+				MaxNotifies:       3,
+				Pause:             PauseItem{QuietStart: "22:00", QuietEnd: "07:00"},
+				MaxAlerts:         4,
+				Alerts:            []AlertItem{{Code: "A1-01", Condition: "5台以下"}},
+				SpotAnnounce:      true,
+				Lang:              LangJa,
+			},
+			want: "ユーザー設定\n\nお気に入り登録されたスポット\n- [A1-01] 千代田区役所\n\n" +
+				"お気に入りグループ（5個まで作成できます）\n- 通勤（2件）\n\n" +
+				"お気に入り登録したスポットの通知の設定（3件まで設定できます）\n- 08:00 平日（通勤）\n\n" +
+				"通知の一時停止\n- 22:00～07:00は送らない\n\n" +
+				"お気に入り登録したスポットの台数アラート（4件まで設定できます）\n- [A1-01] 5台以下\n\n" +
+				"お気に入りの近くに新しいスポットができたときや、お気に入りのスポットがなくなったときのお知らせ\n- 受け取る\n\n" +
+				"表示言語（現在：自動（LINEの設定））",
+		},
+		{
+			name: "メニュー",
+			view: MenuView{Title: "履歴", Items: []MenuItem{{Label: "中央"}, {Label: "銀座"}}},
+			want: "履歴\n- 中央\n- 銀座",
+		},
+		{
+			name: "稼働状況",
+			view: StatusView{OK: true, Text: "正常"},
+			want: "正常",
+		},
+		{
+			name: "文章",
+			view: TextView{Text: "1行目\n2行目"},
+			want: "1行目\n2行目",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenderText(tt.view); got != tt.want {
+				t.Errorf("RenderText() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderJSON(t *testing.T) {
+	view := SpotListView{
+		Title: "検索結果",
+		Spots: []SpotItem{{Area: "A1", Spot: "01", Name: "千代田区役所", Count: 3, HasCount: true}},
+		Stale: true,
+		Lang:  LangEn,
+	}
+	data, err := RenderJSON(view)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Kind string       `json:"kind"`
+		View SpotListView `json:"view"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Kind != "spotList" {
+		t.Errorf("kind = %q", got.Kind)
+	}
+	if got.View.Title != view.Title || len(got.View.Spots) != 1 || got.View.Spots[0] != view.Spots[0] || !got.View.Stale || got.View.Lang != LangEn {
+		t.Errorf("view = %+v", got.View)
+	}
+
+	//種類ごとの名前
+	for _, tt := range []struct {
+		view View
+		kind string
+	}{
+		{TextView{}, "text"},
+		{StatusView{}, "status"},
+		{MenuView{}, "menu"},
+	} {
+		data, err := RenderJSON(tt.view)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got struct {
+			Kind string `json:"kind"`
+		}
+		json.Unmarshal(data, &got)
+		if got.Kind != tt.kind {
+			t.Errorf("%T: kind = %q, want %q", tt.view, got.Kind, tt.kind)
+		}
+	}
+}
+
+func TestRenderLine(t *testing.T) {
+	spots := make([]SpotItem, SpotListCarouselSize)
+	for i := range spots {
+		spots[i] = SpotItem{Area: "A1", Spot: "01", Name: "千代田区役所"}
+	}
+	//少なければバブル、多ければカルーセル
+	message, ok := RenderLine(SpotListView{Title: "一覧", Spots: spots[:1], Lang: LangJa}).(*linebot.FlexMessage)
+	if !ok {
+		t.Fatal("Flexメッセージではない")
+	}
+	if _, ok := message.Contents.(*linebot.BubbleContainer); !ok || message.AltText != "一覧" {
+		t.Errorf("contents = %T, altText = %q", message.Contents, message.AltText)
+	}
+	message = RenderLine(SpotListView{Title: "一覧", Spots: spots, Lang: LangJa}).(*linebot.FlexMessage)
+	if _, ok := message.Contents.(*linebot.CarouselContainer); !ok {
+		t.Errorf("contents = %T", message.Contents)
+	}
+
+	text, ok := RenderLine(TextView{Text: "こんにちは"}).(*linebot.TextMessage)
+	if !ok || text.Text != "こんにちは" {
+		t.Errorf("message = %+v", text)
+	}
+}
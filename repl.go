@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+const (
+	//REPLFormatText 返信を文字だけで表示する
+	REPLFormatText = "text"
+	//REPLFormatJSON 返信をLINEに送るJSONのまま表示する
+	REPLFormatJSON = "json"
+	//replHelp 使い方
+	replHelp = `テキストを入力するとユーザーからのメッセージとして処理します（/fav などのコマンドも同じ）
+:postback DATA       ポストバックを送る
+:press N [VALUE]     直前の返信のボタンNを押す（日時選択ならVALUEに 2020-01-02 や 08:30 を指定）
+:location LAT LON    位置情報を送る
+:sticker             スタンプを送る
+:follow              友だち追加
+:join / :leave       グループへの招待・退出（-group 指定時）
+:notify [GROUP]      通知時刻の通知を送る（GROUPはお気に入りグループ）
+:metrics             /metricsの内容を表示する
+:format text|json    表示形式を切り替える
+:help                この説明
+:quit                終了`
+)
+
+//replButton 返信に含まれていたボタン
+type replButton struct {
+	Label string
+	//Type postback、datetimepicker、message、location
+	Type string
+	Data string
+	Mode string
+	Text string
+}
+
+//REPL LINEを通さずにボットを動かす対話モード
+type REPL struct {
+	UserID  string
+	GroupID string
+	Format  string
+	out     io.Writer
+	sender  *RecordingSender
+	buttons []replButton
+	serial  int
+}
+
+//RunREPL 引数を解釈して対話モードを起動する（戻り値は終了コード）
+func RunREPL(args []string, in io.Reader, out io.Writer) int {
+	flags := flag.NewFlagSet("repl", flag.ContinueOnError)
+	flags.SetOutput(out)
+	userID := flags.String("user", "Urepl", "送信するユーザーのID")
+	groupID := flags.String("group", "", "グループから送る場合のグループID")
+	format := flags.String("format", REPLFormatText, "返信の表示形式（text または json）")
+	endpoint := flags.String("api", "", "BikeshareAPIのURL（既定は本番）")
+	fake := flags.Bool("fake", false, "組み込みの偽のBikeshareAPIを使う")
+	storePath := flags.String("store", "", "ユーザー設定を保存するファイル（既定はメモリ上だけ）")
+	nosign := flags.Bool("nosign", false, "ポストバックの署名を確認しない")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	LoadConfig()
+	if *nosign {
+		PostbackSigningKey = nil
+	}
+	if *fake {
+		server := NewFakeBikeshareServer()
+		defer server.Close()
+		*endpoint = server.URL + "/"
+	}
+	SetupBikeshareAPI(*endpoint)
+	store, err := NewFileUserStore(*storePath)
+	if err != nil {
+		fmt.Fprintf(out, "ユーザー設定を開けません: %v\n", err)
+		return 1
+	}
+	UserStorage = store
+	if err := CacheUsrConfigs(); err != nil {
+		fmt.Fprintf(out, "ユーザー設定を読み込めません: %v\n", err)
+		return 1
+	}
+	if err := LoadSpotNames(); err != nil {
+		fmt.Fprintf(out, "スポット名を取得できません（検索は使えません）: %v\n", err)
+	}
+	//友だち追加済みの状態から始める
+	if GetUserConfigFromCache(*userID) == nil {
+		UpdateUserConfig(UserUpdateTypeUserAdd, *userID, "")
+	}
+
+	repl := NewREPL(*userID, *groupID, out)
+	repl.Format = *format
+	repl.Run(in)
+	return 0
+}
+
+//NewREPL コンストラクタ（送信先を記録用に差し替える）
+func NewREPL(userID, groupID string, out io.Writer) *REPL {
+	sender := NewRecordingSender()
+	Sender = sender
+	LineBotAPI = nil
+	return &REPL{UserID: userID, GroupID: groupID, Format: REPLFormatText, out: out, sender: sender}
+}
+
+//Run 1行ずつ読み込んで処理する
+func (repl *REPL) Run(in io.Reader) {
+	fmt.Fprintln(repl.out, ":help で使い方を表示します")
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(repl.out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(repl.out)
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line == ":quit" || line == ":q" {
+			return
+		}
+		if err := repl.Execute(line); err != nil {
+			fmt.Fprintf(repl.out, "%v\n", err)
+		}
+	}
+}
+
+//Execute 1行分の入力を処理して返信を表示する
+func (repl *REPL) Execute(line string) error {
+	if !strings.HasPrefix(line, ":") {
+		return repl.dispatch(repl.newEvent(linebot.EventTypeMessage, func(event *linebot.Event) {
+			event.Message = &linebot.TextMessage{ID: event.ReplyToken, Text: line}
+		}))
+	}
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case ":help", ":h":
+		fmt.Fprintln(repl.out, replHelp)
+		return nil
+	case ":format":
+		if len(fields) < 2 || (fields[1] != REPLFormatText && fields[1] != REPLFormatJSON) {
+			return fmt.Errorf(":format text または :format json")
+		}
+		repl.Format = fields[1]
+		return nil
+	case ":postback", ":pb":
+		if len(fields) < 2 {
+			return fmt.Errorf(":postback DATA")
+		}
+		return repl.postback(strings.TrimSpace(strings.TrimPrefix(line, fields[0])), nil)
+	case ":press", ":p":
+		if len(fields) < 2 {
+			return fmt.Errorf(":press N [VALUE]")
+		}
+		return repl.press(fields[1], fields[2:])
+	case ":location":
+		if len(fields) < 3 {
+			return fmt.Errorf(":location LAT LON")
+		}
+		lat, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return err
+		}
+		lon, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return err
+		}
+		return repl.dispatch(repl.newEvent(linebot.EventTypeMessage, func(event *linebot.Event) {
+			event.Message = &linebot.LocationMessage{ID: event.ReplyToken, Latitude: lat, Longitude: lon}
+		}))
+	case ":sticker":
+		return repl.dispatch(repl.newEvent(linebot.EventTypeMessage, func(event *linebot.Event) {
+			event.Message = &linebot.StickerMessage{ID: event.ReplyToken, PackageID: "11537", StickerID: "52002734"}
+		}))
+	case ":follow":
+		return repl.dispatch(repl.newEvent(linebot.EventTypeFollow, nil))
+	case ":join":
+		return repl.dispatch(repl.newEvent(linebot.EventTypeJoin, nil))
+	case ":leave":
+		return repl.dispatch(repl.newEvent(linebot.EventTypeLeave, nil))
+	case ":notify":
+		SendScheduledNotify(repl.sourceID(), NotifyTarget{Group: strings.TrimSpace(strings.TrimPrefix(line, fields[0]))})
+		repl.show()
+		return nil
+	case ":metrics":
+		WriteMetrics(repl.out)
+		return nil
+	}
+	return fmt.Errorf("不明なコマンドです: %s（:help で使い方を表示）", fields[0])
+}
+
+//sourceID 送信元のID（グループならグループID）
+func (repl *REPL) sourceID() string {
+	if repl.GroupID != "" {
+		return repl.GroupID
+	}
+	return repl.UserID
+}
+
+//newEvent Webhookで届くのと同じ形のイベントを作る
+func (repl *REPL) newEvent(eventType linebot.EventType, fill func(event *linebot.Event)) *linebot.Event {
+	repl.serial++
+	source := &linebot.EventSource{Type: linebot.EventSourceTypeUser, UserID: repl.UserID}
+	if repl.GroupID != "" {
+		source.Type = linebot.EventSourceTypeGroup
+		source.GroupID = repl.GroupID
+	}
+	event := &linebot.Event{
+		ReplyToken: fmt.Sprintf("repl-%d", repl.serial),
+		Type:       eventType,
+		Mode:       linebot.EventModeActive,
+		Timestamp:  time.Now(),
+		Source:     source,
+	}
+	if fill != nil {
+		fill(event)
+	}
+	return event
+}
+
+//postback ポストバックを送る
+func (repl *REPL) postback(data string, params *linebot.Params) error {
+	return repl.dispatch(repl.newEvent(linebot.EventTypePostback, func(event *linebot.Event) {
+		event.Postback = &linebot.Postback{Data: data, Params: params}
+	}))
+}
+
+//press 直前の返信のボタンを押す
+func (repl *REPL) press(number string, values []string) error {
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 || n > len(repl.buttons) {
+		return fmt.Errorf("ボタンの番号は1～%dです", len(repl.buttons))
+	}
+	button := repl.buttons[n-1]
+	switch button.Type {
+	case "postback":
+		return repl.postback(button.Data, nil)
+	case "datetimepicker":
+		if len(values) == 0 {
+			return fmt.Errorf("日時選択のボタンです（%s）: :press %d VALUE", button.Mode, n)
+		}
+		params := &linebot.Params{}
+		switch button.Mode {
+		case "date":
+			params.Date = values[0]
+		case "time":
+			params.Time = values[0]
+		default:
+			params.Datetime = values[0]
+		}
+		return repl.postback(button.Data, params)
+	case "message":
+		return repl.Execute(button.Text)
+	case "location":
+		return fmt.Errorf("位置情報は :location LAT LON で送ってください")
+	}
+	return fmt.Errorf("このボタンは押せません: %s", button.Type)
+}
+
+//dispatch CallbackHandlerと同じ振り分けを通して返信を表示する
+func (repl *REPL) dispatch(event *linebot.Event) error {
+	if !HandleEvent(event) {
+		return fmt.Errorf("処理できないイベントです: %s", event.Type)
+	}
+	repl.show()
+	return nil
+}
+
+//show 記録された返信を表示する
+func (repl *REPL) show() {
+	messages := repl.sender.Take()
+	if len(messages) == 0 {
+		fmt.Fprintln(repl.out, "（返信なし）")
+		return
+	}
+	//ボタンのない返信なら前のボタンを押せるままにする
+	previous := repl.buttons
+	repl.buttons = nil
+	defer func() {
+		if len(repl.buttons) == 0 {
+			repl.buttons = previous
+		}
+	}()
+	for _, sent := range messages {
+		if sent.To != "" {
+			fmt.Fprintf(repl.out, "--- push: %s\n", sent.To)
+		} else {
+			fmt.Fprintln(repl.out, "--- reply")
+		}
+		b, err := json.Marshal(sent.Message)
+		if err != nil {
+			fmt.Fprintf(repl.out, "JSONにできません: %v\n", err)
+			continue
+		}
+		var tree interface{}
+		json.Unmarshal(b, &tree)
+		var lines []string
+		repl.walk(tree, &lines)
+		if repl.Format == REPLFormatJSON {
+			var indented bytes.Buffer
+			json.Indent(&indented, b, "", "  ")
+			lines = []string{indented.String()}
+		}
+		fmt.Fprintln(repl.out, strings.Join(lines, "\n"))
+	}
+}
+
+//walk メッセージのJSONをたどって文字とボタンを取り出す
+func (repl *REPL) walk(node interface{}, lines *[]string) {
+	switch node := node.(type) {
+	case []interface{}:
+		for _, child := range node {
+			repl.walk(child, lines)
+		}
+	case map[string]interface{}:
+		kind, _ := node["type"].(string)
+		switch kind {
+		case "text":
+			if text, ok := node["text"].(string); ok {
+				*lines = append(*lines, text)
+			}
+		case "image":
+			if url, ok := node["url"].(string); ok {
+				*lines = append(*lines, "(画像) "+url)
+			}
+		case "separator":
+			*lines = append(*lines, "----")
+		case "postback", "datetimepicker", "message", "location", "uri":
+			*lines = append(*lines, repl.addButton(node))
+			return
+		}
+		for _, key := range []string{"header", "hero", "body", "footer", "contents", "template", "actions", "action", "quickReply", "items"} {
+			if child, ok := node[key]; ok {
+				repl.walk(child, lines)
+			}
+		}
+	}
+}
+
+//addButton ボタンを番号付きで覚えておく
+func (repl *REPL) addButton(action map[string]interface{}) string {
+	button := replButton{}
+	button.Type, _ = action["type"].(string)
+	button.Label, _ = action["label"].(string)
+	button.Data, _ = action["data"].(string)
+	button.Mode, _ = action["mode"].(string)
+	button.Text, _ = action["text"].(string)
+	if button.Type == "uri" {
+		uri, _ := action["uri"].(string)
+		return fmt.Sprintf("[%s] %s", button.Label, uri)
+	}
+	repl.buttons = append(repl.buttons, button)
+	return fmt.Sprintf("[%d] %s", len(repl.buttons), button.Label)
+}
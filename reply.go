@@ -1,141 +1,214 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"strings"
+	"time"
 
 	"github.com/line/line-bot-sdk-go/linebot"
 )
 
-//ReplyMessage 返信用共通関数
-func ReplyMessage(replyToken string, message linebot.SendingMessage) error {
-	//_, err := LineBotAPI.ReplyMessage(replyToken, message.WithQuickReplies(CreateQuickReplyItems())).Do()
-	_, err := LineBotAPI.ReplyMessage(replyToken, message).Do()
+//ReplyMessage 返信用共通関数（ctxは失敗したときのログに使う）
+func ReplyMessage(ctx context.Context, replyToken string, message linebot.SendingMessage) error {
+	//err := Sender.Reply(replyToken, message.WithQuickReplies(CreateQuickReplyItems()))
+	err := Sender.Reply(replyToken, message)
+	MetricLineSends.Inc("reply", metricResult(err))
 	if err != nil {
-		//だめかもしれないけどとりあえずエラーメッセージの再送を試みる
-		ReplyMessage(replyToken, linebot.NewTextMessage(err.Error()))
+		LogError(ctx, "返信に失敗しました", LogFields{"error": err})
+		//だめかもしれないけどとりあえずエラーメッセージの再送を1回だけ試みる
+		retryErr := Sender.Reply(replyToken, linebot.NewTextMessage(err.Error()))
+		MetricLineSends.Inc("reply", metricResult(retryErr))
+		if retryErr != nil {
+			LogError(ctx, "エラーメッセージの返信にも失敗しました", LogFields{"error": retryErr})
+		}
 	}
 	return err
 }
 
+//PushMessage 送信用共通関数
+func PushMessage(userID string, message linebot.SendingMessage) error {
+	err := Sender.Push(userID, message)
+	MetricLineSends.Inc("push", metricResult(err))
+	return err
+}
+
 //ReplyToFollowEvent フォローされたとき
-func ReplyToFollowEvent(event *linebot.Event) {
+func ReplyToFollowEvent(ctx context.Context, event *linebot.Event) {
 	//ユーザー登録
-	UpdateUserConfig(UserUpdateTypeUserAdd, event.Source.UserID, "")
+	UpdateUserConfig(UserUpdateTypeUserAdd, SourceID(event), "")
+	FetchProfileLanguage(SourceID(event))
 	//返信
-	ReplyMessage(event.ReplyToken, linebot.NewTextMessage("フォローありがとうございます！\n駐輪場の名前を入力してみてください"))
+	ReplyMessage(ctx, event.ReplyToken, linebot.NewTextMessage(T(GetUserLang(SourceID(event)), "follow.welcome")))
 }
 
 //ReplyToTextMessage テキストメッセージへの返信
-func ReplyToTextMessage(event *linebot.Event, message *linebot.TextMessage) {
+func ReplyToTextMessage(ctx context.Context, event *linebot.Event, message *linebot.TextMessage) {
 	replyToken := event.ReplyToken
 	text := message.Text
+	lang := GetUserLang(SourceID(event))
+	if IsGroupEvent(event) {
+		//グループ・トークルームでは話しかけられたときだけ反応する
+		called, ok := TrimGroupCall(text)
+		if !ok {
+			return
+		}
+		if called == "" {
+			ReplyMessage(ctx, replyToken, linebot.NewTextMessage(MakeGroupUsageText(T(lang, "group.called"), lang)))
+			return
+		}
+		text = called
+		message = &linebot.TextMessage{ID: message.ID, Text: text}
+	}
 
 	switch 1 {
 	default:
 		if strings.Index(text, "/") == 0 {
 			//スラッシュコマンド
-			CommandHandler(event, message)
+			CommandHandler(ctx, event, message)
+			break
+		}
+		if from, to, ok := ParseTripQuery(text); ok {
+			//「AからB」はルート検索
+			SetLogField(ctx, "command", "trip")
+			ReplyMessage(ctx, replyToken, MakeTripPlanMessageForQuery(ctx, from, to, lang))
 			break
 		}
 		//その他のメッセージは駐輪場検索とする
-		reply := MakeSpotListMessage(text)
-		ReplyMessage(replyToken, reply)
+		SetLogField(ctx, "command", "search")
+		reply := MakeSpotListMessage(ctx, text, lang)
+		ReplyMessage(ctx, replyToken, reply)
 
 		// 検索履歴は駐輪場検索のみ保存する
-		UpdateUserConfig(UserUpdateTypeHistory, event.Source.UserID, text)
+		UpdateUserConfig(UserUpdateTypeHistory, SourceID(event), text)
 	}
 }
 
 //ReplyToStickerMessage スタンプへの返信
-func ReplyToStickerMessage(event *linebot.Event, message *linebot.StickerMessage) {
-	fmt.Printf("StickerID=%s\n", message.StickerID)
+func ReplyToStickerMessage(ctx context.Context, event *linebot.Event, message *linebot.StickerMessage) {
+	LogDebug(ctx, "スタンプを受け取りました", LogFields{"sticker_id": message.StickerID})
+	if IsGroupEvent(event) {
+		//グループ・トークルームでは会話の邪魔になるので返さない
+		return
+	}
 	replyToken := event.ReplyToken
 	//適当なスタンプを返す
 	reply := linebot.NewStickerMessage("11537", "52002734")
-	ReplyMessage(replyToken, reply)
+	ReplyMessage(ctx, replyToken, reply)
 }
 
 //ReplyToLocationMessage 位置情報メッセージへの返信
-func ReplyToLocationMessage(event *linebot.Event, message *linebot.LocationMessage) {
+func ReplyToLocationMessage(ctx context.Context, event *linebot.Event, message *linebot.LocationMessage) {
 	replyToken := event.ReplyToken
-	reply := MakeSpotListMessageForLocation(message.Latitude, message.Longitude)
-	ReplyMessage(replyToken, reply)
+	lang := GetUserLang(SourceID(event))
+	//ルート検索の途中なら出発地・目的地として扱う
+	point := TripPoint{Label: tripLocationLabel(message), Lat: message.Latitude, Lon: message.Longitude}
+	if origin, ok := AdvanceTripSession(SourceID(event), point); ok {
+		if origin == nil {
+			reply := linebot.NewTextMessage(T(lang, "trip.askDest")).WithQuickReplies(CreateQuickReplyItems(lang))
+			ReplyMessage(ctx, replyToken, reply)
+			return
+		}
+		ReplyMessage(ctx, replyToken, MakeTripPlanMessage(ctx, *origin, point, lang))
+		return
+	}
+	if IsGroupEvent(event) {
+		//グループ・トークルームではルート検索の途中でなければ反応しない
+		return
+	}
+	reply := MakeSpotListMessageForLocation(ctx, message.Latitude, message.Longitude, lang)
+	ReplyMessage(ctx, replyToken, reply)
 }
 
 //ReplyToPostbackAnalyze グラフ表示
-func ReplyToPostbackAnalyze(event *linebot.Event, command *PostBackCommand) {
+func ReplyToPostbackAnalyze(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
 	replyToken := event.ReplyToken
-	reply := MakeAnalysisMessage(command.Area, command.Spot, command.Span, event.Source.UserID)
-	ReplyMessage(replyToken, reply)
+	reply := MakeAnalysisMessage(ctx, command.Area, command.Spot, command.Span, SourceID(event))
+	ReplyMessage(ctx, replyToken, reply)
+}
+
+//ReplyToPostbackTrip 2地点でルート検索
+func ReplyToPostbackTrip(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
+	StartTripSession(SourceID(event))
+	lang := GetUserLang(SourceID(event))
+	reply := linebot.NewTextMessage(T(lang, "trip.askOrigin")).WithQuickReplies(CreateQuickReplyItems(lang))
+	ReplyMessage(ctx, event.ReplyToken, reply)
 }
 
 //ReplyToPostbackCommand コマンド一覧の表示
-func ReplyToPostbackCommand(event *linebot.Event, command *PostBackCommand) {
+func ReplyToPostbackCommand(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
 	replyToken := event.ReplyToken
-	reply := MakeCommandListMessage()
-	ReplyMessage(replyToken, reply)
+	reply := MakeCommandListMessage(GetUserLang(SourceID(event)))
+	ReplyMessage(ctx, replyToken, reply)
 }
 
 //ReplyToPostbackHistory 履歴表示
-func ReplyToPostbackHistory(event *linebot.Event, command *PostBackCommand) {
+func ReplyToPostbackHistory(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
 	replyToken := event.ReplyToken
-	reply := MakeHistryListMessage(event.Source.UserID)
-	ReplyMessage(replyToken, reply)
+	reply := MakeHistryListMessage(SourceID(event))
+	ReplyMessage(ctx, replyToken, reply)
 }
 
 //ReplyToPostbackServiceStatus サービス稼働状況の表示
-func ReplyToPostbackServiceStatus(event *linebot.Event, command *PostBackCommand) {
+func ReplyToPostbackServiceStatus(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
 	replyToken := event.ReplyToken
-	reply := MakeServiceStatusMessage()
-	ReplyMessage(replyToken, reply)
+	reply := MakeServiceStatusMessage(ctx, GetUserLang(SourceID(event)))
+	ReplyMessage(ctx, replyToken, reply)
 }
 
 //ReplyToPostbackFavList お気に入り一覧表示
-func ReplyToPostbackFavList(event *linebot.Event, command *PostBackCommand) {
+func ReplyToPostbackFavList(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
 	replyToken := event.ReplyToken
-	reply := MakeFavriteListMessage(event.Source.UserID)
-	ReplyMessage(replyToken, reply)
+	var reply linebot.SendingMessage
+	switch {
+	case command.Target == "":
+		reply = MakeFavriteListMessage(ctx, SourceID(event))
+	case command.Mode == PostBackCommandModeEdit:
+		reply = MakeFavoriteGroupEditMessage(SourceID(event), command.Target)
+	default:
+		reply = MakeFavoriteGroupMessage(ctx, SourceID(event), command.Target)
+	}
+	ReplyMessage(ctx, replyToken, reply)
 }
 
 //ReplyToPostbackRanking ランキング表示
-func ReplyToPostbackRanking(event *linebot.Event, command *PostBackCommand) {
+func ReplyToPostbackRanking(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
 	replyToken := event.ReplyToken
-	reply := MakeRankingMessage(20)
-	ReplyMessage(replyToken, reply)
+	reply := MakeRankingMessage(ctx, 20, GetUserLang(SourceID(event)))
+	ReplyMessage(ctx, replyToken, reply)
 }
 
 //ReplyToPostbackDatePicker 日付検索
-func ReplyToPostbackDatePicker(event *linebot.Event, command *PostBackCommand) {
+func ReplyToPostbackDatePicker(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
 	replyToken := event.ReplyToken
 	day := strings.Replace(event.Postback.Params.Date, "-", "", -1)
-	reply := MakeDateAnalysisMessage(command.Area, command.Spot, event.Source.UserID, day)
-	ReplyMessage(replyToken, reply)
+	reply := MakeDateAnalysisMessage(ctx, command.Area, command.Spot, SourceID(event), day)
+	ReplyMessage(ctx, replyToken, reply)
 }
 
 //ReplyToPostbackConfigOpen 設定画面呼び出し
-func ReplyToPostbackConfigOpen(event *linebot.Event, command *PostBackCommand) {
+func ReplyToPostbackConfigOpen(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
 	replyToken := event.ReplyToken
-	reply := MakeDateConfigWindowMessage(event.Source.UserID)
-	ReplyMessage(replyToken, reply)
+	reply := MakeDateConfigWindowMessage(SourceID(event))
+	ReplyMessage(ctx, replyToken, reply)
 }
 
 //ReplyToPostbackFav お気に入り登録
-func ReplyToPostbackFav(event *linebot.Event, command *PostBackCommand) {
+func ReplyToPostbackFav(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
 	var reply linebot.SendingMessage
-	user := GetUserConfigFromCache(event.Source.UserID)
+	user := GetUserConfigFromCache(SourceID(event))
 	if user == nil {
-		reply = linebot.NewTextMessage("ユーザー設定の読み込みに失敗しました")
-		ReplyMessage(event.ReplyToken, reply)
+		reply = linebot.NewTextMessage(T(DefaultLang, "user.loadFailed"))
+		ReplyMessage(ctx, event.ReplyToken, reply)
 		return
 	}
+	lang := user.Lang()
 	// 検索履歴登録
 	code := command.Area + "-" + command.Spot
-	userID := event.Source.UserID
+	userID := SourceID(event)
 	switch command.Mode {
 	case PostBackCommandModeReg:
 		if len(user.Favorites) >= MaxFavorite {
-			reply = linebot.NewTextMessage("これ以上お気に入りを登録できません")
+			reply = linebot.NewTextMessage(T(lang, "fav.full"))
 			break
 		}
 		//登録
@@ -143,59 +216,155 @@ func ReplyToPostbackFav(event *linebot.Event, command *PostBackCommand) {
 		reply = MakeDateConfigWindowMessage(userID)
 	case PostBackCommandModeUnreg:
 		if len(user.Favorites) < 1 {
-			reply = linebot.NewTextMessage("お気に入りを削除できません")
+			reply = linebot.NewTextMessage(T(lang, "fav.cannotDelete"))
 			break
 		}
 		UpdateUserConfig(UserUpdateTypeFavoriteDelete, userID, code)
 		reply = MakeDateConfigWindowMessage(userID)
 	}
 	//返信
-	ReplyMessage(event.ReplyToken, reply)
+	ReplyMessage(ctx, event.ReplyToken, reply)
 }
 
-//ReplyToPostbackNotifyConfig 通知時刻編集
-func ReplyToPostbackNotifyConfig(event *linebot.Event, command *PostBackCommand) {
+//ReplyToPostbackAlertConfig 台数アラート編集
+func ReplyToPostbackAlertConfig(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
 	var reply linebot.SendingMessage
-	user := GetUserConfigFromCache(event.Source.UserID)
+	user := GetUserConfigFromCache(SourceID(event))
 	if user == nil {
-		reply = linebot.NewTextMessage("ユーザー設定の読み込みに失敗しました")
-		ReplyMessage(event.ReplyToken, reply)
+		reply = linebot.NewTextMessage(T(DefaultLang, "user.loadFailed"))
+		ReplyMessage(ctx, event.ReplyToken, reply)
 		return
 	}
+	lang := user.Lang()
 
-	userID := event.Source.UserID
+	userID := SourceID(event)
 	switch command.Mode {
-	case PostBackCommandModeReg:
-		if len(user.Notifies) >= MaxNotifyTimes {
-			reply = linebot.NewTextMessage("これ以上時刻を登録できません")
+	case PostBackCommandModeReg, "":
+		if len(user.Alerts) >= MaxAlerts {
+			reply = linebot.NewTextMessage(T(lang, "alert.full"))
+			break
+		}
+		if command.Area == "" {
+			//スポットを選んでもらう
+			if len(user.Favorites) < 1 {
+				reply = linebot.NewTextMessage(T(lang, "alert.needFavorite"))
+				break
+			}
+			reply = linebot.NewTextMessage(T(lang, "alert.chooseSpot")).WithQuickReplies(CreateAlertSpotQuickReplyItems(user.Favorites))
+			break
+		}
+		code := command.Area + "-" + command.Spot
+		if command.Value == "" {
+			//条件を選んでもらう
+			reply = linebot.NewTextMessage(T(lang, "alert.chooseCondition", code, GetPlaceNameByCode(code))).WithQuickReplies(CreateAlertConditionQuickReplyItems(command.Area, command.Spot, lang))
+			break
+		}
+		if _, err := ParseSpotAlert(code + ":" + command.Value); err != nil {
+			reply = linebot.NewTextMessage(T(lang, "alert.invalid"))
+			break
+		}
+		if err := UpdateUserConfig(UserUpdateTypeAlert, userID, code+":"+command.Value); err != nil {
+			reply = linebot.NewTextMessage(T(lang, "user.saveFailed"))
 			break
 		}
-		target := event.Postback.Params.Time
-		UpdateUserConfig(UserUpdateTypeNotify, userID, target)
 		reply = MakeDateConfigWindowMessage(userID)
 	case PostBackCommandModeUnreg:
-		if len(user.Notifies) < 1 {
-			reply = linebot.NewTextMessage("時刻を削除できません")
+		if len(user.Alerts) < 1 {
+			reply = linebot.NewTextMessage(T(lang, "alert.cannotDelete"))
+			break
+		}
+		if err := UpdateUserConfig(UserUpdateTypeAlertDelete, userID, command.Value); err != nil {
+			reply = linebot.NewTextMessage(T(lang, "user.saveFailed"))
 			break
 		}
-		target := command.Target
-		UpdateUserConfig(UserUpdateTypeNotifyDelete, userID, target)
 		reply = MakeDateConfigWindowMessage(userID)
 	}
 	//返信
-	ReplyMessage(event.ReplyToken, reply)
+	ReplyMessage(ctx, event.ReplyToken, reply)
+}
+
+//ReplyToPostbackAnnounceConfig スポットのお知らせ設定
+func ReplyToPostbackAnnounceConfig(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
+	userID := SourceID(event)
+	var err error
+	switch command.Mode {
+	case PostBackCommandModeReg:
+		err = UpdateUserConfig(UserUpdateTypeAnnounce, userID, "")
+	case PostBackCommandModeUnreg:
+		err = UpdateUserConfig(UserUpdateTypeAnnounceDelete, userID, "")
+	}
+	var reply linebot.SendingMessage = MakeDateConfigWindowMessage(userID)
+	if err != nil {
+		reply = linebot.NewTextMessage(T(GetUserLang(userID), "user.saveFailed"))
+	}
+	//返信
+	ReplyMessage(ctx, event.ReplyToken, reply)
+}
+
+//ReplyToPostbackLanguageConfig 表示言語の設定
+func ReplyToPostbackLanguageConfig(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
+	userID := SourceID(event)
+	var reply linebot.SendingMessage = MakeDateConfigWindowMessage(userID)
+	if err := UpdateUserConfig(UserUpdateTypeLanguage, userID, command.Value); err != nil {
+		reply = linebot.NewTextMessage(T(GetUserLang(userID), "user.saveFailed"))
+	}
+	//返信
+	ReplyMessage(ctx, event.ReplyToken, reply)
+}
+
+//ReplyToRejectedPostback 署名を確認できなかったボタンへの返信
+func ReplyToRejectedPostback(ctx context.Context, event *linebot.Event, err error) {
+	lang := GetUserLang(SourceID(event))
+	key := "postback.invalid"
+	if err == ErrPostbackExpired {
+		key = "postback.expired"
+	}
+	reply := linebot.NewTextMessage(T(lang, key)).WithQuickReplies(CreateConfigQuickReplyItems(lang))
+	ReplyMessage(ctx, event.ReplyToken, reply)
 }
 
 //SendScheduledNotify 通知を送信する
-func SendScheduledNotify(userID string) {
-	message := MakeFavriteListMessage(userID)
+//targetでお気に入りグループやスポットを指定するとそれを送る
+//利用者が待っているわけではないので、APIはAPIBackgroundBudgetまで待つ
+func SendScheduledNotify(userID string, target NotifyTarget) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), APIBackgroundBudget)
+	defer cancel()
+	ctx = WithLogFields(ctx, LogFields{"job": "notify", "user": HashUserID(userID), "group": target.Group, "spot": target.Spot})
+	//一時停止中なら送らない（休暇が明けていればお知らせしてから送る）
+	if !AllowProactivePush(ctx, userID, time.Now()) {
+		MetricScheduledNotifies.Inc("paused")
+		return
+	}
+	//Slackと連携していればSlackにも送る
+	SendSlackNotify(ctx, userID, target)
+	if IsSlackUserKey(userID) {
+		//LINEのユーザーではない
+		MetricScheduledNotifies.Inc("slack_only")
+		return
+	}
+	view := BuildNotifyTargetView(ctx, userID, target)
+	message := RenderLine(view)
 	switch message.(type) {
 	case *linebot.FlexMessage:
-		//_, err := LineBotAPI.PushMessage(userID, message.WithQuickReplies(CreateQuickReplyItems())).Do()
-		_, err := LineBotAPI.PushMessage(userID, message).Do()
-		fmt.Printf("%v\n", err)
+		//err := PushMessage(userID, message.WithQuickReplies(CreateQuickReplyItems()))
+		if err := PushMessage(userID, message); err != nil {
+			fields := logTimings(ctx, start)
+			fields["error"] = err
+			LogError(ctx, "通知を送れませんでした", fields)
+			MetricScheduledNotifies.Inc("failed")
+			return
+		}
+		LogInfo(ctx, "通知を送りました", logTimings(ctx, start))
+		MetricScheduledNotifies.Inc("sent")
 	case *linebot.TextMessage:
 		//バブルコンテナの作成に失敗したときなので何もしない
+		fields := logTimings(ctx, start)
+		if text, ok := view.(TextView); ok {
+			fields["reason"] = text.Text
+		}
+		LogWarn(ctx, "送る台数一覧を作れなかったため通知しません", fields)
+		MetricScheduledNotifies.Inc("no_data")
 		return
 	}
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+//setupBrokenUserStore 保存に失敗するユーザー設定の保存先にする
+func setupBrokenUserStore(t *testing.T) {
+	store, err := NewFileUserStore(filepath.Join(t.TempDir(), "users.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	UserStorage = store
+}
+
+func TestReplyToConfigPostbackSaveFailed(t *testing.T) {
+	setupFakeBot(t)
+	savedSender := Sender
+	defer func() { Sender = savedSender }()
+	sender := NewRecordingSender()
+	Sender = sender
+	setupBrokenUserStore(t)
+
+	event := &linebot.Event{ReplyToken: "token", Source: &linebot.EventSource{Type: linebot.EventSourceTypeUser, UserID: "U1"}}
+	tests := []struct {
+		name  string
+		reply func()
+	}{
+		{name: "お知らせ", reply: func() {
+			ReplyToPostbackAnnounceConfig(testContext(t), event, &PostBackCommand{Type: PostBackCommandTypeAnnounce, Mode: PostBackCommandModeReg})
+		}},
+		{name: "言語", reply: func() {
+			ReplyToPostbackLanguageConfig(testContext(t), event, &PostBackCommand{Type: PostBackCommandTypeLanguage, Value: string(LangEn)})
+		}},
+	}
+	for _, tt := range tests {
+		tt.reply()
+		sent := sender.Take()
+		if len(sent) != 1 {
+			t.Fatalf("%s: sent = %+v", tt.name, sent)
+		}
+		text, ok := sent[0].Message.(*linebot.TextMessage)
+		if !ok || text.Text != T(LangJa, "user.saveFailed") {
+			t.Errorf("%s: reply = %+v", tt.name, sent[0].Message)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	//NotifyTimeLayout 通知時刻のフォーマット（時刻設定ボタンから送られてくる形式）
+	NotifyTimeLayout = "15:04"
+	//NotifyCheckInterval 通知時刻を確認する間隔
+	NotifyCheckInterval = 20 * time.Second
+	//NotifyCatchUp 再起動などで止まっていたときに遡って送信する時間
+	NotifyCatchUp = 10 * time.Minute
+)
+
+//Clock 現在時刻を返す（テストで時刻を固定できるようにするため）
+type Clock interface {
+	Now() time.Time
+}
+
+//systemClock 実際の時刻
+type systemClock struct{}
+
+//Now 現在時刻
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+//LocationTokyo 日本時間
+var LocationTokyo = loadLocationTokyo()
+
+//loadLocationTokyo タイムゾーン情報がない環境でも動くようにする
+func loadLocationTokyo() *time.Location {
+	if loc, err := time.LoadLocation("Asia/Tokyo"); err == nil {
+		return loc
+	}
+	return time.FixedZone("JST", 9*60*60)
+}
+
+//NotifyScheduler ユーザーが設定した通知時刻にお気に入りを送信する
+type NotifyScheduler struct {
+	//Clock 現在時刻
+	Clock Clock
+	//Location 通知時刻のタイムゾーン
+	Location *time.Location
+	//CatchUp 停止していた間の通知をどこまで遡って送るか
+	CatchUp time.Duration
+	//StatePath 最後に処理した時刻を保存するファイル（空なら保存しない）
+	StatePath string
+	//Users 通知対象のユーザー一覧
+	Users func() []UserConfig
+	//Send 通知を送信する（targetは送る内容）
+	Send func(userID string, target NotifyTarget)
+	//Resume 休暇明けのお知らせを送る
+	Resume func(userID string)
+
+	//last 処理済みの最後の分
+	last time.Time
+}
+
+//NewNotifyScheduler コンストラクタ
+func NewNotifyScheduler(statePath string) *NotifyScheduler {
+	return &NotifyScheduler{
+		Clock:     systemClock{},
+		Location:  LocationTokyo,
+		CatchUp:   NotifyCatchUp,
+		StatePath: statePath,
+		Users:     UserConfigs.List,
+		Send:      SendScheduledNotify,
+		Resume:    SendVacationResume,
+	}
+}
+
+//Run stopが閉じられるまで定期的に通知を確認する
+func (scheduler *NotifyScheduler) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	scheduler.Tick()
+	for {
+		select {
+		case <-ticker.C:
+			scheduler.Tick()
+		case <-stop:
+			return
+		}
+	}
+}
+
+//Tick 前回処理した分の次から現在の分までの通知を送信する
+func (scheduler *NotifyScheduler) Tick() {
+	now := scheduler.Clock.Now().In(scheduler.Location).Truncate(time.Minute)
+	if scheduler.last.IsZero() {
+		scheduler.last = scheduler.loadState(now)
+	}
+	//長く止まっていたときは古すぎる通知を送らない
+	if oldest := now.Add(-scheduler.CatchUp); scheduler.last.Before(oldest) {
+		scheduler.last = oldest
+	}
+	for minute := scheduler.last.Add(time.Minute); !minute.After(now); minute = minute.Add(time.Minute) {
+		scheduler.fire(minute)
+		scheduler.last = minute
+		scheduler.saveState()
+	}
+}
+
+//fire 指定した分に通知するユーザーに送信する
+//同じ内容を送るルールが重なっていても1回だけ送る
+func (scheduler *NotifyScheduler) fire(minute time.Time) {
+	minute = minute.In(scheduler.Location)
+	for _, user := range scheduler.Users() {
+		//休暇明けのお知らせは朝になってから（通知しない時間帯は避ける）
+		if user.VacationOver(minute) && minute.Format(NotifyTimeLayout) >= VacationResumeTime && !user.InQuietHours(minute) {
+			scheduler.Resume(user.LineID)
+		}
+		var sent []NotifyTarget
+		for _, schedule := range user.Schedules {
+			if !schedule.Due(minute) || containsNotifyTarget(sent, schedule.NotifyTarget) {
+				continue
+			}
+			scheduler.Send(user.LineID, schedule.NotifyTarget)
+			sent = append(sent, schedule.NotifyTarget)
+		}
+	}
+}
+
+//containsNotifyTarget 送る内容が含まれているか
+func containsNotifyTarget(targets []NotifyTarget, target NotifyTarget) bool {
+	for _, t := range targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+//loadState 最後に処理した分を読み込む
+//なければ現在の分まで処理済みとする（同じ分のうちに再起動しても二重に送らないように、起動した分の通知は送らない）
+func (scheduler *NotifyScheduler) loadState(now time.Time) time.Time {
+	initial := now
+	if scheduler.StatePath == "" {
+		return initial
+	}
+	data, err := ioutil.ReadFile(scheduler.StatePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			LogError(context.Background(), "通知の状態を読み込めませんでした", LogFields{"error": err, "path": scheduler.StatePath})
+		}
+		return initial
+	}
+	last, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		LogError(context.Background(), "通知の状態が不正です", LogFields{"error": err, "path": scheduler.StatePath})
+		return initial
+	}
+	return last.In(scheduler.Location)
+}
+
+//saveState 最後に処理した分を保存する
+func (scheduler *NotifyScheduler) saveState() {
+	if scheduler.StatePath == "" {
+		return
+	}
+	data := []byte(scheduler.last.Format(time.RFC3339))
+	if err := ioutil.WriteFile(scheduler.StatePath, data, 0644); err != nil {
+		LogError(context.Background(), "通知の状態を保存できませんでした", LogFields{"error": err, "path": scheduler.StatePath})
+	}
+}
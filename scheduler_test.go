@@ -0,0 +1,185 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+//fakeClock テスト用の時計
+type fakeClock struct {
+	now time.Time
+}
+
+//Now 現在時刻
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+//schedulerTest 送った通知を記録するスケジューラー
+//通知の内容（Spot）に通知時刻を入れておき、何時の通知が送られたかを見る
+type schedulerTest struct {
+	clock *fakeClock
+	sent  []string
+	users []UserConfig
+}
+
+//newSchedulerTest 指定した時刻に通知するユーザーを1人用意する
+func newSchedulerTest(now time.Time, times ...string) *schedulerTest {
+	test := &schedulerTest{clock: &fakeClock{now: now}}
+	user := NewUserConfig("U1")
+	for i, hhmm := range times {
+		user.Schedules = append(user.Schedules, NotifySchedule{ID: i + 1, Time: hhmm, NotifyTarget: NotifyTarget{Spot: hhmm}})
+	}
+	test.users = []UserConfig{user}
+	return test
+}
+
+//start スケジューラーを起動する（再起動はもう一度呼ぶ）
+func (test *schedulerTest) start(statePath string) *NotifyScheduler {
+	return &NotifyScheduler{
+		Clock:     test.clock,
+		Location:  LocationTokyo,
+		CatchUp:   10 * time.Minute,
+		StatePath: statePath,
+		Users:     func() []UserConfig { return test.users },
+		Send: func(userID string, target NotifyTarget) {
+			test.sent = append(test.sent, target.Spot)
+		},
+		Resume: func(userID string) {},
+	}
+}
+
+//tokyoAt 2024-06-05（水曜日・祝日ではない）の時刻
+func tokyoAt(hhmmss string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", "2024-06-05 "+hhmmss, LocationTokyo)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestNotifySchedulerRestartWithinCatchUp(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "notify_state")
+	test := newSchedulerTest(tokyoAt("07:59:30"), "08:00", "08:01", "08:02", "08:04")
+
+	scheduler := test.start(statePath)
+	scheduler.Tick()
+	test.clock.now = tokyoAt("08:00:10")
+	scheduler.Tick()
+	if want := []string{"08:00"}; !reflect.DeepEqual(test.sent, want) {
+		t.Fatalf("再起動前 = %v, want %v", test.sent, want)
+	}
+
+	//08:00を送った直後に止まり、08:02の途中で再起動する
+	test.clock.now = tokyoAt("08:02:40")
+	restarted := test.start(statePath)
+	restarted.Tick()
+	test.clock.now = tokyoAt("08:03:05")
+	restarted.Tick()
+	test.clock.now = tokyoAt("08:04:00")
+	restarted.Tick()
+	restarted.Tick()
+
+	want := []string{"08:00", "08:01", "08:02", "08:04"}
+	if !reflect.DeepEqual(test.sent, want) {
+		t.Errorf("sent = %v, want %v", test.sent, want)
+	}
+}
+
+func TestNotifySchedulerRestartWithoutState(t *testing.T) {
+	test := newSchedulerTest(tokyoAt("07:59:50"), "08:00", "08:01")
+
+	scheduler := test.start("")
+	scheduler.Tick()
+	test.clock.now = tokyoAt("08:00:05")
+	scheduler.Tick()
+	if want := []string{"08:00"}; !reflect.DeepEqual(test.sent, want) {
+		t.Fatalf("再起動前 = %v, want %v", test.sent, want)
+	}
+
+	//状態を保存していなくても、同じ分のうちに再起動して二重に送らない
+	test.clock.now = tokyoAt("08:00:40")
+	restarted := test.start("")
+	restarted.Tick()
+	test.clock.now = tokyoAt("08:01:00")
+	restarted.Tick()
+	if want := []string{"08:00", "08:01"}; !reflect.DeepEqual(test.sent, want) {
+		t.Errorf("sent = %v, want %v", test.sent, want)
+	}
+}
+
+func TestNotifySchedulerRestartAfterLongOutage(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "notify_state")
+	if err := ioutil.WriteFile(statePath, []byte(tokyoAt("06:00:00").Format(time.RFC3339)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	test := newSchedulerTest(tokyoAt("08:30:20"), "07:00", "08:15", "08:20", "08:21", "08:30")
+
+	test.start(statePath).Tick()
+
+	//遡るのはCatchUp（10分）の範囲だけ
+	want := []string{"08:21", "08:30"}
+	if !reflect.DeepEqual(test.sent, want) {
+		t.Errorf("sent = %v, want %v", test.sent, want)
+	}
+	data, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); got != tokyoAt("08:30:00").Format(time.RFC3339) {
+		t.Errorf("state = %q", got)
+	}
+}
+
+func TestNotifySchedulerCorruptState(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "notify_state")
+	if err := ioutil.WriteFile(statePath, []byte("not a time\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	test := newSchedulerTest(tokyoAt("08:00:30"), "07:55", "07:59", "08:00", "08:01")
+
+	scheduler := test.start(statePath)
+	scheduler.Tick()
+	test.clock.now = tokyoAt("08:01:05")
+	scheduler.Tick()
+	scheduler.Tick()
+
+	//読めない状態は捨てて次の分から始める（遡らず、二重にも送らない）
+	if want := []string{"08:01"}; !reflect.DeepEqual(test.sent, want) {
+		t.Errorf("sent = %v, want %v", test.sent, want)
+	}
+	data, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); got != tokyoAt("08:01:00").Format(time.RFC3339) {
+		t.Errorf("state = %q（読める形で上書きされていない）", got)
+	}
+}
+
+func TestNotifySchedulerSendsSameTargetOnce(t *testing.T) {
+	test := newSchedulerTest(tokyoAt("07:59:30"))
+	user := &test.users[0]
+	user.Schedules = []NotifySchedule{
+		{ID: 1, Time: "08:00"},
+		{ID: 2, Time: "08:00", Weekdays: []time.Weekday{time.Wednesday}},
+		{ID: 3, Time: "08:00", NotifyTarget: NotifyTarget{Group: "通勤"}},
+	}
+	var targets []NotifyTarget
+	scheduler := test.start("")
+	scheduler.Send = func(userID string, target NotifyTarget) {
+		targets = append(targets, target)
+	}
+	scheduler.Tick()
+	test.clock.now = tokyoAt("08:00:00")
+	scheduler.Tick()
+
+	want := []NotifyTarget{{}, {Group: "通勤"}}
+	if !reflect.DeepEqual(targets, want) {
+		t.Errorf("targets = %v, want %v", targets, want)
+	}
+}
@@ -0,0 +1,191 @@
+//Package search スポット名の辞書を使って駐輪場をあいまい検索する
+package search
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	scoreCodeExact   = 1000
+	scoreCodePrefix  = 900
+	scoreAreaPrefix  = 800
+	scoreNameExact   = 700
+	scoreNamePrefix  = 600
+	scoreNameContain = 500
+	scoreFuzzy       = 300
+	//penaltyTypo 1文字違うごとの減点
+	penaltyTypo = 60
+)
+
+var (
+	//codePattern 「a1-1」「A1-01」のようなスポットコード
+	codePattern = regexp.MustCompile(`^([a-z]+[0-9]*)-([0-9]+)$`)
+	//areaPattern 「a1」のようなエリアコード
+	areaPattern = regexp.MustCompile(`^[a-z]+[0-9]+$`)
+)
+
+//Result 検索結果
+type Result struct {
+	Code, Name string
+	Score      int
+}
+
+//entry 検索用に正規化したスポット
+type entry struct {
+	code, name    string
+	norm, reading string
+}
+
+//Index スポット名の検索インデックス
+type Index struct {
+	entries []entry
+}
+
+//NewIndex コード→スポット名の辞書からインデックスを作成する
+func NewIndex(names map[string]string) *Index {
+	index := &Index{}
+	for code, name := range names {
+		index.entries = append(index.entries, entry{
+			code:    code,
+			name:    name,
+			norm:    Normalize(name),
+			reading: Normalize(ToReading(name)),
+		})
+	}
+	sort.Slice(index.entries, func(i, j int) bool { return index.entries[i].code < index.entries[j].code })
+	return index
+}
+
+//Len 登録されているスポットの数
+func (index *Index) Len() int {
+	return len(index.entries)
+}
+
+//Search 関連度の高い順にスポットを返す（limitが0以下なら全件）
+func (index *Index) Search(query string, limit int) []Result {
+	q := Normalize(query)
+	if q == "" {
+		return nil
+	}
+	//ローマ字ならひらがなでも探す
+	forms := []string{q}
+	if isASCIIWord(q) {
+		if kana := RomajiToHiragana(q); kana != q {
+			forms = append(forms, kana)
+		}
+	}
+
+	var results []Result
+	for _, e := range index.entries {
+		score := scoreCode(q, e.code)
+		for _, form := range forms {
+			for _, target := range []string{e.norm, e.reading} {
+				if s := scoreText(form, target); s > score {
+					score = s
+				}
+			}
+		}
+		if score > 0 {
+			results = append(results, Result{Code: e.code, Name: e.name, Score: score})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+//NormalizeCode 「a1-1」を「A1-01」の形にする（コードでなければokがfalse）
+func NormalizeCode(query string) (code string, ok bool) {
+	match := codePattern.FindStringSubmatch(Normalize(query))
+	if match == nil {
+		return "", false
+	}
+	spot, err := strconv.Atoi(match[2])
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%s-%02d", strings.ToUpper(match[1]), spot), true
+}
+
+//scoreCode コードとしての一致度
+func scoreCode(q, code string) int {
+	lower := strings.ToLower(code)
+	if normalized, ok := NormalizeCode(q); ok && normalized == code {
+		return scoreCodeExact
+	}
+	if codePattern.MatchString(q) && strings.HasPrefix(lower, q) {
+		return scoreCodePrefix
+	}
+	if areaPattern.MatchString(q) && strings.HasPrefix(lower, q+"-") {
+		return scoreAreaPrefix
+	}
+	return 0
+}
+
+//scoreText 名前としての一致度
+func scoreText(q, target string) int {
+	if target == "" {
+		return 0
+	}
+	switch pos := strings.Index(target, q); {
+	case target == q:
+		return scoreNameExact
+	case pos == 0:
+		return scoreNamePrefix
+	case pos > 0:
+		return scoreNameContain
+	}
+	//タイプミスを許容する（3文字以上のときだけ）
+	length := len([]rune(q))
+	if length < 3 {
+		return 0
+	}
+	allowed := length / 4
+	if allowed < 1 {
+		allowed = 1
+	} else if allowed > 2 {
+		allowed = 2
+	}
+	if d := substringDistance([]rune(q), []rune(target)); d <= allowed {
+		return scoreFuzzy - penaltyTypo*d
+	}
+	return 0
+}
+
+//substringDistance targetの任意の部分文字列とqueryとの編集距離の最小値
+func substringDistance(query, target []rune) int {
+	prev := make([]int, len(target)+1)
+	cur := make([]int, len(target)+1)
+	//1行目は0（targetのどこから始めてもよい）
+	for i := 1; i <= len(query); i++ {
+		cur[0] = i
+		for j := 1; j <= len(target); j++ {
+			cost := 1
+			if query[i-1] == target[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j-1]+cost, minInt(prev[j]+1, cur[j-1]+1))
+		}
+		prev, cur = cur, prev
+	}
+	best := prev[0]
+	for _, d := range prev {
+		if d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
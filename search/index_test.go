@@ -0,0 +1,94 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+//testNames スポットコード→スポット名
+var testNames = map[string]string{
+	"A1-01": "千代田区役所",
+	"A1-02": "東京駅八重洲口",
+	"A1-03": "秋葉原駅前",
+	"B2-01": "中央区役所",
+	"B2-02": "銀座四丁目",
+	"B2-03": "銀座",
+	"B2-04": "東銀座",
+	"C3-01": "港区役所",
+	"C3-02": "新橋駅前",
+	"D4-01": "新宿区役所",
+}
+
+//codes 検索結果のコードの並び
+func codes(results []Result) []string {
+	var buff []string
+	for _, result := range results {
+		buff = append(buff, result.Code)
+	}
+	return buff
+}
+
+func TestSearch(t *testing.T) {
+	index := NewIndex(testNames)
+	tests := []struct {
+		name  string
+		query string
+		limit int
+		want  []string
+	}{
+		{"コード", "a1-2", 0, []string{"A1-02"}},
+		{"全角のコード", "Ａ１－０３", 0, []string{"A1-03"}},
+		{"エリア", "a1", 0, []string{"A1-01", "A1-02", "A1-03"}},
+		{"件数の上限", "a1", 2, []string{"A1-01", "A1-02"}},
+		//完全一致→前方一致→部分一致の順（同じ順位はコード順）
+		{"名前", "銀座", 0, []string{"B2-03", "B2-02", "B2-04"}},
+		{"ローマ字", "ginza", 0, []string{"B2-03", "B2-02", "B2-04"}},
+		{"ひらがな", "ぎんざ", 0, []string{"B2-03", "B2-02", "B2-04"}},
+		{"部分一致", "区役所", 0, []string{"A1-01", "B2-01", "C3-01", "D4-01"}},
+		{"読みのローマ字", "shinbashi", 0, []string{"C3-02"}},
+		{"半角カナ", "ｱｷﾊﾊﾞﾗ", 0, []string{"A1-03"}},
+		{"タイプミス", "あきはばあ", 0, []string{"A1-03"}},
+		{"2文字はタイプミスを許さない", "ぎさ", 0, nil},
+		{"見つからない", "池袋", 0, nil},
+		{"空白だけ", "　", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := codes(index.Search(tt.query, tt.limit)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchScoreOrder(t *testing.T) {
+	index := NewIndex(testNames)
+	results := index.Search("新宿区役所", 0)
+	if len(results) == 0 || results[0].Code != "D4-01" || results[0].Score != scoreNameExact {
+		t.Fatalf("results = %v", results)
+	}
+	//タイプミスは1文字ごとに減点する
+	results = index.Search("あきはばあ", 0)
+	if len(results) != 1 || results[0].Score != scoreFuzzy-penaltyTypo {
+		t.Errorf("results = %v", results)
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	tests := []struct {
+		query, want string
+		ok          bool
+	}{
+		{"a1-1", "A1-01", true},
+		{"A1-01", "A1-01", true},
+		{"ｂ２－２", "B2-02", true},
+		{"a1", "", false},
+		{"中央", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := NormalizeCode(tt.query)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("NormalizeCode(%q) = %q, %v, want %q, %v", tt.query, got, ok, tt.want, tt.ok)
+		}
+	}
+}
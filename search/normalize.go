@@ -0,0 +1,103 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	halfKatakana = "ｦｧｨｩｪｫｬｭｮｯｰｱｲｳｴｵｶｷｸｹｺｻｼｽｾｿﾀﾁﾂﾃﾄﾅﾆﾇﾈﾉﾊﾋﾌﾍﾎﾏﾐﾑﾒﾓﾔﾕﾖﾗﾘﾙﾚﾛﾜﾝ"
+	fullKatakana = "ヲァィゥェォャュョッーアイウエオカキクケコサシスセソタチツテトナニヌネノハヒフヘホマミムメモヤユヨラリルレロワン"
+)
+
+//halfToFull 半角カナ→全角カナ
+var halfToFull = func() map[rune]rune {
+	table := make(map[rune]rune)
+	full := []rune(fullKatakana)
+	for i, r := range []rune(halfKatakana) {
+		table[r] = full[i]
+	}
+	return table
+}()
+
+//Normalize 検索用に文字列を正規化する
+//全角英数→半角、半角カナ→全角、カタカナ→ひらがな、英字は小文字にして空白と記号の一部を取り除く
+func Normalize(text string) string {
+	var b strings.Builder
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '　':
+			//全角空白
+			continue
+		case r >= '！' && r <= '～':
+			//全角ASCII
+			r = r - '！' + '!'
+		case r == 'ﾞ' || r == 'ﾟ':
+			//単独の濁点・半濁点
+			continue
+		}
+		if full, ok := halfToFull[r]; ok {
+			r = full
+			//後ろの濁点・半濁点を合成する
+			if i+1 < len(runes) {
+				r = composeMark(r, runes[i+1], &i)
+			}
+		}
+		r = toHiragana(r)
+		if unicode.IsSpace(r) || strings.ContainsRune("・･.,、。()（）「」_", r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+//composeMark 半角の濁点・半濁点をひとつ前の文字と合成する
+func composeMark(r rune, next rune, i *int) rune {
+	switch next {
+	case 'ﾞ':
+		switch {
+		case r == 'ウ':
+			*i++
+			return 'ヴ'
+		case (r >= 'カ' && r <= 'ト') || (r >= 'ハ' && r <= 'ホ'):
+			*i++
+			return r + 1
+		}
+	case 'ﾟ':
+		if r >= 'ハ' && r <= 'ホ' {
+			*i++
+			return r + 2
+		}
+	}
+	return r
+}
+
+//toHiragana カタカナ→ひらがな（「ヶ」「ヵ」は「け」「か」にする）
+func toHiragana(r rune) rune {
+	switch r {
+	case 'ヶ', 'ゖ':
+		return 'け'
+	case 'ヵ', 'ゕ':
+		return 'か'
+	}
+	if r >= 'ァ' && r <= 'ヴ' {
+		return r - 0x60
+	}
+	return r
+}
+
+//isASCIIWord 英字だけの文字列か（ローマ字の判定に使う）
+func isASCIIWord(text string) bool {
+	if text == "" {
+		return false
+	}
+	for _, r := range text {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
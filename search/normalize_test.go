@@ -0,0 +1,81 @@
+package search
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		//全角英数・記号→半角、英字は小文字
+		{"ＡＢＣ１２３", "abc123"},
+		{"Ａ１－０１", "a1-01"},
+		{"Tokyo Station", "tokyostation"},
+		//半角カナ→全角（濁点・半濁点は合成）→ひらがな
+		{"ｼﾝﾊﾞｼ", "しんばし"},
+		{"ﾊﾟﾚｽ", "ぱれす"},
+		{"ｳﾞｨ", "ゔぃ"},
+		{"ﾞｶ", "か"},
+		//カタカナ→ひらがな（長音はそのまま）
+		{"シンバシ", "しんばし"},
+		{"タワー", "たわー"},
+		{"市ヶ谷", "市け谷"},
+		//空白と区切りの記号は取り除く
+		{"東京駅　八重洲口", "東京駅八重洲口"},
+		{"中央区役所（本庁舎）", "中央区役所本庁舎"},
+		{"A・B.C、D", "abcd"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.text); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRomajiToHiragana(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		//ヘボン式と訓令式
+		{"shinbashi", "しんばし"},
+		{"sinbasi", "しんばし"},
+		{"chuuou", "ちゅうおう"},
+		{"tyuuou", "ちゅうおう"},
+		{"toukyou", "とうきょう"},
+		{"fujimi", "ふじみ"},
+		{"huzimi", "ふじみ"},
+		//撥音（n・nn）と促音
+		{"ginza", "ぎんざ"},
+		{"shinnbashi", "しんばし"},
+		{"hon", "ほん"},
+		{"kinshichou", "きんしちょう"},
+		{"roppongi", "ろっぽんぎ"},
+		{"hatchoubori", "はっちょうぼり"},
+		//大文字や変換できない文字
+		{"GINZA", "ぎんざ"},
+		{"xyz", "xyz"},
+	}
+	for _, tt := range tests {
+		if got := RomajiToHiragana(tt.text); got != tt.want {
+			t.Errorf("RomajiToHiragana(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestToReading(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"東京駅八重洲口", "とうきょうえき八重洲ぐち"},
+		//長い語を優先する
+		{"新宿区役所", "しんじゅくくやくしょ"},
+		{"西新宿", "にししんじゅく"},
+		{"銀座四丁目", "ぎんざ四ちょうめ"},
+		{"ABC", "ABC"},
+	}
+	for _, tt := range tests {
+		if got := ToReading(tt.text); got != tt.want {
+			t.Errorf("ToReading(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
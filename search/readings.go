@@ -0,0 +1,85 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+//Readings スポット名によく出てくる漢字の読み
+//スポット名の読みはAPIから取れないため、ここにある語だけひらがな・ローマ字で検索できる
+var Readings = map[string]string{
+	//区
+	"千代田": "ちよだ", "中央": "ちゅうおう", "港": "みなと", "新宿": "しんじゅく", "文京": "ぶんきょう",
+	"江東": "こうとう", "品川": "しながわ", "目黒": "めぐろ", "大田": "おおた", "渋谷": "しぶや",
+	"中野": "なかの", "杉並": "すぎなみ", "練馬": "ねりま", "台東": "たいとう", "墨田": "すみだ",
+	"世田谷": "せたがや", "豊島": "としま", "北区": "きたく", "荒川": "あらかわ", "板橋": "いたばし",
+	"足立": "あだち", "葛飾": "かつしか", "江戸川": "えどがわ",
+	//駅・地名
+	"東京": "とうきょう", "有楽町": "ゆうらくちょう", "銀座": "ぎんざ", "日本橋": "にほんばし",
+	"丸の内": "まるのうち", "大手町": "おおてまち", "秋葉原": "あきはばら", "神田": "かんだ",
+	"六本木": "ろっぽんぎ", "赤坂": "あかさか", "表参道": "おもてさんどう", "青山": "あおやま",
+	"豊洲": "とよす", "晴海": "はるみ", "月島": "つきしま", "勝どき": "かちどき", "築地": "つきじ",
+	"浜松町": "はままつちょう", "田町": "たまち", "五反田": "ごたんだ", "大崎": "おおさき",
+	"池袋": "いけぶくろ", "上野": "うえの", "浅草": "あさくさ", "両国": "りょうごく",
+	"錦糸町": "きんしちょう", "市ヶ谷": "いちがや", "市ケ谷": "いちがや", "飯田橋": "いいだばし",
+	"四ツ谷": "よつや", "四谷": "よつや", "九段下": "くだんした", "神保町": "じんぼうちょう",
+	"水道橋": "すいどうばし", "後楽園": "こうらくえん", "茗荷谷": "みょうがだに", "御茶ノ水": "おちゃのみず",
+	"新橋": "しんばし", "汐留": "しおどめ", "虎ノ門": "とらのもん", "霞が関": "かすみがせき",
+	"永田町": "ながたちょう", "麹町": "こうじまち", "半蔵門": "はんぞうもん", "番町": "ばんちょう",
+	"八丁堀": "はっちょうぼり", "人形町": "にんぎょうちょう", "茅場町": "かやばちょう", "京橋": "きょうばし",
+	"門前仲町": "もんぜんなかちょう", "清澄": "きよすみ", "白河": "しらかわ", "木場": "きば",
+	"東陽町": "とうようちょう", "有明": "ありあけ", "お台場": "おだいば", "台場": "だいば",
+	"芝浦": "しばうら", "芝": "しば", "高輪": "たかなわ", "白金": "しろかね", "麻布": "あざぶ",
+	"広尾": "ひろお", "恵比寿": "えびす", "代々木": "よよぎ", "原宿": "はらじゅく", "西新宿": "にししんじゅく",
+	"大久保": "おおくぼ", "高田馬場": "たかだのばば", "早稲田": "わせだ", "本郷": "ほんごう",
+	"湯島": "ゆしま", "根津": "ねづ", "千駄木": "せんだぎ", "大塚": "おおつか", "目白": "めじろ",
+	"天王洲": "てんのうず", "大井町": "おおいまち", "蒲田": "かまた", "羽田": "はねだ",
+	"押上": "おしあげ", "亀戸": "かめいど", "住吉": "すみよし", "森下": "もりした",
+	//施設
+	"区役所": "くやくしょ", "役所": "やくしょ", "公園": "こうえん", "病院": "びょういん", "図書館": "としょかん",
+	"小学校": "しょうがっこう", "中学校": "ちゅうがっこう", "高校": "こうこう", "大学": "だいがく",
+	"会館": "かいかん", "出張所": "しゅっちょうじょ", "区民館": "くみんかん", "広場": "ひろば",
+	"郵便局": "ゆうびんきょく", "交番": "こうばん", "体育館": "たいいくかん", "児童館": "じどうかん",
+	"駐輪場": "ちゅうりんじょう", "ビル": "びる", "タワー": "たわー", "ホテル": "ほてる",
+	//その他
+	"駅": "えき", "口": "ぐち", "前": "まえ", "東": "ひがし", "西": "にし", "南": "みなみ", "北": "きた",
+	"丁目": "ちょうめ", "橋": "はし", "町": "ちょう", "通り": "どおり", "新": "しん", "本": "ほん",
+}
+
+//readingKeys 長い語から順に置き換えるための並び
+var readingKeys = func() []string {
+	keys := make([]string, 0, len(Readings))
+	for key := range Readings {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len([]rune(keys[i])) != len([]rune(keys[j])) {
+			return len([]rune(keys[i])) > len([]rune(keys[j]))
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}()
+
+//ToReading 辞書にある漢字の語を読みに置き換える（最長一致）
+func ToReading(text string) string {
+	var b strings.Builder
+	for len(text) > 0 {
+		matched := false
+		for _, key := range readingKeys {
+			if strings.HasPrefix(text, key) {
+				b.WriteString(Readings[key])
+				text = text[len(key):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			_, size := utf8.DecodeRuneInString(text)
+			b.WriteString(text[:size])
+			text = text[size:]
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+//MessageSender メッセージの送信先
+//本番はLINE、REPLでは送ったメッセージを記録するだけのものに差し替える
+type MessageSender interface {
+	//Reply リプライトークンを使って返信する
+	Reply(replyToken string, message linebot.SendingMessage) error
+	//Push ユーザーを指定して送信する
+	Push(to string, message linebot.SendingMessage) error
+}
+
+//Sender メッセージの送信先
+var Sender MessageSender
+
+//LineSender LINEのMessaging APIで送信する
+type LineSender struct {
+	Client *linebot.Client
+}
+
+//Reply 返信する
+func (sender LineSender) Reply(replyToken string, message linebot.SendingMessage) error {
+	_, err := sender.Client.ReplyMessage(replyToken, message).Do()
+	return err
+}
+
+//Push 送信する
+func (sender LineSender) Push(to string, message linebot.SendingMessage) error {
+	_, err := sender.Client.PushMessage(to, message).Do()
+	return err
+}
+
+//SentMessage 記録したメッセージ
+type SentMessage struct {
+	//ReplyToken 返信のときのリプライトークン
+	ReplyToken string
+	//To プッシュのときの送信先
+	To      string
+	Message linebot.SendingMessage
+}
+
+//RecordingSender 送信せずに記録だけする
+type RecordingSender struct {
+	mu       sync.Mutex
+	messages []SentMessage
+}
+
+//NewRecordingSender コンストラクタ
+func NewRecordingSender() *RecordingSender {
+	return &RecordingSender{}
+}
+
+//Reply 返信を記録する
+func (sender *RecordingSender) Reply(replyToken string, message linebot.SendingMessage) error {
+	sender.record(SentMessage{ReplyToken: replyToken, Message: message})
+	return nil
+}
+
+//Push プッシュを記録する
+func (sender *RecordingSender) Push(to string, message linebot.SendingMessage) error {
+	sender.record(SentMessage{To: to, Message: message})
+	return nil
+}
+
+//record 記録する
+func (sender *RecordingSender) record(message SentMessage) {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+	sender.messages = append(sender.messages, message)
+}
+
+//Take 記録したメッセージを取り出して空にする
+func (sender *RecordingSender) Take() []SentMessage {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+	messages := sender.messages
+	sender.messages = nil
+	return messages
+}
@@ -1,17 +1,19 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	bikeshareapi "github.com/8245snake/bikeshare-client"
+	"github.com/8245snake/bikeshare-line/search"
 	"github.com/line/line-bot-sdk-go/linebot"
 )
 
@@ -22,8 +24,10 @@ const (
 	MaxHistory = 10
 	//MaxFavorite お気に入りの登録件数
 	MaxFavorite = 5
-	//MaxNotifyTimes 通知時刻の設定可能件数
-	MaxNotifyTimes = 2
+	//MaxNotifySchedules 通知の設定可能件数
+	MaxNotifySchedules = 5
+	//ForecastWeeks 台数予測で比較する過去の週数
+	ForecastWeeks = 4
 )
 
 var (
@@ -38,9 +42,11 @@ var (
 	//LineBotAPI LINEのAPIクライアント
 	LineBotAPI *linebot.Client
 	//BikeshareAPI BikeshareのAPIクライアント
-	BikeshareAPI bikeshareapi.ApiClient
+	BikeshareAPI CachedAPIClient
 	//SpotNamesDictionary スポット名の辞書
 	SpotNamesDictionary = make(map[string]string)
+	//SpotSearchIndex スポット名の検索インデックス
+	SpotSearchIndex = search.NewIndex(nil)
 )
 
 //getAccessToken アクセストークン取得
@@ -96,74 +102,121 @@ func CallbackHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	for _, event := range events {
-		switch event.Type {
-		case linebot.EventTypeMessage:
-			switch message := event.Message.(type) {
-			case *linebot.TextMessage:
-				//普通のテキストメッセージ
-				ReplyToTextMessage(event, message)
-			case *linebot.StickerMessage:
-				//スタンプ
-				ReplyToStickerMessage(event, message)
-			case *linebot.LocationMessage:
-				//位置情報
-				ReplyToLocationMessage(event, message)
-			}
-		case linebot.EventTypeFollow:
-			ReplyToFollowEvent(event)
-		case linebot.EventTypeUnfollow:
-			fmt.Printf("%v\n", event)
-		case linebot.EventTypePostback:
-			// Postbackのコマンド振り分け
-			switch command := ParsePostbackData(event.Postback.Data); command.Type {
-			case PostBackCommandTypeAnalyze:
-				ReplyToPostbackAnalyze(event, &command)
-			case PostBackCommandTypeHistory:
-				ReplyToPostbackHistory(event, &command)
-			case PostBackCommandTypeCommands:
-				ReplyToPostbackCommand(event, &command)
-			case PostBackCommandTypeFavoriteList:
-				ReplyToPostbackFavList(event, &command)
-			case PostBackCommandTypeFavorite:
-				ReplyToPostbackFav(event, &command)
-			case PostBackCommandTypeDatePicker:
-				ReplyToPostbackDatePicker(event, &command)
-			case PostBackCommandTypeConfigOpen:
-				ReplyToPostbackConfigOpen(event, &command)
-			case PostBackCommandTypeNotify:
-				ReplyToPostbackNotifyConfig(event, &command)
-			case PostBackCommandTypeStatus:
-				ReplyToPostbackServiceStatus(event, &command)
-			case PostBackCommandTypeRanking:
-				ReplyToPostbackRanking(event, &command)
-			}
-
-		case linebot.EventTypeJoin:
-		case linebot.EventTypeLeave:
-		case linebot.EventTypeMemberJoined:
-		case linebot.EventTypeMemberLeft:
-		case linebot.EventTypeBeacon:
-		case linebot.EventTypeAccountLink:
-		case linebot.EventTypeThings:
-		default:
+		if !HandleEvent(event) {
 			w.WriteHeader(400)
 		}
 	}
 }
 
+//HandleEvent イベントの種類ごとに振り分ける
+//Webhook以外（REPLなど）からも同じ処理を通す
+//知らない種類のイベントならfalseを返す
+//処理にはWebhookBudgetの期限があり、ReplyTo〜に渡すctxでAPIの呼び出しを打ち切る
+func HandleEvent(event *linebot.Event) bool {
+	ctx, done := startEvent(event)
+	defer done()
+	MetricWebhookEvents.Inc(string(event.Type))
+	switch event.Type {
+	case linebot.EventTypeMessage:
+		FetchProfileLanguage(SourceID(event))
+		switch message := event.Message.(type) {
+		case *linebot.TextMessage:
+			//普通のテキストメッセージ
+			ReplyToTextMessage(ctx, event, message)
+		case *linebot.StickerMessage:
+			//スタンプ
+			ReplyToStickerMessage(ctx, event, message)
+		case *linebot.LocationMessage:
+			//位置情報
+			ReplyToLocationMessage(ctx, event, message)
+		}
+	case linebot.EventTypeFollow:
+		ReplyToFollowEvent(ctx, event)
+	case linebot.EventTypeUnfollow:
+		LogInfo(ctx, "ブロックされました", nil)
+	case linebot.EventTypePostback:
+		FetchProfileLanguage(SourceID(event))
+		// 署名を確認してから振り分ける
+		command, err := VerifyPostbackData(event.Postback.Data, time.Now())
+		if err != nil {
+			LogWarn(ctx, "ポストバックを拒否しました", LogFields{"error": err, "data": event.Postback.Data})
+			if err == ErrPostbackExpired {
+				MetricPostbacksRejected.Inc("expired")
+			} else {
+				MetricPostbacksRejected.Inc("invalid")
+			}
+			ReplyToRejectedPostback(ctx, event, err)
+			break
+		}
+		MetricPostbacks.Inc(string(command.Type))
+		SetLogField(ctx, "command", string(command.Type))
+		// Postbackのコマンド振り分け
+		switch command.Type {
+		case PostBackCommandTypeAnalyze:
+			ReplyToPostbackAnalyze(ctx, event, &command)
+		case PostBackCommandTypeHistory:
+			ReplyToPostbackHistory(ctx, event, &command)
+		case PostBackCommandTypeCommands:
+			ReplyToPostbackCommand(ctx, event, &command)
+		case PostBackCommandTypeFavoriteList:
+			ReplyToPostbackFavList(ctx, event, &command)
+		case PostBackCommandTypeFavorite:
+			ReplyToPostbackFav(ctx, event, &command)
+		case PostBackCommandTypeFavoriteGroup:
+			ReplyToPostbackFavoriteGroup(ctx, event, &command)
+		case PostBackCommandTypeDatePicker:
+			ReplyToPostbackDatePicker(ctx, event, &command)
+		case PostBackCommandTypeConfigOpen:
+			ReplyToPostbackConfigOpen(ctx, event, &command)
+		case PostBackCommandTypeNotify:
+			ReplyToPostbackNotifyConfig(ctx, event, &command)
+		case PostBackCommandTypeStatus:
+			ReplyToPostbackServiceStatus(ctx, event, &command)
+		case PostBackCommandTypeRanking:
+			ReplyToPostbackRanking(ctx, event, &command)
+		case PostBackCommandTypeAlert:
+			ReplyToPostbackAlertConfig(ctx, event, &command)
+		case PostBackCommandTypeAnnounce:
+			ReplyToPostbackAnnounceConfig(ctx, event, &command)
+		case PostBackCommandTypeTrip:
+			ReplyToPostbackTrip(ctx, event, &command)
+		case PostBackCommandTypeLanguage:
+			ReplyToPostbackLanguageConfig(ctx, event, &command)
+		case PostBackCommandTypeSlack:
+			ReplyToPostbackSlack(ctx, event, &command)
+		case PostBackCommandTypePause:
+			ReplyToPostbackPause(ctx, event, &command)
+		}
+
+	case linebot.EventTypeJoin:
+		ReplyToJoinEvent(ctx, event)
+	case linebot.EventTypeLeave:
+		ReplyToLeaveEvent(ctx, event)
+	case linebot.EventTypeMemberJoined:
+		ReplyToMemberJoinedEvent(ctx, event)
+	case linebot.EventTypeMemberLeft:
+	case linebot.EventTypeBeacon:
+	case linebot.EventTypeAccountLink:
+	case linebot.EventTypeThings:
+	default:
+		return false
+	}
+	return true
+}
+
 //NotifyHandler 通知指示
 func NotifyHandler(w http.ResponseWriter, req *http.Request) {
 	//パース
 	req.ParseForm()
 	params := req.Form
 	userID := params.Get("user")
-	SendScheduledNotify(userID)
+	SendScheduledNotify(userID, NotifyTarget{Group: params.Get("group"), Spot: params.Get("spot")})
 }
 
 //GetPlaceNameByCode コードから名前を返す
 //ない場合は空文字を返す
 func GetPlaceNameByCode(code string) (name string) {
-	if val, ok := SpotNamesDictionary[code]; ok {
+	if val, ok := GetSpotNames()[code]; ok {
 		name = val
 	}
 	return name
@@ -179,48 +232,138 @@ func SplitAreaSpot(code string) (area string, spot string) {
 	return
 }
 
-func init() {
+//LoadConfig 環境変数から設定を読み込む（通信はしない）
+func LoadConfig() {
+	ClientID = os.Getenv("LINE_CLIENT_ID")
+	ClientSecret = os.Getenv("LINE_CLIENT_SECRET")
+	GraphBaseURL = os.Getenv("GRAPH_BASE_URL")
+	//ポストバックの署名鍵（未設定ならチャネルシークレットを使う）
+	if key := os.Getenv("POSTBACK_SECRET"); key != "" {
+		PostbackSigningKey = []byte(key)
+	} else {
+		PostbackSigningKey = []byte(ClientSecret)
+	}
+	//グラフのURLの署名鍵（未設定ならチャネルシークレットを使う）
+	if key := os.Getenv("GRAPH_SECRET"); key != "" {
+		GraphSigningKey = []byte(key)
+	} else {
+		GraphSigningKey = []byte(ClientSecret)
+	}
+	SetBotName(os.Getenv("BOT_NAME"))
+	//Slack連携
+	SlackSigningSecret = os.Getenv("SLACK_SIGNING_SECRET")
+	SlackAPI = NewSlackClient(os.Getenv("SLACK_API_URL"), os.Getenv("SLACK_BOT_TOKEN"))
+	MetricsToken = os.Getenv("METRICS_TOKEN")
+}
+
+//SetupLineBot アクセストークンを取得してLINEのAPIクライアントを作成する
+func SetupLineBot() error {
 	//SSL証明書エラーを無視する
 	Client.Transport = &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
-	ClientID = os.Getenv("LINE_CLIENT_ID")
-	ClientSecret = os.Getenv("LINE_CLIENT_SECRET")
 	AccessToken = getAccessToken()
-	if bot, err := linebot.New(ClientSecret, AccessToken, linebot.WithHTTPClient(&Client)); err == nil {
-		LineBotAPI = bot
-	} else {
+	bot, err := linebot.New(ClientSecret, AccessToken, linebot.WithHTTPClient(&Client))
+	if err != nil {
+		return err
+	}
+	LineBotAPI = bot
+	Sender = LineSender{Client: bot}
+	return nil
+}
+
+//SetupBikeshareAPI BikeshareのAPIクライアントを作成する
+//endpointが空なら本番のAPIを使う
+func SetupBikeshareAPI(endpoint string) {
+	client := bikeshareapi.NewApiClient()
+	//タイムアウト・やり直し・遮断はトランスポートで行う
+	client.Client = &http.Client{Transport: NewAPITransport()}
+	client.SetCertKey(os.Getenv("API_CERT"))
+	if endpoint != "" {
+		client.SetEndpoint(endpoint)
+	}
+	//台数の取得結果は使い回す
+	BikeshareAPI = NewCachedAPIClient(client)
+}
+
+//SetupUserStore ユーザー設定の保存先を開いてキャッシュに読み込む
+func SetupUserStore(storeType UserStoreType, path string) error {
+	store, err := NewUserStore(storeType, path)
+	if err != nil {
+		return err
+	}
+	UserStorage = store
+	return CacheUsrConfigs()
+}
+
+//LoadSpotNames スポット名の辞書を初期化
+func LoadSpotNames() error {
+	ctx, cancel := context.WithTimeout(context.Background(), APIBackgroundBudget)
+	defer cancel()
+	places, err := BikeshareAPI.GetAllSpotNamesContext(ctx)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string)
+	for _, place := range places {
+		names[place.Area+"-"+place.Spot] = place.Name
+	}
+	SetSpotNames(names)
+	return nil
+}
+
+//setupServer Webhookを受けるための初期化（失敗したら起動しない）
+func setupServer() {
+	LoadConfig()
+	if err := SetupLineBot(); err != nil {
 		panic(err)
 	}
-	BikeshareAPI = bikeshareapi.NewApiClient()
-	BikeshareAPI.SetCertKey(os.Getenv("API_CERT"))
+	endpoint := ""
 	if os.Getenv("MODE") == "DEBUG" {
 		//デバッグ用
-		BikeshareAPI.SetEndpoint("http://localhost:5001/")
+		endpoint = "http://localhost:5001/"
 	}
-
-	//ユーザー設定を取得
-	if err := CacheUsrConfigs(); err != nil {
+	SetupBikeshareAPI(endpoint)
+	if err := SetupUserStore(UserStoreType(os.Getenv("USER_STORE")), os.Getenv("USER_STORE_PATH")); err != nil {
 		panic(err)
 	}
-	//スポット名の辞書を初期化
-	places, err := BikeshareAPI.GetAllSpotNames()
-	if err != nil {
+	if err := LoadSpotNames(); err != nil {
 		panic(err)
 	}
-	for _, place := range places {
-		SpotNamesDictionary[place.Area+"-"+place.Spot] = place.Name
-	}
 }
 
 func main() {
+	SetupLogger()
+	if len(os.Args) > 1 && os.Args[1] == "repl" {
+		//開発用の対話モード（ログは返信の表示に混ざらないように標準エラー出力に出す）
+		AppLogger.Out = os.Stderr
+		os.Exit(RunREPL(os.Args[2:], os.Stdin, os.Stdout))
+	}
+	setupServer()
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "5050"
 	}
 
+	//通知時刻の管理をボット自身で行う
+	if os.Getenv("NOTIFY_SCHEDULER") == "on" {
+		scheduler := NewNotifyScheduler(os.Getenv("NOTIFY_STATE_PATH"))
+		go scheduler.Run(NotifyCheckInterval, nil)
+	}
+	//台数アラートの監視
+	go NewAlertPoller().Run(AlertCheckInterval, nil)
+	//スポット一覧の更新
+	go NewSpotMasterRefresher().Run(SpotMasterRefreshInterval, nil)
+
 	http.HandleFunc("/callback", CallbackHandler)
 	http.HandleFunc("/notify", NotifyHandler)
+	http.HandleFunc("/graph", GraphHandler)
+	http.HandleFunc("/metrics", MetricsHandler)
+	if SlackSigningSecret != "" {
+		http.HandleFunc("/slack/command", SlackCommandHandler)
+		http.HandleFunc("/slack/actions", SlackActionHandler)
+	}
 
 	if err := http.ListenAndServe(":"+port, nil); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,574 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+const (
+	//SlackDefaultAPIURL SlackのWeb APIのURL
+	SlackDefaultAPIURL = "https://slack.com/api/"
+	//SlackRequestMaxAge これより古いタイムスタンプのリクエストは再送攻撃とみなす
+	SlackRequestMaxAge = 5 * time.Minute
+	//SlackLinkCodeTimeout 連携コードの有効期限
+	SlackLinkCodeTimeout = 10 * time.Minute
+	//SlackLinkCodeLength 連携コードの文字数
+	SlackLinkCodeLength = 8
+	//SlackLinkMaxFailures 1人のSlackユーザーが連携コードを間違えられる回数（SlackLinkCodeTimeoutごと）
+	SlackLinkMaxFailures = 5
+	//SlackLinkMaxFailuresTotal 全体で連携コードを間違えられる回数（SlackLinkCodeTimeoutごと）
+	SlackLinkMaxFailuresTotal = 50
+	//slackLinkCodeAlphabet 連携コードに使う文字（見間違えやすい0・O・1・I・Lは使わない）
+	slackLinkCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+	//SlackUserKeyPrefix LINEと連携していないSlackユーザーの設定の保存キー
+	SlackUserKeyPrefix = "slack:"
+	//SlackTimeout SlackのAPIの応答を待つ時間
+	SlackTimeout = 10 * time.Second
+)
+
+var (
+	//SlackSigningSecret Slackアプリの署名シークレット（空ならSlack連携は無効）
+	SlackSigningSecret string
+	//SlackAPI SlackのAPIクライアント
+	SlackAPI SlackClient
+)
+
+//SlackClient SlackのAPIクライアント
+type SlackClient struct {
+	//APIURL Web APIのURL（テスト用のサーバーに向けられるようにしておく）
+	APIURL string
+	//Token ボットトークン（空ならメッセージを送信しない）
+	Token string
+	//HTTPClient 送信に使うクライアント
+	HTTPClient *http.Client
+}
+
+//NewSlackClient コンストラクタ
+//ボットトークンを送るので、証明書を確認しないLINE用のClientとは別のクライアントを使う
+func NewSlackClient(apiURL, token string) SlackClient {
+	if apiURL == "" {
+		apiURL = SlackDefaultAPIURL
+	}
+	if !strings.HasSuffix(apiURL, "/") {
+		apiURL += "/"
+	}
+	return SlackClient{APIURL: apiURL, Token: token, HTTPClient: &http.Client{Timeout: SlackTimeout}}
+}
+
+//PostMessage chat.postMessageでメッセージを送信する
+func (client *SlackClient) PostMessage(message SlackMessage) error {
+	if client.Token == "" {
+		return fmt.Errorf("Slackのボットトークンが設定されていません")
+	}
+	body, err := client.postJSON(client.APIURL+"chat.postMessage", message, client.Token)
+	if err != nil {
+		return err
+	}
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return err
+	}
+	if !result.OK {
+		return fmt.Errorf("chat.postMessageに失敗しました: %s", result.Error)
+	}
+	return nil
+}
+
+//Respond ボタン操作への返信をresponse_urlに送信する
+//response_urlはそれ自体が認証になっているので、ボットトークンは付けない
+func (client *SlackClient) Respond(responseURL string, message SlackMessage) error {
+	_, err := client.postJSON(responseURL, message, "")
+	return err
+}
+
+//postJSON JSONをPOSTしてレスポンスの本文を返す
+//tokenが空でなければAuthorizationヘッダーに付ける（Web APIのURL以外には渡さないこと）
+func (client *SlackClient) postJSON(target string, payload interface{}, token string) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", target, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	httpClient := client.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Slackへの送信に失敗しました(%d): %s", resp.StatusCode, string(body))
+	}
+	return body, nil
+}
+
+//VerifySlackRequest Slackからのリクエストの署名を確認する
+func VerifySlackRequest(header http.Header, body []byte, now time.Time) bool {
+	if SlackSigningSecret == "" {
+		return false
+	}
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > SlackRequestMaxAge || age < -SlackRequestMaxAge {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(SlackSigningSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature")))
+}
+
+//readSlackRequest 本文を読んで署名を確認する
+func readSlackRequest(w http.ResponseWriter, req *http.Request) (url.Values, bool) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(400)
+		return nil, false
+	}
+	if !VerifySlackRequest(req.Header, body, time.Now()) {
+		w.WriteHeader(401)
+		return nil, false
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		w.WriteHeader(400)
+		return nil, false
+	}
+	return values, true
+}
+
+//writeSlackMessage メッセージをJSONで返す
+func writeSlackMessage(w http.ResponseWriter, message SlackMessage) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(message); err != nil {
+		LogError(context.Background(), "Slackへの応答を書き込めませんでした", LogFields{"error": err})
+	}
+}
+
+//SlackCommandHandler スラッシュコマンド（/bikeshare など）
+func SlackCommandHandler(w http.ResponseWriter, req *http.Request) {
+	values, ok := readSlackRequest(w, req)
+	if !ok {
+		return
+	}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(req.Context(), SlackCommandBudget)
+	defer cancel()
+	ctx = WithLogFields(ctx, LogFields{"channel": "slack", "slack_user": HashUserID(values.Get("user_id")), "command": slackCommandName(values.Get("text"))})
+	message := HandleSlackCommand(ctx, values.Get("user_id"), values.Get("command"), values.Get("text"))
+	writeSlackMessage(w, message)
+	LogInfo(ctx, "Slackのコマンドを処理しました", logTimings(ctx, start))
+}
+
+//slackCommandName ログに出すコマンドの種類（スポット検索ならsearch）
+func slackCommandName(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return "help"
+	}
+	switch name := strings.ToLower(fields[0]); name {
+	case "help", "fav", "favorite", "favorites", "ranking", "status", "link":
+		return name
+	}
+	return "search"
+}
+
+//slackActionPayload ボタン操作のペイロード
+type slackActionPayload struct {
+	Type        string `json:"type"`
+	ResponseURL string `json:"response_url"`
+	User        struct {
+		ID string `json:"id"`
+	} `json:"user"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+//SlackActionHandler ボタン操作（Interactivity）
+func SlackActionHandler(w http.ResponseWriter, req *http.Request) {
+	values, ok := readSlackRequest(w, req)
+	if !ok {
+		return
+	}
+	var payload slackActionPayload
+	if err := json.Unmarshal([]byte(values.Get("payload")), &payload); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	if payload.Type != "block_actions" {
+		return
+	}
+	//3秒以内に応答しないといけないので、返信はresponse_urlに送る
+	go func() {
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), WebhookBudget)
+		defer cancel()
+		ctx = WithLogFields(ctx, LogFields{"channel": "slack", "slack_user": HashUserID(payload.User.ID)})
+		defer func() { LogInfo(ctx, "Slackのボタン操作を処理しました", logTimings(ctx, start)) }()
+		for _, action := range payload.Actions {
+			message := HandleSlackAction(ctx, payload.User.ID, action.Value)
+			if err := SlackAPI.Respond(payload.ResponseURL, message); err != nil {
+				LogError(ctx, "Slackに返信できませんでした", LogFields{"error": err})
+			}
+		}
+	}()
+}
+
+//SlackUserKey Slackユーザーの設定の保存キー
+//LINEと連携していればLINEのユーザーID、していなければSlack専用の設定を作る
+func SlackUserKey(slackID string) string {
+	if user, ok := UserConfigs.FindBySlackID(slackID); ok {
+		return user.LineID
+	}
+	key := SlackUserKeyPrefix + slackID
+	if GetUserConfigFromCache(key) == nil {
+		//保存できなかったことはUpdateUserConfigFuncがログに出している
+		UpdateUserConfigFunc(key, func(user *UserConfig) {
+			user.SlackID = slackID
+		})
+	}
+	return key
+}
+
+//IsSlackUserKey LINEと連携していないSlackユーザーの保存キーか
+func IsSlackUserKey(userID string) bool {
+	return strings.HasPrefix(userID, SlackUserKeyPrefix)
+}
+
+//HandleSlackCommand スラッシュコマンドの処理
+//「help」「fav」「ranking」「status」「link コード」以外はスポット検索とする
+func HandleSlackCommand(ctx context.Context, slackID, command, text string) SlackMessage {
+	userID := SlackUserKey(slackID)
+	lang := GetUserLang(userID)
+	text = strings.TrimSpace(text)
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return SlackMessage{ResponseType: SlackResponseEphemeral, Text: T(lang, "slack.helpTitle"), Blocks: CreateSlackHelpBlocks(command, lang)}
+	}
+	switch strings.ToLower(fields[0]) {
+	case "help":
+		return SlackMessage{ResponseType: SlackResponseEphemeral, Text: T(lang, "slack.helpTitle"), Blocks: CreateSlackHelpBlocks(command, lang)}
+	case "fav", "favorite", "favorites":
+		if len(fields) > 1 {
+			//お気に入りグループ
+			return RenderSlack(BuildFavoriteGroupView(ctx, userID, fields[1]))
+		}
+		return MakeSlackFavoriteListMessage(ctx, userID)
+	case "ranking":
+		return MakeSlackRankingMessage(ctx, 20, lang)
+	case "status":
+		return MakeSlackServiceStatusMessage(ctx, lang)
+	case "link":
+		if len(fields) < 2 {
+			return NewSlackTextMessage(T(lang, "slack.linkInvalid"))
+		}
+		return LinkSlackUser(slackID, fields[1])
+	}
+	message := MakeSlackSpotListMessage(ctx, text, lang)
+	//検索履歴はスポット検索のみ保存する
+	UpdateUserConfig(UserUpdateTypeHistory, userID, text)
+	return message
+}
+
+//HandleSlackAction ボタン操作の処理（ボタンの値はLINEと同じ署名付きのポストバック文字列）
+func HandleSlackAction(ctx context.Context, slackID, data string) SlackMessage {
+	userID := SlackUserKey(slackID)
+	lang := GetUserLang(userID)
+	command, err := VerifyPostbackData(data, time.Now())
+	if err == ErrPostbackExpired {
+		return NewSlackTextMessage(T(lang, "postback.expired"))
+	} else if err != nil {
+		return NewSlackTextMessage(T(lang, "postback.invalid"))
+	}
+	switch command.Type {
+	case PostBackCommandTypeAnalyze:
+		return MakeSlackAnalysisMessage(ctx, command.Area, command.Spot, userID)
+	case PostBackCommandTypeFavorite:
+		return UpdateSlackFavorite(userID, &command)
+	case PostBackCommandTypeFavoriteList:
+		if command.Target != "" {
+			return RenderSlack(BuildFavoriteGroupView(ctx, userID, command.Target))
+		}
+		return MakeSlackFavoriteListMessage(ctx, userID)
+	case PostBackCommandTypeRanking:
+		return MakeSlackRankingMessage(ctx, 20, lang)
+	case PostBackCommandTypeStatus:
+		return MakeSlackServiceStatusMessage(ctx, lang)
+	}
+	return NewSlackTextMessage(T(lang, "slack.unsupported"))
+}
+
+//MakeSlackSpotListMessage スポット検索
+func MakeSlackSpotListMessage(ctx context.Context, query string, lang Lang) SlackMessage {
+	return RenderSlack(BuildSearchView(ctx, query, lang))
+}
+
+//MakeSlackFavoriteListMessage お気に入り一覧
+func MakeSlackFavoriteListMessage(ctx context.Context, userID string) SlackMessage {
+	return RenderSlack(BuildFavoriteListView(ctx, userID))
+}
+
+//MakeSlackRankingMessage 台数ランキング
+func MakeSlackRankingMessage(ctx context.Context, limit int, lang Lang) SlackMessage {
+	return RenderSlack(BuildRankingView(ctx, limit, lang))
+}
+
+//MakeSlackServiceStatusMessage システム稼働状況
+func MakeSlackServiceStatusMessage(ctx context.Context, lang Lang) SlackMessage {
+	return RenderSlack(BuildServiceStatusView(ctx, lang))
+}
+
+//MakeSlackAnalysisMessage グラフ表示
+func MakeSlackAnalysisMessage(ctx context.Context, area, spot, userID string) SlackMessage {
+	return RenderSlack(BuildAnalysisView(ctx, area, spot, userID))
+}
+
+//UpdateSlackFavorite お気に入り登録・解除
+func UpdateSlackFavorite(userID string, command *PostBackCommand) SlackMessage {
+	user := GetUserConfigFromCache(userID)
+	if user == nil {
+		return NewSlackTextMessage(T(DefaultLang, "user.loadFailed"))
+	}
+	lang := user.Lang()
+	code := command.Area + "-" + command.Spot
+	switch command.Mode {
+	case PostBackCommandModeReg:
+		if len(user.Favorites) >= MaxFavorite {
+			return NewSlackTextMessage(T(lang, "fav.full"))
+		}
+		if err := UpdateUserConfig(UserUpdateTypeFavorite, userID, code); err != nil {
+			return NewSlackTextMessage(T(lang, "user.loadFailed"))
+		}
+		return NewSlackTextMessage(T(lang, "slack.favAdded", slackCodeText(command.Area, command.Spot)))
+	case PostBackCommandModeUnreg:
+		if !contains(user.Favorites, code) {
+			return NewSlackTextMessage(T(lang, "fav.cannotDelete"))
+		}
+		if err := UpdateUserConfig(UserUpdateTypeFavoriteDelete, userID, code); err != nil {
+			return NewSlackTextMessage(T(lang, "user.loadFailed"))
+		}
+		return NewSlackTextMessage(T(lang, "slack.favRemoved", slackCodeText(command.Area, command.Spot)))
+	}
+	return NewSlackTextMessage(T(lang, "slack.unsupported"))
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  LINEとの連携
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//slackLinkCode 連携コードの発行先
+type slackLinkCode struct {
+	LineID  string
+	Expires time.Time
+}
+
+var (
+	slackLinkCodesMu sync.Mutex
+	slackLinkCodes   = make(map[string]slackLinkCode)
+	//slackLinkFailures 連携コードを間違えた回数（総当たりで当てられないようにする）
+	slackLinkFailures = newLinkFailureCounter(SlackLinkCodeTimeout, SlackLinkMaxFailures, SlackLinkMaxFailuresTotal)
+)
+
+//IssueSlackLinkCode LINEユーザーに連携コード（SlackLinkCodeLength文字の英数字）を発行する
+func IssueSlackLinkCode(lineID string) (string, error) {
+	code := make([]byte, SlackLinkCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(slackLinkCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = slackLinkCodeAlphabet[n.Int64()]
+	}
+	slackLinkCodesMu.Lock()
+	defer slackLinkCodesMu.Unlock()
+	now := time.Now()
+	for key, issued := range slackLinkCodes {
+		//期限切れと同じユーザーの古いコードは消しておく
+		if now.After(issued.Expires) || issued.LineID == lineID {
+			delete(slackLinkCodes, key)
+		}
+	}
+	slackLinkCodes[string(code)] = slackLinkCode{LineID: lineID, Expires: now.Add(SlackLinkCodeTimeout)}
+	return string(code), nil
+}
+
+//consumeSlackLinkCode 連携コードを使う（1回しか使えない）
+//小文字で入力されても受け付ける
+func consumeSlackLinkCode(code string) (string, bool) {
+	slackLinkCodesMu.Lock()
+	defer slackLinkCodesMu.Unlock()
+	code = strings.ToUpper(code)
+	issued, ok := slackLinkCodes[code]
+	if !ok {
+		return "", false
+	}
+	delete(slackLinkCodes, code)
+	if time.Now().After(issued.Expires) {
+		return "", false
+	}
+	return issued.LineID, true
+}
+
+//LinkSlackUser 連携コードを使ってSlackユーザーとLINEユーザーを結びつける
+//Slack専用の設定にあったお気に入りと履歴はLINEの設定に移す
+func LinkSlackUser(slackID, code string) SlackMessage {
+	lang := GetUserLang(SlackUserKey(slackID))
+	now := time.Now()
+	if !slackLinkFailures.Allow(slackID, now) {
+		return NewSlackTextMessage(T(lang, "slack.linkLocked", int(SlackLinkCodeTimeout/time.Minute)))
+	}
+	lineID, ok := consumeSlackLinkCode(code)
+	if !ok {
+		slackLinkFailures.Add(slackID, now)
+		return NewSlackTextMessage(T(lang, "slack.linkInvalid"))
+	}
+	standalone := GetUserConfigFromCache(SlackUserKeyPrefix + slackID)
+	err := UpdateUserConfigFunc(lineID, func(user *UserConfig) {
+		user.SlackID = slackID
+		if standalone != nil {
+			for _, favorite := range standalone.Favorites {
+				user.Favorites = AddList(user.Favorites, favorite, MaxFavorite)
+			}
+			for _, history := range standalone.Histories {
+				user.Histories = AddList(user.Histories, history, MaxHistory)
+			}
+		}
+	})
+	if err != nil {
+		return NewSlackTextMessage(T(lang, "user.loadFailed"))
+	}
+	//ほかのLINEユーザーとの連携は外す
+	for _, user := range UserConfigs.List() {
+		if user.SlackID != slackID || user.LineID == lineID {
+			continue
+		}
+		//外せなかったことはDeleteUserConfig・UpdateUserConfigFuncがログに出している
+		if IsSlackUserKey(user.LineID) {
+			DeleteUserConfig(user.LineID)
+		} else {
+			UpdateUserConfigFunc(user.LineID, func(user *UserConfig) { user.SlackID = "" })
+		}
+	}
+	return NewSlackTextMessage(T(GetUserLang(lineID), "slack.linked"))
+}
+
+//linkFailureCounter 一定時間ごとに区切って失敗した回数を数える
+type linkFailureCounter struct {
+	mu       sync.Mutex
+	window   time.Duration
+	maxEach  int
+	maxTotal int
+	start    time.Time
+	total    int
+	counts   map[string]int
+}
+
+//newLinkFailureCounter コンストラクタ
+func newLinkFailureCounter(window time.Duration, maxEach, maxTotal int) *linkFailureCounter {
+	return &linkFailureCounter{window: window, maxEach: maxEach, maxTotal: maxTotal, counts: make(map[string]int)}
+}
+
+//Allow まだ試してよいか（本人か全体の失敗が上限に達していればfalse）
+func (counter *linkFailureCounter) Allow(key string, now time.Time) bool {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+	counter.reset(now)
+	return counter.counts[key] < counter.maxEach && counter.total < counter.maxTotal
+}
+
+//Add 失敗を1回数える
+func (counter *linkFailureCounter) Add(key string, now time.Time) {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+	counter.reset(now)
+	counter.counts[key]++
+	counter.total++
+}
+
+//reset 区切りの時間が過ぎていれば数え直す（呼び出し側でロックをとる）
+func (counter *linkFailureCounter) reset(now time.Time) {
+	if now.Sub(counter.start) < counter.window {
+		return
+	}
+	counter.start = now
+	counter.total = 0
+	counter.counts = make(map[string]int)
+}
+
+//ReplyToPostbackSlack LINEでSlack連携のコードを発行する
+func ReplyToPostbackSlack(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
+	lang := GetUserLang(SourceID(event))
+	var reply linebot.SendingMessage
+	switch {
+	case SlackSigningSecret == "":
+		reply = linebot.NewTextMessage(T(lang, "slack.notConfigured"))
+	case IsGroupEvent(event):
+		reply = linebot.NewTextMessage(T(lang, "slack.groupOnly"))
+	default:
+		code, err := IssueSlackLinkCode(SourceID(event))
+		if err != nil {
+			LogError(ctx, "Slack連携のコードを発行できませんでした", LogFields{"error": err})
+			reply = linebot.NewTextMessage(T(lang, "search.failed"))
+			break
+		}
+		reply = linebot.NewTextMessage(T(lang, "slack.linkCode", code, int(SlackLinkCodeTimeout/time.Minute)))
+	}
+	ReplyMessage(ctx, event.ReplyToken, reply)
+}
+
+//SendSlackNotify 連携しているSlackにもお気に入りの台数を送る
+func SendSlackNotify(ctx context.Context, userID string, target NotifyTarget) {
+	user := GetUserConfigFromCache(userID)
+	if user == nil || user.SlackID == "" || SlackAPI.Token == "" {
+		return
+	}
+	message := RenderSlack(BuildNotifyTargetView(ctx, userID, target))
+	if len(message.Blocks) == 0 {
+		//一覧を作れなかったときなので何もしない
+		return
+	}
+	//ボットとのDMに送る
+	message.Channel = user.SlackID
+	message.ResponseType = ""
+	if err := SlackAPI.PostMessage(message); err != nil {
+		LogError(ctx, "Slackに通知を送れませんでした", LogFields{"error": err})
+	}
+}
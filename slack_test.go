@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//setupFakeBot 偽のBikeshareAPIとメモリ上のユーザー設定でボットを動かす（終わったら元に戻す）
+func setupFakeBot(t *testing.T) {
+	api := NewFakeBikeshareServer()
+	savedAPI, savedStorage, savedGraph, savedKey := BikeshareAPI, UserStorage, GraphBaseURL, PostbackSigningKey
+	t.Cleanup(func() {
+		api.Close()
+		BikeshareAPI, UserStorage, GraphBaseURL, PostbackSigningKey = savedAPI, savedStorage, savedGraph, savedKey
+		UserConfigs.ReplaceAll(nil)
+		SetSpotNames(nil)
+	})
+	SetupBikeshareAPI(api.URL + "/")
+	//グラフも偽のAPIのグラフ検索を使う
+	GraphBaseURL = ""
+	PostbackSigningKey = []byte("test-postback-secret")
+	store, err := NewFileUserStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	UserStorage = store
+	UserConfigs.ReplaceAll(nil)
+	if err := LoadSpotNames(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//testContext テストの間だけ有効なcontext
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+//slackRequest 偽のSlackが受け取ったリクエスト
+type slackRequest struct {
+	Path          string
+	Authorization string
+	Message       SlackMessage
+}
+
+//setupFakeSlack SLACK_API_URLとresponse_urlを受ける偽のSlack
+func setupFakeSlack(t *testing.T) (*httptest.Server, <-chan slackRequest) {
+	requests := make(chan slackRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var message SlackMessage
+		if err := json.NewDecoder(req.Body).Decode(&message); err != nil {
+			t.Errorf("%s: %v", req.URL.Path, err)
+		}
+		requests <- slackRequest{Path: req.URL.Path, Authorization: req.Header.Get("Authorization"), Message: message}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	savedAPI, savedSecret := SlackAPI, SlackSigningSecret
+	t.Cleanup(func() {
+		server.Close()
+		SlackAPI, SlackSigningSecret = savedAPI, savedSecret
+	})
+	SlackAPI = NewSlackClient(server.URL, "xoxb-test")
+	SlackSigningSecret = "test-signing-secret"
+	return server, requests
+}
+
+//postSlack 署名を付けてSlackからのリクエストを送る
+func postSlack(handler http.HandlerFunc, values url.Values) *httptest.ResponseRecorder {
+	body := values.Encode()
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(SlackSigningSecret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+	req := httptest.NewRequest("POST", "/slack", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+//slackCommand スラッシュコマンドを送って応答のメッセージを返す
+func slackCommand(t *testing.T, slackID, text string) SlackMessage {
+	w := postSlack(SlackCommandHandler, url.Values{"user_id": {slackID}, "command": {"/bikeshare"}, "text": {text}})
+	if w.Code != 200 {
+		t.Fatalf("status = %d", w.Code)
+	}
+	var message SlackMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &message); err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+//slackAction ボタンを押す
+func slackAction(t *testing.T, server *httptest.Server, slackID, value string) {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"type":         "block_actions",
+		"response_url": server.URL + "/response",
+		"user":         map[string]string{"id": slackID},
+		"actions":      []map[string]string{{"action_id": "test", "value": value}},
+	})
+	if w := postSlack(SlackActionHandler, url.Values{"payload": {string(payload)}}); w.Code != 200 {
+		t.Fatalf("status = %d", w.Code)
+	}
+}
+
+//receiveSlack 偽のSlackが受け取るのを待つ
+func receiveSlack(t *testing.T, requests <-chan slackRequest) slackRequest {
+	select {
+	case req := <-requests:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("Slackに送られなかった")
+	}
+	return slackRequest{}
+}
+
+//blockTypes ブロックの種類の並び
+func blockTypes(message SlackMessage) string {
+	var types []string
+	for _, block := range message.Blocks {
+		types = append(types, block.Type)
+	}
+	return strings.Join(types, ",")
+}
+
+//assertSpotListBlocks 台数一覧のブロック（見出し・更新日時・区切り・スポットごとに詳細ボタン）
+func assertSpotListBlocks(t *testing.T, message SlackMessage, title string, names ...string) {
+	t.Helper()
+	if message.ResponseType != SlackResponseEphemeral || message.Text != title {
+		t.Errorf("response_type = %q, text = %q, want %q", message.ResponseType, message.Text, title)
+	}
+	want := "section,context,divider" + strings.Repeat(",section", len(names))
+	if got := blockTypes(message); got != want {
+		t.Fatalf("blocks = %s, want %s", got, want)
+	}
+	if got := message.Blocks[0].Text; got.Type != "mrkdwn" || got.Text != "*"+title+"*" {
+		t.Errorf("見出し = %+v", got)
+	}
+	for i, name := range names {
+		block := message.Blocks[3+i]
+		if !strings.Contains(block.Text.Text, name) {
+			t.Errorf("%d番目 = %q, want %q", i+1, block.Text.Text, name)
+		}
+		button := block.Accessory
+		if button == nil || button.Type != "button" || button.ActionID != string(PostBackCommandTypeAnalyze) {
+			t.Fatalf("%d番目のボタン = %+v", i+1, button)
+		}
+		command, err := VerifyPostbackData(button.Value, time.Now())
+		if err != nil || command.Type != PostBackCommandTypeAnalyze {
+			t.Errorf("%d番目のボタンの値 = %q（%v）", i+1, button.Value, err)
+		}
+	}
+}
+
+func TestNewSlackClientVerifiesCertificates(t *testing.T) {
+	client := NewSlackClient("", "xoxb-test")
+	if client.HTTPClient == &Client {
+		t.Fatal("LINE用のClientを使っている")
+	}
+	if client.HTTPClient.Transport != nil {
+		t.Errorf("Transport = %v（標準のTransportで証明書を確認する）", client.HTTPClient.Transport)
+	}
+	if client.HTTPClient.Timeout <= 0 {
+		t.Error("タイムアウトがない")
+	}
+	if client.APIURL != SlackDefaultAPIURL {
+		t.Errorf("APIURL = %q", client.APIURL)
+	}
+}
+
+func TestSlackCommandSearch(t *testing.T) {
+	setupFakeBot(t)
+	setupFakeSlack(t)
+
+	message := slackCommand(t, "US1", "区役所")
+	lang := GetUserLang(SlackUserKey("US1"))
+	assertSpotListBlocks(t, message, T(lang, "search.found", "区役所", 4), "千代田区役所", "中央区役所", "港区役所", "新宿区役所")
+	//検索履歴に残る
+	if user := GetUserConfigFromCache(SlackUserKey("US1")); user == nil || !contains(user.Histories, "区役所") {
+		t.Errorf("履歴に残っていない: %+v", user)
+	}
+}
+
+func TestSlackCommandFavorites(t *testing.T) {
+	setupFakeBot(t)
+	setupFakeSlack(t)
+	if err := UpdateUserConfigFunc(SlackUserKey("US1"), func(user *UserConfig) {
+		user.Favorites = []string{"B2-02", "A1-01"}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	//並びはAPIが返す順
+	message := slackCommand(t, "US1", "fav")
+	lang := GetUserLang(SlackUserKey("US1"))
+	assertSpotListBlocks(t, message, T(lang, "fav.title"), "千代田区役所", "銀座四丁目")
+}
+
+func TestSlackCommandRanking(t *testing.T) {
+	setupFakeBot(t)
+	setupFakeSlack(t)
+
+	message := slackCommand(t, "US1", "ranking")
+	lang := GetUserLang(SlackUserKey("US1"))
+	names := make([]string, len(fakeSpots))
+	for i, block := range message.Blocks[3:] {
+		if i < len(names) {
+			names[i] = strings.SplitN(strings.SplitN(block.Text.Text, "] ", 2)[1], "\n", 2)[0]
+		}
+	}
+	assertSpotListBlocks(t, message, T(lang, "ranking.title", len(fakeSpots)), names...)
+}
+
+func TestSlackCommandStatus(t *testing.T) {
+	setupFakeBot(t)
+	setupFakeSlack(t)
+
+	message := slackCommand(t, "US1", "status")
+	lang := GetUserLang(SlackUserKey("US1"))
+	if message.Text != T(lang, "status.ok") || len(message.Blocks) != 0 || message.ResponseType != SlackResponseEphemeral {
+		t.Errorf("message = %+v", message)
+	}
+}
+
+func TestSlackActionAnalysisAndFavorite(t *testing.T) {
+	setupFakeBot(t)
+	server, requests := setupFakeSlack(t)
+	lang := GetUserLang(SlackUserKey("US1"))
+
+	//詳細ボタンはresponse_urlにグラフを返す
+	slackAction(t, server, "US1", GetPostbackDataForAnalyze("A1", "01", 2))
+	req := receiveSlack(t, requests)
+	//response_urlにはボットトークンを送らない
+	if req.Path != "/response" || req.Authorization != "" {
+		t.Errorf("path = %s, Authorization = %q", req.Path, req.Authorization)
+	}
+	message := req.Message
+	if got := blockTypes(message); got != "section,image,context,actions" {
+		t.Fatalf("blocks = %s", got)
+	}
+	if !strings.Contains(message.Blocks[0].Text.Text, "千代田区役所") {
+		t.Errorf("見出し = %q", message.Blocks[0].Text.Text)
+	}
+	image := message.Blocks[1]
+	if !strings.HasPrefix(image.ImageURL, BikeshareAPI.Endpoint+"graph.png?") || !strings.Contains(image.ImageURL, "area=A1") || image.AltText == "" {
+		t.Errorf("image = %+v", image)
+	}
+	button, _ := message.Blocks[3].Elements[0].(map[string]interface{})
+	if button["type"] != "button" || button["style"] != "primary" || button["action_id"] != string(PostBackCommandTypeFavorite) {
+		t.Fatalf("お気に入りボタン = %v", button)
+	}
+
+	//お気に入りに登録する
+	slackAction(t, server, "US1", button["value"].(string))
+	req = receiveSlack(t, requests)
+	if want := T(lang, "slack.favAdded", "[A1-01] 千代田区役所"); req.Message.Text != want {
+		t.Errorf("text = %q, want %q", req.Message.Text, want)
+	}
+	if user := GetUserConfigFromCache(SlackUserKey("US1")); user == nil || !contains(user.Favorites, "A1-01") {
+		t.Errorf("お気に入りに登録されていない: %+v", user)
+	}
+}
+
+func TestSendSlackNotify(t *testing.T) {
+	setupFakeBot(t)
+	_, requests := setupFakeSlack(t)
+	if err := UpdateUserConfigFunc("U1", func(user *UserConfig) {
+		user.SlackID = "US1"
+		user.Favorites = []string{"C3-02"}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	SendSlackNotify(testContext(t), "U1", NotifyTarget{})
+	req := receiveSlack(t, requests)
+	if req.Path != "/chat.postMessage" || req.Authorization != "Bearer xoxb-test" {
+		t.Errorf("path = %s, Authorization = %q", req.Path, req.Authorization)
+	}
+	if req.Message.Channel != "US1" || req.Message.ResponseType != "" {
+		t.Errorf("channel = %q, response_type = %q", req.Message.Channel, req.Message.ResponseType)
+	}
+	if got := blockTypes(req.Message); got != "section,context,divider,section" || !strings.Contains(req.Message.Blocks[3].Text.Text, "新橋駅前") {
+		t.Errorf("blocks = %s", got)
+	}
+}
+
+func TestLinkSlackUser(t *testing.T) {
+	setupFakeBot(t)
+	setupFakeSlack(t)
+	//連携する前にSlackで登録したお気に入り
+	if err := UpdateUserConfigFunc(SlackUserKeyPrefix+"US1", func(user *UserConfig) {
+		user.SlackID = "US1"
+		user.Favorites = []string{"A1-01"}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	code, err := IssueSlackLinkCode("U1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != SlackLinkCodeLength || strings.Trim(code, slackLinkCodeAlphabet) != "" {
+		t.Errorf("code = %q", code)
+	}
+
+	//小文字で入力してもよい
+	if message := slackCommand(t, "US1", "link "+strings.ToLower(code)); message.Text != T(LangJa, "slack.linked") {
+		t.Errorf("text = %q", message.Text)
+	}
+	if got := SlackUserKey("US1"); got != "U1" {
+		t.Errorf("SlackUserKey = %q", got)
+	}
+	if user := GetUserConfigFromCache("U1"); user == nil || user.SlackID != "US1" || !contains(user.Favorites, "A1-01") {
+		t.Errorf("U1 = %+v", user)
+	}
+	if GetUserConfigFromCache(SlackUserKeyPrefix+"US1") != nil {
+		t.Error("Slack専用の設定が残っている")
+	}
+	//1回しか使えない
+	if message := slackCommand(t, "US2", "link "+code); message.Text != T(LangJa, "slack.linkInvalid") {
+		t.Errorf("2回目 = %q", message.Text)
+	}
+}
+
+func TestLinkSlackUserLimitsFailures(t *testing.T) {
+	setupFakeBot(t)
+	setupFakeSlack(t)
+	saved := slackLinkFailures
+	defer func() { slackLinkFailures = saved }()
+	slackLinkFailures = newLinkFailureCounter(time.Hour, 2, 3)
+
+	code, err := IssueSlackLinkCode("U1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked := T(LangJa, "slack.linkLocked", int(SlackLinkCodeTimeout/time.Minute))
+	tests := []struct {
+		slackID string
+		code    string
+		want    string
+	}{
+		{"US1", "WRONG", T(LangJa, "slack.linkInvalid")},
+		{"US1", "WRONG", T(LangJa, "slack.linkInvalid")},
+		//本人が上限に達したら正しいコードでも受け付けない
+		{"US1", code, locked},
+		//全体で上限に達したらほかのユーザーも受け付けない
+		{"US2", "WRONG", T(LangJa, "slack.linkInvalid")},
+		{"US3", code, locked},
+	}
+	for i, tt := range tests {
+		if message := slackCommand(t, tt.slackID, "link "+tt.code); message.Text != tt.want {
+			t.Errorf("%d: text = %q, want %q", i, message.Text, tt.want)
+		}
+	}
+	//時間が経てばまた試せる
+	if !slackLinkFailures.Allow("US1", time.Now().Add(2*time.Hour)) {
+		t.Error("時間が経っても試せない")
+	}
+}
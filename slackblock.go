@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	//SlackMaxBlocks 1メッセージに入れられるブロック数
+	SlackMaxBlocks = 50
+	//SlackResponseEphemeral コマンドを打った人にだけ見える返信
+	SlackResponseEphemeral = "ephemeral"
+)
+
+//SlackMessage Slackに送るメッセージ
+type SlackMessage struct {
+	Channel         string       `json:"channel,omitempty"`
+	ResponseType    string       `json:"response_type,omitempty"`
+	ReplaceOriginal bool         `json:"replace_original,omitempty"`
+	Text            string       `json:"text"`
+	Blocks          []SlackBlock `json:"blocks,omitempty"`
+}
+
+//SlackBlock Block Kitのブロック
+type SlackBlock struct {
+	Type      string        `json:"type"`
+	Text      *SlackText    `json:"text,omitempty"`
+	Accessory *SlackElement `json:"accessory,omitempty"`
+	//Elements actionsブロックならSlackElement、contextブロックならSlackText
+	Elements []interface{} `json:"elements,omitempty"`
+	ImageURL string        `json:"image_url,omitempty"`
+	AltText  string        `json:"alt_text,omitempty"`
+	Title    *SlackText    `json:"title,omitempty"`
+}
+
+//SlackText テキストオブジェクト
+type SlackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+//SlackElement ボタンなどの要素
+type SlackElement struct {
+	Type     string     `json:"type"`
+	Text     *SlackText `json:"text,omitempty"`
+	ActionID string     `json:"action_id,omitempty"`
+	Value    string     `json:"value,omitempty"`
+	Style    string     `json:"style,omitempty"`
+}
+
+//NewSlackTextMessage テキストだけのメッセージ
+func NewSlackTextMessage(text string) SlackMessage {
+	return SlackMessage{ResponseType: SlackResponseEphemeral, Text: text}
+}
+
+//slackMarkdown mrkdwnのテキスト
+func slackMarkdown(text string) *SlackText {
+	return &SlackText{Type: "mrkdwn", Text: text}
+}
+
+//slackPlain plain_textのテキスト
+func slackPlain(text string) *SlackText {
+	return &SlackText{Type: "plain_text", Text: text}
+}
+
+//slackButton ボタン（値はポストバック文字列と同じものを使う）
+func slackButton(label, actionID, value, style string) *SlackElement {
+	return &SlackElement{Type: "button", Text: slackPlain(label), ActionID: actionID, Value: value, Style: style}
+}
+
+//CreateSlackSpotListBlocks 台数一覧のブロック作成
+func CreateSlackSpotListBlocks(view SpotListView) []SlackBlock {
+	lang := view.Lang
+	blocks := []SlackBlock{
+		{Type: "section", Text: slackMarkdown("*" + view.Title + "*")},
+		{Type: "context", Elements: []interface{}{slackMarkdown(view.LastUpdate)}},
+		{Type: "divider"},
+	}
+	for i, spot := range view.Spots {
+		if len(blocks) >= SlackMaxBlocks-1 {
+			//入りきらない分は件数だけ表示する
+			blocks = append(blocks, SlackBlock{Type: "context", Elements: []interface{}{slackMarkdown(T(lang, "slack.more", len(view.Spots)-i))}})
+			break
+		}
+		blocks = append(blocks, SlackBlock{
+			Type:      "section",
+			Text:      slackMarkdown(spotItemLabel(spot, lang)),
+			Accessory: slackButton(T(lang, "button.detail"), string(PostBackCommandTypeAnalyze), GetPostbackDataForAnalyze(spot.Area, spot.Spot, 2), ""),
+		})
+	}
+	return blocks
+}
+
+//CreateSlackAnalysisBlocks グラフのブロック作成
+func CreateSlackAnalysisBlocks(view AnalysisView) []SlackBlock {
+	lang := view.Lang
+	blocks := []SlackBlock{
+		{Type: "section", Text: slackMarkdown("*" + view.Title + "*")},
+		{Type: "image", ImageURL: view.URL, AltText: view.Title},
+	}
+	var context []interface{}
+	for _, text := range []string{view.Description, view.Forecast, view.LastUpdate} {
+		if text != "" {
+			context = append(context, slackMarkdown(text))
+		}
+	}
+	if len(context) > 0 {
+		blocks = append(blocks, SlackBlock{Type: "context", Elements: context})
+	}
+	//お気に入りの登録・解除
+	var button *SlackElement
+	if !view.Favorite {
+		button = slackButton(T(lang, "fav.add"), string(PostBackCommandTypeFavorite), GetPostbackDataForFovarite(view.Area, view.Spot, PostBackCommandModeReg), "primary")
+	} else {
+		button = slackButton(T(lang, "slack.favRemove"), string(PostBackCommandTypeFavorite), GetPostbackDataForFovarite(view.Area, view.Spot, PostBackCommandModeUnreg), "danger")
+	}
+	blocks = append(blocks, SlackBlock{Type: "actions", Elements: []interface{}{button}})
+	return blocks
+}
+
+//CreateSlackHelpBlocks 使い方のブロック作成
+func CreateSlackHelpBlocks(command string, lang Lang) []SlackBlock {
+	return []SlackBlock{
+		{Type: "section", Text: slackMarkdown(T(lang, "slack.help", command))},
+		{Type: "actions", Elements: []interface{}{
+			slackButton(T(lang, "slack.favorites"), string(PostBackCommandTypeFavoriteList), GetPostbackDataFavoriteList(), ""),
+			slackButton(T(lang, "command.ranking"), string(PostBackCommandTypeRanking), GetPostbackDataRanking(), ""),
+			slackButton(T(lang, "command.status"), string(PostBackCommandTypeStatus), GetPostbackDataServiceStatus(), ""),
+		}},
+	}
+}
+
+//RenderSlack Slackのメッセージにする
+//Block Kitで作れないものは文字だけで表示する
+func RenderSlack(view View) SlackMessage {
+	switch view := view.(type) {
+	case SpotListView:
+		return SlackMessage{ResponseType: SlackResponseEphemeral, Text: view.Title, Blocks: CreateSlackSpotListBlocks(view)}
+	case AnalysisView:
+		return SlackMessage{ResponseType: SlackResponseEphemeral, Text: view.Title, Blocks: CreateSlackAnalysisBlocks(view)}
+	}
+	return NewSlackTextMessage(RenderText(view))
+}
+
+//slackCodeText スポットを表す文字列
+func slackCodeText(area, spot string) string {
+	return fmt.Sprintf("[%s-%s] %s", area, spot, GetPlaceNameByCode(area+"-"+spot))
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bikeshareapi "github.com/8245snake/bikeshare-client"
+	"github.com/8245snake/bikeshare-line/search"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+const (
+	//SpotMasterRefreshInterval スポット一覧を取り直す間隔
+	SpotMasterRefreshInterval = 6 * time.Hour
+	//SpotAnnounceDistance お気に入りの近くとみなす距離（メートル）
+	SpotAnnounceDistance = 500
+	//SpotMasterMaxRemovedPercent 一度に廃止されてよいスポットの割合（%）
+	//これより多く消えたらAPIが一部しか返さなかったとみなして辞書を入れ替えない
+	SpotMasterMaxRemovedPercent = 10
+)
+
+//spotMasterMu スポット名の辞書と検索インデックスを入れ替えるときの排他制御
+var spotMasterMu sync.RWMutex
+
+//SetSpotNames スポット名の辞書と検索インデックスをまとめて入れ替える
+func SetSpotNames(names map[string]string) {
+	index := search.NewIndex(names)
+	spotMasterMu.Lock()
+	defer spotMasterMu.Unlock()
+	SpotNamesDictionary = names
+	SpotSearchIndex = index
+}
+
+//GetSpotSearchIndex スポット名の検索インデックスを取得
+func GetSpotSearchIndex() *search.Index {
+	spotMasterMu.RLock()
+	defer spotMasterMu.RUnlock()
+	return SpotSearchIndex
+}
+
+//GetSpotNames スポット名の辞書を取得（入れ替えられるだけで書き換えられることはないのでそのまま参照してよい）
+func GetSpotNames() map[string]string {
+	spotMasterMu.RLock()
+	defer spotMasterMu.RUnlock()
+	return SpotNamesDictionary
+}
+
+//SpotRename スポット名の変更
+type SpotRename struct {
+	Code, OldName, NewName string
+}
+
+//SpotMasterDiff スポット一覧の差分
+type SpotMasterDiff struct {
+	Added   []bikeshareapi.SpotName
+	Removed []bikeshareapi.SpotName
+	Renamed []SpotRename
+}
+
+//Empty 差分がないか
+func (diff SpotMasterDiff) Empty() bool {
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Renamed) == 0
+}
+
+//DiffSpotNames 新しいスポット一覧から辞書を作り、今の辞書との差分をとる
+func DiffSpotNames(current map[string]string, places []bikeshareapi.SpotName) (map[string]string, SpotMasterDiff) {
+	var diff SpotMasterDiff
+	names := make(map[string]string)
+	for _, place := range places {
+		code := place.Area + "-" + place.Spot
+		names[code] = place.Name
+		if old, ok := current[code]; !ok {
+			diff.Added = append(diff.Added, place)
+		} else if old != place.Name {
+			diff.Renamed = append(diff.Renamed, SpotRename{Code: code, OldName: old, NewName: place.Name})
+		}
+	}
+	for code, name := range current {
+		if _, ok := names[code]; !ok {
+			area, spot := SplitAreaSpot(code)
+			diff.Removed = append(diff.Removed, bikeshareapi.SpotName{Area: area, Spot: spot, Name: name})
+		}
+	}
+	sort.Slice(diff.Removed, func(i, j int) bool {
+		return diff.Removed[i].Area+"-"+diff.Removed[i].Spot < diff.Removed[j].Area+"-"+diff.Removed[j].Spot
+	})
+	return names, diff
+}
+
+//SpotMasterRefresher スポット一覧を定期的に取り直して、変更をユーザーに知らせる
+type SpotMasterRefresher struct {
+	//Clock 現在時刻
+	Clock Clock
+	//Send メッセージを送信する
+	Send func(userID string, message linebot.SendingMessage) error
+}
+
+//NewSpotMasterRefresher コンストラクタ
+func NewSpotMasterRefresher() *SpotMasterRefresher {
+	return &SpotMasterRefresher{
+		Clock: systemClock{},
+		Send:  PushMessage,
+	}
+}
+
+//Run stopが閉じられるまで定期的にスポット一覧を取り直す
+func (refresher *SpotMasterRefresher) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := refresher.Refresh(); err != nil {
+				LogError(context.Background(), "スポット一覧の更新に失敗しました", LogFields{"error": err, "job": "spotmaster"})
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+//Refresh スポット一覧を取り直して辞書を入れ替える
+func (refresher *SpotMasterRefresher) Refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), APIBackgroundBudget)
+	defer cancel()
+	ctx = WithLogFields(ctx, LogFields{"job": "spotmaster"})
+	places, err := BikeshareAPI.GetAllSpotNamesContext(ctx)
+	if err != nil {
+		return err
+	}
+	if len(places) == 0 {
+		//空の一覧で辞書を消してしまわないようにする
+		return fmt.Errorf("スポット一覧が空でした")
+	}
+	current := GetSpotNames()
+	names, diff := DiffSpotNames(current, places)
+	if diff.Empty() {
+		return nil
+	}
+	if len(diff.Removed)*100 > len(current)*SpotMasterMaxRemovedPercent {
+		//全ユーザーに廃止のお知らせを送ってしまわないようにする
+		return fmt.Errorf("スポット一覧から%d件中%d件が消えたため更新しません", len(current), len(diff.Removed))
+	}
+	SetSpotNames(names)
+	LogInfo(ctx, "スポット一覧を更新しました", LogFields{"added": len(diff.Added), "removed": len(diff.Removed), "renamed": len(diff.Renamed)})
+	refresher.announce(ctx, diff)
+	return nil
+}
+
+//announce お知らせを受け取るユーザーに関係する変更を送信する
+func (refresher *SpotMasterRefresher) announce(ctx context.Context, diff SpotMasterDiff) {
+	var users []UserConfig
+	now := refresher.Clock.Now()
+	for _, user := range UserConfigs.List() {
+		if user.SpotAnnounce && len(user.AllFavorites()) > 0 && AllowProactivePush(ctx, user.LineID, now) {
+			users = append(users, user)
+		}
+	}
+	if len(users) == 0 {
+		return
+	}
+	//新しいスポットとお気に入りの位置を取得する
+	locations := make(map[string]bikeshareapi.SpotInfo)
+	if len(diff.Added) > 0 {
+		var codes []string
+		for _, place := range diff.Added {
+			codes = append(codes, place.Area+"-"+place.Spot)
+		}
+		for _, user := range users {
+			for _, code := range user.AllFavorites() {
+				if !contains(codes, code) {
+					codes = append(codes, code)
+				}
+			}
+		}
+		spotinfos, err := BikeshareAPI.GetPlacesContext(ctx, bikeshareapi.SearchPlacesOption{Places: codes})
+		if err != nil {
+			LogWarn(ctx, "スポットの位置の取得に失敗しました", LogFields{"error": err})
+		}
+		for _, info := range spotinfos {
+			locations[info.Area+"-"+info.Spot] = info
+		}
+	}
+
+	for _, user := range users {
+		lines := spotAnnounceLines(user, diff, locations)
+		if len(lines) == 0 {
+			continue
+		}
+		message := linebot.NewTextMessage(T(user.Lang(), "announce.title") + "\n" + strings.Join(lines, "\n"))
+		if err := refresher.Send(user.LineID, message); err != nil {
+			LogError(ctx, "スポット一覧の変更を送れませんでした", LogFields{"error": err, "user": HashUserID(user.LineID)})
+		}
+	}
+}
+
+//spotAnnounceLines ユーザーに関係する変更の文章
+func spotAnnounceLines(user UserConfig, diff SpotMasterDiff, locations map[string]bikeshareapi.SpotInfo) []string {
+	lang := user.Lang()
+	favorites := user.AllFavorites()
+	var lines []string
+	for _, place := range diff.Removed {
+		code := place.Area + "-" + place.Spot
+		if contains(favorites, code) {
+			lines = append(lines, T(lang, "announce.removed", code, place.Name))
+		}
+	}
+	for _, rename := range diff.Renamed {
+		if contains(favorites, rename.Code) {
+			lines = append(lines, T(lang, "announce.renamed", rename.Code, rename.OldName, rename.NewName))
+		}
+	}
+	for _, place := range diff.Added {
+		code := place.Area + "-" + place.Spot
+		added, ok := locations[code]
+		if !ok {
+			continue
+		}
+		//一番近いお気に入りを探す
+		nearest, best := "", math.MaxFloat64
+		for _, favorite := range favorites {
+			if info, ok := locations[favorite]; ok {
+				if d := distanceMeters(added.Lat, added.Lon, info.Lat, info.Lon); d < best {
+					nearest, best = favorite, d
+				}
+			}
+		}
+		if nearest != "" && best <= SpotAnnounceDistance {
+			lines = append(lines, T(lang, "announce.added", nearest, int(best), code, place.Name))
+		}
+	}
+	return lines
+}
+
+//distanceMeters 2点間の距離（メートル）
+func distanceMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371000
+	if (lat1 == 0 && lon1 == 0) || (lat2 == 0 && lon2 == 0) {
+		//位置不明
+		return math.MaxFloat64
+	}
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	bikeshareapi "github.com/8245snake/bikeshare-client"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+func TestDiffSpotNames(t *testing.T) {
+	current := map[string]string{
+		"A1-01": "千代田区役所",
+		"A1-02": "東京駅八重洲口",
+		"B2-01": "中央区役所",
+	}
+	places := []bikeshareapi.SpotName{
+		{Area: "A1", Spot: "01", Name: "千代田区役所前"},
+		{Area: "A1", Spot: "02", Name: "東京駅八重洲口"},
+		{Area: "C3", Spot: "01", Name: "港区役所"},
+	}
+	names, diff := DiffSpotNames(current, places)
+	want := map[string]string{"A1-01": "千代田区役所前", "A1-02": "東京駅八重洲口", "C3-01": "港区役所"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %v", names)
+	}
+	if !reflect.DeepEqual(diff.Added, []bikeshareapi.SpotName{{Area: "C3", Spot: "01", Name: "港区役所"}}) {
+		t.Errorf("Added = %v", diff.Added)
+	}
+	if !reflect.DeepEqual(diff.Removed, []bikeshareapi.SpotName{{Area: "B2", Spot: "01", Name: "中央区役所"}}) {
+		t.Errorf("Removed = %v", diff.Removed)
+	}
+	if !reflect.DeepEqual(diff.Renamed, []SpotRename{{Code: "A1-01", OldName: "千代田区役所", NewName: "千代田区役所前"}}) {
+		t.Errorf("Renamed = %v", diff.Renamed)
+	}
+
+	if _, diff := DiffSpotNames(want, places); !diff.Empty() {
+		t.Errorf("同じ一覧の差分 = %+v", diff)
+	}
+}
+
+func TestSpotAnnounceLines(t *testing.T) {
+	user := NewUserConfig("U1")
+	user.Favorites = []string{"A1-01", "A1-02"}
+	diff := SpotMasterDiff{
+		Added: []bikeshareapi.SpotName{
+			{Area: "A1", Spot: "09", Name: "近くの新しいスポット"},
+			{Area: "D4", Spot: "09", Name: "遠くの新しいスポット"},
+			{Area: "E5", Spot: "09", Name: "位置不明のスポット"},
+		},
+		Removed: []bikeshareapi.SpotName{
+			{Area: "A1", Spot: "02", Name: "東京駅八重洲口"},
+			{Area: "B2", Spot: "01", Name: "お気に入りではない"},
+		},
+		Renamed: []SpotRename{
+			{Code: "A1-01", OldName: "千代田区役所", NewName: "千代田区役所前"},
+			{Code: "B2-02", OldName: "銀座四丁目", NewName: "銀座"},
+		},
+	}
+	locations := map[string]bikeshareapi.SpotInfo{
+		"A1-01": {Lat: 35.694003, Lon: 139.753634},
+		//約200m北
+		"A1-09": {Lat: 35.695800, Lon: 139.753634},
+		"D4-09": {Lat: 35.693840, Lon: 139.703549},
+		"E5-09": {},
+	}
+	lines := spotAnnounceLines(user, diff, locations)
+	want := []string{
+		T(LangJa, "announce.removed", "A1-02", "東京駅八重洲口"),
+		T(LangJa, "announce.renamed", "A1-01", "千代田区役所", "千代田区役所前"),
+		T(LangJa, "announce.added", "A1-01", 199, "A1-09", "近くの新しいスポット"),
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	//関係する変更がなければ何も送らない
+	other := NewUserConfig("U2")
+	other.Favorites = []string{"C3-01"}
+	if lines := spotAnnounceLines(other, diff, locations); len(lines) != 0 {
+		t.Errorf("関係ないユーザー = %v", lines)
+	}
+}
+
+func TestSpotMasterRefreshRejectsPartialList(t *testing.T) {
+	setupFakeBot(t)
+	//今の辞書には偽のAPIにないスポットがたくさんある
+	names := map[string]string{}
+	for code, name := range GetSpotNames() {
+		names[code] = name
+	}
+	for i := 0; i < len(fakeSpots); i++ {
+		names[fmt.Sprintf("Z9-%02d", i)] = "消えるスポット"
+	}
+	SetSpotNames(names)
+	UpdateUserConfigFunc("U1", func(user *UserConfig) {
+		user.Favorites = []string{"Z9-00"}
+		user.SpotAnnounce = true
+	})
+	var sent []string
+	refresher := NewSpotMasterRefresher()
+	refresher.Send = func(userID string, message linebot.SendingMessage) error {
+		sent = append(sent, userID)
+		return nil
+	}
+
+	if err := refresher.Refresh(); err == nil {
+		t.Error("半分が消えた一覧を受け付けた")
+	}
+	if len(sent) != 0 || len(GetSpotNames()) != len(names) {
+		t.Errorf("sent = %v, names = %d", sent, len(GetSpotNames()))
+	}
+
+	//消えたスポットがなければ入れ替えて知らせる
+	names = map[string]string{}
+	for _, spot := range fakeSpots {
+		names[spot.Area+"-"+spot.Spot] = spot.Name
+	}
+	names["A1-01"] = "千代田区役所（旧）"
+	SetSpotNames(names)
+	UpdateUserConfigFunc("U1", func(user *UserConfig) { user.Favorites = []string{"A1-01"} })
+	if err := refresher.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sent, []string{"U1"}) || GetSpotNames()["A1-01"] != "千代田区役所" {
+		t.Errorf("sent = %v, A1-01 = %s", sent, GetSpotNames()["A1-01"])
+	}
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	bikeshareapi "github.com/8245snake/bikeshare-client"
 	"github.com/line/line-bot-sdk-go/linebot"
@@ -14,33 +17,35 @@ const (
 	ColorUnregButton = "#ee0000"
 )
 
-//CommandListItem コマンドリストの要素
-type CommandListItem struct {
-	ActionType        linebot.ActionType
-	Label, Data, Text string
-}
-
-//TemplateMessageParameter グテンプレートのパラメータ
-type TemplateMessageParameter struct {
-	Area, Spot, Title, URL, Description, LastUpdate string
-	RegButtonVisible                                bool
-}
-
 //getLastUpdateTime 「最終更新日時：yyyy/mm/dd hh:mi」の文字列を生成
-func getLastUpdateTime(spotinfos ...bikeshareapi.SpotInfo) (lastUpdateTime string) {
-	lastUpdateTime = "最終更新日時不明"
+func getLastUpdateTime(lang Lang, spotinfos ...bikeshareapi.SpotInfo) (lastUpdateTime string) {
+	lastUpdateTime = T(lang, "spot.noLastUpdate")
 	if len(spotinfos) > 0 {
 		if len(spotinfos[0].Counts) > 0 {
-			lastUpdateTime = fmt.Sprintf("最終更新日時：%s", spotinfos[0].Counts[0].Time.Format("2006/01/02 15:04"))
+			lastUpdateTime = T(lang, "spot.lastUpdate", spotinfos[0].Counts[0].Time.Format("2006/01/02 15:04"))
 		}
 	}
 	return
 }
 
+//spotItemLabel 一覧の1行の文字列
+func spotItemLabel(item SpotItem, lang Lang) string {
+	name := item.Name
+	if item.Note != "" {
+		name += "\n" + item.Note
+	}
+	if item.HasCount {
+		return T(lang, "spot.count", item.Area, item.Spot, name, item.Count)
+	}
+	return T(lang, "spot.countUnknown", item.Area, item.Spot, name)
+}
+
 //CreateSpotListBubbleContainer 台数一覧のテンプレート作成
-func CreateSpotListBubbleContainer(title, altText string, spotinfos []bikeshareapi.SpotInfo) linebot.BubbleContainer {
+func CreateSpotListBubbleContainer(view SpotListView) linebot.BubbleContainer {
+	lang := view.Lang
+	title := view.Title
 	//最終更新日時
-	lastUpdateTime := getLastUpdateTime(spotinfos...)
+	lastUpdateTime := view.LastUpdate
 	//ヘッダ
 	header := linebot.BoxComponent{
 		Type:   linebot.FlexComponentTypeBox,
@@ -68,19 +73,13 @@ func CreateSpotListBubbleContainer(title, altText string, spotinfos []bikesharea
 		Layout:  linebot.FlexBoxLayoutTypeVertical,
 		Spacing: linebot.FlexComponentSpacingTypeMd,
 	}
-	for _, info := range spotinfos {
-		var listitem string
-		if len(info.Counts) > 0 {
-			listitem = fmt.Sprintf("[%s-%s] %s (%d台)", info.Area, info.Spot, info.Name, info.Counts[0].Count)
-		} else {
-			listitem = fmt.Sprintf("[%s-%s] %s (台数不明)", info.Area, info.Spot, info.Name)
-		}
+	for _, spot := range view.Spots {
 		item := CreateListInnerBox(
-			listitem,
+			spotItemLabel(spot, lang),
 			ColorRegButton,
-			"詳細",
-			"グラフ作成中です。\nしばらくお待ち下さい・・・",
-			GetPostbackDataForAnalyze(info.Area, info.Spot, 2),
+			T(lang, "button.detail"),
+			T(lang, "graph.wait"),
+			GetPostbackDataForAnalyze(spot.Area, spot.Spot, 2),
 		)
 		body.Contents = append(body.Contents,
 			&linebot.SeparatorComponent{Type: linebot.FlexComponentTypeSeparator},
@@ -99,11 +98,22 @@ func CreateSpotListBubbleContainer(title, altText string, spotinfos []bikesharea
 	footer.Contents = append(footer.Contents,
 		&linebot.TextComponent{
 			Type: linebot.FlexComponentTypeText,
-			Text: "「詳細」ボタンをクリックすると時系列グラフを表示します（返信まで2秒程度かかります）",
+			Text: T(lang, "spot.listFooter"),
 			Size: linebot.FlexTextSizeTypeXs,
 			Wrap: true,
 		},
 	)
+	//お気に入りグループなら編集画面へ
+	if data, err := GetPostbackDataFavoriteGroup(view.Group, PostBackCommandModeEdit); view.Group != "" && err == nil {
+		footer.Contents = append(footer.Contents,
+			&linebot.ButtonComponent{
+				Type:   linebot.FlexComponentTypeButton,
+				Margin: linebot.FlexComponentMarginTypeMd,
+				Height: linebot.FlexButtonHeightTypeSm,
+				Action: linebot.NewPostbackAction(T(lang, "favgroup.edit"), data, "", ""),
+			},
+		)
+	}
 	//メッセージをセット
 	container := linebot.BubbleContainer{
 		Type:   linebot.FlexContainerTypeBubble,
@@ -114,6 +124,57 @@ func CreateSpotListBubbleContainer(title, altText string, spotinfos []bikesharea
 	return container
 }
 
+//notifyItemLabel 通知の表示（時刻・曜日と、お気に入り以外を送るならその名前）
+func notifyItemLabel(item NotifyItem, lang Lang) string {
+	label := item.Time + " " + notifyDaysLabel(item, lang)
+	switch {
+	case item.Group != "":
+		return T(lang, "notify.withGroup", label, item.Group)
+	case item.Spot != "":
+		return T(lang, "notify.withGroup", label, item.SpotName)
+	}
+	return label
+}
+
+//notifyDaysLabel 通知する日の表示
+func notifyDaysLabel(item NotifyItem, lang Lang) string {
+	if item.Date != "" {
+		if date, err := time.Parse(NotifyDateLayout, item.Date); err == nil {
+			return T(lang, "notify.once", date.Format("1/2"))
+		}
+	}
+	var label string
+	switch item.Weekdays {
+	case "":
+		label = T(lang, "notify.everyday")
+	case "12345":
+		label = T(lang, "notify.weekdays")
+	case "06":
+		label = T(lang, "notify.weekends")
+	default:
+		//月曜始まりで並べる
+		weekdays := ParseWeekdays(item.Weekdays)
+		var names []string
+		for i := 1; i <= 7; i++ {
+			if weekday := time.Weekday(i % 7); containsWeekday(weekdays, weekday) {
+				names = append(names, weekdayName(weekday, lang))
+			}
+		}
+		label = strings.Join(names, T(lang, "notify.daySeparator"))
+	}
+	if item.SkipHolidays {
+		label += T(lang, "notify.exceptHolidays")
+	} else if item.AsSunday {
+		label += T(lang, "notify.asSundayNote")
+	}
+	return label
+}
+
+//weekdayName 曜日の短い名前
+func weekdayName(weekday time.Weekday, lang Lang) string {
+	return T(lang, "weekday."+strconv.Itoa(int(weekday)))
+}
+
 //CreateListInnerBox リストの中身（台数一覧用）
 func CreateListInnerBox(listitem, buttonColor, buttonCaption, postbackText, postbackData string) linebot.BoxComponent {
 	item := linebot.BoxComponent{
@@ -179,8 +240,8 @@ func CreateListInnerBoxHalf(listitem, buttonColor, buttonCaption, postbackText,
 }
 
 //CreateSpotListCarouselContainer 件数が多いとき用のテンプレート
-func CreateSpotListCarouselContainer(title, altText string, spotinfos []bikeshareapi.SpotInfo) linebot.CarouselContainer {
-	contents := CreateSpotListBubbleContainer(title, altText, spotinfos)
+func CreateSpotListCarouselContainer(view SpotListView) linebot.CarouselContainer {
+	contents := CreateSpotListBubbleContainer(view)
 	container := linebot.CarouselContainer{
 		Type:     linebot.FlexContainerTypeCarousel,
 		Contents: []*linebot.BubbleContainer{&contents},
@@ -189,10 +250,10 @@ func CreateSpotListCarouselContainer(title, altText string, spotinfos []bikeshar
 }
 
 //CreateAnalysisBubbleContainer グラフのコンテナ作成
-func CreateAnalysisBubbleContainer(param TemplateMessageParameter) linebot.BubbleContainer {
+func CreateAnalysisBubbleContainer(param AnalysisView) linebot.BubbleContainer {
 	var label, text, color string
-	label = "お気に入りに登録する"
-	text = "お気に入りに登録しています"
+	label = T(param.Lang, "fav.add")
+	text = T(param.Lang, "fav.adding")
 	color = ColorRegButton
 	postbackdataFavList := GetPostbackDataForFovarite(param.Area, param.Spot, PostBackCommandModeReg)
 	postbackdataDatePicker := GetPostbackDataForDateAnalyze(param.Area, param.Spot)
@@ -243,6 +304,19 @@ func CreateAnalysisBubbleContainer(param TemplateMessageParameter) linebot.Bubbl
 			&linebot.SeparatorComponent{},
 		)
 	}
+	//予測できないときもあるため
+	if param.Forecast != "" {
+		inner.Contents = append(inner.Contents,
+			&linebot.TextComponent{ //台数予測
+				Type:   linebot.FlexComponentTypeText,
+				Text:   param.Forecast,
+				Weight: linebot.FlexTextWeightTypeBold,
+				Size:   linebot.FlexTextSizeTypeSm,
+				Flex:   linebot.IntPtr(1),
+				Wrap:   true,
+			},
+		)
+	}
 	//最終更新日時がないときもあるため
 	if param.LastUpdate != "" {
 		inner.Contents = append(inner.Contents,
@@ -253,11 +327,12 @@ func CreateAnalysisBubbleContainer(param TemplateMessageParameter) linebot.Bubbl
 				Gravity: linebot.FlexComponentGravityTypeBottom,
 				Size:    linebot.FlexTextSizeTypeXs,
 				Flex:    linebot.IntPtr(2),
+				Wrap:    true,
 			},
 		)
 	}
 	//お気に入り未登録ならボタン表示
-	if param.RegButtonVisible {
+	if !param.Favorite {
 		inner.Contents = append(inner.Contents,
 			&linebot.ButtonComponent{
 				Type:   linebot.FlexComponentTypeButton,
@@ -283,7 +358,7 @@ func CreateAnalysisBubbleContainer(param TemplateMessageParameter) linebot.Bubbl
 			Height: linebot.FlexButtonHeightTypeSm,
 			Flex:   linebot.IntPtr(1),
 			Color:  color,
-			Action: linebot.NewDatetimePickerAction("別の日のグラフを表示する", postbackdataDatePicker, "date", "", "2020-12-31", "2019-06-01"),
+			Action: linebot.NewDatetimePickerAction(T(param.Lang, "graph.otherDay"), postbackdataDatePicker, "date", "", "2020-12-31", "2019-06-01"),
 		},
 	)
 	body := linebot.BoxComponent{
@@ -304,7 +379,7 @@ func CreateAnalysisBubbleContainer(param TemplateMessageParameter) linebot.Bubbl
 }
 
 //CreateCommandListBubbleContainer コマンドの一覧画面（履歴一覧やコマンド一覧に使用する）
-func CreateCommandListBubbleContainer(title string, commands []CommandListItem) linebot.BubbleContainer {
+func CreateCommandListBubbleContainer(title string, commands []MenuItem) linebot.BubbleContainer {
 	//ボディ
 	body := linebot.BoxComponent{
 		Type:   linebot.FlexComponentTypeBox,
@@ -312,11 +387,10 @@ func CreateCommandListBubbleContainer(title string, commands []CommandListItem)
 	}
 	for _, item := range commands {
 		var action linebot.TemplateAction
-		switch item.ActionType {
-		case linebot.ActionTypePostback:
-			action = linebot.NewPostbackAction(item.Label, item.Data, "", item.Text)
-		case linebot.ActionTypeMessage:
-			action = linebot.NewMessageAction(item.Label, item.Data)
+		if item.Postback != "" {
+			action = linebot.NewPostbackAction(item.Label, item.Postback, "", item.Text)
+		} else {
+			action = linebot.NewMessageAction(item.Label, item.Message)
 		}
 
 		body.Contents = append(body.Contents,
@@ -344,7 +418,8 @@ func CreateCommandListBubbleContainer(title string, commands []CommandListItem)
 }
 
 //CreateConfigBubbleContainer 設定画面作成
-func CreateConfigBubbleContainer(user *bikeshareapi.Users) linebot.BubbleContainer {
+func CreateConfigBubbleContainer(view ConfigView) linebot.BubbleContainer {
+	lang := view.Lang
 	//ボディ
 	body := linebot.BoxComponent{
 		Type:   linebot.FlexComponentTypeBox,
@@ -353,7 +428,7 @@ func CreateConfigBubbleContainer(user *bikeshareapi.Users) linebot.BubbleContain
 	body.Contents = append(body.Contents,
 		&linebot.TextComponent{
 			Type:   linebot.FlexComponentTypeText,
-			Text:   "ユーザー設定",
+			Text:   T(lang, "config.title"),
 			Align:  linebot.FlexComponentAlignTypeCenter,
 			Weight: linebot.FlexTextWeightTypeBold,
 			Color:  "#1DB446",
@@ -364,20 +439,153 @@ func CreateConfigBubbleContainer(user *bikeshareapi.Users) linebot.BubbleContain
 		},
 		&linebot.TextComponent{
 			Type:   linebot.FlexComponentTypeText,
-			Text:   "お気に入り登録されたスポット",
+			Text:   T(lang, "config.favorites"),
+			Color:  "#aaaaaa",
+			Size:   linebot.FlexTextSizeTypeXs,
+			Margin: linebot.FlexComponentMarginTypeXl,
+		},
+	)
+	for _, favorite := range view.Favorites {
+		item := CreateListInnerBox(
+			fmt.Sprintf("[%s-%s] %s", favorite.Area, favorite.Spot, favorite.Name),
+			ColorUnregButton,
+			T(lang, "button.delete"),
+			T(lang, "fav.deleting"),
+			GetPostbackDataForFovarite(favorite.Area, favorite.Spot, PostBackCommandModeUnreg),
+		)
+		body.Contents = append(body.Contents,
+			&item,
+			&linebot.SeparatorComponent{
+				Color: "#ffffff",
+			},
+		)
+	}
+
+	body.Contents = append(body.Contents,
+		&linebot.SeparatorComponent{
+			Margin: linebot.FlexComponentMarginTypeMd,
+		},
+		&linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   T(lang, "config.favoriteGroups", view.MaxFavoriteGroups),
+			Color:  "#aaaaaa",
+			Size:   linebot.FlexTextSizeTypeXs,
+			Margin: linebot.FlexComponentMarginTypeXl,
+			Wrap:   true,
+		},
+	)
+	for _, group := range view.FavoriteGroups {
+		//ポストバックに収まらない名前のグループは編集できないので出さない
+		data, err := GetPostbackDataFavoriteGroup(group.Name, PostBackCommandModeEdit)
+		if err != nil {
+			continue
+		}
+		item := CreateListInnerBox(
+			T(lang, "favgroup.item", group.Name, group.Count),
+			ColorRegButton,
+			T(lang, "favgroup.editButton"),
+			group.Name,
+			data,
+		)
+		body.Contents = append(body.Contents,
+			&item,
+			&linebot.SeparatorComponent{
+				Color: "#ffffff",
+			},
+		)
+	}
+	if len(view.FavoriteGroups) == 0 {
+		body.Contents = append(body.Contents,
+			&linebot.TextComponent{
+				Type: linebot.FlexComponentTypeText,
+				Text: T(lang, "favgroup.howto"),
+				Size: linebot.FlexTextSizeTypeSm,
+				Wrap: true,
+			},
+		)
+	}
+
+	body.Contents = append(body.Contents,
+		&linebot.SeparatorComponent{
+			Margin: linebot.FlexComponentMarginTypeMd,
+		},
+		&linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   T(lang, "config.notifies", view.MaxNotifies),
+			Color:  "#aaaaaa",
+			Size:   linebot.FlexTextSizeTypeXs,
+			Margin: linebot.FlexComponentMarginTypeXl,
+			Wrap:   true,
+		},
+	)
+	for _, notify := range view.Notifies {
+		item := CreateListInnerBox(
+			notifyItemLabel(notify, lang),
+			ColorRegButton,
+			T(lang, "button.edit"),
+			notify.Time,
+			GetPostbackDataForNotify(PostBackCommandModeEdit, notify.ID, ""),
+		)
+		body.Contents = append(body.Contents,
+			&item,
+			&linebot.SeparatorComponent{
+				Color: "#ffffff",
+			},
+		)
+	}
+	if len(view.Notifies) < view.MaxNotifies {
+		//時刻を選ぶと毎日の通知として登録して編集画面を開く
+		item := CreateListInnerBoxHalf(
+			T(lang, "config.unset"),
+			ColorRegButton,
+			T(lang, "button.register"),
+			T(lang, "button.registering"),
+			GetPostbackDataForNotify(PostBackCommandModeReg, 0, ""),
+		)
+		body.Contents = append(body.Contents,
+			&item,
+			&linebot.SeparatorComponent{
+				Color: "#ffffff",
+			},
+		)
+	}
+	body.Contents = append(body.Contents, createPauseContents(view.Pause, lang)...)
+
+	body.Contents = append(body.Contents,
+		&linebot.SeparatorComponent{
+			Margin: linebot.FlexComponentMarginTypeMd,
+		},
+		&linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   T(lang, "config.alerts", view.MaxAlerts),
 			Color:  "#aaaaaa",
 			Size:   linebot.FlexTextSizeTypeXs,
 			Margin: linebot.FlexComponentMarginTypeXl,
+			Wrap:   true,
 		},
 	)
-	for _, code := range user.Favorites {
-		area, spot := SplitAreaSpot(code)
+	for _, alert := range view.Alerts {
 		item := CreateListInnerBox(
-			fmt.Sprintf("[%s] %s", code, GetPlaceNameByCode(code)),
+			fmt.Sprintf("[%s] %s", alert.Code, alert.Condition),
 			ColorUnregButton,
-			"削除",
-			"お気に入りから削除しています",
-			GetPostbackDataForFovarite(area, spot, PostBackCommandModeUnreg),
+			T(lang, "button.delete"),
+			T(lang, "alert.deleting"),
+			GetPostbackDataForAlert(PostBackCommandModeUnreg, "", "", alert.Key),
+		)
+		body.Contents = append(body.Contents,
+			&item,
+			&linebot.SeparatorComponent{
+				Color: "#ffffff",
+			},
+		)
+	}
+	if len(view.Alerts) < view.MaxAlerts {
+		item := CreateListInnerBox(
+			T(lang, "config.unset"),
+			ColorRegButton,
+			T(lang, "button.register"),
+			T(lang, "alert.registering"),
+			GetPostbackDataForAlert(PostBackCommandModeReg, "", "", ""),
 		)
 		body.Contents = append(body.Contents,
 			&item,
@@ -393,49 +601,418 @@ func CreateConfigBubbleContainer(user *bikeshareapi.Users) linebot.BubbleContain
 		},
 		&linebot.TextComponent{
 			Type:   linebot.FlexComponentTypeText,
-			Text:   "お気に入り登録したスポットの通知時刻の設定（2件まで設定できます）",
+			Text:   T(lang, "config.announce"),
 			Color:  "#aaaaaa",
 			Size:   linebot.FlexTextSizeTypeXs,
 			Margin: linebot.FlexComponentMarginTypeXl,
 			Wrap:   true,
 		},
 	)
-	for i := 0; i < MaxNotifyTimes; i++ {
-		if len(user.Notifies) > i {
-			item := CreateListInnerBoxHalf(
-				user.Notifies[i],
-				ColorUnregButton,
-				"削除",
-				"削除しています",
-				GetPostbackDataForNotify(PostBackCommandModeUnreg, user.Notifies[i]),
-			)
-			body.Contents = append(body.Contents,
-				&item,
-				&linebot.SeparatorComponent{
-					Color: "#ffffff",
-				},
-			)
+	var announce linebot.BoxComponent
+	if view.SpotAnnounce {
+		announce = CreateListInnerBox(
+			T(lang, "announce.enabled"),
+			ColorUnregButton,
+			T(lang, "announce.stop"),
+			T(lang, "announce.stopping"),
+			GetPostbackDataForAnnounce(PostBackCommandModeUnreg),
+		)
+	} else {
+		announce = CreateListInnerBox(
+			T(lang, "announce.disabled"),
+			ColorRegButton,
+			T(lang, "announce.receive"),
+			T(lang, "announce.starting"),
+			GetPostbackDataForAnnounce(PostBackCommandModeReg),
+		)
+	}
+	body.Contents = append(body.Contents, &announce)
+
+	body.Contents = append(body.Contents,
+		&linebot.SeparatorComponent{
+			Margin: linebot.FlexComponentMarginTypeMd,
+		},
+		&linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   T(lang, "config.language", LangName(view.Language, lang)),
+			Color:  "#aaaaaa",
+			Size:   linebot.FlexTextSizeTypeXs,
+			Margin: linebot.FlexComponentMarginTypeXl,
+			Wrap:   true,
+		},
+	)
+	//今の設定以外を切り替えボタンとして並べる
+	for _, choice := range LangChoices {
+		if choice == view.Language {
+			continue
+		}
+		item := CreateListInnerBox(
+			LangName(choice, lang),
+			ColorRegButton,
+			T(lang, "button.switch"),
+			LangName(choice, lang),
+			GetPostbackDataForLanguage(choice),
+		)
+		body.Contents = append(body.Contents,
+			&item,
+			&linebot.SeparatorComponent{
+				Color: "#ffffff",
+			},
+		)
+	}
+
+	//メッセージをセット
+	container := linebot.BubbleContainer{
+		Type: linebot.FlexContainerTypeBubble,
+		Body: &body,
+	}
+	return container
+}
+
+//createPauseContents 設定画面の通知の一時停止の欄
+func createPauseContents(pause PauseItem, lang Lang) []linebot.FlexComponent {
+	contents := []linebot.FlexComponent{
+		&linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   T(lang, "pause.title"),
+			Color:  "#aaaaaa",
+			Size:   linebot.FlexTextSizeTypeXs,
+			Margin: linebot.FlexComponentMarginTypeMd,
+			Wrap:   true,
+		},
+	}
+	addRow := func(label string, kind NotifyPauseKind) {
+		item := CreateListInnerBox(label, ColorUnregButton, T(lang, "pause.resume"), T(lang, "pause.resuming"), GetPostbackDataForPause(PostBackCommandModeUnreg, kind, ""))
+		contents = append(contents,
+			&item,
+			&linebot.SeparatorComponent{
+				Color: "#ffffff",
+			},
+		)
+	}
+	if pause.VacationStart != "" {
+		addRow(T(lang, "pause.vacation", pause.VacationStart, pause.VacationEnd), NotifyPauseVacation)
+	}
+	if pause.QuietStart != "" {
+		addRow(T(lang, "pause.quiet", pause.QuietStart, pause.QuietEnd), NotifyPauseQuiet)
+	}
+	if pause.SnoozeUntil != "" {
+		addRow(T(lang, "pause.snoozed", pause.SnoozeUntil), NotifyPauseSnooze)
+	} else {
+		contents = append(contents, createSnoozeButtons(lang))
+	}
+	contents = append(contents,
+		&linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   T(lang, "pause.howto"),
+			Color:  "#aaaaaa",
+			Size:   linebot.FlexTextSizeTypeXxs,
+			Margin: linebot.FlexComponentMarginTypeSm,
+			Wrap:   true,
+		},
+	)
+	return contents
+}
+
+//createSnoozeButtons すぐに一時停止できるボタン
+func createSnoozeButtons(lang Lang) *linebot.BoxComponent {
+	buttons := linebot.BoxComponent{
+		Type:    linebot.FlexComponentTypeBox,
+		Layout:  linebot.FlexBoxLayoutTypeHorizontal,
+		Spacing: linebot.FlexComponentSpacingTypeXs,
+	}
+	buttons.Contents = append(buttons.Contents,
+		&linebot.TextComponent{
+			Type:    linebot.FlexComponentTypeText,
+			Text:    T(lang, "pause.snooze"),
+			Size:    linebot.FlexTextSizeTypeSm,
+			Wrap:    true,
+			Gravity: linebot.FlexComponentGravityTypeCenter,
+			Flex:    linebot.IntPtr(3),
+		},
+	)
+	for _, days := range []int{1, 3, 7} {
+		buttons.Contents = append(buttons.Contents, createFavoriteGroupButton(T(lang, "pause.days", days), ColorRegButton, GetPostbackDataForPause(PostBackCommandModeReg, NotifyPauseSnooze, fmt.Sprintf("%dd", days))))
+	}
+	return &buttons
+}
+
+//CreateFavoriteGroupBubbleContainer お気に入りグループの編集画面
+func CreateFavoriteGroupBubbleContainer(view FavoriteGroupView) linebot.BubbleContainer {
+	lang := view.Lang
+	//ボディ
+	body := linebot.BoxComponent{
+		Type:    linebot.FlexComponentTypeBox,
+		Layout:  linebot.FlexBoxLayoutTypeVertical,
+		Spacing: linebot.FlexComponentSpacingTypeSm,
+	}
+	body.Contents = append(body.Contents,
+		&linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   T(lang, "favgroup.editTitle", view.Name),
+			Weight: linebot.FlexTextWeightTypeBold,
+			Color:  "#1DB446",
+			Size:   linebot.FlexTextSizeTypeLg,
+			Wrap:   true,
+		},
+		&linebot.TextComponent{
+			Type:  linebot.FlexComponentTypeText,
+			Text:  T(lang, "favgroup.editHint", view.Name, view.MaxSpots),
+			Color: "#aaaaaa",
+			Size:  linebot.FlexTextSizeTypeXs,
+			Wrap:  true,
+		},
+		&linebot.SeparatorComponent{
+			Margin: linebot.FlexComponentMarginTypeMd,
+		},
+	)
+	//ポストバックに収まらないボタンは空白にする（グループ名が長すぎるとき）
+	filler := &linebot.FillerComponent{Type: linebot.FlexComponentTypeFiller, Flex: linebot.IntPtr(2)}
+	groupButton := func(label, color string, mode PostBackCommandMode, area, spot string) linebot.FlexComponent {
+		data, err := GetPostbackDataForFavoriteGroup(mode, view.Name, area, spot)
+		if err != nil {
+			return filler
+		}
+		return createFavoriteGroupButton(label, color, data)
+	}
+	for i, spot := range view.Spots {
+		item := linebot.BoxComponent{
+			Type:    linebot.FlexComponentTypeBox,
+			Layout:  linebot.FlexBoxLayoutTypeHorizontal,
+			Spacing: linebot.FlexComponentSpacingTypeXs,
+		}
+		item.Contents = append(item.Contents,
+			&linebot.TextComponent{
+				Type:    linebot.FlexComponentTypeText,
+				Text:    fmt.Sprintf("%d. [%s-%s] %s", i+1, spot.Area, spot.Spot, spot.Name),
+				Size:    linebot.FlexTextSizeTypeSm,
+				Wrap:    true,
+				Gravity: linebot.FlexComponentGravityTypeCenter,
+				Flex:    linebot.IntPtr(7),
+			},
+		)
+		//先頭は上へ、末尾は下へ動かせない
+		if i > 0 {
+			item.Contents = append(item.Contents, groupButton("↑", "", PostBackCommandModeUp, spot.Area, spot.Spot))
 		} else {
-			item := CreateListInnerBoxHalf(
-				"未登録",
-				ColorRegButton,
-				"新規登録",
-				" 登録しています",
-				GetPostbackDataForNotify(PostBackCommandModeReg, ""),
-			)
-			body.Contents = append(body.Contents,
-				&item,
-				&linebot.SeparatorComponent{
-					Color: "#ffffff",
-				},
-			)
+			item.Contents = append(item.Contents, filler)
 		}
+		if i < len(view.Spots)-1 {
+			item.Contents = append(item.Contents, groupButton("↓", "", PostBackCommandModeDown, spot.Area, spot.Spot))
+		} else {
+			item.Contents = append(item.Contents, filler)
+		}
+		item.Contents = append(item.Contents, groupButton("×", ColorUnregButton, PostBackCommandModeUnreg, spot.Area, spot.Spot))
+		body.Contents = append(body.Contents, &item)
 	}
 
+	//フッター
+	footer := linebot.BoxComponent{
+		Type:    linebot.FlexComponentTypeBox,
+		Layout:  linebot.FlexBoxLayoutTypeHorizontal,
+		Spacing: linebot.FlexComponentSpacingTypeSm,
+	}
+	if data, err := GetPostbackDataFavoriteGroup(view.Name, ""); err == nil {
+		footer.Contents = append(footer.Contents, &linebot.ButtonComponent{
+			Type:   linebot.FlexComponentTypeButton,
+			Style:  linebot.FlexButtonStyleTypePrimary,
+			Height: linebot.FlexButtonHeightTypeSm,
+			Color:  ColorRegButton,
+			Action: linebot.NewPostbackAction(T(lang, "favgroup.show"), data, "", ""),
+		})
+	}
+	if data, err := GetPostbackDataForFavoriteGroup(PostBackCommandModeDelete, view.Name, "", ""); err == nil {
+		footer.Contents = append(footer.Contents, &linebot.ButtonComponent{
+			Type:   linebot.FlexComponentTypeButton,
+			Style:  linebot.FlexButtonStyleTypePrimary,
+			Height: linebot.FlexButtonHeightTypeSm,
+			Color:  ColorUnregButton,
+			Action: linebot.NewPostbackAction(T(lang, "favgroup.deleteGroup"), data, "", ""),
+		})
+	}
 	//メッセージをセット
 	container := linebot.BubbleContainer{
 		Type: linebot.FlexContainerTypeBubble,
 		Body: &body,
 	}
+	//中身のないフッターはLINEに拒否される
+	if len(footer.Contents) > 0 {
+		container.Footer = &footer
+	}
+	return container
+}
+
+//createFavoriteGroupButton 編集画面の小さいボタン
+func createFavoriteGroupButton(label, color, postbackData string) *linebot.ButtonComponent {
+	button := &linebot.ButtonComponent{
+		Type:   linebot.FlexComponentTypeButton,
+		Style:  linebot.FlexButtonStyleTypeSecondary,
+		Height: linebot.FlexButtonHeightTypeSm,
+		Flex:   linebot.IntPtr(2),
+		Action: linebot.NewPostbackAction(label, postbackData, "", ""),
+	}
+	if color != "" {
+		button.Style = linebot.FlexButtonStyleTypePrimary
+		button.Color = color
+	}
+	return button
+}
+
+//CreateNotifyScheduleBubbleContainer 通知の編集画面
+func CreateNotifyScheduleBubbleContainer(view NotifyScheduleView) linebot.BubbleContainer {
+	lang := view.Lang
+	weekdays := ParseWeekdays(view.Weekdays)
+	caption := func(text string) *linebot.TextComponent {
+		return &linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   text,
+			Color:  "#aaaaaa",
+			Size:   linebot.FlexTextSizeTypeXs,
+			Margin: linebot.FlexComponentMarginTypeLg,
+			Wrap:   true,
+		}
+	}
+	//ボディ
+	body := linebot.BoxComponent{
+		Type:    linebot.FlexComponentTypeBox,
+		Layout:  linebot.FlexBoxLayoutTypeVertical,
+		Spacing: linebot.FlexComponentSpacingTypeSm,
+	}
+	body.Contents = append(body.Contents,
+		&linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   T(lang, "notify.editTitle", view.Time),
+			Weight: linebot.FlexTextWeightTypeBold,
+			Color:  "#1DB446",
+			Size:   linebot.FlexTextSizeTypeLg,
+			Wrap:   true,
+		},
+		&linebot.TextComponent{
+			Type: linebot.FlexComponentTypeText,
+			Text: notifyItemLabel(view.NotifyItem, lang),
+			Size: linebot.FlexTextSizeTypeSm,
+			Wrap: true,
+		},
+		&linebot.SeparatorComponent{
+			Margin: linebot.FlexComponentMarginTypeMd,
+		},
+		caption(T(lang, "notify.days")),
+	)
+
+	//曜日は押すたびに入れたり外したりする（月曜始まりで並べる）
+	days := linebot.BoxComponent{
+		Type:    linebot.FlexComponentTypeBox,
+		Layout:  linebot.FlexBoxLayoutTypeHorizontal,
+		Spacing: linebot.FlexComponentSpacingTypeXs,
+	}
+	for i := 1; i <= 7; i++ {
+		weekday := time.Weekday(i % 7)
+		color := ""
+		if len(weekdays) == 0 || containsWeekday(weekdays, weekday) {
+			color = ColorRegButton
+		}
+		button := createFavoriteGroupButton(weekdayName(weekday, lang), color, GetPostbackDataForNotify(PostBackCommandModeDays, view.ID, toggleWeekday(weekdays, weekday)))
+		button.Flex = linebot.IntPtr(1)
+		days.Contents = append(days.Contents, button)
+	}
+	presets := linebot.BoxComponent{
+		Type:    linebot.FlexComponentTypeBox,
+		Layout:  linebot.FlexBoxLayoutTypeHorizontal,
+		Spacing: linebot.FlexComponentSpacingTypeXs,
+	}
+	presets.Contents = append(presets.Contents,
+		createFavoriteGroupButton(T(lang, "notify.everyday"), "", GetPostbackDataForNotify(PostBackCommandModeDays, view.ID, "")),
+		createFavoriteGroupButton(T(lang, "notify.weekdays"), "", GetPostbackDataForNotify(PostBackCommandModeDays, view.ID, "12345")),
+		createFavoriteGroupButton(T(lang, "notify.weekends"), "", GetPostbackDataForNotify(PostBackCommandModeDays, view.ID, "06")),
+	)
+	body.Contents = append(body.Contents, &days, &presets)
+
+	//祝日（送る→送らない→日曜扱い→送るの順に切り替える）
+	body.Contents = append(body.Contents, caption(T(lang, "notify.holidays")))
+	var holidays linebot.BoxComponent
+	switch {
+	case view.SkipHolidays:
+		holidays = CreateListInnerBox(T(lang, "notify.holidaysSkip"), ColorRegButton, T(lang, "button.switch"), T(lang, "notify.asSunday"),
+			GetPostbackDataForNotify(PostBackCommandModeHoliday, view.ID, NotifyHolidaysAsSunday))
+	case view.AsSunday:
+		holidays = CreateListInnerBox(T(lang, "notify.asSunday"), ColorRegButton, T(lang, "button.switch"), T(lang, "notify.holidaysSend"),
+			GetPostbackDataForNotify(PostBackCommandModeHoliday, view.ID, NotifyHolidaysSend))
+	default:
+		holidays = CreateListInnerBox(T(lang, "notify.holidaysSend"), ColorRegButton, T(lang, "button.switch"), T(lang, "notify.holidaysSkip"),
+			GetPostbackDataForNotify(PostBackCommandModeHoliday, view.ID, NotifyHolidaysSkip))
+	}
+	body.Contents = append(body.Contents, &holidays)
+
+	//繰り返し
+	body.Contents = append(body.Contents, caption(T(lang, "notify.repeat")))
+	var repeat linebot.BoxComponent
+	if view.Date != "" {
+		repeat = CreateListInnerBox(notifyDaysLabel(view.NotifyItem, lang), ColorRegButton, T(lang, "button.switch"), T(lang, "notify.repeatWeekly"),
+			GetPostbackDataForNotify(PostBackCommandModeOnce, view.ID, ""))
+	} else {
+		repeat = CreateListInnerBox(T(lang, "notify.repeatWeekly"), ColorRegButton, T(lang, "button.switch"), T(lang, "notify.repeatTomorrow"),
+			GetPostbackDataForNotify(PostBackCommandModeOnce, view.ID, "on"))
+	}
+	body.Contents = append(body.Contents, &repeat)
+
+	//送る内容はクイックリプライで選ぶ
+	target := T(lang, "notify.allFavorites")
+	switch {
+	case view.Group != "":
+		target = view.Group
+	case view.Spot != "":
+		target = fmt.Sprintf("[%s] %s", view.Spot, view.SpotName)
+	}
+	body.Contents = append(body.Contents,
+		caption(T(lang, "notify.target")),
+		&linebot.TextComponent{
+			Type: linebot.FlexComponentTypeText,
+			Text: target,
+			Size: linebot.FlexTextSizeTypeSm,
+			Wrap: true,
+		},
+		&linebot.TextComponent{
+			Type:  linebot.FlexComponentTypeText,
+			Text:  T(lang, "notify.targetHint"),
+			Color: "#aaaaaa",
+			Size:  linebot.FlexTextSizeTypeXxs,
+			Wrap:  true,
+		},
+	)
+
+	//フッター
+	footer := linebot.BoxComponent{
+		Type:    linebot.FlexComponentTypeBox,
+		Layout:  linebot.FlexBoxLayoutTypeHorizontal,
+		Spacing: linebot.FlexComponentSpacingTypeSm,
+	}
+	footer.Contents = append(footer.Contents,
+		&linebot.ButtonComponent{
+			Type:   linebot.FlexComponentTypeButton,
+			Style:  linebot.FlexButtonStyleTypePrimary,
+			Height: linebot.FlexButtonHeightTypeSm,
+			Color:  ColorRegButton,
+			Action: linebot.NewDatetimePickerAction(T(lang, "notify.changeTime"), GetPostbackDataForNotify(PostBackCommandModeTime, view.ID, ""), "time", view.Time, "", ""),
+		},
+		&linebot.ButtonComponent{
+			Type:   linebot.FlexComponentTypeButton,
+			Style:  linebot.FlexButtonStyleTypePrimary,
+			Height: linebot.FlexButtonHeightTypeSm,
+			Color:  ColorUnregButton,
+			Action: linebot.NewPostbackAction(T(lang, "button.delete"), GetPostbackDataForNotify(PostBackCommandModeUnreg, view.ID, ""), "", T(lang, "button.deleting")),
+		},
+		&linebot.ButtonComponent{
+			Type:   linebot.FlexComponentTypeButton,
+			Style:  linebot.FlexButtonStyleTypeSecondary,
+			Height: linebot.FlexButtonHeightTypeSm,
+			Action: linebot.NewPostbackAction(T(lang, "config.back"), GetPostbackDataConfigOpen(), "", ""),
+		},
+	)
+	//メッセージをセット
+	container := linebot.BubbleContainer{
+		Type:   linebot.FlexContainerTypeBubble,
+		Body:   &body,
+		Footer: &footer,
+	}
 	return container
 }
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bikeshareapi "github.com/8245snake/bikeshare-client"
+	"github.com/8245snake/bikeshare-line/search"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+const (
+	//TripCandidates 借りる・返すスポットの候補数
+	TripCandidates = 3
+	//TripFewBikes これより少ない台数のスポットは借りる候補として後回しにする
+	TripFewBikes = 3
+	//TripSessionTimeout 2地点の位置情報を待つ時間
+	TripSessionTimeout = 10 * time.Minute
+)
+
+//tripPattern 「AからB」「AからBまで」
+var tripPattern = regexp.MustCompile(`^\s*(.+?)\s*から\s*(.+?)\s*(?:まで)?\s*$`)
+
+//TripPoint 出発地・目的地
+type TripPoint struct {
+	Label    string
+	Lat, Lon float64
+}
+
+//tripCandidate 借りる・返すスポットの候補
+type tripCandidate struct {
+	Info     bikeshareapi.SpotInfo
+	Distance float64
+}
+
+//tripSession 位置情報で出発地と目的地を続けて受け取るための状態
+type tripSession struct {
+	Origin  *TripPoint
+	Expires time.Time
+}
+
+var (
+	tripSessionsMu sync.Mutex
+	tripSessions   = make(map[string]tripSession)
+)
+
+//ParseTripQuery 「AからB」をパースする
+//AとBがどちらもスポットの検索で見つかるときだけルート検索とし、
+//「から」を含むスポット名やそれ以外の言葉は駐輪場検索に回す
+func ParseTripQuery(text string) (from string, to string, ok bool) {
+	match := tripPattern.FindStringSubmatch(text)
+	if match == nil || match[1] == "" || match[2] == "" {
+		return "", "", false
+	}
+	index := GetSpotSearchIndex()
+	//全体がスポット名の一部ならスポットを探している
+	if hits := index.Search(text, 1); len(hits) > 0 && strings.Contains(search.Normalize(hits[0].Name), search.Normalize(text)) {
+		return "", "", false
+	}
+	if len(index.Search(match[1], 1)) == 0 || len(index.Search(match[2], 1)) == 0 {
+		return "", "", false
+	}
+	return match[1], match[2], true
+}
+
+//ResolveTripPoint スポット名の検索で一番近いスポットの位置を地点とする
+func ResolveTripPoint(ctx context.Context, query string) (TripPoint, bool) {
+	hits := GetSpotSearchIndex().Search(query, 1)
+	if len(hits) == 0 {
+		return TripPoint{}, false
+	}
+	spotinfos, err := BikeshareAPI.GetPlacesContext(ctx, bikeshareapi.SearchPlacesOption{Places: []string{hits[0].Code}})
+	if err != nil || len(spotinfos) == 0 {
+		return TripPoint{}, false
+	}
+	info := spotinfos[0]
+	return TripPoint{Label: fmt.Sprintf("%s（[%s-%s] %s）", query, info.Area, info.Spot, info.Name), Lat: info.Lat, Lon: info.Lon}, true
+}
+
+//StartTripSession 出発地の位置情報を待つ
+func StartTripSession(userID string) {
+	tripSessionsMu.Lock()
+	defer tripSessionsMu.Unlock()
+	tripSessions[userID] = tripSession{Expires: time.Now().Add(TripSessionTimeout)}
+}
+
+//AdvanceTripSession 位置情報を受け取って状態を進める
+//出発地を受け取ったときはorigin=nil、目的地を受け取ったときは出発地を返す。待っていなければok=false
+func AdvanceTripSession(userID string, point TripPoint) (origin *TripPoint, ok bool) {
+	tripSessionsMu.Lock()
+	defer tripSessionsMu.Unlock()
+	session, ok := tripSessions[userID]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(session.Expires) {
+		delete(tripSessions, userID)
+		return nil, false
+	}
+	if session.Origin == nil {
+		session.Origin = &point
+		session.Expires = time.Now().Add(TripSessionTimeout)
+		tripSessions[userID] = session
+		return nil, true
+	}
+	delete(tripSessions, userID)
+	return session.Origin, true
+}
+
+//MakeTripPlanMessageForQuery 「AからB」への返信
+func MakeTripPlanMessageForQuery(ctx context.Context, from, to string, lang Lang) linebot.SendingMessage {
+	origin, ok := ResolveTripPoint(ctx, from)
+	if !ok {
+		return linebot.NewTextMessage(T(lang, "trip.originNotFound", from))
+	}
+	dest, ok := ResolveTripPoint(ctx, to)
+	if !ok {
+		return linebot.NewTextMessage(T(lang, "trip.destNotFound", to))
+	}
+	return MakeTripPlanMessage(ctx, origin, dest, lang)
+}
+
+//MakeTripPlanMessage 出発地の近くで借りるスポットと目的地の近くで返すスポットを提案する
+func MakeTripPlanMessage(ctx context.Context, origin, dest TripPoint, lang Lang) linebot.SendingMessage {
+	if origin.Label == "" {
+		origin.Label = T(lang, "trip.origin")
+	}
+	if dest.Label == "" {
+		dest.Label = T(lang, "trip.dest")
+	}
+	rent, err := findTripCandidates(ctx, origin)
+	if err != nil {
+		return RenderLine(apiErrorView(ctx, lang, "search.failed", err))
+	}
+	ret, err := findTripCandidates(ctx, dest)
+	if err != nil {
+		return RenderLine(apiErrorView(ctx, lang, "search.failed", err))
+	}
+	//借りるスポットは台数が少ないところを後回しにして近い順
+	sort.SliceStable(rent, func(i, j int) bool {
+		fewI := tripCount(rent[i]) < TripFewBikes
+		fewJ := tripCount(rent[j]) < TripFewBikes
+		if fewI != fewJ {
+			return !fewI
+		}
+		return rent[i].Distance < rent[j].Distance
+	})
+	//返すスポットは近い順
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Distance < ret[j].Distance })
+	if len(rent) > TripCandidates {
+		rent = rent[:TripCandidates]
+	}
+	if len(ret) > TripCandidates {
+		ret = ret[:TripCandidates]
+	}
+	if len(rent) == 0 || len(ret) == 0 {
+		return linebot.NewTextMessage(T(lang, "trip.noSpots"))
+	}
+	container := CreateTripBubbleContainer(origin, dest, rent, ret, lang)
+	return linebot.NewFlexMessage(T(lang, "trip.title"), &container)
+}
+
+//findTripCandidates 地点の近くのスポットと徒歩距離
+func findTripCandidates(ctx context.Context, point TripPoint) ([]tripCandidate, error) {
+	distances, err := BikeshareAPI.GetDistancesContext(ctx, bikeshareapi.SearchDistanceOption{Lat: point.Lat, Lon: point.Lon})
+	if err != nil {
+		return nil, err
+	}
+	var candidates []tripCandidate
+	for _, item := range distances.Spots {
+		candidates = append(candidates, tripCandidate{
+			Info:     item.SpotInfo,
+			Distance: distanceMeters(point.Lat, point.Lon, item.SpotInfo.Lat, item.SpotInfo.Lon),
+		})
+	}
+	return candidates, nil
+}
+
+//tripCount 現在の台数（不明なら0）
+func tripCount(candidate tripCandidate) int {
+	if len(candidate.Info.Counts) == 0 {
+		return 0
+	}
+	return candidate.Info.Counts[0].Count
+}
+
+//tripCandidateText 候補の表示用文字列
+func tripCandidateText(candidate tripCandidate, lang Lang) string {
+	info := candidate.Info
+	count := T(lang, "spot.bikesUnknown")
+	if len(info.Counts) > 0 {
+		count = T(lang, "spot.bikes", info.Counts[0].Count)
+	}
+	return T(lang, "trip.candidate", info.Area, info.Spot, info.Name, int(candidate.Distance), count)
+}
+
+//CreateTripBubbleContainer ルート検索結果のテンプレート作成
+func CreateTripBubbleContainer(origin, dest TripPoint, rent, ret []tripCandidate, lang Lang) linebot.BubbleContainer {
+	//ヘッダ
+	header := linebot.BoxComponent{
+		Type:   linebot.FlexComponentTypeBox,
+		Layout: linebot.FlexBoxLayoutTypeVertical,
+	}
+	header.Contents = append(header.Contents,
+		&linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   T(lang, "trip.title"),
+			Weight: linebot.FlexTextWeightTypeBold,
+			Color:  "#aaaaaa",
+			Size:   linebot.FlexTextSizeTypeMd,
+		},
+		&linebot.TextComponent{
+			Type: linebot.FlexComponentTypeText,
+			Text: fmt.Sprintf("%s\n↓\n%s", origin.Label, dest.Label),
+			Size: linebot.FlexTextSizeTypeSm,
+			Wrap: true,
+		},
+	)
+
+	//ボディ
+	body := linebot.BoxComponent{
+		Type:    linebot.FlexComponentTypeBox,
+		Layout:  linebot.FlexBoxLayoutTypeVertical,
+		Spacing: linebot.FlexComponentSpacingTypeMd,
+	}
+	sections := []struct {
+		title      string
+		candidates []tripCandidate
+	}{
+		{T(lang, "trip.rent"), rent},
+		{T(lang, "trip.return"), ret},
+	}
+	for _, section := range sections {
+		body.Contents = append(body.Contents,
+			&linebot.TextComponent{
+				Type:   linebot.FlexComponentTypeText,
+				Text:   section.title,
+				Weight: linebot.FlexTextWeightTypeBold,
+				Color:  "#1DB446",
+				Size:   linebot.FlexTextSizeTypeSm,
+			},
+		)
+		for _, candidate := range section.candidates {
+			item := CreateListInnerBox(
+				tripCandidateText(candidate, lang),
+				ColorRegButton,
+				T(lang, "button.detail"),
+				T(lang, "graph.wait"),
+				GetPostbackDataForAnalyze(candidate.Info.Area, candidate.Info.Spot, 2),
+			)
+			body.Contents = append(body.Contents,
+				&linebot.SeparatorComponent{Type: linebot.FlexComponentTypeSeparator},
+				&item,
+			)
+		}
+		body.Contents = append(body.Contents,
+			&linebot.SeparatorComponent{Type: linebot.FlexComponentTypeSeparator},
+		)
+	}
+
+	//メッセージをセット
+	container := linebot.BubbleContainer{
+		Type:   linebot.FlexContainerTypeBubble,
+		Header: &header,
+		Body:   &body,
+	}
+	return container
+}
+
+//tripLocationLabel 位置情報メッセージの地点名（なければ空文字）
+func tripLocationLabel(message *linebot.LocationMessage) string {
+	if label := strings.TrimSpace(message.Title); label != "" {
+		return label
+	}
+	if label := strings.TrimSpace(message.Address); label != "" {
+		return label
+	}
+	return ""
+}
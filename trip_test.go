@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestParseTripQuery(t *testing.T) {
+	SetSpotNames(map[string]string{
+		"A1-01": "千代田区役所",
+		"A1-02": "東京駅八重洲口",
+		"B2-01": "中央区役所",
+		"B2-02": "からす森公園",
+		"C3-01": "新橋駅前",
+		"C3-02": "汐留から坂",
+	})
+	defer SetSpotNames(nil)
+
+	tests := []struct {
+		text     string
+		from, to string
+		ok       bool
+	}{
+		{"東京駅から新橋", "東京駅", "新橋", true},
+		{"千代田区役所 から 新橋駅前まで", "千代田区役所", "新橋駅前", true},
+		{"a1-1からc3-1", "a1-1", "c3-1", true},
+		//どちらかが見つからなければ駐輪場検索
+		{"東京駅から大阪", "", "", false},
+		{"さっきから探してる", "", "", false},
+		//「から」を含むスポット名
+		{"からす森公園", "", "", false},
+		{"汐留から坂", "", "", false},
+		{"東京駅", "", "", false},
+	}
+	for _, tt := range tests {
+		from, to, ok := ParseTripQuery(tt.text)
+		if from != tt.from || to != tt.to || ok != tt.ok {
+			t.Errorf("ParseTripQuery(%q) = %q, %q, %v, want %q, %q, %v", tt.text, from, to, ok, tt.from, tt.to, tt.ok)
+		}
+	}
+}
@@ -1,7 +1,8 @@
 package main
 
 import (
-	"sync"
+	"context"
+	"time"
 
 	bikeshareapi "github.com/8245snake/bikeshare-client"
 )
@@ -24,16 +25,61 @@ const (
 	UserUpdateTypeFavoriteDelete UserUpdateType = "d_favorite"
 	//UserUpdateTypeNotifyDelete 通知時刻
 	UserUpdateTypeNotifyDelete UserUpdateType = "d_notify"
+	//UserUpdateTypeAlert 台数アラート
+	UserUpdateTypeAlert UserUpdateType = "u_alert"
+	//UserUpdateTypeAlertDelete 台数アラート
+	UserUpdateTypeAlertDelete UserUpdateType = "d_alert"
+	//UserUpdateTypeAnnounce スポットのお知らせを受け取る
+	UserUpdateTypeAnnounce UserUpdateType = "u_announce"
+	//UserUpdateTypeAnnounceDelete スポットのお知らせを受け取らない
+	UserUpdateTypeAnnounceDelete UserUpdateType = "d_announce"
+	//UserUpdateTypeLanguage 表示言語（空文字はLINEの設定に合わせる）
+	UserUpdateTypeLanguage UserUpdateType = "u_language"
 )
 
-//UserConfigs ユーザー設定
-var UserConfigs []bikeshareapi.Users
+//UserConfig ユーザー設定（APIのユーザ情報にボット独自の設定を加えたもの）
+type UserConfig struct {
+	bikeshareapi.Users
+	//Alerts 台数アラート
+	Alerts []SpotAlert `json:",omitempty"`
+	//SpotAnnounce お気に入りの近くのスポットの新設・お気に入りの廃止や名称変更を知らせる
+	SpotAnnounce bool `json:",omitempty"`
+	//Language 設定画面で選んだ表示言語（空文字ならProfileLanguageに従う）
+	Language string `json:",omitempty"`
+	//ProfileLanguage LINEのプロフィールの言語設定
+	ProfileLanguage string `json:",omitempty"`
+	//FavoriteGroups 名前を付けたお気に入り（Favoritesとは別に持つ）
+	FavoriteGroups []FavoriteGroup `json:",omitempty"`
+	//NotifyGroups 通知時刻ごとに送るお気に入りグループ（旧形式。読み込んだらSchedulesに移行する）
+	NotifyGroups map[string]string `json:",omitempty"`
+	//Schedules 通知のルール（Notifiesはここから作る時刻の一覧）
+	//移行済みかどうかをnilと空で見分けるので、空でも省略せずに保存する
+	Schedules []NotifySchedule
+	//NotifyPause 通知の一時停止
+	NotifyPause
+}
+
+//NewUserConfig 空のユーザー設定
+func NewUserConfig(userID string) UserConfig {
+	return UserConfig{Users: bikeshareapi.Users{LineID: userID}}
+}
+
+var (
+	//UserConfigs ユーザー設定
+	UserConfigs = NewUserCache()
+	//UserStorage ユーザー設定の保存先
+	UserStorage UserStore
+)
 
 //CacheUsrConfigs ユーザー設定を変数に格納
 func CacheUsrConfigs() error {
 	//ユーザ情報をキャッシュ
-	if user, err := BikeshareAPI.GetUsers(); err == nil {
-		UserConfigs = user
+	if users, err := UserStorage.List(); err == nil {
+		//旧形式の通知時刻はキャッシュの上で移行しておく（保存は次に更新したとき）
+		for i := range users {
+			MigrateNotifySchedules(&users[i])
+		}
+		UserConfigs.ReplaceAll(users)
 	} else {
 		return err
 	}
@@ -41,46 +87,85 @@ func CacheUsrConfigs() error {
 }
 
 //GetUserConfigFromCache キャッシュから設定を取得（nilが返る可能性がある）
-func GetUserConfigFromCache(userID string) *bikeshareapi.Users {
-	for _, user := range UserConfigs {
-		if user.LineID == userID {
-			return &user
-		}
+//返り値はコピーなので書き換えてもキャッシュには反映されない
+func GetUserConfigFromCache(userID string) *UserConfig {
+	if user, ok := UserConfigs.Get(userID); ok {
+		return &user
 	}
 	return nil
 }
 
 //UpdateUserConfig ユーザー情報を更新
-func UpdateUserConfig(updateType UserUpdateType, UsaerID string, value string) {
-	//排他制御する
-	var mu sync.Mutex
-	mu.Lock()
-	defer mu.Unlock()
-	//ユーザー設定を取得
-	user := GetUserConfigFromCache(UsaerID)
-	if user == nil {
-		user = &bikeshareapi.Users{LineID: UsaerID}
-	}
-	switch updateType {
-	case UserUpdateTypeUserAdd:
-		//なにもしない
-	case UserUpdateTypeHistory:
-		user.Histories = AddList(user.Histories, value, MaxHistory)
-	case UserUpdateTypeNotify:
-		user.Notifies = AddList(user.Notifies, value, MaxNotifyTimes)
-	case UserUpdateTypeFavorite:
-		user.Favorites = AddList(user.Favorites, value, MaxFavorite)
-	case UserUpdateTypeHistoryDelete:
-		user.Histories = RemoveList(user.Histories, value)
-	case UserUpdateTypeNotifyDelete:
-		user.Notifies = RemoveList(user.Notifies, value)
-	case UserUpdateTypeFavoriteDelete:
-		user.Favorites = RemoveList(user.Favorites, value)
+func UpdateUserConfig(updateType UserUpdateType, UsaerID string, value string) error {
+	return UpdateUserConfigFunc(UsaerID, func(user *UserConfig) {
+		switch updateType {
+		case UserUpdateTypeUserAdd:
+			//なにもしない
+		case UserUpdateTypeHistory:
+			user.Histories = AddList(user.Histories, value, MaxHistory)
+		case UserUpdateTypeNotify:
+			if len(user.Schedules) < MaxNotifySchedules {
+				user.Schedules = AddNotifySchedule(user.Schedules, NotifySchedule{Time: value})
+				syncNotifyTimes(user)
+			}
+		case UserUpdateTypeFavorite:
+			user.Favorites = AddList(user.Favorites, value, MaxFavorite)
+		case UserUpdateTypeHistoryDelete:
+			user.Histories = RemoveList(user.Histories, value)
+		case UserUpdateTypeNotifyDelete:
+			RemoveNotifySchedule(user, value)
+		case UserUpdateTypeFavoriteDelete:
+			user.Favorites = RemoveList(user.Favorites, value)
+		case UserUpdateTypeAlert:
+			if alert, err := ParseSpotAlert(value); err == nil {
+				user.Alerts = AddAlert(user.Alerts, alert, MaxAlerts)
+			}
+		case UserUpdateTypeAlertDelete:
+			user.Alerts = RemoveAlert(user.Alerts, value)
+		case UserUpdateTypeAnnounce:
+			user.SpotAnnounce = true
+		case UserUpdateTypeAnnounceDelete:
+			user.SpotAnnounce = false
+		case UserUpdateTypeLanguage:
+			user.Language = string(ParseLang(value))
+		}
+	})
+}
+
+//UpdateUserConfigFunc ユーザー情報をコールバックで書き換えて保存する
+//保存できなかったときはここでログに出すので、呼び出し側はエラーを利用者に伝えるかだけ決めればよい
+func UpdateUserConfigFunc(userID string, fn func(user *UserConfig)) error {
+	//同じユーザーの更新は直列にする（別のユーザーは並行して更新できる）
+	unlock := UserConfigs.LockUser(userID)
+	defer unlock()
+	//保存先で読み込みから書き込みまで行う
+	user, err := UserStorage.Update(userID, func(user *UserConfig) {
+		//旧形式の通知時刻の移行と、済んだ1回だけの通知や一時停止の片付けもついでに行う
+		now := time.Now().In(LocationTokyo)
+		MigrateNotifySchedules(user)
+		PruneNotifySchedules(user, now)
+		PruneNotifyPause(user, now)
+		fn(user)
+	})
+	if err != nil {
+		LogError(context.Background(), "ユーザー設定を保存できませんでした", LogFields{"error": err, "user": HashUserID(userID)})
+		return err
 	}
-	//送信したらレスポンスのデータで内部変数を更新
-	if users, err := BikeshareAPI.UpdateUser(*user); err == nil {
-		UserConfigs = users
+	//保存できたら内部変数を更新
+	UserConfigs.Set(user)
+	return nil
+}
+
+//DeleteUserConfig ユーザー（グループ）設定を削除する
+func DeleteUserConfig(userID string) error {
+	unlock := UserConfigs.LockUser(userID)
+	defer unlock()
+	if err := UserStorage.Delete(userID); err != nil {
+		LogError(context.Background(), "ユーザー設定を削除できませんでした", LogFields{"error": err, "user": HashUserID(userID)})
+		return err
 	}
+	UserConfigs.Delete(userID)
+	return nil
 }
 
 //AddList 検索履歴を先頭に追加したスライスを返す
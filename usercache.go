@@ -0,0 +1,194 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+//UserCache LINEのユーザーIDをキーにしたユーザー設定のキャッシュ
+//取り出した値はコピーなので呼び出し側で書き換えてもキャッシュには影響しない
+type UserCache struct {
+	mu    sync.RWMutex
+	users map[string]UserConfig
+	//slackIDs SlackのユーザーIDから連携しているLINEのユーザーIDを引く索引
+	slackIDs map[string]string
+	locks    keyedMutex
+}
+
+//NewUserCache コンストラクタ
+func NewUserCache() *UserCache {
+	return &UserCache{users: make(map[string]UserConfig), slackIDs: make(map[string]string)}
+}
+
+//Get ユーザー設定のコピーを取得する
+func (cache *UserCache) Get(userID string) (UserConfig, bool) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	user, ok := cache.users[userID]
+	if !ok {
+		return UserConfig{}, false
+	}
+	return copyUser(user), true
+}
+
+//FindBySlackID Slackユーザーと連携しているLINEユーザーの設定のコピーを取得する
+//Slack専用の設定（SlackUserKeyPrefixで始まるキー）は含まない
+func (cache *UserCache) FindBySlackID(slackID string) (UserConfig, bool) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	user, ok := cache.users[cache.slackIDs[slackID]]
+	if !ok || slackID == "" {
+		return UserConfig{}, false
+	}
+	return copyUser(user), true
+}
+
+//List すべてのユーザー設定のコピーを取得する
+func (cache *UserCache) List() []UserConfig {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	users := make([]UserConfig, 0, len(cache.users))
+	for _, user := range cache.users {
+		users = append(users, copyUser(user))
+	}
+	return users
+}
+
+//Set 1ユーザー分を差し替える
+func (cache *UserCache) Set(user UserConfig) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.unindex(user.LineID)
+	cache.users[user.LineID] = copyUser(user)
+	cache.index(user)
+}
+
+//Delete 1ユーザー分を削除する
+func (cache *UserCache) Delete(userID string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.unindex(userID)
+	delete(cache.users, userID)
+}
+
+//ReplaceAll 中身をすべて入れ替える
+func (cache *UserCache) ReplaceAll(users []UserConfig) {
+	buff := make(map[string]UserConfig, len(users))
+	for _, user := range users {
+		buff[user.LineID] = copyUser(user)
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.users = buff
+	cache.slackIDs = make(map[string]string)
+	for _, user := range buff {
+		cache.index(user)
+	}
+}
+
+//index Slackの索引に登録する（呼び出し側でロックをとる）
+func (cache *UserCache) index(user UserConfig) {
+	if user.SlackID != "" && !IsSlackUserKey(user.LineID) {
+		cache.slackIDs[user.SlackID] = user.LineID
+	}
+}
+
+//unindex Slackの索引から外す（呼び出し側でロックをとる）
+//同じSlackユーザーにあとから連携したLINEユーザーの索引は残す
+func (cache *UserCache) unindex(userID string) {
+	old, ok := cache.users[userID]
+	if ok && old.SlackID != "" && cache.slackIDs[old.SlackID] == userID {
+		delete(cache.slackIDs, old.SlackID)
+	}
+}
+
+//LockUser ユーザー単位で排他制御する（読み込み～保存～キャッシュ更新をひとまとめにするため）
+//戻り値の関数でロックを解除する
+func (cache *UserCache) LockUser(userID string) (unlock func()) {
+	return cache.locks.Lock(userID)
+}
+
+//copyUser スライスまで複製したユーザー設定を返す
+func copyUser(user UserConfig) UserConfig {
+	user.Favorites = copyStrings(user.Favorites)
+	user.Notifies = copyStrings(user.Notifies)
+	user.Histories = copyStrings(user.Histories)
+	if user.Alerts != nil {
+		alerts := make([]SpotAlert, len(user.Alerts))
+		copy(alerts, user.Alerts)
+		user.Alerts = alerts
+	}
+	if user.FavoriteGroups != nil {
+		groups := make([]FavoriteGroup, len(user.FavoriteGroups))
+		for i, group := range user.FavoriteGroups {
+			groups[i] = FavoriteGroup{Name: group.Name, Spots: copyStrings(group.Spots)}
+		}
+		user.FavoriteGroups = groups
+	}
+	if user.Schedules != nil {
+		schedules := make([]NotifySchedule, len(user.Schedules))
+		for i, schedule := range user.Schedules {
+			if schedule.Weekdays != nil {
+				schedule.Weekdays = append([]time.Weekday{}, schedule.Weekdays...)
+			}
+			schedules[i] = schedule
+		}
+		user.Schedules = schedules
+	}
+	if user.NotifyGroups != nil {
+		notifyGroups := make(map[string]string, len(user.NotifyGroups))
+		for hhmm, name := range user.NotifyGroups {
+			notifyGroups[hhmm] = name
+		}
+		user.NotifyGroups = notifyGroups
+	}
+	return user
+}
+
+//copyStrings スライスを複製する（nilはnilのまま）
+func copyStrings(slice []string) []string {
+	if slice == nil {
+		return nil
+	}
+	buff := make([]string, len(slice))
+	copy(buff, slice)
+	return buff
+}
+
+//keyedMutex キーごとの排他制御
+//使われていないキーのロックは捨てるので際限なく増えることはない
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+//Lock キーに対応するロックをとる
+func (km *keyedMutex) Lock(key string) (unlock func()) {
+	km.mu.Lock()
+	if km.locks == nil {
+		km.locks = make(map[string]*keyedLock)
+	}
+	lock, ok := km.locks[key]
+	if !ok {
+		lock = &keyedLock{}
+		km.locks[key] = lock
+	}
+	lock.refs++
+	km.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		km.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(km.locks, key)
+		}
+		km.mu.Unlock()
+	}
+}
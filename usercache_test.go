@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	bikeshareapi "github.com/8245snake/bikeshare-client"
+)
+
+//racyUserStore 読み込みと書き込みの間に排他制御のない保存先（APIに保存するときと同じく、直列にするのは呼び出し側の役目）
+type racyUserStore struct {
+	mu    sync.Mutex
+	users map[string]UserConfig
+}
+
+func (store *racyUserStore) Get(userID string) (UserConfig, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	user, ok := store.users[userID]
+	return copyUser(user), ok, nil
+}
+
+func (store *racyUserStore) Put(user UserConfig) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.users[user.LineID] = copyUser(user)
+	return nil
+}
+
+func (store *racyUserStore) Delete(userID string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.users, userID)
+	return nil
+}
+
+func (store *racyUserStore) List() ([]UserConfig, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	var users []UserConfig
+	for _, user := range store.users {
+		users = append(users, copyUser(user))
+	}
+	return users, nil
+}
+
+func (store *racyUserStore) Update(userID string, fn func(user *UserConfig)) (UserConfig, error) {
+	user, ok, _ := store.Get(userID)
+	if !ok {
+		user = NewUserConfig(userID)
+	}
+	fn(&user)
+	//読み込みから書き込みまでの間に他の更新が割り込みやすくする
+	time.Sleep(time.Millisecond)
+	user.LineID = userID
+	return user, store.Put(user)
+}
+
+//setupRacyUserStore キャッシュと保存先を空にしてracyUserStoreを使う（終わったら元に戻す）
+func setupRacyUserStore(t *testing.T) *racyUserStore {
+	saved := UserStorage
+	store := &racyUserStore{users: make(map[string]UserConfig)}
+	UserStorage = store
+	UserConfigs.ReplaceAll(nil)
+	t.Cleanup(func() {
+		UserStorage = saved
+		UserConfigs.ReplaceAll(nil)
+	})
+	return store
+}
+
+func TestUpdateUserConfigFuncConcurrent(t *testing.T) {
+	store := setupRacyUserStore(t)
+	const users, updates = 4, 20
+	var wg sync.WaitGroup
+	for u := 0; u < users; u++ {
+		for i := 0; i < updates; i++ {
+			wg.Add(1)
+			go func(userID, history string) {
+				defer wg.Done()
+				if err := UpdateUserConfigFunc(userID, func(user *UserConfig) {
+					user.Histories = append(user.Histories, history)
+				}); err != nil {
+					t.Error(err)
+				}
+			}(fmt.Sprintf("U%d", u), fmt.Sprint(i))
+		}
+	}
+	wg.Wait()
+
+	for u := 0; u < users; u++ {
+		userID := fmt.Sprintf("U%d", u)
+		//同じユーザーの更新がひとつも失われていない
+		saved, _, _ := store.Get(userID)
+		if len(saved.Histories) != updates {
+			t.Errorf("%s: 保存先の更新 = %d件, want %d", userID, len(saved.Histories), updates)
+		}
+		//キャッシュも保存先と同じ
+		cached := GetUserConfigFromCache(userID)
+		if cached == nil {
+			t.Fatalf("%s: キャッシュにない", userID)
+		}
+		got := append([]string{}, cached.Histories...)
+		want := append([]string{}, saved.Histories...)
+		sort.Strings(got)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: キャッシュ = %v, 保存先 = %v", userID, cached.Histories, saved.Histories)
+		}
+	}
+	//使い終わったロックは残らない
+	UserConfigs.locks.mu.Lock()
+	defer UserConfigs.locks.mu.Unlock()
+	if len(UserConfigs.locks.locks) != 0 {
+		t.Errorf("locks = %d", len(UserConfigs.locks.locks))
+	}
+}
+
+func TestUserCacheReadsDuringUpdates(t *testing.T) {
+	setupRacyUserStore(t)
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	//更新の最中に読み込んでも競合しない
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				for _, user := range UserConfigs.List() {
+					_ = len(user.Favorites)
+				}
+				GetUserConfigFromCache("U1")
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		UpdateUserConfigFunc("U1", func(user *UserConfig) {
+			user.Favorites = AddList(user.Favorites, fmt.Sprintf("A1-%02d", i), MaxFavorite)
+		})
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestUserCacheReturnsCopies(t *testing.T) {
+	cache := NewUserCache()
+	cache.Set(UserConfig{
+		Users:          bikeshareapi.Users{LineID: "U1", Favorites: []string{"A1-01"}},
+		Alerts:         []SpotAlert{{Code: "A1-01", Threshold: 3}},
+		FavoriteGroups: []FavoriteGroup{{Name: "通勤", Spots: []string{"A1-01"}}},
+		Schedules:      []NotifySchedule{{Time: "08:00", Weekdays: []time.Weekday{time.Monday}}},
+		NotifyGroups:   map[string]string{"08:00": "通勤"},
+	})
+
+	user, ok := cache.Get("U1")
+	if !ok {
+		t.Fatal("Get: ok = false")
+	}
+	user.Favorites[0] = "X"
+	user.Alerts[0].Threshold = 99
+	user.FavoriteGroups[0].Spots[0] = "X"
+	user.Schedules[0].Weekdays[0] = time.Sunday
+	user.NotifyGroups["08:00"] = "X"
+	for _, listed := range cache.List() {
+		listed.Favorites[0] = "Y"
+	}
+
+	got, _ := cache.Get("U1")
+	if got.Favorites[0] != "A1-01" || got.Alerts[0].Threshold != 3 || got.FavoriteGroups[0].Spots[0] != "A1-01" ||
+		got.Schedules[0].Weekdays[0] != time.Monday || got.NotifyGroups["08:00"] != "通勤" {
+		t.Errorf("取り出した値の書き換えがキャッシュに反映された: %+v", got)
+	}
+
+	//Setに渡した値をあとで書き換えても影響しない
+	set := UserConfig{Users: bikeshareapi.Users{LineID: "U2", Favorites: []string{"B2-01"}}}
+	cache.Set(set)
+	set.Favorites[0] = "X"
+	if got, _ := cache.Get("U2"); got.Favorites[0] != "B2-01" {
+		t.Errorf("Setした値の書き換えがキャッシュに反映された: %v", got.Favorites)
+	}
+}
+
+func TestUserCacheFindBySlackID(t *testing.T) {
+	cache := NewUserCache()
+	cache.ReplaceAll([]UserConfig{
+		{Users: bikeshareapi.Users{LineID: "U1", SlackID: "US1"}},
+		{Users: bikeshareapi.Users{LineID: SlackUserKeyPrefix + "US2", SlackID: "US2"}},
+	})
+	find := func(slackID string) string {
+		user, _ := cache.FindBySlackID(slackID)
+		return user.LineID
+	}
+	if got := find("US1"); got != "U1" {
+		t.Errorf("US1 = %q", got)
+	}
+	//Slack専用の設定は連携しているLINEユーザーではない
+	if got := find("US2"); got != "" {
+		t.Errorf("US2 = %q", got)
+	}
+	if got := find(""); got != "" {
+		t.Errorf("空 = %q", got)
+	}
+
+	//連携先を付け替える
+	cache.Set(UserConfig{Users: bikeshareapi.Users{LineID: "U2", SlackID: "US1"}})
+	cache.Set(UserConfig{Users: bikeshareapi.Users{LineID: "U1"}})
+	if got := find("US1"); got != "U2" {
+		t.Errorf("付け替え後 = %q", got)
+	}
+	cache.Delete("U2")
+	if got := find("US1"); got != "" {
+		t.Errorf("削除後 = %q", got)
+	}
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	bikeshareapi "github.com/8245snake/bikeshare-client"
+)
+
+//UserStoreType ユーザー情報の保存先の種類
+type UserStoreType string
+
+const (
+	//UserStoreTypeRemote BikeshareAPIのprivate/userに保存する（標準）
+	UserStoreTypeRemote UserStoreType = "remote"
+	//UserStoreTypeFile ローカルのファイルに保存する
+	UserStoreTypeFile UserStoreType = "file"
+)
+
+//UserStore ユーザー情報の保存先
+type UserStore interface {
+	//Get ユーザー情報を取得する（存在しないときはokがfalse）
+	Get(userID string) (user UserConfig, ok bool, err error)
+	//Put ユーザー情報を丸ごと保存する
+	Put(user UserConfig) error
+	//Delete ユーザー情報を削除する
+	Delete(userID string) error
+	//List すべてのユーザー情報を取得する
+	List() ([]UserConfig, error)
+	//Update ユーザー情報をコールバックで書き換えて保存する（存在しないときは新規作成）
+	Update(userID string, fn func(user *UserConfig)) (UserConfig, error)
+}
+
+//NewUserStore 種類を指定して保存先を作成
+func NewUserStore(storeType UserStoreType, path string) (UserStore, error) {
+	switch storeType {
+	case UserStoreTypeRemote, "":
+		return NewRemoteUserStore(&BikeshareAPI.APIClient, path)
+	case UserStoreTypeFile:
+		if path == "" {
+			return nil, fmt.Errorf("USER_STORE_PATHが指定されていません")
+		}
+		return NewFileUserStore(path)
+	}
+	return nil, fmt.Errorf("不明なUSER_STOREです: %s", storeType)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  ファイル
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+const (
+	//userStoreCompactMin ログの行数がこれを超えるまでは詰め直さない
+	userStoreCompactMin = 1000
+	//userStoreCompactRatio ログの行数がユーザー数のこの倍を超えたら詰め直す
+	userStoreCompactRatio = 4
+)
+
+//userStoreRecord ログの1行（保存ならUser、削除ならIDを持つ）
+type userStoreRecord struct {
+	Op   string      `json:"op"`
+	User *UserConfig `json:"user,omitempty"`
+	ID   string      `json:"id,omitempty"`
+}
+
+const (
+	userStoreOpPut    = "put"
+	userStoreOpDelete = "delete"
+)
+
+//FileUserStore ユーザー情報を追記型のログファイルに保存する
+//変更のたびにファイル全体を書き直さず、変更したユーザーの1行だけを追記してfsyncする
+//起動時にログを読み直して最新の状態を作り、行数が増えすぎたら今の状態だけのログに詰め直す
+//pathが空のときはメモリ上だけで保持する
+type FileUserStore struct {
+	mu   sync.Mutex
+	path string
+	//file 追記用に開いたログ（メモリ上だけのときはnil）
+	file *os.File
+	//size 最後に書ききったところまでのサイズ（書き込みに失敗したらここまで切り詰める）
+	size int64
+	//records ログの行数
+	records int
+	users   map[string]UserConfig
+}
+
+//NewFileUserStore コンストラクタ（ファイルがあれば読み込む）
+//以前のJSON配列の形式のファイルも読み込んでログの形式に書き直す
+func NewFileUserStore(path string) (*FileUserStore, error) {
+	store := &FileUserStore{path: path, users: make(map[string]UserConfig)}
+	if path == "" {
+		return store, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	compact := false
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var users []UserConfig
+		if err := json.Unmarshal(trimmed, &users); err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			store.users[user.LineID] = user
+		}
+		compact = true
+	} else if len(data) > 0 {
+		torn, err := store.replay(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		compact = torn || store.needsCompaction()
+	}
+	if compact || data == nil {
+		if err := store.compact(); err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	if err := store.openLog(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+//replay ログを先頭から読んで状態を作る
+//書き込みの途中で落ちて最後の行が壊れているときはその行を捨ててtornをtrueにする
+func (store *FileUserStore) replay(data []byte) (torn bool, err error) {
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var record userStoreRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			if i == len(lines)-1 {
+				return true, nil
+			}
+			return false, fmt.Errorf("%d行目を読み込めません: %v", i+1, err)
+		}
+		store.records++
+		switch record.Op {
+		case userStoreOpPut:
+			if record.User != nil {
+				store.users[record.User.LineID] = *record.User
+			}
+		case userStoreOpDelete:
+			delete(store.users, record.ID)
+		default:
+			return false, fmt.Errorf("%d行目の操作が不明です: %s", i+1, record.Op)
+		}
+	}
+	return false, nil
+}
+
+//openLog 追記用にログを開く
+func (store *FileUserStore) openLog() error {
+	file, err := os.OpenFile(store.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	store.file, store.size = file, info.Size()
+	return nil
+}
+
+//Close ログを閉じる
+func (store *FileUserStore) Close() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.file == nil {
+		return nil
+	}
+	err := store.file.Close()
+	store.file = nil
+	return err
+}
+
+//Get ユーザー情報を取得する
+func (store *FileUserStore) Get(userID string) (UserConfig, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	user, ok := store.users[userID]
+	return copyUser(user), ok, nil
+}
+
+//Put ユーザー情報を保存する
+func (store *FileUserStore) Put(user UserConfig) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	user = copyUser(user)
+	if err := store.append(userStoreRecord{Op: userStoreOpPut, User: &user}); err != nil {
+		return err
+	}
+	store.users[user.LineID] = user
+	store.compactIfNeeded()
+	return nil
+}
+
+//Delete ユーザー情報を削除する
+func (store *FileUserStore) Delete(userID string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, existed := store.users[userID]; !existed {
+		return nil
+	}
+	if err := store.append(userStoreRecord{Op: userStoreOpDelete, ID: userID}); err != nil {
+		return err
+	}
+	delete(store.users, userID)
+	store.compactIfNeeded()
+	return nil
+}
+
+//List すべてのユーザー情報を取得する
+func (store *FileUserStore) List() ([]UserConfig, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.sortedUsers(), nil
+}
+
+//Update ユーザー情報をコールバックで書き換えて保存する
+func (store *FileUserStore) Update(userID string, fn func(user *UserConfig)) (UserConfig, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	before, existed := store.users[userID]
+	user := copyUser(before)
+	if !existed {
+		user = NewUserConfig(userID)
+	}
+	fn(&user)
+	user.LineID = userID
+	saved := copyUser(user)
+	if err := store.append(userStoreRecord{Op: userStoreOpPut, User: &saved}); err != nil {
+		return UserConfig{}, err
+	}
+	store.users[userID] = saved
+	store.compactIfNeeded()
+	return user, nil
+}
+
+//replaceAll 中身をすべて入れ替える
+func (store *FileUserStore) replaceAll(users []UserConfig) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	before := store.users
+	store.users = make(map[string]UserConfig)
+	for _, user := range users {
+		store.users[user.LineID] = user
+	}
+	if err := store.compact(); err != nil {
+		store.users = before
+		return err
+	}
+	return nil
+}
+
+//sortedUsers ID順に並べたスライスを返す（ロックは呼び出し側でとる）
+func (store *FileUserStore) sortedUsers() []UserConfig {
+	users := make([]UserConfig, 0, len(store.users))
+	for _, user := range store.users {
+		users = append(users, copyUser(user))
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].LineID < users[j].LineID })
+	return users
+}
+
+//append ログに1行追記してディスクに書ききる（ロックは呼び出し側でとる）
+//途中までしか書けなかったときは書く前のサイズに切り詰めて、次の行が壊れた行に続かないようにする
+func (store *FileUserStore) append(record userStoreRecord) error {
+	if store.path == "" {
+		return nil
+	}
+	if store.file == nil {
+		return fmt.Errorf("%sは閉じられています", store.path)
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := store.file.Write(line); err != nil {
+		store.file.Truncate(store.size)
+		return err
+	}
+	if err := store.file.Sync(); err != nil {
+		store.file.Truncate(store.size)
+		return err
+	}
+	store.size += int64(len(line))
+	store.records++
+	return nil
+}
+
+//needsCompaction ログを詰め直す時期か
+func (store *FileUserStore) needsCompaction() bool {
+	return store.records > userStoreCompactMin && store.records > userStoreCompactRatio*len(store.users)
+}
+
+//compactIfNeeded 必要ならログを詰め直す（失敗しても追記はできているのでログに残すだけ）
+func (store *FileUserStore) compactIfNeeded() {
+	if store.path == "" || !store.needsCompaction() {
+		return
+	}
+	if err := store.compact(); err != nil {
+		LogWarn(context.Background(), "ユーザー設定のログを詰め直せませんでした", LogFields{"error": err, "path": store.path})
+	}
+}
+
+//compact 今の状態だけのログを一時ファイルに書いてから置き換える（ロックは呼び出し側でとる）
+func (store *FileUserStore) compact() error {
+	if store.path == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	users := store.sortedUsers()
+	for i := range users {
+		line, err := json.Marshal(userStoreRecord{Op: userStoreOpPut, User: &users[i]})
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(store.path), filepath.Base(store.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), store.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if store.file != nil {
+		store.file.Close()
+		store.file = nil
+	}
+	store.records = len(users)
+	return store.openLog()
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  BikeshareAPI
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//RemoteUserStore ユーザー情報をBikeshareAPIに保存する
+//読み込みは手元の写しから行うのでAPIが落ちていても参照はできる
+//APIに項目がないボット独自の設定（アラートや通知のルールなど）は写しにだけ保存される
+//写しの保存先がなければ、それらの設定は再起動すると消える
+//LINEと連携していないSlackユーザーの設定はLINEのユーザーではないのでAPIに送らず、写しにだけ保存する
+type RemoteUserStore struct {
+	api    *APIClient
+	mirror *FileUserStore
+	locks  keyedMutex
+}
+
+//NewRemoteUserStore コンストラクタ
+//mirrorPathにはAPIの内容とボット独自の設定を写しておき、起動時にAPIが落ちていればそちらを使う
+//空ならメモリ上だけに写す（APIの項目だけで動かす以前からの設定のまま起動できる）
+func NewRemoteUserStore(api *APIClient, mirrorPath string) (*RemoteUserStore, error) {
+	if mirrorPath == "" {
+		LogWarn(context.Background(), "USER_STORE_PATHが未設定のため、APIに項目がない設定は再起動すると消えます", nil)
+	}
+	mirror, err := NewFileUserStore(mirrorPath)
+	if err != nil {
+		return nil, err
+	}
+	store := &RemoteUserStore{api: api, mirror: mirror}
+	ctx, cancel := context.WithTimeout(context.Background(), APIBackgroundBudget)
+	defer cancel()
+	users, err := api.GetUsersContext(ctx)
+	if err != nil {
+		if len(mirror.users) == 0 {
+			return nil, err
+		}
+		LogWarn(ctx, "ユーザー情報の取得に失敗したため保存済みの写しを使います", LogFields{"error": err})
+		return store, nil
+	}
+	//APIにない項目は写しの内容を引き継ぐ
+	configs := make([]UserConfig, 0, len(users))
+	//Slack専用の設定は写しのほうを正とする（以前はAPIにも送っていたので、写しになければAPIのものを使う）
+	mirrored, _ := mirror.List()
+	for _, user := range mirrored {
+		if IsSlackUserKey(user.LineID) {
+			configs = append(configs, user)
+		}
+	}
+	for _, user := range users {
+		config, ok, _ := mirror.Get(user.LineID)
+		if ok && IsSlackUserKey(user.LineID) {
+			continue
+		}
+		config.Users = user
+		configs = append(configs, config)
+	}
+	if err := mirror.replaceAll(configs); err != nil {
+		LogWarn(context.Background(), "ユーザー情報の写しを保存できませんでした", LogFields{"error": err, "path": store.mirror.path})
+	}
+	return store, nil
+}
+
+//Get ユーザー情報を取得する
+func (store *RemoteUserStore) Get(userID string) (UserConfig, bool, error) {
+	return store.mirror.Get(userID)
+}
+
+//Put ユーザー情報をAPIに送信する
+func (store *RemoteUserStore) Put(user UserConfig) error {
+	if IsSlackUserKey(user.LineID) {
+		return store.mirror.Put(user)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), APIBackgroundBudget)
+	defer cancel()
+	users, err := store.api.UpdateUserContext(ctx, user.Users)
+	if err != nil {
+		return err
+	}
+	//レスポンスに含まれる内容を正とする
+	for _, item := range users {
+		if item.LineID == user.LineID {
+			user.Users = item
+			break
+		}
+	}
+	//ボット独自の設定は写しにしか残らないので、書き込めなければ失敗とする
+	if err := store.mirror.Put(user); err != nil {
+		LogError(context.Background(), "ユーザー情報の写しを保存できませんでした", LogFields{"error": err, "path": store.mirror.path})
+		return err
+	}
+	return nil
+}
+
+//Delete ユーザー情報を削除する
+//APIには削除がないので中身を空にして送信する
+func (store *RemoteUserStore) Delete(userID string) error {
+	if IsSlackUserKey(userID) {
+		return store.mirror.Delete(userID)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), APIBackgroundBudget)
+	defer cancel()
+	if _, err := store.api.UpdateUserContext(ctx, bikeshareapi.Users{LineID: userID}); err != nil {
+		return err
+	}
+	return store.mirror.Delete(userID)
+}
+
+//List すべてのユーザー情報を取得する
+func (store *RemoteUserStore) List() ([]UserConfig, error) {
+	return store.mirror.List()
+}
+
+//Update ユーザー情報をコールバックで書き換えてAPIに送信する
+func (store *RemoteUserStore) Update(userID string, fn func(user *UserConfig)) (UserConfig, error) {
+	unlock := store.locks.Lock(userID)
+	defer unlock()
+	user, ok, err := store.mirror.Get(userID)
+	if err != nil {
+		return UserConfig{}, err
+	}
+	if !ok {
+		user = NewUserConfig(userID)
+	}
+	fn(&user)
+	user.LineID = userID
+	if err := store.Put(user); err != nil {
+		return UserConfig{}, err
+	}
+	user, _, err = store.mirror.Get(userID)
+	return user, err
+}
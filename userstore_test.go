@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	bikeshareapi "github.com/8245snake/bikeshare-client"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+)
+
+//newTestUsersAPI private/usersとprivate/userだけを持つ偽のAPI（APIの項目だけを覚える）
+func newTestUsersAPI(t *testing.T) *APIClient {
+	var mu sync.Mutex
+	users := map[string]static.JUser{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/private/users", func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body := static.JUsers{Users: []static.JUser{}}
+		for _, user := range users {
+			body.Users = append(body.Users, user)
+		}
+		json.NewEncoder(w).Encode(body)
+	})
+	mux.HandleFunc("/private/user", func(w http.ResponseWriter, req *http.Request) {
+		var user static.JUser
+		if err := json.NewDecoder(req.Body).Decode(&user); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		users[user.LineID] = user
+		mu.Unlock()
+		json.NewEncoder(w).Encode(static.JUsers{Users: []static.JUser{user}})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client := bikeshareapi.NewApiClient()
+	client.SetEndpoint(server.URL + "/")
+	return &APIClient{ApiClient: client}
+}
+
+func TestNewUserStorePath(t *testing.T) {
+	if _, err := NewUserStore(UserStoreTypeFile, ""); err == nil {
+		t.Error("USER_STORE=fileで保存先なしを受け付けた")
+	}
+	//APIの認証情報だけの以前からの設定でも起動できる
+	savedAPI := BikeshareAPI
+	defer func() { BikeshareAPI = savedAPI }()
+	SetupBikeshareAPI(newTestUsersAPI(t).Endpoint)
+	for _, storeType := range []UserStoreType{"", UserStoreTypeRemote} {
+		store, err := NewUserStore(storeType, "")
+		if err != nil {
+			t.Fatalf("USER_STORE=%q: %v", storeType, err)
+		}
+		if _, err := store.Update("U1", func(user *UserConfig) { user.Language = "en" }); err != nil {
+			t.Errorf("USER_STORE=%q: %v", storeType, err)
+		}
+	}
+}
+
+func TestRemoteUserStoreKeepsBotSettingsAcrossRestart(t *testing.T) {
+	api := newTestUsersAPI(t)
+	path := filepath.Join(t.TempDir(), "users.json")
+	store, err := NewRemoteUserStore(api, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Update("U1", func(user *UserConfig) {
+		user.Favorites = []string{"A1-01"}
+		user.Language = "en"
+		user.SpotAnnounce = true
+		user.FavoriteGroups = []FavoriteGroup{{Name: "通勤", Spots: []string{"A1-01"}}}
+		user.NotifyPause = NotifyPause{QuietStart: "22:00", QuietEnd: "07:00"}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//再起動（APIの内容と写しから作り直す）
+	restarted, err := NewRemoteUserStore(api, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, ok, err := restarted.Get("U1")
+	if err != nil || !ok {
+		t.Fatalf("Get = %v, %v", ok, err)
+	}
+	if len(user.Favorites) != 1 || user.Favorites[0] != "A1-01" {
+		t.Errorf("Favorites = %v", user.Favorites)
+	}
+	if user.Language != "en" || !user.SpotAnnounce {
+		t.Errorf("Language = %q, SpotAnnounce = %v", user.Language, user.SpotAnnounce)
+	}
+	if len(user.FavoriteGroups) != 1 || user.FavoriteGroups[0].Name != "通勤" {
+		t.Errorf("FavoriteGroups = %v", user.FavoriteGroups)
+	}
+	if user.QuietStart != "22:00" || user.QuietEnd != "07:00" {
+		t.Errorf("NotifyPause = %+v", user.NotifyPause)
+	}
+}
+
+func TestRemoteUserStoreFailsWhenMirrorCannotBeSaved(t *testing.T) {
+	api := newTestUsersAPI(t)
+	dir := t.TempDir()
+	store, err := NewRemoteUserStore(api, filepath.Join(dir, "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//書き込めなくする
+	store.mirror.Close()
+	if _, err := store.Update("U1", func(user *UserConfig) { user.Language = "en" }); err == nil {
+		t.Error("写しを保存できなかったのに成功した")
+	}
+}
+
+func TestRemoteUserStoreKeepsSlackUsersLocal(t *testing.T) {
+	api := newTestUsersAPI(t)
+	path := filepath.Join(t.TempDir(), "users.json")
+	store, err := NewRemoteUserStore(api, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := SlackUserKeyPrefix + "US1"
+	if _, err := store.Update(key, func(user *UserConfig) {
+		user.SlackID = "US1"
+		user.Favorites = []string{"A1-01"}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	//LINEのユーザーではないのでAPIには送らない
+	users, err := api.GetUsersContext(testContext(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 0 {
+		t.Errorf("APIのユーザー = %+v", users)
+	}
+
+	//再起動しても写しから読める
+	restarted, err := NewRemoteUserStore(api, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, ok, _ := restarted.Get(key); !ok || !reflect.DeepEqual(user.Favorites, []string{"A1-01"}) {
+		t.Errorf("再起動後 = %+v, %v", user, ok)
+	}
+	if err := restarted.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := restarted.Get(key); ok {
+		t.Error("削除されていない")
+	}
+}
+
+//countLines ファイルの行数
+func countLines(t *testing.T, path string) int {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestFileUserStoreAppendsAndReplays(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.log")
+	store, err := NewFileUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Update("U1", func(user *UserConfig) { user.Favorites = []string{"A1-01"} })
+	store.Update("U2", func(user *UserConfig) { user.Language = "en" })
+	store.Update("U1", func(user *UserConfig) { user.Favorites = append(user.Favorites, "B2-02") })
+	store.Delete("U2")
+	//変更ごとに1行ずつ追記する
+	if got := countLines(t, path); got != 4 {
+		t.Errorf("lines = %d, want 4", got)
+	}
+	store.Close()
+
+	reopened, err := NewFileUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	users, _ := reopened.List()
+	if len(users) != 1 || users[0].LineID != "U1" || !reflect.DeepEqual(users[0].Favorites, []string{"A1-01", "B2-02"}) {
+		t.Errorf("users = %+v", users)
+	}
+}
+
+func TestFileUserStoreDropsTornLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.log")
+	store, err := NewFileUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Update("U1", func(user *UserConfig) { user.Language = "en" })
+	store.Close()
+	//書き込みの途中で落ちた
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	file.WriteString(`{"op":"put","user":{"LineID":"U2"`)
+	file.Close()
+
+	reopened, err := NewFileUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := reopened.Get("U2"); ok {
+		t.Error("壊れた行を読み込んだ")
+	}
+	//壊れた行は捨てて続きを書ける
+	reopened.Update("U3", func(user *UserConfig) {})
+	reopened.Close()
+	again, err := NewFileUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	if users, _ := again.List(); len(users) != 2 {
+		t.Errorf("users = %+v", users)
+	}
+
+	//途中の行が壊れているのは読み込まない
+	ioutil.WriteFile(path, []byte("{\n"+`{"op":"delete","id":"U1"}`+"\n"), 0600)
+	if _, err := NewFileUserStore(path); err == nil {
+		t.Error("途中の壊れた行を受け付けた")
+	}
+}
+
+func TestFileUserStoreMigratesJSONArray(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	ioutil.WriteFile(path, []byte(`[{"LineID":"U1","Favorites":["A1-01"]},{"LineID":"U2","Language":"en"}]`), 0600)
+	store, err := NewFileUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if users, _ := store.List(); len(users) != 2 || users[1].Language != "en" {
+		t.Errorf("users = %+v", users)
+	}
+	//ログの形式に書き直している
+	if got := countLines(t, path); got != 2 {
+		t.Errorf("lines = %d, want 2", got)
+	}
+}
+
+func TestFileUserStoreCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.log")
+	store, err := NewFileUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for i := 0; i < userStoreCompactMin+1; i++ {
+		store.Update("U1", func(user *UserConfig) { user.Histories = []string{fmt.Sprint(i)} })
+	}
+	if got := countLines(t, path); got > userStoreCompactMin {
+		t.Errorf("詰め直していない: lines = %d", got)
+	}
+	if user, _, _ := store.Get("U1"); !reflect.DeepEqual(user.Histories, []string{fmt.Sprint(userStoreCompactMin)}) {
+		t.Errorf("Histories = %v", user.Histories)
+	}
+}
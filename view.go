@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	bikeshareapi "github.com/8245snake/bikeshare-client"
+	"github.com/8245snake/bikeshare-line/forecast"
+	"github.com/8245snake/bikeshare-line/holiday"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+)
+
+//View 返信の内容（LINE・Slack・テキストなど送信先に依存しない）
+//データの取得はBuild*で行い、見た目はRender*で作る
+type View interface {
+	//ViewKind 種類
+	ViewKind() string
+}
+
+//TextView 文章だけの返信（エラーなど）
+type TextView struct {
+	Text string `json:"text"`
+}
+
+//SpotItem 一覧の1スポット
+type SpotItem struct {
+	Area     string `json:"area"`
+	Spot     string `json:"spot"`
+	Name     string `json:"name"`
+	Count    int    `json:"count"`
+	HasCount bool   `json:"hasCount"`
+	//Note 距離など名前の下に出す補足
+	Note string `json:"note,omitempty"`
+}
+
+//SpotListView 台数一覧
+type SpotListView struct {
+	Title      string     `json:"title"`
+	AltText    string     `json:"altText"`
+	LastUpdate string     `json:"lastUpdate"`
+	Spots      []SpotItem `json:"spots"`
+	//Stale APIが失敗したので前回取得した台数を表示している
+	Stale bool `json:"stale,omitempty"`
+	//Group お気に入りグループの一覧ならその名前
+	Group string `json:"group,omitempty"`
+	//Groups 切り替えて表示できるお気に入りグループ
+	Groups []string `json:"groups,omitempty"`
+	Lang   Lang     `json:"lang"`
+}
+
+//AnalysisView グラフ表示
+type AnalysisView struct {
+	Area        string `json:"area"`
+	Spot        string `json:"spot"`
+	Title       string `json:"title"`
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+	LastUpdate  string `json:"lastUpdate,omitempty"`
+	Forecast    string `json:"forecast,omitempty"`
+	//Stale APIが失敗したので前回取得したグラフを表示している
+	Stale bool `json:"stale,omitempty"`
+	//Favorite お気に入り登録済みか
+	Favorite bool `json:"favorite"`
+	//Groups このスポットを追加できるお気に入りグループ
+	Groups []string `json:"groups,omitempty"`
+	Lang   Lang     `json:"lang"`
+}
+
+//AlertItem 設定画面に出す台数アラート
+type AlertItem struct {
+	Key       string `json:"key"`
+	Code      string `json:"code"`
+	Condition string `json:"condition"`
+}
+
+//NotifyItem 設定画面に出す通知
+type NotifyItem struct {
+	ID   int    `json:"id"`
+	Time string `json:"time"`
+	//Weekdays 曜日の番号の並び（空なら毎日）
+	Weekdays     string `json:"weekdays,omitempty"`
+	SkipHolidays bool   `json:"skipHolidays,omitempty"`
+	//AsSunday 祝日は日曜日として扱う
+	AsSunday bool `json:"asSunday,omitempty"`
+	//Date 1回だけ送る日付
+	Date string `json:"date,omitempty"`
+	//Group 送るお気に入りグループ
+	Group string `json:"group,omitempty"`
+	//Spot 送るスポット（GroupもSpotも空なら通常のお気に入り）
+	Spot     string `json:"spot,omitempty"`
+	SpotName string `json:"spotName,omitempty"`
+}
+
+//PauseItem 設定画面に出す通知の一時停止（止めていなければ空）
+type PauseItem struct {
+	//SnoozeUntil 一時停止の終わり（M/D HH:MM）
+	SnoozeUntil string `json:"snoozeUntil,omitempty"`
+	QuietStart  string `json:"quietStart,omitempty"`
+	QuietEnd    string `json:"quietEnd,omitempty"`
+	//VacationStart 休暇の初日（M/D）
+	VacationStart string `json:"vacationStart,omitempty"`
+	VacationEnd   string `json:"vacationEnd,omitempty"`
+}
+
+//NotifyScheduleView 通知の編集画面
+type NotifyScheduleView struct {
+	NotifyItem
+	//Groups 送る内容に選べるお気に入りグループ
+	Groups []string `json:"groups,omitempty"`
+	//Favorites 送る内容に選べるお気に入りのスポット
+	Favorites []string `json:"favorites,omitempty"`
+	Lang      Lang     `json:"lang"`
+}
+
+//FavoriteGroupItem 設定画面に出すお気に入りグループ
+type FavoriteGroupItem struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+//ConfigView 設定画面
+type ConfigView struct {
+	Favorites         []SpotItem          `json:"favorites"`
+	FavoriteGroups    []FavoriteGroupItem `json:"favoriteGroups"`
+	MaxFavoriteGroups int                 `json:"maxFavoriteGroups"`
+	Notifies          []NotifyItem        `json:"notifies"`
+	MaxNotifies       int                 `json:"maxNotifies"`
+	Alerts            []AlertItem         `json:"alerts"`
+	MaxAlerts         int                 `json:"maxAlerts"`
+	SpotAnnounce      bool                `json:"spotAnnounce"`
+	Pause             PauseItem           `json:"pause"`
+	//Language 設定画面で選んだ言語（空文字は自動）
+	Language Lang `json:"language"`
+	Lang     Lang `json:"lang"`
+}
+
+//StatusView システム稼働状況
+type StatusView struct {
+	OK   bool   `json:"ok"`
+	Text string `json:"text"`
+}
+
+//MenuItem コマンド一覧・履歴一覧の要素
+type MenuItem struct {
+	Label string `json:"label"`
+	//Postback 押したときのポストバック文字列（空ならMessageを送信する）
+	Postback string `json:"postback,omitempty"`
+	Message  string `json:"message,omitempty"`
+	//Text ポストバックのときにトークに表示する文字列
+	Text string `json:"text,omitempty"`
+}
+
+//MenuView コマンド一覧・履歴一覧
+type MenuView struct {
+	Title   string     `json:"title"`
+	AltText string     `json:"altText"`
+	Items   []MenuItem `json:"items"`
+}
+
+//ViewKind 種類
+func (TextView) ViewKind() string { return "text" }
+
+//ViewKind 種類
+func (SpotListView) ViewKind() string { return "spotList" }
+
+//ViewKind 種類
+func (AnalysisView) ViewKind() string { return "analysis" }
+
+//ViewKind 種類
+func (ConfigView) ViewKind() string { return "config" }
+
+//ViewKind 種類
+func (StatusView) ViewKind() string { return "status" }
+
+//ViewKind 種類
+func (MenuView) ViewKind() string { return "menu" }
+
+//ViewKind 種類
+func (NotifyScheduleView) ViewKind() string { return "notifySchedule" }
+
+//textView 文言のキーからTextViewを作る
+func textView(lang Lang, key string, args ...interface{}) View {
+	return TextView{Text: T(lang, key, args...)}
+}
+
+//apiErrorView APIの失敗を伝える文章（何が起きたかを添える）
+func apiErrorView(ctx context.Context, lang Lang, key string, err error) View {
+	LogError(ctx, "APIの呼び出しに失敗しました", LogFields{"error": err, "kind": string(APIErrorKindOf(err))})
+	return TextView{Text: T(lang, key) + "\n" + APIErrorText(lang, err)}
+}
+
+//newSpotItems APIのスポット情報を一覧の要素に変換する
+func newSpotItems(spotinfos []bikeshareapi.SpotInfo) []SpotItem {
+	var items []SpotItem
+	for _, info := range spotinfos {
+		item := SpotItem{Area: info.Area, Spot: info.Spot, Name: info.Name}
+		if len(info.Counts) > 0 {
+			item.Count = info.Counts[0].Count
+			item.HasCount = true
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+//newSpotListView 台数一覧を作る
+func newSpotListView(title, altText string, spotinfos []bikeshareapi.SpotInfo, lang Lang) SpotListView {
+	return SpotListView{
+		Title:      title,
+		AltText:    altText,
+		LastUpdate: getLastUpdateTime(lang, spotinfos...),
+		Spots:      newSpotItems(spotinfos),
+		Lang:       lang,
+	}
+}
+
+//setStale APIが失敗して前回取得した台数を表示しているなら最終更新日時に注意書きを添える
+func (view *SpotListView) setStale(stale bool) {
+	if !stale {
+		return
+	}
+	view.Stale = true
+	view.LastUpdate += "\n" + T(view.Lang, "api.stale")
+}
+
+//BuildServiceStatusView システム稼働状況
+func BuildServiceStatusView(ctx context.Context, lang Lang) View {
+	status, err := BikeshareAPI.GetStatusContext(ctx)
+	if err != nil {
+		return apiErrorView(ctx, lang, "status.apiError", err)
+	}
+	if status.Status == static.StatusOK {
+		return StatusView{OK: true, Text: T(lang, "status.ok")}
+	}
+	view := StatusView{Text: T(lang, "status.ok")}
+	if status.Connection != static.StatusOK {
+		view.Text = T(lang, "status.dbError")
+	}
+	if status.Scraping != static.StatusOK {
+		view.Text = T(lang, "status.scrapingError")
+	}
+	return view
+}
+
+//BuildLocationView 位置情報から近いスポット
+func BuildLocationView(ctx context.Context, lat, lon float64, lang Lang) View {
+	distances, stale, err := BikeshareAPI.FetchDistances(ctx, bikeshareapi.SearchDistanceOption{Lat: lat, Lon: lon})
+	if err != nil {
+		return apiErrorView(ctx, lang, "search.failed", err)
+	}
+	var spotinfos []bikeshareapi.SpotInfo
+	var notes []string
+	for _, place := range distances.Spots {
+		spotinfos = append(spotinfos, place.SpotInfo)
+		notes = append(notes, place.Distance)
+	}
+	view := newSpotListView(T(lang, "location.title"), T(lang, "location.alt"), spotinfos, lang)
+	for i := range view.Spots {
+		view.Spots[i].Note = notes[i]
+	}
+	view.setStale(stale)
+	return view
+}
+
+//BuildSearchView スポット名の検索
+func BuildSearchView(ctx context.Context, query string, lang Lang) View {
+	//スポット名の辞書からあいまい検索して、台数だけAPIから取得する
+	hits := GetSpotSearchIndex().Search(query, 0)
+	count := len(hits)
+	MetricSearchHits.Observe(float64(count))
+	if count == 0 {
+		MetricSearches.Inc("none")
+		return textView(lang, "search.notFound", query)
+	} else if count >= 100 {
+		MetricSearches.Inc("too_many")
+		return textView(lang, "search.tooMany", query, count)
+	}
+	MetricSearches.Inc("hit")
+	var codes []string
+	for _, hit := range hits {
+		codes = append(codes, hit.Code)
+	}
+	spotinfos, stale, err := BikeshareAPI.FetchPlaces(ctx, bikeshareapi.SearchPlacesOption{Places: codes})
+	if err != nil {
+		return apiErrorView(ctx, lang, "search.spotFailed", err)
+	}
+	spotinfos = sortSpotInfosByCodes(spotinfos, codes)
+	view := newSpotListView(T(lang, "search.found", query, count), T(lang, "search.alt"), spotinfos, lang)
+	view.setStale(stale)
+	return view
+}
+
+//BuildFavoriteListView お気に入り一覧
+func BuildFavoriteListView(ctx context.Context, userID string) View {
+	user := GetUserConfigFromCache(userID)
+	if user == nil {
+		return textView(DefaultLang, "user.loadFailed")
+	}
+	lang := user.Lang()
+	names := user.FavoriteGroupNames()
+	if len(user.Favorites) < 1 {
+		if len(names) > 0 {
+			return textView(lang, "favgroup.list", strings.Join(names, " / "))
+		}
+		return textView(lang, "fav.empty")
+	}
+	spotinfos, stale, err := BikeshareAPI.FetchPlaces(ctx, bikeshareapi.SearchPlacesOption{Places: user.Favorites})
+	if err != nil {
+		return apiErrorView(ctx, lang, "search.failed", err)
+	}
+	if len(spotinfos) < 1 {
+		return textView(lang, "fav.noSpots")
+	}
+	view := newSpotListView(T(lang, "fav.title"), T(lang, "search.alt"), spotinfos, lang)
+	view.Groups = names
+	view.setStale(stale)
+	return view
+}
+
+//BuildRankingView 台数ランキング
+func BuildRankingView(ctx context.Context, limit int, lang Lang) View {
+	spotinfos, stale, err := BikeshareAPI.FetchPlaces(ctx, bikeshareapi.SearchPlacesOption{Sort: "countd", Limit: limit})
+	if err != nil {
+		return apiErrorView(ctx, lang, "search.failed", err)
+	}
+	count := len(spotinfos)
+	if count == 0 {
+		return textView(lang, "search.zero")
+	} else if count >= 100 {
+		return textView(lang, "search.tooManyHits")
+	}
+	view := newSpotListView(T(lang, "ranking.title", count), T(lang, "search.alt"), spotinfos, lang)
+	view.setStale(stale)
+	return view
+}
+
+//BuildAnalysisView グラフ表示
+//日付を指定しないときは説明・台数予測・最終更新日時も載せる
+func BuildAnalysisView(ctx context.Context, area string, spot string, userID string, days ...string) View {
+	lang := GetUserLang(userID)
+	option := bikeshareapi.SearchGraphOption{
+		Area:        area,
+		Spot:        spot,
+		Property:    "500,380",
+		UploadImgur: false,
+		Days:        days,
+	}
+	graph, stale, err := GetGraphInfo(ctx, option)
+	if err != nil {
+		return apiErrorView(ctx, lang, "graph.failed", err)
+	}
+
+	//お気に入り登録/解除の判定
+	user := GetUserConfigFromCache(userID)
+	if user == nil {
+		return textView(lang, "user.loadFailed")
+	}
+	view := AnalysisView{
+		Area:     area,
+		Spot:     spot,
+		Title:    graph.Title,
+		URL:      graph.URL,
+		Favorite: contains(user.Favorites, area+"-"+spot),
+		Lang:     lang,
+	}
+	//まだ入っていなくて空きのあるお気に入りグループには追加できる
+	for _, group := range user.FavoriteGroups {
+		if !contains(group.Spots, area+"-"+spot) && len(group.Spots) < MaxFavoriteGroupSpots {
+			view.Groups = append(view.Groups, group.Name)
+		}
+	}
+	if len(days) == 0 {
+		view.Description = graph.SpotInfo.Description
+		view.LastUpdate = getLastUpdateTime(lang, graph.SpotInfo)
+		if len(graph.SpotInfo.Counts) > 0 {
+			view.Forecast = MakeForecastText(ctx, area, spot, graph.SpotInfo.Counts[0], lang)
+		}
+	}
+	if stale {
+		view.Stale = true
+		view.LastUpdate = strings.TrimSpace(view.LastUpdate + "\n" + T(lang, "api.stale"))
+	}
+	return view
+}
+
+//BuildCommandListView コマンド一覧
+func BuildCommandListView(lang Lang) View {
+	items := []MenuItem{
+		{Label: T(lang, "command.ranking"), Postback: GetPostbackDataRanking(), Text: T(lang, "command.rankingMsg")},
+		{Label: T(lang, "command.trip"), Postback: GetPostbackDataForTrip(), Text: T(lang, "command.tripMsg")},
+		{Label: T(lang, "command.config"), Postback: GetPostbackDataConfigOpen(), Text: T(lang, "command.configMsg")},
+		{Label: T(lang, "command.status"), Postback: GetPostbackDataServiceStatus(), Text: T(lang, "command.statusMsg")},
+	}
+	if SlackSigningSecret != "" {
+		items = append(items, MenuItem{Label: T(lang, "command.slack"), Postback: GetPostbackDataForSlack(), Text: T(lang, "command.slackMsg")})
+	}
+	return MenuView{Title: T(lang, "command.title"), AltText: T(lang, "command.alt"), Items: items}
+}
+
+//BuildHistoryView 検索履歴
+func BuildHistoryView(userID string) View {
+	user := GetUserConfigFromCache(userID)
+	if user == nil {
+		return textView(DefaultLang, "history.empty")
+	}
+	lang := user.Lang()
+	view := MenuView{Title: T(lang, "history.title"), AltText: T(lang, "history.alt")}
+	for _, history := range user.Histories {
+		view.Items = append(view.Items, MenuItem{Label: history, Message: history})
+	}
+	return view
+}
+
+//BuildConfigView 設定画面
+func BuildConfigView(userID string) View {
+	user := GetUserConfigFromCache(userID)
+	if user == nil {
+		return textView(DefaultLang, "user.loadFailed")
+	}
+	lang := user.Lang()
+	view := ConfigView{
+		MaxFavoriteGroups: MaxFavoriteGroups,
+		MaxNotifies:       MaxNotifySchedules,
+		MaxAlerts:         MaxAlerts,
+		SpotAnnounce:      user.SpotAnnounce,
+		Language:          Lang(user.Language),
+		Lang:              lang,
+	}
+	for _, group := range user.FavoriteGroups {
+		view.FavoriteGroups = append(view.FavoriteGroups, FavoriteGroupItem{Name: group.Name, Count: len(group.Spots)})
+	}
+	now := time.Now().In(LocationTokyo)
+	for _, schedule := range user.Schedules {
+		if !schedule.Expired(now) {
+			view.Notifies = append(view.Notifies, newNotifyItem(schedule))
+		}
+	}
+	view.Pause = newPauseItem(user.NotifyPause, now)
+	for _, code := range user.Favorites {
+		area, spot := SplitAreaSpot(code)
+		view.Favorites = append(view.Favorites, SpotItem{Area: area, Spot: spot, Name: GetPlaceNameByCode(code)})
+	}
+	for _, alert := range user.Alerts {
+		view.Alerts = append(view.Alerts, AlertItem{Key: alert.Key(), Code: alert.Code, Condition: alert.Condition(lang)})
+	}
+	return view
+}
+
+//MakeForecastText 過去の同じ曜日（祝日なら過去の祝日）の推移から台数を予測した文章を作成（予測できなければ空文字）
+func MakeForecastText(ctx context.Context, area string, spot string, current bikeshareapi.BikeCount, lang Lang) string {
+	//比較する日の台数を並行して取得する
+	today := current.Time.In(LocationTokyo)
+	days := holiday.ComparableDays(today, ForecastWeeks)
+	history := make([]forecast.Series, len(days))
+	var wg sync.WaitGroup
+	for i := range days {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			day := days[i].Format("20060102")
+			info, err := BikeshareAPI.GetCountsContext(ctx, bikeshareapi.SearchCountsOption{Area: area, Spot: spot, Day: day})
+			if err != nil {
+				return
+			}
+			for _, count := range info.Counts {
+				history[i] = append(history[i], forecast.Point{Time: count.Time, Count: count.Count})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	result, err := forecast.Predict(
+		forecast.Point{Time: current.Time, Count: current.Count},
+		history,
+		forecast.DefaultHorizon,
+		forecast.DefaultWindow,
+		forecast.DefaultStep,
+	)
+	if err != nil {
+		return ""
+	}
+	text := T(lang, "forecast.text", int(result.Horizon/time.Minute), result.Predicted)
+	if result.WillBeEmpty() {
+		text += T(lang, "forecast.empty", result.EmptyAt.Format("15:04"))
+	}
+	name, ok := holiday.Name(today)
+	if lang == LangEn {
+		name, ok = holiday.EnglishName(today)
+	}
+	if ok {
+		text += "\n" + T(lang, "forecast.basis", name, result.Samples)
+	}
+	return text
+}
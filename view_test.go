@@ -0,0 +1,235 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	bikeshareapi "github.com/8245snake/bikeshare-client"
+)
+
+//spotCodes 一覧のスポットコードの並び
+func spotCodes(spots []SpotItem) []string {
+	var codes []string
+	for _, spot := range spots {
+		codes = append(codes, spot.Area+"-"+spot.Spot)
+	}
+	return codes
+}
+
+func TestBuildSearchView(t *testing.T) {
+	setupFakeBot(t)
+	ctx := testContext(t)
+
+	view, ok := BuildSearchView(ctx, "区役所", LangEn).(SpotListView)
+	if !ok {
+		t.Fatalf("view = %T", view)
+	}
+	if view.Title != T(LangEn, "search.found", "区役所", 4) || view.Lang != LangEn || view.Stale {
+		t.Errorf("view = %+v", view)
+	}
+	if got, want := spotCodes(view.Spots), []string{"A1-01", "B2-01", "C3-01", "D4-01"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("spots = %v, want %v", got, want)
+	}
+	for _, spot := range view.Spots {
+		if !spot.HasCount || spot.Name == "" {
+			t.Errorf("spot = %+v", spot)
+		}
+	}
+
+	if got, want := BuildSearchView(ctx, "池袋", LangJa), View(TextView{Text: T(LangJa, "search.notFound", "池袋")}); got != want {
+		t.Errorf("見つからない: %+v", got)
+	}
+}
+
+func TestBuildLocationView(t *testing.T) {
+	setupFakeBot(t)
+
+	//秋葉原駅前の位置
+	view, ok := BuildLocationView(testContext(t), 35.698353, 139.773114, LangJa).(SpotListView)
+	if !ok {
+		t.Fatalf("view = %T", view)
+	}
+	if len(view.Spots) != len(fakeSpots) || view.Spots[0].Name != "秋葉原駅前" || view.Spots[0].Note != "0m" {
+		t.Errorf("spots = %+v", view.Spots)
+	}
+}
+
+func TestBuildFavoriteListView(t *testing.T) {
+	setupFakeBot(t)
+	ctx := testContext(t)
+
+	if got := BuildFavoriteListView(ctx, "Unknown"); got != View(TextView{Text: T(DefaultLang, "user.loadFailed")}) {
+		t.Errorf("ユーザーがいない: %+v", got)
+	}
+	UpdateUserConfigFunc("U1", func(user *UserConfig) {})
+	if got := BuildFavoriteListView(ctx, "U1"); got != View(TextView{Text: T(LangJa, "fav.empty")}) {
+		t.Errorf("お気に入りなし: %+v", got)
+	}
+	UpdateUserConfigFunc("U1", func(user *UserConfig) {
+		user.FavoriteGroups = []FavoriteGroup{{Name: "通勤", Spots: []string{"A1-02"}}}
+	})
+	if got := BuildFavoriteListView(ctx, "U1"); got != View(TextView{Text: T(LangJa, "favgroup.list", "通勤")}) {
+		t.Errorf("グループだけ: %+v", got)
+	}
+
+	UpdateUserConfigFunc("U1", func(user *UserConfig) {
+		user.Favorites = []string{"C3-02", "A1-01"}
+	})
+	view, ok := BuildFavoriteListView(ctx, "U1").(SpotListView)
+	if !ok {
+		t.Fatalf("view = %T", view)
+	}
+	if got, want := spotCodes(view.Spots), []string{"A1-01", "C3-02"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("spots = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(view.Groups, []string{"通勤"}) || view.Title != T(LangJa, "fav.title") {
+		t.Errorf("view = %+v", view)
+	}
+}
+
+func TestBuildAnalysisView(t *testing.T) {
+	setupFakeBot(t)
+	UpdateUserConfigFunc("U1", func(user *UserConfig) {
+		user.Favorites = []string{"A1-01"}
+		user.FavoriteGroups = []FavoriteGroup{
+			{Name: "通勤", Spots: []string{"A1-01"}},
+			{Name: "買い物", Spots: []string{"B2-02"}},
+		}
+	})
+
+	view, ok := BuildAnalysisView(testContext(t), "A1", "01", "U1").(AnalysisView)
+	if !ok {
+		t.Fatalf("view = %T", view)
+	}
+	if !strings.Contains(view.Title, "千代田区役所") || !strings.HasPrefix(view.URL, BikeshareAPI.Endpoint+"graph.png?") {
+		t.Errorf("title = %q, url = %q", view.Title, view.URL)
+	}
+	//登録済みのお気に入りと、まだ入っていないグループ
+	if !view.Favorite || !reflect.DeepEqual(view.Groups, []string{"買い物"}) {
+		t.Errorf("favorite = %v, groups = %v", view.Favorite, view.Groups)
+	}
+	if view.LastUpdate == "" {
+		t.Error("日付を指定しないときは最終更新日時を載せる")
+	}
+
+	//日付を指定したときは説明や予測を載せない
+	view = BuildAnalysisView(testContext(t), "B2", "02", "U1", "20240605").(AnalysisView)
+	if view.Favorite || view.LastUpdate != "" || view.Forecast != "" || !reflect.DeepEqual(view.Groups, []string{"通勤"}) {
+		t.Errorf("view = %+v", view)
+	}
+}
+
+func TestAnalysisViewGraphImage(t *testing.T) {
+	setupFakeBot(t)
+	UpdateUserConfigFunc("U1", func(user *UserConfig) {})
+	tests := []struct {
+		name      string
+		graphBase string
+	}{
+		{name: "APIのグラフ検索"},
+		{name: "このボットで描画", graphBase: "self"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.graphBase != "" {
+				bot := httptest.NewServer(http.HandlerFunc(GraphHandler))
+				defer bot.Close()
+				GraphBaseURL = bot.URL
+				defer func() { GraphBaseURL = "" }()
+			}
+			got := BuildAnalysisView(testContext(t), "A1", "01", "U1")
+			view, ok := got.(AnalysisView)
+			if !ok {
+				t.Fatalf("view = %+v", got)
+			}
+			//画像のURLからグラフを取得できる
+			resp, err := http.Get(view.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "image/png" {
+				t.Errorf("%s: status = %d, content-type = %s", view.URL, resp.StatusCode, resp.Header.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestBuildServiceStatusView(t *testing.T) {
+	setupFakeBot(t)
+	if got := BuildServiceStatusView(testContext(t), LangEn); got != View(StatusView{OK: true, Text: T(LangEn, "status.ok")}) {
+		t.Errorf("view = %+v", got)
+	}
+}
+
+func TestBuildViewsWhenAPIFails(t *testing.T) {
+	setupFakeBot(t)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer failing.Close()
+	SetupBikeshareAPI(failing.URL + "/")
+	ctx := testContext(t)
+
+	reason := "\n" + T(LangJa, "apierr.status", 404)
+	if got := BuildSearchView(ctx, "区役所", LangJa); got != View(TextView{Text: T(LangJa, "search.spotFailed") + reason}) {
+		t.Errorf("検索: %+v", got)
+	}
+	if got := BuildServiceStatusView(ctx, LangJa); got != View(TextView{Text: T(LangJa, "status.apiError") + reason}) {
+		t.Errorf("稼働状況: %+v", got)
+	}
+}
+
+func TestBuildConfigView(t *testing.T) {
+	setupFakeBot(t)
+	UpdateUserConfigFunc("U1", func(user *UserConfig) {
+		user.Favorites = []string{"A1-01"}
+		user.FavoriteGroups = []FavoriteGroup{{Name: "通勤", Spots: []string{"A1-01", "C3-02"}}}
+		user.Schedules = []NotifySchedule{
+			{ID: 1, Time: "08:00", Weekdays: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, NotifyTarget: NotifyTarget{Group: "通勤"}},
+		}
+		user.Language = "en"
+		user.QuietStart, user.QuietEnd = "22:00", "07:00"
+	})
+
+	view, ok := BuildConfigView("U1").(ConfigView)
+	if !ok {
+		t.Fatalf("view = %T", view)
+	}
+	want := ConfigView{
+		Favorites:         []SpotItem{{Area: "A1", Spot: "01", Name: "千代田区役所"}},
+		FavoriteGroups:    []FavoriteGroupItem{{Name: "通勤", Count: 2}},
+		MaxFavoriteGroups: MaxFavoriteGroups,
+		Notifies:          []NotifyItem{{ID: 1, Time: "08:00", Weekdays: "12345", Group: "通勤"}},
+		MaxNotifies:       MaxNotifySchedules,
+		MaxAlerts:         MaxAlerts,
+		Pause:             PauseItem{QuietStart: "22:00", QuietEnd: "07:00"},
+		Language:          LangEn,
+		Lang:              LangEn,
+	}
+	if !reflect.DeepEqual(view, want) {
+		t.Errorf("view =\n%+v\nwant\n%+v", view, want)
+	}
+}
+
+func TestMakeForecastTextHolidayName(t *testing.T) {
+	setupFakeBot(t)
+	current := bikeshareapi.BikeCount{Time: time.Date(2024, time.January, 1, 12, 0, 0, 0, LocationTokyo), Count: 5}
+	tests := []struct {
+		lang Lang
+		want string
+	}{
+		{lang: LangJa, want: "祝日（元日）"},
+		{lang: LangEn, want: "public holiday (New Year's Day)"},
+	}
+	for _, tt := range tests {
+		got := MakeForecastText(testContext(t), "A1", "01", current, tt.lang)
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("%s: %q に %q がない", tt.lang, got, tt.want)
+		}
+	}
+}